@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// ============== CLIENT ACCESS RESTRICTIONS ==============
+
+// TrustedProxies là các dải IP của reverse proxy được tin X-Forwarded-For, ví dụ {"10.0.0.0/8"}.
+// Rỗng thì luôn dùng địa chỉ kết nối.
+var TrustedProxies = []string{}
+
+var trustedProxyPrefixes = mustParsePrefixes(TrustedProxies)
+
+// StatusCIDRs là các dải IP được gọi /status, /result và /preview (thêm vào allowedCIDRs của
+// session), ví dụ {"10.0.0.0/8"}. Rỗng thì không giới hạn.
+var StatusCIDRs = []string{}
+
+var statusCIDRPrefixes = mustParsePrefixes(StatusCIDRs)
+
+// StatusSessionCIDRs áp dụng allowedCIDRs của session cho /status, /result và /preview như download.
+// Tắt khi dashboard đọc tiến độ từ mạng khác với mạng được tải.
+var StatusSessionCIDRs = true
+
+func mustParsePrefixes(list []string) []netip.Prefix {
+	prefixes, err := parsePrefixes(list)
+	if err != nil {
+		panic(err)
+	}
+	return prefixes
+}
+
+// parsePrefixes nhận CIDR ("10.1.0.0/16", "2001:db8::/32") hoặc IP đơn lẻ
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", s)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// connAddr là địa chỉ của kết nối (hop cuối), không xét X-Forwarded-For
+func connAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// fromTrustedProxy báo kết nối đến từ TrustedProxies, khi đó header X-Forwarded-* được tin
+func fromTrustedProxy(r *http.Request) bool {
+	addr, ok := connAddr(r)
+	return ok && containsAddr(trustedProxyPrefixes, addr)
+}
+
+// clientIP là địa chỉ client: địa chỉ kết nối, hoặc khi kết nối đến từ TrustedProxies thì
+// địa chỉ đầu tiên không thuộc proxy tin cậy khi đọc X-Forwarded-For từ phải sang trái
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	addr, ok := connAddr(r)
+	if !ok {
+		return addr, false
+	}
+	if !containsAddr(trustedProxyPrefixes, addr) {
+		return addr, true
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap().WithZone("")
+		if !containsAddr(trustedProxyPrefixes, addr) {
+			break
+		}
+	}
+	return addr, true
+}
+
+// statusNetworkAllowed kiểm tra client được xem trạng thái/preview của session theo StatusCIDRs và
+// (khi StatusSessionCIDRs) allowedCIDRs của session
+func statusNetworkAllowed(r *http.Request, session *Session) bool {
+	if len(statusCIDRPrefixes) == 0 && (!StatusSessionCIDRs || len(session.AllowedCIDRs) == 0) {
+		return true
+	}
+	addr, ok := clientIP(r)
+	if !ok {
+		return false
+	}
+	if len(statusCIDRPrefixes) > 0 && !containsAddr(statusCIDRPrefixes, addr) {
+		return false
+	}
+	return !StatusSessionCIDRs || len(session.AllowedCIDRs) == 0 || containsAddr(session.AllowedCIDRs, addr)
+}
+
+// referrerPolicy giới hạn download theo Referer/Origin (hostname hoặc "*.example.com")
+type referrerPolicy struct {
+	Allowed    []string
+	AllowEmpty bool // Cho phép khi không có cả Referer lẫn Origin
+	Strict     bool // Mọi header có mặt đều phải khớp, Origin "null" tính là không khớp
+}
+
+func validateReferrerPatterns(patterns []string) error {
+	for _, p := range patterns {
+		host := strings.TrimPrefix(p, "*.")
+		if host == "" || strings.ContainsAny(host, "*/:") {
+			return fmt.Errorf("invalid pattern %q", p)
+		}
+	}
+	return nil
+}
+
+func (p *referrerPolicy) matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range p.Allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// allows kiểm tra header Origin và Referer của request. Không có header nào thì theo AllowEmpty.
+func (p *referrerPolicy) allows(r *http.Request) bool {
+	var hosts []string
+	present := false
+	for _, name := range []string{"Origin", "Referer"} {
+		v := r.Header.Get(name)
+		if v == "" {
+			continue
+		}
+		present = true
+		if u, err := url.Parse(v); err == nil && u.Hostname() != "" {
+			hosts = append(hosts, u.Hostname())
+		} else {
+			hosts = append(hosts, "") // "null" hoặc không parse được
+		}
+	}
+	if !present {
+		return p.AllowEmpty
+	}
+
+	for _, host := range hosts {
+		ok := host != "" && p.matches(host)
+		if ok && !p.Strict {
+			return true
+		}
+		if !ok && p.Strict {
+			return false
+		}
+	}
+	return p.Strict
+}
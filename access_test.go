@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// useStatusCIDRs đặt StatusCIDRs và StatusSessionCIDRs cho một test
+func useStatusCIDRs(t *testing.T, cidrs []string, sessionCIDRs bool) {
+	list, prefixes, apply := StatusCIDRs, statusCIDRPrefixes, StatusSessionCIDRs
+	StatusCIDRs, statusCIDRPrefixes, StatusSessionCIDRs = cidrs, mustParsePrefixes(cidrs), sessionCIDRs
+	t.Cleanup(func() { StatusCIDRs, statusCIDRPrefixes, StatusSessionCIDRs = list, prefixes, apply })
+}
+
+// Test client là 127.0.0.1, ngoài 10.0.0.0/8
+func TestStatusCIDRs(t *testing.T) {
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	restricted := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"allowedCIDRs":["10.0.0.0/8"]}`)
+	open := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}]}`)
+	token := func(link string) string { return link[strings.LastIndex(link, "/")+1:] }
+	check := func(name, link string, want int) {
+		t.Helper()
+		for _, path := range []string{"/status/", "/preview/"} {
+			status, body := download(t, base+path+token(link))
+			if status != want {
+				t.Fatalf("%s: %s = %d %s, want %d", name, path, status, body, want)
+			}
+			if want == http.StatusForbidden && !strings.Contains(string(body), "not allowed from this network") {
+				t.Fatalf("%s: %s body = %s, want the forbidden_network message", name, path, body)
+			}
+		}
+	}
+
+	useStatusCIDRs(t, nil, true)
+	check("session allowedCIDRs", restricted, http.StatusForbidden)
+	check("no restriction", open, http.StatusOK)
+
+	useStatusCIDRs(t, nil, false)
+	check("session allowedCIDRs off", restricted, http.StatusOK)
+	if status, _ := download(t, restricted); status != http.StatusForbidden {
+		t.Fatalf("download outside allowedCIDRs = %d, want 403 whatever the status setting", status)
+	}
+
+	useStatusCIDRs(t, []string{"10.0.0.0/8"}, false)
+	check("denied by status-cidrs", open, http.StatusForbidden)
+
+	useStatusCIDRs(t, []string{"127.0.0.0/8"}, true)
+	check("allowed by status-cidrs", open, http.StatusOK)
+	check("allowed by status-cidrs but not the session", restricted, http.StatusForbidden)
+}
+
+func TestConfigStatusCIDRs(t *testing.T) {
+	cfg, err := parseConfig(t, map[string]string{"STATUS_CIDRS": "10.0.0.0/8, 192.168.1.1"}, "-status-session-cidrs=false")
+	if err != nil || strings.Join(cfg.StatusCIDRs, ",") != "10.0.0.0/8,192.168.1.1" || cfg.StatusSessionCIDRs {
+		t.Fatalf("config = %v %v %v", cfg.StatusCIDRs, cfg.StatusSessionCIDRs, err)
+	}
+	if cfg, _ := parseConfig(t, nil); !cfg.StatusSessionCIDRs || len(cfg.StatusCIDRs) != 0 {
+		t.Fatalf("defaults = %v %v, want no list and session CIDRs applied", cfg.StatusCIDRs, cfg.StatusSessionCIDRs)
+	}
+	if _, err := parseConfig(t, nil, "-status-cidrs", "10.0.0.0/33"); err == nil || !strings.Contains(err.Error(), "status-cidrs") {
+		t.Fatalf("invalid CIDR = %v", err)
+	}
+}
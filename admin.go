@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============== ADMIN SESSIONS ==============
+
+// GET /admin/sessions liệt kê session, DELETE /admin/sessions/{token} thu hồi một session và cắt
+// download đang chạy của nó, POST /admin/cleanup chạy lượt dọn session hết hạn ngay và thu hồi
+// các session khớp bộ lọc. Với backend chung chỉ thấy các session trong cache của instance này.
+
+// revokedAbortReason là lý do abort của download bị cắt khi session bị thu hồi
+const revokedAbortReason = "session revoked"
+
+// adminSession là một session trong GET /admin/sessions
+type adminSession struct {
+	Token              string    `json:"token"`
+	Files              int       `json:"files"`
+	CreatedAt          time.Time `json:"created_at"`
+	AgeSeconds         int64     `json:"age_seconds"`
+	ExpiresAt          time.Time `json:"expires_at"`
+	APIKey             string    `json:"api_key,omitempty"`   // Tên API key đã tạo session
+	ClientIP           string    `json:"client_ip,omitempty"` // IP đã tạo session qua /create hoặc clone
+	Open               bool      `json:"open,omitempty"`
+	DownloadsActive    int       `json:"downloads_active"`
+	DownloadsCompleted int       `json:"downloads_completed"`
+}
+
+type adminSessionsResponse struct {
+	Sessions []adminSession `json:"sessions"`
+	Total    int            `json:"total"`
+}
+
+// cleanupResponse là kết quả của POST /admin/cleanup
+type cleanupResponse struct {
+	Expired            int `json:"expired"`
+	TombstonesPruned   int `json:"tombstones_pruned"`
+	Revoked            int `json:"revoked"`
+	CancelledDownloads int `json:"cancelled_downloads"`
+}
+
+// sessionFilter chọn session theo ?apiKey= (tên key) và ?olderThan= (tuổi tối thiểu)
+type sessionFilter struct {
+	apiKey    string
+	olderThan time.Duration
+	byAge     bool // Có ?olderThan=, kể cả "0s" (mọi session)
+}
+
+func parseSessionFilter(r *http.Request) (sessionFilter, error) {
+	q := r.URL.Query()
+	f := sessionFilter{apiKey: q.Get("apiKey")}
+	if v := q.Get("olderThan"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return f, fmt.Errorf("bad olderThan: %q", v)
+		}
+		f.olderThan, f.byAge = d, true
+	}
+	return f, nil
+}
+
+func (f sessionFilter) empty() bool { return f.apiKey == "" && !f.byAge }
+
+// match phải được gọi khi đang giữ mu
+func (f sessionFilter) match(s *Session, now time.Time) bool {
+	if f.apiKey != "" && s.APIKeyName != f.apiKey {
+		return false
+	}
+	return now.Sub(s.CreatedAt) >= f.olderThan
+}
+
+// cancelReason là lý do abort khi context của download bị server hủy (tắt hoặc thu hồi session),
+// "" khi bị hủy vì lý do khác (client ngắt kết nối, hết thời gian)
+func (s *Session) cancelReason() string {
+	if shuttingDown.Load() {
+		return shutdownAbortReason
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	if s.revoked {
+		return revokedAbortReason
+	}
+	return ""
+}
+
+// revokeSessionLocked xóa session cùng bản ghi, hủy các download đang chạy và để tombstone
+// "revoked" (không clone được). Trả số download đã hủy. Phải giữ mu.Lock
+func revokeSessionLocked(session *Session, now time.Time) int {
+	session.revoked = true
+	cancelled := 0
+	for id, d := range session.downloads {
+		d.cancel(nil)
+		delete(session.downloads, id)
+		cancelled++
+	}
+	deleteSessionLocked(session.token)
+	tombstones[session.token] = tombstone{Reason: "revoked", Until: now.Add(TombstoneRetention)}
+	return cancelled
+}
+
+func handleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseSessionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	resp := adminSessionsResponse{Sessions: []adminSession{}}
+	mu.RLock()
+	for e := sessionOrder.Front(); e != nil; e = e.Next() {
+		s := sessions[e.Value.(string)]
+		if s.isExpired(now) || !filter.match(s, now) {
+			continue
+		}
+		item := adminSession{
+			Token:              s.token,
+			Files:              len(s.Files),
+			CreatedAt:          s.CreatedAt,
+			AgeSeconds:         int64(now.Sub(s.CreatedAt) / time.Second),
+			ExpiresAt:          s.expiresAt(),
+			APIKey:             s.APIKeyName,
+			Open:               s.Open,
+			DownloadsActive:    len(s.downloads),
+			DownloadsCompleted: s.completed,
+		}
+		if s.owner.IsValid() {
+			item.ClientIP = s.owner.String()
+		}
+		resp.Sessions = append(resp.Sessions, item)
+	}
+	mu.RUnlock()
+	resp.Total = len(resp.Sessions)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func handleRevoke(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	syncSharedSession(token)
+
+	mu.Lock()
+	session, ok := sessions[token]
+	if !ok {
+		mu.Unlock()
+		http.Error(w, "Invalid or expired token", http.StatusNotFound)
+		return
+	}
+	cancelled := revokeSessionLocked(session, time.Now())
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"token": token, "cancelled_downloads": cancelled})
+
+	slog.InfoContext(r.Context(), "Revoked session", "token", token, "cancelled_downloads", cancelled)
+}
+
+func handleCleanup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseSessionFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var resp cleanupResponse
+	mu.Lock()
+	resp.Expired, resp.TombstonesPruned, _ = sweepExpiredLocked(now)
+	if !filter.empty() {
+		var matched []*Session
+		for _, s := range sessions {
+			if !s.isExpired(now) && filter.match(s, now) {
+				matched = append(matched, s)
+			}
+		}
+		for _, s := range matched {
+			resp.CancelledDownloads += revokeSessionLocked(s, now)
+			resp.Revoked++
+		}
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	slog.InfoContext(r.Context(), "Forced cleanup", "expired", resp.Expired, "tombstones_pruned", resp.TombstonesPruned,
+		"revoked", resp.Revoked, "cancelled_downloads", resp.CancelledDownloads, "api_key", filter.apiKey, "older_than", filter.olderThan.String())
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ============== ANALYTICS ==============
+
+// downloadAttempt là một lần gọi /download, kể cả bị từ chối
+type downloadAttempt struct {
+	Time      time.Time `json:"time"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Outcome   string    `json:"outcome"`          // completed, failed, aborted, throttled, forbidden_network, ...
+	Reason    string    `json:"reason,omitempty"` // Lý do abort
+	Bytes     int64     `json:"bytes"`
+}
+
+// sessionAnalytics nằm trong Session nên đi theo session vào tombstone và hết hạn cùng nó
+type sessionAnalytics struct {
+	recent   []downloadAttempt // Tối đa AnalyticsMaxAttempts lần gần nhất
+	attempts int64
+	outcomes map[string]int64
+	bytes    int64
+	clients  map[string]struct{}
+	first    time.Time
+}
+
+type analyticsReport struct {
+	Attempts      int64             `json:"attempts"`
+	Outcomes      map[string]int64  `json:"outcomes"`
+	BytesSent     int64             `json:"bytes_sent"`
+	UniqueClients int               `json:"unique_clients"`
+	FirstAttempt  *time.Time        `json:"first_attempt,omitempty"`
+	LastAttempt   *time.Time        `json:"last_attempt,omitempty"`
+	Recent        []downloadAttempt `json:"recent"`
+}
+
+// recordAttempt ghi lại một lần download. Phải giữ mu.Lock
+func (s *Session) recordAttempt(r *http.Request, outcome string, bytes int64) {
+	s.recordAttemptReason(r, outcome, "", bytes)
+}
+
+func (s *Session) recordAttemptReason(r *http.Request, outcome, reason string, bytes int64) {
+	a := &s.analytics
+	ip := ""
+	if addr, ok := clientIP(r); ok {
+		ip = addr.String()
+	}
+
+	now := time.Now().UTC()
+	if a.attempts == 0 {
+		a.first = now
+	}
+	a.attempts++
+	a.bytes += bytes
+	if a.outcomes == nil {
+		a.outcomes = make(map[string]int64)
+		a.clients = make(map[string]struct{})
+	}
+	a.outcomes[outcome]++
+	if len(a.clients) < maxTrackedClients {
+		a.clients[ip] = struct{}{}
+	}
+
+	if len(a.recent) >= AnalyticsMaxAttempts {
+		a.recent = append(a.recent[:0], a.recent[1:]...)
+	}
+	a.recent = append(a.recent, downloadAttempt{
+		Time:      now,
+		ClientIP:  ip,
+		UserAgent: r.UserAgent(),
+		Outcome:   outcome,
+		Reason:    reason,
+		Bytes:     bytes,
+	})
+}
+
+// maxTrackedClients giới hạn bộ nhớ cho unique_clients của một session
+const maxTrackedClients = 1000
+
+// report phải được gọi khi đang giữ mu
+func (a *sessionAnalytics) report() *analyticsReport {
+	rep := &analyticsReport{
+		Attempts:      a.attempts,
+		Outcomes:      make(map[string]int64, len(a.outcomes)),
+		BytesSent:     a.bytes,
+		UniqueClients: len(a.clients),
+		Recent:        append([]downloadAttempt{}, a.recent...),
+	}
+	for k, v := range a.outcomes {
+		rep.Outcomes[k] = v
+	}
+	if a.attempts > 0 {
+		first, last := a.first, a.recent[len(a.recent)-1].Time
+		rep.FirstAttempt, rep.LastAttempt = &first, &last
+	}
+	return rep
+}
+
+// handleAnalytics trả thống kê của token còn sống hoặc đã hết hạn/đã tải (trong TombstoneRetention)
+// cho admin hoặc API key đã tạo session
+func handleAnalytics(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mu.RLock()
+	session := sessions[token]
+	if session == nil {
+		session = tombstones[token].session
+	}
+	var creator string
+	if session != nil {
+		creator = session.APIKeyName
+	}
+	mu.RUnlock()
+	if !requireCreatorOrAdmin(w, r, creator) {
+		return
+	}
+
+	var rep *analyticsReport
+	if session != nil {
+		mu.RLock()
+		rep = session.analytics.report()
+		mu.RUnlock()
+	}
+
+	if rep == nil {
+		http.Error(w, "Invalid or expired token", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ============== ARCHIVE FORMATS ==============
+
+// normalizeArchiveFormat chuẩn hóa archiveFormat của request ("zip" mặc định, "tar", "tar.gz" hoặc
+// "tgz") thành giá trị lưu trong archiveOptions.Format, "" = zip
+func normalizeArchiveFormat(v string) (string, bool) {
+	switch strings.ToLower(v) {
+	case "", "zip":
+		return "", true
+	case "tar":
+		return "tar", true
+	case "tar.gz", "tgz":
+		return "tar.gz", true
+	}
+	return "", false
+}
+
+// extension là đuôi tên file mặc định của archive
+func (o archiveOptions) extension() string {
+	if o.Format == "" {
+		return ".zip"
+	}
+	return "." + o.Format
+}
+
+// contentType là Content-Type mặc định của response
+func (o archiveOptions) contentType() string {
+	switch o.Format {
+	case "tar":
+		return "application/x-tar"
+	case "tar.gz":
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// defaultArchiveName là zipName khi request không đặt tên
+func (o archiveOptions) defaultArchiveName() string {
+	return "files" + o.extension()
+}
+
+// archiveWriter ghi lần lượt các entry vào archive đang stream. Khi abort, handleDownload không
+// gọi Close để client không nhận được archive trông như hoàn chỉnh.
+type archiveWriter interface {
+	// writeEntry ghi một file từ body; size là dung lượng chưa nén, -1 = chưa biết
+	writeEntry(entry zipEntry, size int64, body io.Reader, progress *downloadProgress) error
+	// writeText ghi một entry text do server sinh (ERRORS.txt, placeholder)
+	writeText(name, content string) error
+	Flush() error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, opts archiveOptions, spool *spoolReservation) archiveWriter {
+	switch opts.Format {
+	case "tar":
+		return &tarArchive{tw: tar.NewWriter(w), opts: opts, spool: spool}
+	case "tar.gz":
+		gz, _ := gzip.NewWriterLevel(w, cmp.Or(opts.Level, gzip.DefaultCompression)) // Level đã được kiểm tra lúc tạo session
+		return &tarArchive{tw: tar.NewWriter(gz), gz: gz, opts: opts, spool: spool}
+	}
+	zw := zip.NewWriter(w)
+	registerDeflateLevel(zw, opts.Level)
+	return &zipArchive{zw: zw, opts: opts}
+}
+
+// MaxTarOwnerID là uid/gid lớn nhất của tarUid/tarGid, vừa trường octal 8 byte của USTAR nên mọi
+// bản tar đọc được mà không cần PAX
+const MaxTarOwnerID = 1<<21 - 1
+
+// validateTarOwner kiểm tra tarUid/tarGid/tarUname/tarGname: chỉ với archive tar, id trong
+// 0..MaxTarOwnerID, tên ASCII in được tối đa 32 byte như trường uname/gname của USTAR
+func validateTarOwner(req *DownloadRequest, format string) error {
+	if req.TarUID == 0 && req.TarGID == 0 && req.TarUname == "" && req.TarGname == "" {
+		return nil
+	}
+	if format == "" {
+		return errors.New(`tarUid, tarGid, tarUname and tarGname require archiveFormat "tar" or "tar.gz"`)
+	}
+	if req.TarUID < 0 || req.TarUID > MaxTarOwnerID || req.TarGID < 0 || req.TarGID > MaxTarOwnerID {
+		return fmt.Errorf("tarUid and tarGid must be between 0 and %d", MaxTarOwnerID)
+	}
+	if !validTarOwnerName(req.TarUname) || !validTarOwnerName(req.TarGname) {
+		return errors.New("tarUname and tarGname must be at most 32 printable ASCII characters without spaces")
+	}
+	return nil
+}
+
+func validTarOwnerName(name string) bool {
+	return len(name) <= 32 && strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r > '~' }) < 0
+}
+
+// zipArchive ghi zip; entry không cần biết trước dung lượng (data descriptor)
+type zipArchive struct {
+	zw   *zip.Writer
+	opts archiveOptions
+}
+
+func (a *zipArchive) writeEntry(entry zipEntry, _ int64, body io.Reader, progress *downloadProgress) error {
+	fileWriter, err := a.create(newEntryHeader(entry, a.opts))
+	if err != nil {
+		return err
+	}
+	if entry.CRC != nil {
+		body = io.TeeReader(body, entry.CRC)
+	}
+
+	_, err = io.Copy(&progressWriter{w: fileWriter, p: progress}, body)
+	return err
+}
+
+func (a *zipArchive) writeText(name, content string) error {
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	header.SetMode(defaultFileMode)
+	setEntryTime(header, time.Now(), a.opts)
+
+	w, err := a.create(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, content)
+	return err
+}
+
+// create mở entry mới, mã hóa nó khi archive có password
+func (a *zipArchive) create(header *zip.FileHeader) (io.Writer, error) {
+	if a.opts.Encrypted {
+		encryptEntry(a.zw, header, a.opts.password, a.opts.Level)
+	}
+	return a.zw.CreateHeader(header)
+}
+
+func (a *zipArchive) Flush() error { return a.zw.Flush() }
+func (a *zipArchive) Close() error { return a.zw.Close() }
+
+// tarArchive ghi tar (bọc gzip với tar.gz). Header tar cần dung lượng trước dữ liệu nên body không
+// rõ dung lượng được spool ra file tạm trước. Body ngắn hơn dung lượng đã khai được đệm byte 0 để
+// các entry sau vẫn đọc được, rồi entry đó được báo lỗi.
+type tarArchive struct {
+	tw    *tar.Writer
+	gz    *gzip.Writer // nil với tar không nén
+	opts  archiveOptions
+	spool *spoolReservation
+}
+
+func (a *tarArchive) header(name string, mode int64, t time.Time, size int64) *tar.Header {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     mode,
+		Uid:      a.opts.UID,
+		Gid:      a.opts.GID,
+		Uname:    a.opts.Uname,
+		Gname:    a.opts.Gname,
+		Size:     size,
+		ModTime:  t.Truncate(time.Second),
+	}
+	if a.opts.TimestampExtras {
+		// PAX giữ thời gian tới phần giây lẻ, giống extra field thời gian của zip
+		h.Format, h.ModTime = tar.FormatPAX, t.UTC()
+	}
+	return h
+}
+
+func (a *tarArchive) writeEntry(entry zipEntry, size int64, body io.Reader, progress *downloadProgress) error {
+	if size < 0 {
+		f, release, err := createSpoolFile(a.spool, "tar", 0)
+		if err != nil {
+			return err
+		}
+		defer release()
+		if size, err = io.Copy(f, body); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = f
+	}
+	if entry.CRC != nil {
+		body = io.TeeReader(body, entry.CRC)
+	}
+
+	if err := a.tw.WriteHeader(a.header(entry.Name, int64(entry.Mode.Perm()), entry.Time, size)); err != nil {
+		return err
+	}
+	n, err := io.Copy(&progressWriter{w: a.tw, p: progress}, io.LimitReader(body, size))
+	if err != nil {
+		a.pad(size - n)
+		return err
+	}
+	if n < size {
+		a.pad(size - n)
+		return fmt.Errorf("source sent %d bytes, expected %d", n, size)
+	}
+	// Nguồn dài hơn Content-Length: phần thừa không vào archive
+	if m, _ := body.Read(make([]byte, 1)); m > 0 {
+		return fmt.Errorf("source sent more than the expected %d bytes", size)
+	}
+	return nil
+}
+
+// pad ghi n byte 0 vào phần còn thiếu của entry hiện tại
+func (a *tarArchive) pad(n int64) {
+	io.CopyN(a.tw, zeroReader{}, n)
+}
+
+func (a *tarArchive) writeText(name, content string) error {
+	if err := a.tw.WriteHeader(a.header(name, int64(defaultFileMode), time.Now(), int64(len(content)))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(a.tw, content)
+	return err
+}
+
+func (a *tarArchive) Flush() error {
+	if err := a.tw.Flush(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Flush()
+	}
+	return nil
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// zeroReader đọc ra byte 0 vô hạn
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
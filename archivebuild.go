@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============== ARCHIVE PIPELINE ==============
+
+// buildArchive là luồng fetch + ghi archive dùng chung cho /download, /zip và bản dựng artifact:
+// tải song song (prefetch) các file, ghi entry theo thứ tự, xử lý file lỗi theo onError và các
+// giới hạn, rồi ghi báo cáo cuối archive. Handler lo kiểm tra truy cập, header và kết quả.
+
+// buildOptions là các tùy chọn của một lần dựng archive, chụp từ session khi giữ mu
+type buildOptions struct {
+	token          string // Chỉ để log
+	session        *Session
+	names          nameOptions
+	archive        archiveOptions
+	mirrorStrategy string
+	retry          retrySettings
+	deadlines      deadlinePolicy
+	hedge          hedgePolicy
+	onError        string
+	failLimits     failureLimits
+	placeholders   bool
+	errorReport    string
+	checksums      bool
+	createdAt      time.Time
+
+	// Layout resume stream và bản ghi từng entry (lưu lại vào session.resume khi stream)
+	resumable bool
+	plan      resumePlan
+	records   []resumeRecord
+
+	client    context.Context // Context của kết nối client, để phân biệt client ngắt với download bị hủy
+	spool     *spoolReservation
+	progress  *downloadProgress
+	startedAt time.Time
+}
+
+// buildOptionsLocked chụp các tùy chọn dựng archive của session. Phải giữ mu
+func (s *Session) buildOptionsLocked(token string) buildOptions {
+	return buildOptions{
+		token:          token,
+		session:        s,
+		names:          s.nameOptions(),
+		archive:        s.Archive,
+		mirrorStrategy: s.MirrorStrategy,
+		retry:          s.Retry,
+		deadlines:      s.Deadlines,
+		hedge:          s.Hedge,
+		onError:        s.OnError,
+		failLimits:     s.FailureLimits,
+		placeholders:   s.FailurePlaceholders,
+		errorReport:    s.ErrorReport,
+		checksums:      s.Checksums,
+		createdAt:      s.CreatedAt,
+	}
+}
+
+// archiveAbortError là archive bị dừng giữa chừng: archive không được đóng để client không nhận
+// một file trông như hoàn chỉnh. Reason rỗng khi client đã ngắt kết nối
+type archiveAbortError struct {
+	Outcome string // aborted hoặc cancelled
+	Reason  string
+}
+
+func (e *archiveAbortError) Error() string {
+	if e.Reason == "" {
+		return "archive " + e.Outcome
+	}
+	return fmt.Sprintf("archive %s: %s", e.Outcome, e.Reason)
+}
+
+// resumeChangedError là nguồn của entry Index đã đổi so với bản client đã nhận một phần khi
+// resume; chưa có gì được gửi đi
+type resumeChangedError struct {
+	Index int
+}
+
+func (e *resumeChangedError) Error() string {
+	return fmt.Sprintf("source of file %d changed since the interrupted download", e.Index)
+}
+
+// buildOutcome là kết quả download (và lý do abort) theo lỗi buildArchive trả về
+func buildOutcome(err error) (outcome, reason string) {
+	var abort *archiveAbortError
+	switch {
+	case err == nil:
+		return "completed", ""
+	case errors.As(err, &abort):
+		return abort.Outcome, abort.Reason
+	}
+	return "failed", ""
+}
+
+// endStream kết thúc response của download stream theo lỗi buildArchive trả về: nguồn đổi khi
+// resume thì trả lỗi resume, archive bị dừng thì cắt kết nối ngay
+func endStream(w http.ResponseWriter, err error) {
+	var changed *resumeChangedError
+	switch {
+	case err == nil:
+	case errors.As(err, &changed):
+		writeResumeChanged(w, changed.Index)
+	default:
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// buildArchive ghi archive của files ra w. ctx mang tổng thời gian của download và bị hủy khi
+// download bị hủy; opts.progress nhận kết quả từng file. Archive chỉ được đóng khi trả về nil
+func buildArchive(ctx context.Context, w io.Writer, files []FileEntry, opts buildOptions) error {
+	token, progress, plan, records := opts.token, opts.progress, opts.plan, opts.records
+	archive := newArchiveWriter(w, opts.archive, opts.spool)
+	hedges := newHedgeBudget(opts.hedge)
+
+	// Tên đã resolve lúc tạo session được giữ nguyên, đăng ký trước để các file còn lại không trùng
+	namer := newEntryNamer(opts.names)
+	for _, f := range files {
+		if f.resolvedName != "" {
+			namer.reserve(f.resolvedName)
+		}
+	}
+	entryName := func(entry FileEntry, fileName string) (string, error) {
+		if entry.resolvedName != "" {
+			return entry.resolvedName, nil
+		}
+		return namer.name(entry, fileName)
+	}
+
+	// abortAs ghi ERRORS.txt (hoặc manifest.json) với lý do; archive không được đóng nên client
+	// không nhận được file trông như hoàn chỉnh
+	abortAs := func(outcome, reason string) error {
+		progress.setAbortReason(reason)
+		if opts.resumable {
+			// Không thêm ERRORS.txt: phần client đã nhận phải là tiền tố của archive sinh lại khi resume
+			archive.Flush()
+		} else if opts.errorReport == "json" {
+			if err := writeManifest(archive, namer.unique("manifest.json"), progress.report("aborted")); err != nil {
+				slog.ErrorContext(ctx, "Failed to write manifest", "token", token, "error", err)
+			}
+		} else if err := writeErrorsReport(archive, "ERRORS.txt", "Archive aborted: "+reason, progress.failureReport()); err != nil {
+			slog.ErrorContext(ctx, "Failed to write errors report", "token", token, "error", err)
+		}
+		slog.WarnContext(ctx, "Aborting download", "token", token, "reason", reason, "files", len(files), "duration_ms", time.Since(opts.startedAt).Milliseconds())
+		return &archiveAbortError{Outcome: outcome, Reason: reason}
+	}
+	abortDownload := func(reason string) error { return abortAs("aborted", reason) }
+
+	// cancelled trả lỗi khi client đã ngắt kết nối (lỗi của file đang dở chỉ là hệ quả, không ghi
+	// gì thêm) hoặc download bị hủy qua DELETE hay rotate với force (client còn kết nối nhận
+	// ERRORS.txt như khi abort)
+	cancelled := func() error {
+		switch {
+		case errors.Is(context.Cause(ctx), errDownloadCancelled):
+			return abortAs("cancelled", cancelledAbortReason)
+		case errors.Is(context.Cause(ctx), errSessionRotated):
+			return abortDownload(rotatedAbortReason)
+		case opts.client.Err() != nil:
+			slog.InfoContext(ctx, "Client disconnected", "token", token, "files_done", progress.filesCompleted.Load(), "files", len(files),
+				"duration_ms", time.Since(opts.startedAt).Milliseconds())
+			return &archiveAbortError{Outcome: "cancelled"}
+		}
+		return nil
+	}
+	// stopped là lý do dừng khi ctx đã xong: hủy, server tắt hoặc session bị thu hồi, còn lại là
+	// hết tổng thời gian (với resume, client tải tiếp phần còn lại)
+	stopped := func() error {
+		if err := cancelled(); err != nil {
+			return err
+		}
+		if reason := opts.session.cancelReason(); reason != "" {
+			return abortDownload(reason)
+		}
+		return abortDownload(deadlineAbortReason)
+	}
+
+	// failEntry ghi nhận file lỗi; trả lỗi khi phải dừng: onError = "abort" hoặc vượt
+	// maxFailures/maxFailureRatio
+	failEntry := func(index int, fileURL string, err error) error {
+		if ctx.Err() != nil {
+			if err := cancelled(); err != nil {
+				return err
+			}
+		}
+		progress.fail(index, fileURL, err)
+
+		reason := opts.failLimits.exceeded(progress.filesFailed.Load(), len(files))
+		if opts.onError == "abort" {
+			reason = fmt.Sprintf("%s failed: %v", fileURL, err)
+		}
+		if reason == "" {
+			if opts.placeholders {
+				name := namer.placeholder(files[index])
+				if err := writeFailurePlaceholder(archive, name, fileURL, err); err != nil {
+					slog.ErrorContext(ctx, "Failed to write placeholder", "token", token, "name", name, "error", err)
+				}
+			}
+			return nil
+		}
+		return abortDownload(reason)
+	}
+
+	// recordResume lưu ETag khi entry bắt đầu stream (để resume được giữa entry) và CRC khi
+	// stream xong (để lần resume sau bỏ qua hẳn entry)
+	recordResume := func(index int, ze zipEntry, etag string, done bool) {
+		if ze.CRC == nil {
+			return
+		}
+		rec := resumeRecord{ETag: etag, Mode: ze.Mode}
+		if done {
+			rec.Done, rec.CRC = true, ze.CRC.Sum32()
+		}
+		session := opts.session
+		mu.Lock()
+		if len(session.resume) < len(session.Files) {
+			session.resume = append(session.resume, make([]resumeRecord, len(session.Files)-len(session.resume))...)
+		}
+		session.resume[index] = rec
+		mu.Unlock()
+	}
+
+	probes := make(probeCache)
+	dedupe := newDedupeCache(opts.spool, files)
+	defer dedupe.cleanup()
+
+	// Giới hạn MaxFileBytes/MaxArchiveBytes theo byte chưa nén. Khi archive đầy, các file từ
+	// limitFrom trở đi bị bỏ qua và zip vẫn được đóng bình thường
+	limitFrom := -1
+	fitsLimits := func(index int, fileURL string, n int64) (bool, error) {
+		if MaxFileBytes > 0 && n > MaxFileBytes {
+			slog.WarnContext(ctx, "Rejected file: exceeds MaxFileBytes", "token", token, "url", fileURL, "bytes", n)
+			return false, failEntry(index, fileURL, &tooLargeError{What: "file", Limit: MaxFileBytes})
+		}
+		if MaxArchiveBytes > 0 && n >= 0 && progress.bytesWritten.Load()+n > MaxArchiveBytes {
+			limitFrom = index
+			return false, nil
+		}
+		return true, nil
+	}
+
+	// writeCached ghi entry từ nội dung đã tải trước đó trong cùng archive, trả false nếu cache
+	// không dùng được
+	writeCached := func(index int, cached *cachedContent, entry FileEntry, fileURL string) (bool, error) {
+		f, err := os.Open(cached.path)
+		if err != nil {
+			slog.WarnContext(ctx, "Dedupe cache unavailable", "token", token, "url", fileURL, "error", err)
+			return false, nil
+		}
+		defer f.Close()
+
+		err = checkContentType(entry, cached.header, func() string { return sniffFile(f) })
+		if err == nil {
+			err = entry.checkSize(cached.size)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected file", "token", token, "url", fileURL, "error", err)
+			return true, failEntry(index, fileURL, err)
+		}
+
+		if ok, err := fitsLimits(index, fileURL, cached.size); !ok {
+			return true, err
+		}
+
+		fileName, err := entryName(entry, cached.name)
+		if err != nil {
+			return true, failEntry(index, fileURL, err)
+		}
+		slog.InfoContext(ctx, "Reusing", "token", token, "url", fileURL, "name", fileName, "bytes_saved", cached.size)
+		progress.setCurrentFile(fileName)
+
+		ze := zipEntry{Name: fileName, Mode: entryMode(entry, cached.header), Time: lastModified(cached.header), ContentType: cached.header.Get("Content-Type")}
+		if opts.resumable {
+			ze = resumeEntry(entry, ze.Mode, opts.createdAt)
+			ze.CRC = crc32.NewIEEE()
+		}
+		recordResume(index, ze, strongETag(cached.header), false)
+		body, hasher := hashBody(f, opts.checksums, entry)
+		err = archive.writeEntry(ze, cached.size, body, progress)
+		if err == nil {
+			err = entry.checkChecksum(hasher.sum())
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Error streaming", "token", token, "url", fileURL, "error", err)
+			return true, failEntry(index, fileURL, err)
+		}
+		recordResume(index, ze, strongETag(cached.header), true)
+		progress.reuse(index, fileName, fileURL, cached.size, hasher.sum())
+		return true, nil
+	}
+
+	// fetchEntry thử lần lượt URL chính và các mirror cho tới khi thành công, mỗi URL retry
+	// theo policy của file. Chạy song song trong pool prefetch nên chỉ dùng state an toàn đồng thời
+	var probesMu sync.Mutex
+	fetchEntry := func(parent context.Context, i int) *prefetched {
+		entry := files[i]
+		res := &prefetched{timeout: opts.deadlines.fileDeadline(opts.startedAt, time.Now(), len(files)-i)}
+		res.ctx, res.cancel = context.WithTimeout(withForwardHeaders(parent, entry.headers), res.timeout)
+
+		candidates := entry.sources()
+		if opts.mirrorStrategy == "fastest" && len(candidates) > 1 {
+			probesMu.Lock()
+			candidates = rankMirrors(res.ctx, candidates, probes)
+			probesMu.Unlock()
+		}
+		policy := entry.retryPolicy(opts.retry)
+		hedge := hedges.forEntry()
+		for _, res.fileURL = range candidates {
+			var n int
+			res.fileName, res.resp, n, res.err = fetchWithRetry(res.ctx, res.fileURL, policy, hedge)
+			res.attempts += n
+			if res.err == nil {
+				res.resp.Body = newReadahead(res.resp.Body, PrefetchBufferBytes)
+				break
+			}
+			if parent.Err() != nil {
+				break // Download bị hủy, không phải lỗi của file
+			}
+			slog.WarnContext(ctx, "Error fetching", "token", token, "url", res.fileURL, "error", res.err)
+		}
+		return res
+	}
+
+	// URL giống hệt một entry trước đó được lấy từ dedupe cache nên không prefetch;
+	// entry client đã có khi resume cũng vậy
+	seenURLs := make(map[string]bool)
+	skipPrefetch := make([]bool, len(files))
+	for i, f := range files {
+		u := strings.TrimSpace(f.URL) + headersFingerprint(f.headers)
+		skipPrefetch[i] = i < plan.Boundary || seenURLs[u]
+		seenURLs[u] = true
+	}
+	prefetch := startPrefetch(ctx, len(files), func(i int) bool { return skipPrefetch[i] }, fetchEntry)
+	defer prefetch.close()
+
+	// Context riêng của file đang xử lý, hủy khi sang file tiếp theo
+	cancelFile := context.CancelFunc(func() {})
+	defer func() { cancelFile() }()
+
+	for i, entry := range files {
+		if limitFrom >= 0 {
+			break
+		}
+		// Check context trước mỗi file
+		if ctx.Err() != nil {
+			return stopped()
+		}
+		prefetch.finish()
+
+		// Entry client đã có khi resume: ghi lại từ bản ghi (chỉ để đúng offset và central directory)
+		if i < plan.Boundary {
+			rec := records[i]
+			h, err := rawEntryHeader(resumeEntry(entry, rec.Mode, opts.createdAt), opts.archive, rec.CRC, entry.resolvedSize)
+			if err == nil {
+				err = writeRawEntry(archive.(*zipArchive).zw, h) // Resume stream chỉ có với zip
+			}
+			if err != nil {
+				if err := failEntry(i, entry.URL, err); err != nil {
+					return err
+				}
+				continue
+			}
+			progress.complete(i, entry.resolvedName, entry.URL, entry.resolvedSize, "")
+			continue
+		}
+
+		cancelFile()
+
+		// URL giống hệt một entry trước đó: không fetch lại
+		key := entry.sourceKey()
+		var fetched *prefetched
+		if prefetch.has(i) {
+			fetched = prefetch.take(i)
+		} else {
+			if cached := dedupe.exact(key, entry.URL); cached != nil {
+				if ok, err := writeCached(i, cached, entry, entry.URL); err != nil {
+					return err
+				} else if ok {
+					continue
+				}
+			}
+			fetched = fetchEntry(ctx, i)
+		}
+		cancelFile = fetched.cancel
+		fileCtx, fileTimeout := fetched.ctx, fetched.timeout
+		fileURL, fileName, resp, attempts, err := fetched.fileURL, fetched.fileName, fetched.resp, fetched.attempts, fetched.err
+		if err != nil {
+			if err := failEntry(i, fileURL, fileDeadlineError(err, fileCtx, ctx, fileTimeout)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Resume giữa một entry: nguồn phải còn đúng bản client đã nhận một phần
+		if i == plan.Boundary && plan.Partial {
+			if etag := strongETag(resp.Header); etag != records[i].ETag {
+				resp.Body.Close()
+				slog.WarnContext(ctx, "Rejected resume: source changed", "token", token, "url", fileURL, "etag", etag, "was", records[i].ETag)
+				return &resumeChangedError{Index: i}
+			}
+		}
+
+		// Cùng object (URL đã chuẩn hóa + ETag) nhưng khác chữ ký: dùng lại bytes đã tải
+		if cached := dedupe.matchETag(key, resp); cached != nil {
+			ok, err := writeCached(i, cached, entry, fileURL)
+			if ok || err != nil {
+				resp.Body.Close()
+			}
+			if err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+		}
+
+		// Kiểm tra Content-Type mong đợi, peek 512 byte đầu để sniff khi cần
+		var body io.Reader = resp.Body
+		if entry.ExpectContentType != "" {
+			br := bufio.NewReaderSize(resp.Body, sniffLen)
+			sniff := func() string {
+				b, _ := br.Peek(sniffLen)
+				return http.DetectContentType(b)
+			}
+			if err := checkContentType(entry, resp.Header, sniff); err != nil {
+				slog.WarnContext(ctx, "Rejected file", "token", token, "url", fileURL, "error", err)
+				resp.Body.Close()
+				if err := failEntry(i, fileURL, err); err != nil {
+					return err
+				}
+				continue
+			}
+			body = br
+		}
+
+		// Kiểm tra dung lượng mong đợi: theo Content-Length nếu có (và đếm lại lúc copy phòng
+		// origin báo sai), nếu không thì spool ra file tạm và kiểm tra trước khi ghi vào zip
+		var sizeCounter *countingReader
+		releaseSized := func() {}
+		if entry.hasSizeCheck() {
+			var err error
+			if resp.ContentLength >= 0 {
+				err = entry.checkSize(resp.ContentLength)
+				sizeCounter = &countingReader{r: body}
+				body = sizeCounter
+			} else {
+				var f *os.File
+				f, releaseSized, err = spoolForSizeCheck(opts.spool, entry, body)
+				body = f
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Rejected file", "token", token, "url", fileURL, "error", err)
+				resp.Body.Close()
+				if err := failEntry(i, fileURL, err); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if ok, err := fitsLimits(i, fileURL, resp.ContentLength); !ok {
+			resp.Body.Close()
+			releaseSized()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		body = limitBody(body, progress.bytesWritten.Load())
+
+		baseName := fileName
+		fileName, err = entryName(entry, fileName)
+		if err != nil {
+			slog.WarnContext(ctx, "Rejected file", "token", token, "url", fileURL, "error", err)
+			resp.Body.Close()
+			releaseSized()
+			if err := failEntry(i, fileURL, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if attempts > 1 {
+			slog.InfoContext(ctx, "Streaming", "token", token, "url", fileURL, "name", fileName, "attempts", attempts)
+		} else {
+			slog.InfoContext(ctx, "Streaming", "token", token, "url", fileURL, "name", fileName)
+		}
+		progress.setCurrentFile(fileName)
+
+		size := resp.ContentLength
+		if size < 0 {
+			size = entry.resolvedSize
+		}
+		body, finish := dedupe.capture(key, entry.URL, baseName, size, resp.Header, body)
+		ze := zipEntry{Name: fileName, Mode: entryMode(entry, resp.Header), Time: lastModified(resp.Header), ContentType: resp.Header.Get("Content-Type")}
+		if opts.resumable {
+			ze = resumeEntry(entry, ze.Mode, opts.createdAt)
+			ze.CRC = crc32.NewIEEE()
+		}
+		recordResume(i, ze, strongETag(resp.Header), false)
+		body, hasher := hashBody(body, opts.checksums, entry)
+		written := progress.bytesWritten.Load()
+		err = archive.writeEntry(ze, resp.ContentLength, body, progress)
+		if err == nil && sizeCounter != nil {
+			err = entry.checkSize(sizeCounter.n)
+		}
+		if err == nil {
+			err = entry.checkChecksum(hasher.sum())
+		}
+		resp.Body.Close()
+		releaseSized()
+		finish(err == nil)
+		var tooLarge *tooLargeError
+		if errors.As(err, &tooLarge) && tooLarge.What == "archive" {
+			progress.fail(i, fileURL, err)
+			limitFrom = i + 1
+			continue
+		}
+		if err != nil {
+			err = fileDeadlineError(err, fileCtx, ctx, fileTimeout)
+			slog.WarnContext(ctx, "Error streaming", "token", token, "url", fileURL, "error", err)
+			if err := failEntry(i, fileURL, err); err != nil {
+				return err
+			}
+			continue
+		}
+		recordResume(i, ze, strongETag(resp.Header), true)
+		progress.complete(i, fileName, fileURL, progress.bytesWritten.Load()-written, hasher.sum())
+	}
+	progress.setCurrentFile("")
+	if ctx.Err() != nil {
+		// File cuối bị hủy giữa chừng vì server tắt, session bị thu hồi, client hủy hoặc hết tổng
+		// thời gian: không đóng archive như thể đã xong
+		return stopped()
+	}
+
+	if limitFrom >= 0 {
+		prefetch.close()
+		for j := limitFrom; j < len(files); j++ {
+			progress.fail(j, files[j].URL, &tooLargeError{What: "archive", Limit: MaxArchiveBytes})
+		}
+		slog.WarnContext(ctx, "Archive limit reached", "token", token, "files_skipped", len(files)-limitFrom, "files", len(files))
+	}
+
+	if opts.checksums {
+		if err := writeChecksums(archive, namer.unique(checksumsName), progress.fileResults()); err != nil {
+			slog.ErrorContext(ctx, "Failed to write checksums", "token", token, "error", err)
+		}
+	}
+
+	// File bị bỏ qua (onError skip) được liệt kê trong ERRORS.txt (hoặc manifest.json) để người dùng
+	// biết archive thiếu file
+	if opts.errorReport == "json" {
+		report := progress.report(resultStatus("completed", false, int(progress.filesFailed.Load())))
+		if err := writeManifest(archive, namer.unique("manifest.json"), report); err != nil {
+			slog.ErrorContext(ctx, "Failed to write manifest", "token", token, "error", err)
+		}
+	} else if failures := progress.failureReport(); len(failures) > 0 && !opts.placeholders {
+		summary := fmt.Sprintf("Archive incomplete: %d of %d files could not be downloaded", len(failures), len(files))
+		if err := writeErrorsReport(archive, namer.unique("ERRORS.txt"), summary, failures); err != nil {
+			slog.ErrorContext(ctx, "Failed to write errors report", "token", token, "error", err)
+		}
+	}
+	archive.Close()
+
+	if hedges != nil && hedges.fired.Load() > 0 {
+		slog.InfoContext(ctx, "Hedged requests", "token", token, "fired", hedges.fired.Load(), "won", hedges.won.Load(),
+			"server_fired", hedgesFired.Load(), "server_won", hedgesWon.Load())
+	}
+	slog.InfoContext(ctx, "Download completed", "token", token, "files", len(files), "files_failed", progress.filesFailed.Load(),
+		"bytes", progress.bytesWritten.Load(), "bytes_deduplicated", progress.bytesDeduplicated.Load(), "duration_ms", time.Since(opts.startedAt).Milliseconds())
+	return nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ============== ARCHIVE ARTIFACTS ==============
+
+// Session resumable với resumableMode "file": GET đầu tiên dựng archive ra file spool (qua chính
+// buildArchive của luồng stream thường), các lần sau phục vụ file đó bằng http.ServeContent nên
+// mọi dạng Range/If-Range đều được. Session giữ tới hết TTL, file bị xóa cùng session. Với
+// DataDir, trạng thái dựng được lưu cùng bản ghi session để restart không làm mất archive đã dựng
+// (xem restoreArtifactLocked).
+
+// archiveArtifact là archive đã/đang dựng của một session
+type archiveArtifact struct {
+	done    chan struct{} // Đóng khi dựng xong, thành công hay lỗi
+	path    string
+	size    int64
+	etag    string
+	modTime time.Time
+	err     error
+	token   string // Token mang tên file (đổi khi rotate), được đánh dấu trong spoolActive
+
+	partBytes int64    // Kích thước đoạn lúc dựng, xem ArtifactPartBytes
+	partSums  []string // SHA-256 hex của từng đoạn partBytes
+}
+
+// artifactRecord là trạng thái artifact trong bản ghi session của backend không dùng chung
+type artifactRecord struct {
+	State   string          `json:"state"` // building hoặc ready
+	Path    string          `json:"path,omitempty"`
+	Size    int64           `json:"size,omitempty"`
+	ETag    string          `json:"etag,omitempty"`
+	ModTime time.Time       `json:"mod_time,omitzero"`
+	Report  *downloadReport `json:"report,omitempty"` // Kết quả từng file của lần dựng, cho /status và /result
+
+	PartBytes  int64    `json:"part_bytes,omitempty"`
+	PartSHA256 []string `json:"part_sha256,omitempty"`
+}
+
+// finished trả về true khi đã dựng xong (kể cả lỗi)
+func (a *archiveArtifact) finished() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// artifactWriter ghi archive ra file, băm nội dung để làm ETag
+type artifactWriter struct {
+	f     *os.File
+	hash  hash.Hash
+	parts *partHasher
+	n     int64
+}
+
+func (w *artifactWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.hash.Write(p[:n])
+	w.parts.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter đếm byte ServeContent gửi đi và giữ status cho analytics
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *countingResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeArchiveBuilding trả 202 khi archive đang được dựng (hoặc chưa dựng với HEAD)
+func writeArchiveBuilding(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(ArtifactRetryAfter.Seconds())), 1)))
+	localizedError(w, r, http.StatusAccepted, "archive_building")
+}
+
+// serveArtifact phục vụ session resumableMode "file": dựng artifact nếu chưa có (request này chờ
+// tới khi xong), request khác trong lúc dựng nhận 202. part > 0 chỉ trả đoạn part của plan, plan trả
+// chính plan. Gọi khi giữ mu.Lock, trả về khi đã unlock
+func serveArtifact(w http.ResponseWriter, r *http.Request, session *Session, token string, part int, plan bool) {
+	a := session.artifact
+	switch {
+	case a == nil && (r.Method == http.MethodHead || plan), a != nil && !a.finished():
+		if a == nil && plan {
+			// Plan cần archive đã dựng: bắt đầu dựng để client hỏi lại sau Retry-After
+			startArtifactBuild(r.Context(), session, token)
+		}
+		session.recordAttempt(r, "building", 0)
+		mu.Unlock()
+		writeArchiveBuilding(w, r)
+		return
+	case a == nil:
+		session.touch(time.Now())
+		a = startArtifactBuild(r.Context(), session, token)
+		mu.Unlock()
+		select {
+		case <-a.done:
+		case <-r.Context().Done():
+			// Client bỏ đi: archive vẫn được dựng tiếp cho lần tải sau
+			return
+		}
+		mu.Lock()
+	}
+	if a.err != nil {
+		session.recordAttemptReason(r, "failed", a.err.Error(), 0)
+		mu.Unlock()
+		http.Error(w, "Failed to build archive", http.StatusBadGateway)
+		return
+	}
+	if plan {
+		writePartPlan(w, r, session, token, a)
+		return
+	}
+	if part > len(a.partSums) {
+		session.recordAttempt(r, "bad_part", 0)
+		mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	session.touch(time.Now())
+	zipName := session.ZipName
+	contentType := session.ContentType
+	disposition := session.Disposition
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	session.active++
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		releaseSessionLocked(session, time.Now())
+		mu.Unlock()
+	}()
+
+	f, err := os.Open(a.path)
+	if err != nil {
+		// Session vừa bị xóa và file đã bị dọn
+		localizedError(w, r, http.StatusGone, "session_expired")
+		return
+	}
+	defer f.Close()
+
+	// Một đoạn của plan: file riêng {zipName}.001, .002... ghép lại theo thứ tự ra đúng archive
+	var content io.ReadSeeker = f
+	size, etag := a.size, a.etag
+	if part > 0 {
+		off, length := a.partRange(part)
+		content, size, etag = io.NewSectionReader(f, off, length), length, `"`+a.partSums[part-1]+`"`
+		contentType, zipName = "application/octet-stream", fmt.Sprintf("%s.%03d", zipName, part)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, zipName))
+	setArchiveValidators(w, etag, a.modTime) // ServeContent xét If-None-Match/If-Modified-Since trên đúng các giá trị này
+	cw := &countingResponseWriter{ResponseWriter: throttleResponse(r.Context(), w)}
+	http.ServeContent(cw, r, "", a.modTime, content)
+
+	outcome := "completed"
+	switch {
+	case cw.status == http.StatusNotModified:
+		outcome = "not_modified"
+	case cw.status >= 400:
+		outcome = "bad_range"
+	case cw.n < size && cw.status != http.StatusPartialContent && r.Method != http.MethodHead:
+		outcome = "interrupted"
+	}
+	bytesStreamed.Add(cw.n)
+	mu.Lock()
+	session.recordAttempt(r, outcome, cw.n)
+	mu.Unlock()
+}
+
+// prebuildLocked bắt đầu dựng artifact của session prebuild ngay, không chờ GET đầu tiên. Phải giữ mu.Lock
+func (s *Session) prebuildLocked(token string) {
+	if s.Prebuild && s.artifact == nil {
+		s.buildInBackgroundLocked(token)
+	}
+}
+
+// buildInBackgroundLocked dựng artifact khi không có request của client. Phải giữ mu.Lock
+func (s *Session) buildInBackgroundLocked(token string) {
+	startArtifactBuild(context.Background(), s, token)
+}
+
+// startArtifactBuild dựng artifact trong nền, không hủy theo client của ctx. Phải giữ mu.Lock
+func startArtifactBuild(ctx context.Context, session *Session, token string) *archiveArtifact {
+	a := &archiveArtifact{done: make(chan struct{})}
+	session.artifact = a
+	session.started = true
+	persistSessionLocked(session)
+
+	// Dựng cả archive dù request là part hay plan; không tiêu thụ session
+	files := append([]FileEntry(nil), session.Files...)
+	opts := session.buildOptionsLocked(token)
+	opts.client = context.Background()
+	zipName, webhook := session.ZipName, session.Webhook
+	ctx, finish := session.startDownloadLocked(context.WithoutCancel(ctx), true)
+
+	slog.InfoContext(ctx, "Building archive", "token", token)
+	go func() {
+		a.err = runArtifactBuild(ctx, a, files, opts, zipName, webhook)
+		finish()
+
+		// done được đóng khi còn giữ mu để dropArtifactLocked luôn thấy đúng trạng thái, và trước
+		// khi ghi bản ghi để bản ghi thấy kết quả dựng
+		mu.Lock()
+		defer mu.Unlock()
+		close(a.done)
+		switch {
+		case a.err != nil:
+			slog.ErrorContext(ctx, "Failed to build archive", "token", token, "error", a.err)
+			if session.artifact == a {
+				session.artifact = nil
+				persistSessionLocked(session)
+			}
+		case session.artifact != a:
+			// Session bị xóa trong lúc dựng
+			a.removeFile()
+		default:
+			if session.token != a.token {
+				// Session được rotate trong lúc dựng
+				a.renameLocked(session.token)
+			}
+			slog.InfoContext(ctx, "Built archive", "token", token, "bytes", a.size)
+			persistSessionLocked(session)
+		}
+	}()
+	return a
+}
+
+// runArtifactBuild dựng archive của files bằng buildArchive, ghi ra file spool của a
+func runArtifactBuild(ctx context.Context, a *archiveArtifact, files []FileEntry, opts buildOptions, zipName string, webhook *WebhookConfig) error {
+	var estimate int64
+	for _, f := range files {
+		estimate += f.resolvedSize
+	}
+	// Chỗ cho cả file archive lẫn file tạm của luồng dựng (dedupe, kiểm tra dung lượng)
+	spool, err := reserveSpool(opts.token, estimate+spoolEstimate(files))
+	if err != nil {
+		return err
+	}
+	defer spool.close()
+	f, release, err := createSpoolFile(spool, "archive", estimate)
+	if err != nil {
+		return err
+	}
+
+	opts.spool, opts.startedAt = spool, time.Now()
+	opts.progress = newDownloadProgress(len(files))
+	opts.progress.setTotalBytes(knownTotalBytes(files))
+	finishProgress := opts.session.watchProgress(opts.progress)
+	reporter := startWebhookReporter(opts.token, zipName, webhook, opts.progress)
+
+	aw := &artifactWriter{f: f, hash: sha256.New(), parts: &partHasher{size: ArtifactPartBytes}}
+	err = buildArchive(ctx, aw, files, opts)
+	outcome, _ := buildOutcome(err)
+	reporter.finish(outcome, false)
+	finishProgress(outcome, false)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		release()
+		return err
+	}
+
+	aw.parts.finish()
+	a.path, a.token, a.size, a.modTime = f.Name(), opts.token, aw.n, time.Now()
+	a.partBytes, a.partSums = ArtifactPartBytes, aw.parts.sums
+	a.etag = `"` + hex.EncodeToString(aw.hash.Sum(nil)[:16]) + `"`
+	return nil
+}
+
+// dropArtifactLocked xóa artifact của session; artifact đang dựng được xóa khi dựng xong. Phải giữ mu.Lock
+func (s *Session) dropArtifactLocked() {
+	a := s.artifact
+	if a == nil {
+		return
+	}
+	s.artifact = nil
+	if a.finished() && a.err == nil {
+		a.removeFile()
+	}
+}
+
+// removeFile xóa file archive đã dựng, trả phần spool đã đặt trước và bỏ đánh dấu với sweeper
+func (a *archiveArtifact) removeFile() {
+	os.Remove(a.path)
+	spoolMu.Lock()
+	releaseSpoolClaimLocked(a.path)
+	spoolMu.Unlock()
+	releaseSpool(a.token)
+}
+
+// renameLocked đổi file archive đã dựng sang tên của newToken khi session được rotate, để bản ghi
+// và restoreArtifactLocked (chỉ giữ file mang token của session) vẫn nhận ra nó. Phải giữ mu
+func (a *archiveArtifact) renameLocked(newToken string) {
+	path, err := renameSpoolFile(a.path, a.token, newToken)
+	if err != nil {
+		slog.Error("Failed to rename archive", "file", a.path, "token", newToken, "error", err)
+		return
+	}
+	a.path, a.token = path, newToken
+}
+
+// artifactSize trả dung lượng archive đã dựng, 0 nếu chưa có. Phải giữ mu
+func (s *Session) artifactSize() int64 {
+	if a := s.artifact; a != nil && a.finished() && a.err == nil {
+		return a.size
+	}
+	return 0
+}
+
+// artifactRecordLocked là trạng thái artifact để ghi vào bản ghi session, nil nếu chưa dựng hoặc
+// dựng lỗi (GET sau sẽ dựng lại). Phải giữ mu
+func (s *Session) artifactRecordLocked() *artifactRecord {
+	a := s.artifact
+	switch {
+	case a == nil:
+		return nil
+	case !a.finished():
+		return &artifactRecord{State: "building"}
+	case a.err != nil:
+		return nil
+	}
+	rec := &artifactRecord{State: "ready", Path: a.path, Size: a.size, ETag: a.etag, ModTime: a.modTime, PartBytes: a.partBytes, PartSHA256: a.partSums}
+	if p := s.progress; p != nil && s.progressOutcome == "completed" {
+		report := p.report(resultStatus(s.progressOutcome, false, int(p.filesFailed.Load())))
+		rec.Report = &report
+	}
+	return rec
+}
+
+// restoreArtifactLocked đối chiếu artifact đã lưu của session vừa nạp lúc khởi động: archive đã
+// dựng xong còn nguyên trên đĩa được phục vụ lại mà không fetch lại origin, bản đang dựng dở (hoặc
+// file đã mất) bị xóa và xếp lại để dựng từ đầu. Phải giữ mu.Lock
+func (s *Session) restoreArtifactLocked(token string, rec *artifactRecord) {
+	keep := ""
+	if rec != nil && rec.State == "ready" {
+		info, err := os.Stat(rec.Path)
+		matched, _ := filepath.Match(spoolFilePattern(token, "archive"), filepath.Base(rec.Path))
+		parts := len(rec.PartSHA256) == artifactPartCount(rec.Size, rec.PartBytes)
+		if err == nil && matched && parts && info.Mode().IsRegular() && info.Size() == rec.Size {
+			keep = rec.Path
+		} else {
+			slog.Warn("Archive artifact missing, rebuilding", "token", token, "file", rec.Path)
+		}
+	}
+	removeSpoolFiles(token, "archive", keep)
+
+	switch {
+	case keep != "":
+		a := &archiveArtifact{
+			done: make(chan struct{}), path: rec.Path, size: rec.Size, etag: rec.ETag, modTime: rec.ModTime,
+			partBytes: rec.PartBytes, partSums: rec.PartSHA256, token: token,
+		}
+		close(a.done)
+		acquireSpool(token)
+		s.artifact = a
+		if rec.Report != nil {
+			s.progress, s.progressOutcome = progressFromReport(*rec.Report), "completed"
+		}
+		slog.Info("Restored archive", "token", token, "bytes", rec.Size)
+	case rec != nil:
+		slog.Info("Requeued archive build", "token", token, "state", rec.State)
+		s.buildInBackgroundLocked(token)
+	default:
+		s.prebuildLocked(token)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useDataDir bật backend file và đặt spool trong thư mục tạm của test
+func useDataDir(t *testing.T) {
+	dataDir, spoolDir, b := DataDir, SpoolDir, backend
+	DataDir, SpoolDir, backend = t.TempDir(), t.TempDir(), fileBackend{}
+	t.Cleanup(func() {
+		mu.Lock()
+		for token, s := range sessions {
+			s.artifact = nil
+			forgetSessionLocked(token)
+		}
+		DataDir, SpoolDir, backend = dataDir, spoolDir, b
+		mu.Unlock()
+	})
+}
+
+// restart mô phỏng process khởi động lại: bộ nhớ mất hết (session, artifact đang dựng, sổ spool),
+// bản ghi trong DataDir và file spool còn lại, rồi chạy các bước khởi động như main
+func restart(t *testing.T) {
+	t.Helper()
+	mu.Lock()
+	for token, s := range sessions {
+		s.artifact = nil // Không xóa file: process cũ chết chứ không dọn
+		forgetSessionLocked(token)
+	}
+	mu.Unlock()
+	spoolMu.Lock()
+	clear(spoolActive)
+	spoolMu.Unlock()
+
+	if err := loadPersistedSessions(); err != nil {
+		t.Fatal(err)
+	}
+	pruneSessionFiles()
+	sweepOrphanSpoolFiles()
+}
+
+// waitArtifact chờ artifact của token dựng xong
+func waitArtifact(t *testing.T, token string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.RLock()
+		s := sessions[token]
+		var built bool
+		if s != nil {
+			built = s.artifactSize() > 0
+		}
+		mu.RUnlock()
+		if built {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("archive of %s not built", token)
+}
+
+func archiveFiles(t *testing.T) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(SpoolDir, "*-archive-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// countingOrigin trả nội dung theo path và đếm số request; gate (nếu có) chặn request đầu tiên
+func countingOrigin(t *testing.T, gate chan struct{}) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 && gate != nil {
+			<-gate
+		}
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &hits
+}
+
+func createPrebuilt(t *testing.T, base, origin string, paths ...string) (string, string) {
+	t.Helper()
+	var files []string
+	for _, p := range paths {
+		files = append(files, `{"url":"`+origin+p+`"}`)
+	}
+	link := createSession(t, base, `{"files":[`+strings.Join(files, ",")+`],"resumable":true,"resumableMode":"file","prebuild":true}`)
+	return link, link[strings.LastIndex(link, "/")+1:]
+}
+
+func readRecord(t *testing.T, token string) persistedSession {
+	t.Helper()
+	data, err := os.ReadFile(sessionFilePath(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec persistedSession
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// Restart sau khi dựng xong: archive được phục vụ lại từ file cũ, không fetch lại origin, và kết
+// quả từng file vẫn đọc được qua /result
+func TestArtifactRestartReady(t *testing.T) {
+	useDataDir(t)
+	origin, hits := countingOrigin(t, nil)
+	base := newTestServer(t)
+	link, token := createPrebuilt(t, base, origin.URL, "/a.txt", "/b.txt")
+	waitArtifact(t, token)
+	status, before := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	if rec := readRecord(t, token); rec.Artifact == nil || rec.Artifact.State != "ready" || rec.Artifact.Report == nil {
+		t.Fatalf("record artifact = %+v, want ready with a report", rec.Artifact)
+	}
+	fetched := hits.Load()
+	_, plan := fetchPlan(t, link)
+
+	restart(t)
+	if _, restored := fetchPlan(t, link); fmt.Sprint(restored) != fmt.Sprint(plan) {
+		t.Fatalf("part plan after restart = %+v, want %+v", restored, plan)
+	}
+	status, after := download(t, link)
+	if status != http.StatusOK || !bytes.Equal(after, before) {
+		t.Fatalf("download after restart = %d, %d bytes, want the %d bytes from before", status, len(after), len(before))
+	}
+	if hits.Load() != fetched {
+		t.Fatalf("origin fetched %d times after restart", hits.Load()-fetched)
+	}
+	status, result := download(t, base+"/result/"+token)
+	if status != http.StatusOK || !strings.Contains(string(result), `"files_completed":2`) {
+		t.Fatalf("result after restart = %d %s", status, result)
+	}
+}
+
+// Restart giữa lúc dựng: file dở bị xóa, build được xếp lại và archive vẫn tải được
+func TestArtifactRestartBuilding(t *testing.T) {
+	useDataDir(t)
+	gate := make(chan struct{})
+	origin, _ := countingOrigin(t, gate)
+	defer close(gate) // Thả build của "process cũ" sau khi test xong
+	base := newTestServer(t)
+	link, token := createPrebuilt(t, base, origin.URL, "/a.txt")
+	if rec := readRecord(t, token); rec.Artifact == nil || rec.Artifact.State != "building" {
+		t.Fatalf("record artifact = %+v, want building", rec.Artifact)
+	}
+
+	restart(t)
+	waitArtifact(t, token)
+	if files := archiveFiles(t); len(files) != 1 {
+		t.Fatalf("archive files after rebuild = %v, want only the new one", files)
+	}
+	status, body := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download after restart = %d: %s", status, body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil || len(zr.File) != 1 {
+		t.Fatalf("archive after rebuild: %v", err)
+	}
+}
+
+// File artifact mất trong lúc tắt: build được xếp lại thay vì trả link chết
+func TestArtifactRestartMissingFile(t *testing.T) {
+	useDataDir(t)
+	origin, hits := countingOrigin(t, nil)
+	base := newTestServer(t)
+	link, token := createPrebuilt(t, base, origin.URL, "/a.txt")
+	waitArtifact(t, token)
+	for _, p := range archiveFiles(t) {
+		os.Remove(p)
+	}
+	fetched := hits.Load()
+
+	restart(t)
+	waitArtifact(t, token)
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("download after restart = %d", status)
+	}
+	if hits.Load() == fetched {
+		t.Fatal("archive was not rebuilt")
+	}
+}
+
+// Artifact không còn session (bản ghi đã bị xóa hoặc hết hạn) bị dọn lúc khởi động
+func TestArtifactRestartOrphan(t *testing.T) {
+	useDataDir(t)
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	_, gone := createPrebuilt(t, base, origin.URL, "/a.txt")
+	_, kept := createPrebuilt(t, base, origin.URL, "/b.txt")
+	waitArtifact(t, gone)
+	waitArtifact(t, kept)
+	if err := os.Remove(sessionFilePath(gone)); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * SpoolOrphanAge)
+	for _, p := range archiveFiles(t) {
+		os.Chtimes(p, old, old)
+	}
+
+	restart(t)
+	files := archiveFiles(t)
+	if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), kept) {
+		t.Fatalf("archive files after restart = %v, want only the one of %s", files, kept)
+	}
+}
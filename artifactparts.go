@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"time"
+)
+
+// ============== PARALLEL PART DOWNLOAD ==============
+
+// Archive resumableMode "file" đã dựng được chia thành các đoạn ArtifactPartBytes byte để client tải
+// song song (như aria2/axel): GET /download/{token}/parts trả plan gồm khoảng byte và SHA-256 của
+// từng đoạn, mỗi đoạn tải bằng Range trên link chính hoặc qua /download/{token}/part/{n}. Checksum
+// được tính trong lúc dựng nên plan không phải đọc lại file. Session chia part (partMaxBytes) không
+// thể resumable nên /part/{n} của hai loại không trùng nhau.
+
+// ArtifactPartBytes là kích thước mỗi đoạn của plan (đoạn cuối có thể ngắn hơn), áp dụng cho archive
+// dựng sau khi đổi
+var ArtifactPartBytes int64 = 16 << 20
+
+// partHasher băm nội dung theo từng đoạn size byte khi archive được ghi ra
+type partHasher struct {
+	size int64
+	cur  hash.Hash
+	n    int64 // Số byte đã vào cur
+	sums []string
+}
+
+func (h *partHasher) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		if h.cur == nil {
+			h.cur = sha256.New()
+		}
+		k := min(int64(len(p)), h.size-h.n)
+		h.cur.Write(p[:k])
+		h.n += k
+		p = p[k:]
+		if h.n == h.size {
+			h.finish()
+		}
+	}
+	return total, nil
+}
+
+// finish đóng đoạn đang băm dở, gọi khi archive đã ghi xong
+func (h *partHasher) finish() {
+	if h.n > 0 {
+		h.sums = append(h.sums, hex.EncodeToString(h.cur.Sum(nil)))
+	}
+	h.cur, h.n = nil, 0
+}
+
+// artifactPartCount là số đoạn partBytes của archive size byte
+func artifactPartCount(size, partBytes int64) int {
+	if partBytes <= 0 {
+		return 0
+	}
+	return int((size + partBytes - 1) / partBytes)
+}
+
+// partRange là offset và độ dài của đoạn n (bắt đầu từ 1)
+func (a *archiveArtifact) partRange(n int) (int64, int64) {
+	off := int64(n-1) * a.partBytes
+	return off, min(a.partBytes, a.size-off)
+}
+
+// partPlan là response của GET /download/{token}/parts
+type partPlan struct {
+	Size      int64      `json:"size"`
+	PartBytes int64      `json:"part_bytes"`
+	ETag      string     `json:"etag"` // Gửi kèm If-Range khi tải bằng Range để không ghép đoạn của hai bản dựng
+	Parts     []planPart `json:"parts"`
+}
+
+type planPart struct {
+	N      int    `json:"n"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Range  string `json:"range"` // Giá trị header Range trên download_url
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url"` // /download/{token}/part/{n}, ký như download_url
+}
+
+// writePartPlan trả plan của archive đã dựng xong. Gọi khi giữ mu, trả về khi đã unlock
+func writePartPlan(w http.ResponseWriter, r *http.Request, session *Session, token string, a *archiveArtifact) {
+	count := len(a.partSums)
+	urls := partURLs(r, session.LinkDomain, session.ShortLink, token, session.signedExpiry(), count)
+	session.touch(time.Now())
+	session.recordAttempt(r, "part_plan", 0)
+	mu.Unlock()
+
+	plan := partPlan{Size: a.size, PartBytes: a.partBytes, ETag: a.etag, Parts: make([]planPart, count)}
+	for i := range plan.Parts {
+		off, length := a.partRange(i + 1)
+		plan.Parts[i] = planPart{
+			N:      i + 1,
+			Offset: off,
+			Length: length,
+			Range:  fmt.Sprintf("bytes=%d-%d", off, off+length-1),
+			SHA256: a.partSums[i],
+			URL:    urls[i],
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(plan)
+}
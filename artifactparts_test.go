@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"download-multi-file/client"
+)
+
+func TestPartHasher(t *testing.T) {
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	h := &partHasher{size: 1000}
+	for p := data; len(p) > 0; {
+		k := min(len(p), 333) // Ghi lệch biên đoạn
+		h.Write(p[:k])
+		p = p[k:]
+	}
+	h.finish()
+	var want []string
+	for off := 0; off < len(data); off += 1000 {
+		sum := sha256.Sum256(data[off:min(off+1000, len(data))])
+		want = append(want, hex.EncodeToString(sum[:]))
+	}
+	if fmt.Sprint(h.sums) != fmt.Sprint(want) {
+		t.Fatalf("sums = %v, want %v", h.sums, want)
+	}
+}
+
+// usePartBytes đặt ArtifactPartBytes nhỏ để archive của test có nhiều đoạn
+func usePartBytes(t *testing.T, n int64) {
+	partBytes := ArtifactPartBytes
+	ArtifactPartBytes = n
+	t.Cleanup(func() { ArtifactPartBytes = partBytes })
+}
+
+func fetchPlan(t *testing.T, link string) (int, partPlan) {
+	t.Helper()
+	resp, err := http.Get(link + "/parts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var plan partPlan
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, plan
+}
+
+func TestPartPlan(t *testing.T) {
+	usePartBytes(t, 1000)
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	payload := strings.Repeat("x", 1500)
+	files := `{"url":"` + origin.URL + `/a.txt"},{"url":"` + origin.URL + `/` + payload + `"}`
+
+	// Chưa dựng: /parts bắt đầu dựng và trả 202
+	link := createSession(t, base, `{"files":[`+files+`],"resumable":true,"resumableMode":"file"}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+	if status, _ := fetchPlan(t, link); status != http.StatusAccepted {
+		t.Fatalf("plan before build = %d, want 202", status)
+	}
+	waitArtifact(t, token)
+
+	status, archive := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	status, plan := fetchPlan(t, link)
+	if status != http.StatusOK || plan.Size != int64(len(archive)) || plan.PartBytes != 1000 {
+		t.Fatalf("plan = %d %+v, want the %d byte archive in 1000 byte parts", status, plan, len(archive))
+	}
+	if want := (len(archive) + 999) / 1000; len(plan.Parts) != want || want < 2 {
+		t.Fatalf("plan has %d parts, want %d", len(plan.Parts), want)
+	}
+	var next int64
+	for _, p := range plan.Parts {
+		chunk := archive[p.Offset : p.Offset+p.Length]
+		sum := sha256.Sum256(chunk)
+		if p.Offset != next || p.SHA256 != hex.EncodeToString(sum[:]) || p.Range != fmt.Sprintf("bytes=%d-%d", p.Offset, p.Offset+p.Length-1) {
+			t.Fatalf("part %+v does not match the archive at %d", p, next)
+		}
+		next += p.Length
+
+		resp, err := http.Get(p.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		name := fmt.Sprintf(`filename="files.zip.%03d"`, p.N)
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(body.Bytes(), chunk) || !strings.Contains(resp.Header.Get("Content-Disposition"), name) {
+			t.Fatalf("GET %s = %d %s, %d bytes, want part %d", p.URL, resp.StatusCode, resp.Header.Get("Content-Disposition"), body.Len(), p.N)
+		}
+	}
+	if status, _ := download(t, fmt.Sprintf("%s/part/%d", link, len(plan.Parts)+1)); status != http.StatusNotFound {
+		t.Fatalf("part past the plan = %d, want 404", status)
+	}
+
+	// Session không phải resumableMode "file" không có plan
+	stream := createSession(t, base, `{"files":[`+files+`]}`)
+	if status, _ := fetchPlan(t, stream); status != http.StatusBadRequest {
+		t.Fatalf("plan of a streamed session = %d, want 400", status)
+	}
+}
+
+func TestDownloadParallel(t *testing.T) {
+	usePartBytes(t, 700)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.URL.Path, strings.Repeat(r.URL.Path, 200))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link, token := createPrebuilt(t, base, origin.URL, "/a.txt", "/b.txt", "/c.txt")
+	waitArtifact(t, token)
+	_, archive := download(t, link)
+
+	c := client.New(base)
+	for _, concurrency := range []int{1, 3, 16} {
+		var buf bytes.Buffer
+		n, err := c.DownloadParallel(context.Background(), token, &buf, concurrency)
+		if err != nil || n != int64(len(archive)) || !bytes.Equal(buf.Bytes(), archive) {
+			t.Fatalf("concurrency %d: %d bytes, %v; want the %d byte archive", concurrency, n, err, len(archive))
+		}
+	}
+
+	// Một byte của archive trên đĩa bị hỏng: đoạn chứa nó không khớp checksum
+	mu.RLock()
+	path := sessions[token].artifact.path
+	mu.RUnlock()
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{archive[800] ^ 0xff}, 800)
+	f.Close()
+	if _, err := c.DownloadParallel(context.Background(), link, &bytes.Buffer{}, 4); !errors.Is(err, client.ErrChecksumMismatch) || !strings.Contains(err.Error(), "part 2") {
+		t.Fatalf("download of a corrupted archive = %v, want a checksum mismatch on part 2", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============== API KEYS & SIGNED LINKS ==============
+
+// Đặt bằng flag lúc khởi động; rỗng = tắt, giữ hành vi cũ
+var (
+	APIKeys         []APIKey // --api-keys, --api-keys-file: X-Api-Key hợp lệ cho các API tạo session hoặc thêm file
+	APIKeyRateLimit int      // --api-key-rate-limit: số request mỗi phút của một key, 0 = không giới hạn
+	HMACSecret      string   // --hmac-secret: download_url được ký kèm hạn (?exp=&sig=)
+)
+
+// APIKey là một key được chấp nhận trong X-Api-Key. Name (không phải key) được ghi vào session và log
+type APIKey struct {
+	Name      string
+	Key       string
+	RateLimit int // Số request mỗi phút, 0 = APIKeyRateLimit
+}
+
+var (
+	apiKeyLimitersMu sync.Mutex
+	apiKeyLimiters   = make(map[string]*downloadLimiter) // Theo Name, số key cố định nên không cần dọn
+)
+
+// namedAPIKeys đặt tên key1, key2... cho các key của --api-keys
+func namedAPIKeys(keys []string) []APIKey {
+	named := make([]APIKey, len(keys))
+	for i, k := range keys {
+		named[i] = APIKey{Name: "key" + strconv.Itoa(i+1), Key: k}
+	}
+	return named
+}
+
+// readAPIKeysFile đọc file key: mỗi dòng "name key [requests mỗi phút]", bỏ dòng trống và dòng bắt đầu bằng #
+func readAPIKeysFile(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: want \"name key [rate]\"", i+1)
+		}
+		k := APIKey{Name: fields[0], Key: fields[1]}
+		if len(fields) == 3 {
+			if k.RateLimit, err = strconv.Atoi(fields[2]); err != nil || k.RateLimit < 0 {
+				return nil, fmt.Errorf("line %d: invalid rate %q", i+1, fields[2])
+			}
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// validateAPIKeys kiểm tra tên không trùng, để limiter và log phân biệt được các key
+func validateAPIKeys(keys []APIKey) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Key == "" {
+			return fmt.Errorf("api key %q is empty", k.Name)
+		}
+		if seen[k.Name] {
+			return fmt.Errorf("duplicate api key name %q", k.Name)
+		}
+		seen[k.Name] = true
+	}
+	return nil
+}
+
+// splitList tách danh sách phân cách bằng dấu phẩy (API key, CIDR), bỏ phần tử rỗng
+func splitList(s string) []string {
+	var keys []string
+	for _, k := range strings.Split(s, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// requireAPIKey kiểm tra X-Api-Key khi có APIKeys: thiếu key là 401, key sai là 403, quá giới hạn
+// của key là 429. Trả về tên key ("" khi tắt)
+func requireAPIKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(APIKeys) == 0 {
+		return "", true
+	}
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		w.Header().Set("WWW-Authenticate", `ApiKey header="X-Api-Key"`)
+		http.Error(w, "Missing X-Api-Key", http.StatusUnauthorized)
+		return "", false
+	}
+	found := lookupAPIKey(key)
+	if found < 0 {
+		http.Error(w, "Invalid API key", http.StatusForbidden)
+		return "", false
+	}
+
+	k := APIKeys[found]
+	limit := k.RateLimit
+	if limit == 0 {
+		limit = APIKeyRateLimit
+	}
+	if limit > 0 {
+		apiKeyLimitersMu.Lock()
+		l := apiKeyLimiters[k.Name]
+		if l == nil {
+			l = &downloadLimiter{}
+			apiKeyLimiters[k.Name] = l
+		}
+		allowed, wait := l.allow(limit, time.Now())
+		apiKeyLimitersMu.Unlock()
+		if !allowed {
+			slog.WarnContext(r.Context(), "Throttled request", "api_key", k.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+			http.Error(w, "API key rate limit exceeded, try again later", http.StatusTooManyRequests)
+			return "", false
+		}
+	}
+	return k.Name, true
+}
+
+// lookupAPIKey trả chỉ số của key trong APIKeys, -1 nếu không có. So sánh digest của key để thời
+// gian không phụ thuộc vào key nào hay độ dài của nó
+func lookupAPIKey(key string) int {
+	got := sha256.Sum256([]byte(key))
+	found := -1
+	for i, k := range APIKeys {
+		want := sha256.Sum256([]byte(k.Key))
+		found = subtle.ConstantTimeSelect(subtle.ConstantTimeCompare(got[:], want[:]), i, found)
+	}
+	return found
+}
+
+// requireCreatorOrAdmin cho qua Bearer AdminKey, hoặc X-Api-Key của key đã tạo session (creator
+// là APIKeyName của session). Session tạo khi không bật API key chỉ admin thao tác được
+func requireCreatorOrAdmin(w http.ResponseWriter, r *http.Request, creator string) bool {
+	if _, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); bearer || creator == "" {
+		return requireAdmin(w, r)
+	}
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		w.Header().Set("WWW-Authenticate", `ApiKey header="X-Api-Key"`)
+		http.Error(w, "Missing X-Api-Key", http.StatusUnauthorized)
+		return false
+	}
+	if i := lookupAPIKey(key); i < 0 || APIKeys[i].Name != creator {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// signedExpiry là hạn ghi vào link ký: hạn hiện tại, hoặc trần maxLifetime với
+// session sliding (hạn của nó còn lùi dần). Phải giữ mu
+func (s *Session) signedExpiry() time.Time {
+	if s.SlidingTTL {
+		return s.CreatedAt.Add(s.maxLifetime())
+	}
+	return s.expiresAt()
+}
+
+func downloadSignature(token string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(HMACSecret))
+	mac.Write([]byte(token + "\n" + strconv.FormatInt(exp, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signDownloadURL thêm exp và sig vào link khi có HMACSecret
+func signDownloadURL(link, token string, until time.Time) string {
+	if HMACSecret == "" {
+		return link
+	}
+	exp := until.Unix()
+	return link + "?" + url.Values{"exp": {strconv.FormatInt(exp, 10)}, "sig": {downloadSignature(token, exp)}}.Encode()
+}
+
+// checkDownloadSignature kiểm tra exp/sig của link khi có HMACSecret: thiếu là 401, sai hoặc
+// quá hạn là 403. Chạy trước khi tra token nên không lộ token nào tồn tại
+func checkDownloadSignature(w http.ResponseWriter, r *http.Request, token string) bool {
+	if HMACSecret == "" {
+		return true
+	}
+	q := r.URL.Query()
+	expParam, sig := q.Get("exp"), q.Get("sig")
+	if expParam == "" || sig == "" {
+		localizedError(w, r, http.StatusUnauthorized, "signature_required")
+		return false
+	}
+	exp, err := strconv.ParseInt(expParam, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(downloadSignature(token, exp))) {
+		localizedError(w, r, http.StatusForbidden, "signature_invalid")
+		return false
+	}
+	if time.Now().Unix() > exp {
+		localizedError(w, r, http.StatusForbidden, "signature_expired")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setAuth bật API key và HMACSecret trong test
+func setAuth(t *testing.T, keys []APIKey, secret string) {
+	apiKeys, hmacSecret := APIKeys, HMACSecret
+	APIKeys, HMACSecret = keys, secret
+	t.Cleanup(func() { APIKeys, HMACSecret = apiKeys, hmacSecret })
+}
+
+func signatureStatus(t *testing.T, token, query string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/download/"+token+"?"+query, nil)
+	if checkDownloadSignature(w, r, token) {
+		return http.StatusOK, ""
+	}
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func TestCheckDownloadSignature(t *testing.T) {
+	setAuth(t, nil, "test-hmac-secret")
+	const token = "5d2d4da8-7d89-4efd-a4dd-2e996115bc4d"
+	exp := time.Now().Add(time.Hour).Unix()
+	sig := downloadSignature(token, exp)
+	valid := url.Values{"exp": {strconv.FormatInt(exp, 10)}, "sig": {sig}}
+
+	expired := time.Now().Add(-time.Minute).Unix()
+	tamperedSig := []byte(sig)
+	tamperedSig[0] ^= 1
+	tests := []struct {
+		name   string
+		token  string
+		query  string
+		status int
+		msg    string
+	}{
+		{"valid", token, valid.Encode(), http.StatusOK, ""},
+		{"missing", token, "", http.StatusUnauthorized, catalogs[DefaultLanguage]["signature_required"]},
+		{"missing sig", token, "exp=" + valid.Get("exp"), http.StatusUnauthorized, catalogs[DefaultLanguage]["signature_required"]},
+		{"tampered sig", token, url.Values{"exp": valid["exp"], "sig": {string(tamperedSig)}}.Encode(), http.StatusForbidden, catalogs[DefaultLanguage]["signature_invalid"]},
+		{"extended exp", token, url.Values{"exp": {strconv.FormatInt(exp+3600, 10)}, "sig": {sig}}.Encode(), http.StatusForbidden, catalogs[DefaultLanguage]["signature_invalid"]},
+		{"other token", "7a0c1f5e-2b3d-4e6f-8a9b-0c1d2e3f4a5b", valid.Encode(), http.StatusForbidden, catalogs[DefaultLanguage]["signature_invalid"]},
+		{"bad exp", token, "exp=soon&sig=" + sig, http.StatusForbidden, catalogs[DefaultLanguage]["signature_invalid"]},
+		{"expired", token, url.Values{"exp": {strconv.FormatInt(expired, 10)}, "sig": {downloadSignature(token, expired)}}.Encode(), http.StatusForbidden, catalogs[DefaultLanguage]["signature_expired"]},
+	}
+	for _, tt := range tests {
+		if status, msg := signatureStatus(t, tt.token, tt.query); status != tt.status || msg != tt.msg {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, status, msg, tt.status, tt.msg)
+		}
+	}
+}
+
+func TestCheckDownloadSignatureDisabled(t *testing.T) {
+	setAuth(t, nil, "")
+	if status, msg := signatureStatus(t, "any-token", "sig=garbage&exp=1"); status != http.StatusOK {
+		t.Fatalf("legacy mode: %d %q, want signature ignored", status, msg)
+	}
+}
+
+func postCreateWithKey(t *testing.T, base, key, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, base+"/create", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("X-Api-Key", key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	body := `{"files":[{"url":"` + origin.URL + `/a.txt"}]}`
+
+	// Không cấu hình key hay secret: hành vi cũ, không cần credential và link không ký
+	setAuth(t, nil, "")
+	if status, resp := postCreateWithKey(t, base, "", body); status != http.StatusOK {
+		t.Fatalf("legacy create = %d: %s", status, resp)
+	}
+	link := createSession(t, base, body)
+	if strings.Contains(link, "sig=") {
+		t.Fatalf("legacy link %s is signed", link)
+	}
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("legacy download = %d", status)
+	}
+
+	setAuth(t, []APIKey{{Name: "ci", Key: "ci-secret-key"}}, "test-hmac-secret")
+	for _, tt := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong-key", http.StatusForbidden},
+		{"ci-secret-key", http.StatusOK},
+	} {
+		if status, resp := postCreateWithKey(t, base, tt.key, body); status != tt.status {
+			t.Fatalf("create with key %q = %d: %s, want %d", tt.key, status, resp, tt.status)
+		}
+	}
+
+	_, resp := postCreateWithKey(t, base, "ci-secret-key", body)
+	var created DownloadResponse
+	if err := json.Unmarshal([]byte(resp), &created); err != nil {
+		t.Fatal(err)
+	}
+	link = created.DownloadURL
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("sig") == "" || u.Query().Get("exp") == "" {
+		t.Fatalf("signed link = %s (%v)", link, err)
+	}
+	unsigned := base + u.Path
+	if status, _ := download(t, unsigned); status != http.StatusUnauthorized {
+		t.Fatalf("unsigned download = %d, want 401", status)
+	}
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("signed download = %d, want 200", status)
+	}
+}
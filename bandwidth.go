@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============== BANDWIDTH LIMIT ==============
+
+// Giới hạn băng thông gửi archive tới client, đặt bằng flag lúc khởi động; 0 = không giới hạn.
+// Áp cho download stream và archive phục vụ từ file, không áp cho request tới origin.
+var (
+	MaxStreamBPS int64 // --max-stream-bps: byte/giây tối đa của một download
+	MaxEgressBPS int64 // --max-egress-bps: byte/giây tối đa của mọi download cộng lại
+)
+
+// byteBucket là token bucket theo byte: rate byte mỗi giây, dồn tối đa một giây. An toàn đồng thời
+type byteBucket struct {
+	mu      sync.Mutex
+	rate    float64
+	tokens  float64
+	updated time.Time
+}
+
+var egressBucket *byteBucket // Bucket chung của MaxEgressBPS, nil khi tắt
+
+// newByteBucket trả nil khi rate <= 0 (không giới hạn)
+func newByteBucket(rate int64) *byteBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &byteBucket{rate: float64(rate)}
+}
+
+// reserve lấy n byte và trả thời gian phải chờ trước khi gửi. Bucket được phép âm nên các download
+// dùng chung bucket xếp hàng theo thứ tự reserve thay vì tranh nhau
+func (b *byteBucket) reserve(n int, now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.updated.IsZero() {
+		b.tokens = b.rate
+	} else {
+		b.tokens = math.Min(b.rate, b.tokens+now.Sub(b.updated).Seconds()*b.rate)
+	}
+	b.updated = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttledResponseWriter chia mỗi Write thành các phần nhỏ và chờ bucket của download và bucket
+// chung trước khi gửi từng phần. Hủy ctx (client ngắt, download bị hủy) thì dừng chờ ngay
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	buckets []*byteBucket
+	chunk   int
+}
+
+// throttleResponse bọc w theo MaxStreamBPS và MaxEgressBPS, trả nguyên w khi cả hai đều tắt
+func throttleResponse(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	var buckets []*byteBucket
+	for _, b := range []*byteBucket{newByteBucket(MaxStreamBPS), egressBucket} {
+		if b != nil {
+			buckets = append(buckets, b)
+		}
+	}
+	if len(buckets) == 0 {
+		return w
+	}
+	// Mỗi phần khoảng 1/8 giây của bucket chậm nhất, tối đa 32 KiB, để tốc độ đều
+	chunk := 32 << 10
+	for _, b := range buckets {
+		chunk = min(chunk, max(int(b.rate)/8, 1))
+	}
+	return &throttledResponseWriter{ResponseWriter: w, ctx: ctx, buckets: buckets, chunk: chunk}
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		part := p[:min(len(p), w.chunk)]
+		now := time.Now()
+		var wait time.Duration
+		for _, b := range w.buckets {
+			wait = max(wait, b.reserve(len(part), now))
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-w.ctx.Done():
+				t.Stop()
+				return written, w.ctx.Err()
+			case <-t.C:
+			}
+		}
+		n, err := w.ResponseWriter.Write(part)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush giữ http.Flusher của ResponseWriter gốc
+func (w *throttledResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
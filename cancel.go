@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============== DOWNLOAD CANCELLATION ==============
+
+// Client ngắt kết nối thì download dừng ngay: request tới origin và prefetch bị hủy qua context,
+// file đang dở không bị tính là lỗi, kết quả là "cancelled". DELETE /download/{token} hủy chủ động
+// các download đang chạy của session (trên instance này); session vẫn còn, link dùng lại được.
+
+// cancelledAbortReason là lý do abort của download bị hủy qua DELETE /download/{token}
+const cancelledAbortReason = "download cancelled"
+
+// errDownloadCancelled là cause của context download bị hủy qua DELETE
+var errDownloadCancelled = errors.New(cancelledAbortReason)
+
+// runningDownload là một download đang chạy của session
+type runningDownload struct {
+	cancel   context.CancelCauseFunc
+	internal bool // Dựng artifact: dùng chung cho mọi lần tải nên DELETE không hủy
+}
+
+// startDownloadLocked đăng ký một download của session để rotate --force, thu hồi và DELETE có
+// thể hủy, trả ctx có tổng thời gian của download (mang header forward của session) và hàm gọi
+// khi download xong. Phải giữ mu.Lock
+func (s *Session) startDownloadLocked(parent context.Context, internal bool) (context.Context, func()) {
+	cancelCtx, cancelCause := context.WithCancelCause(withForwardHeaders(parent, s.headers))
+	ctx, cancel := context.WithTimeout(cancelCtx, s.Deadlines.Total)
+	id := downloadSeq.Add(1)
+	if s.downloads == nil {
+		s.downloads = make(map[uint64]runningDownload)
+	}
+	s.downloads[id] = runningDownload{cancel: cancelCause, internal: internal}
+	s.active++
+	return ctx, func() {
+		mu.Lock()
+		delete(s.downloads, id)
+		releaseSessionLocked(s, time.Now())
+		mu.Unlock()
+		cancel()
+		cancelCause(nil)
+	}
+}
+
+// handleCancelDownload hủy các download stream đang chạy của token. Quyền như khi tải: token (kèm
+// chữ ký nếu bật HMACSecret), allowedCIDRs và allowedReferrers của session
+func handleCancelDownload(w http.ResponseWriter, r *http.Request, token string) {
+	mu.Lock()
+	session, ok := sessions[token]
+	if !ok {
+		t, gone := tombstones[token]
+		mu.Unlock()
+		if gone {
+			localizedError(w, r, http.StatusGone, "token_gone", t.Reason)
+			return
+		}
+		localizedError(w, r, http.StatusNotFound, "invalid_token")
+		return
+	}
+	if session.isExpired(time.Now()) {
+		expireSessionLocked(session, time.Now())
+		mu.Unlock()
+		localizedError(w, r, http.StatusGone, "session_expired")
+		return
+	}
+	if len(session.AllowedCIDRs) > 0 {
+		if addr, ok := clientIP(r); !ok || !containsAddr(session.AllowedCIDRs, addr) {
+			mu.Unlock()
+			slog.WarnContext(r.Context(), "Rejected cancel: outside allowedCIDRs", "token", token)
+			localizedError(w, r, http.StatusForbidden, "forbidden_network")
+			return
+		}
+	}
+	if session.Referrers != nil && !session.Referrers.allows(r) {
+		mu.Unlock()
+		slog.WarnContext(r.Context(), "Rejected cancel: referrer not allowed", "token", token, "referrer", r.Referer(), "origin", r.Header.Get("Origin"))
+		localizedError(w, r, http.StatusForbidden, "forbidden_referrer")
+		return
+	}
+	cancelled := 0
+	for id, d := range session.downloads {
+		if d.internal {
+			continue
+		}
+		d.cancel(errDownloadCancelled)
+		delete(session.downloads, id)
+		cancelled++
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"token": token, "cancelled_downloads": cancelled})
+
+	slog.InfoContext(r.Context(), "Cancelled downloads", "token", token, "cancelled_downloads", cancelled)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ============== CHECKSUMS ==============
+
+// checksumsName là entry liệt kê SHA-256 của các file đã ghi, cùng định dạng với sha256sum
+const checksumsName = "checksums.sha256"
+
+func (f FileEntry) validateChecksum() error {
+	if f.SHA256 == "" {
+		return nil
+	}
+	if _, err := hex.DecodeString(f.SHA256); err != nil || len(f.SHA256) != sha256.Size*2 {
+		return errors.New("sha256 must be 64 hex characters")
+	}
+	return nil
+}
+
+// checkChecksum so sánh SHA-256 đã tính với sha256 mong đợi của entry
+func (f FileEntry) checkChecksum(actual string) error {
+	if f.SHA256 == "" || strings.EqualFold(f.SHA256, actual) {
+		return nil
+	}
+	return &mismatchError{What: "sha256", Expected: strings.ToLower(f.SHA256), Actual: actual}
+}
+
+// hashingReader tính SHA-256 của dữ liệu đọc qua
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	return n, err
+}
+
+// sum trả digest dạng hex, "" với hashingReader nil (không cần checksum)
+func (h *hashingReader) sum() string {
+	if h == nil {
+		return ""
+	}
+	return hex.EncodeToString(h.h.Sum(nil))
+}
+
+// hashBody bọc body để tính SHA-256 khi session bật checksums hoặc entry có sha256
+func hashBody(body io.Reader, checksums bool, entry FileEntry) (io.Reader, *hashingReader) {
+	if !checksums && entry.SHA256 == "" {
+		return body, nil
+	}
+	h := &hashingReader{r: body, h: sha256.New()}
+	return h, h
+}
+
+// writeChecksums ghi checksums.sha256 cho các file đã ghi xong, theo vị trí trong archive
+func writeChecksums(aw archiveWriter, name string, results []fileResult) error {
+	var b strings.Builder
+	for _, f := range results {
+		if f.SHA256 != "" {
+			fmt.Fprintf(&b, "%s  %s\n", f.SHA256, f.Name)
+		}
+	}
+	if err := aw.writeText(name, b.String()); err != nil {
+		return err
+	}
+	return aw.Flush()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// ============== DOWNLOAD CLAIMS ==============
+
+type claimConflictResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+func writeDownloadInProgress(w http.ResponseWriter, r *http.Request) {
+	setLanguageHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(claimConflictResponse{
+		Error:   "download_in_progress",
+		Message: localize(r, "download_in_progress"),
+	})
+}
+
+// canClaim cho biết còn lượt để bắt đầu thêm một download tiêu thụ session. Download đang chạy
+// giữ chỗ một lượt nên hai GET đồng thời không cùng dùng lượt cuối. Phải giữ mu.Lock
+func (s *Session) canClaim() bool {
+	return s.MaxDownloads == 0 || s.completed+s.claims < s.MaxDownloads
+}
+
+// sentCounter đếm số byte đã ghi ra response, để biết client đã nhận payload hay chưa
+type sentCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *sentCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestServer chạy server thật trên httptest (download_url trỏ về nó), cho phép fetch origin
+// loopback và tắt giới hạn tạo session theo IP; trả về URL gốc
+func newTestServer(t testing.TB) string {
+	t.Helper()
+	allowPrivate, createRate, publicURL := AllowPrivateNetworks.Load(), CreateRateLimit, PublicURL
+	srv := httptest.NewServer(newServer(Config{}))
+	AllowPrivateNetworks.Store(true)
+	CreateRateLimit, PublicURL = 0, srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		AllowPrivateNetworks.Store(allowPrivate)
+		CreateRateLimit, PublicURL = createRate, publicURL
+	})
+	return srv.URL
+}
+
+// createSession gọi /create với body JSON và trả download_url
+func createSession(t testing.TB, base, body string) string {
+	t.Helper()
+	link, err := postCreate(base, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+// postCreate là createSession cho goroutine khác goroutine của test
+func postCreate(base, body string) (string, error) {
+	resp, err := http.Post(base+"/create", "application/json", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("create = %d: %s", resp.StatusCode, msg)
+	}
+	var created DownloadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	return created.DownloadURL, nil
+}
+
+// TestSingleUseClaimConcurrent: nhiều GET cùng lúc trên link dùng một lần, đúng một request được
+// stream, các request còn lại nhận 409 download_in_progress
+func TestSingleUseClaimConcurrent(t *testing.T) {
+	release := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}]}`)
+
+	const clients = 20
+	type result struct {
+		status int
+		body   []byte
+	}
+	results := make(chan result, clients)
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(link)
+			if err != nil {
+				results <- result{body: []byte(err.Error())}
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			results <- result{resp.StatusCode, body}
+		}()
+	}
+
+	// Request giữ lượt bị chặn ở origin nên mọi request khác phải trả về trước
+	for range clients - 1 {
+		res := <-results
+		if res.status != http.StatusConflict || !bytes.Contains(res.body, []byte("download_in_progress")) {
+			t.Fatalf("concurrent download = %d %q, want 409 download_in_progress", res.status, res.body)
+		}
+	}
+	close(release)
+	res := <-results
+	wg.Wait()
+	if res.status != http.StatusOK {
+		t.Fatalf("claimed download = %d %q, want 200", res.status, res.body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(res.body), int64(len(res.body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "a.txt" {
+		t.Fatalf("archive entries = %v, want a.txt", zr.File)
+	}
+
+	// Lượt duy nhất đã dùng: token bị tiêu thụ
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatalf("download after completion = %d, want 410", resp.StatusCode)
+	}
+}
@@ -0,0 +1,152 @@
+// Package client là SDK Go cho API của download-multi-file: tạo session, theo dõi tiến độ và tải
+// archive mà không phải tự dựng JSON và URL.
+//
+//	c := client.New("http://localhost:8080")
+//	resp, err := c.Create(ctx, &client.CreateRequest{Files: client.URLs("https://example.com/a.pdf")})
+//	...
+//	n, err := c.DownloadToFile(ctx, resp.DownloadURL, "files.zip")
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ============== CLIENT ==============
+
+// Client gọi một server download-multi-file. Các field có thể đổi trước lần gọi đầu tiên
+type Client struct {
+	BaseURL    string       // Ví dụ "http://localhost:8080"
+	APIKey     string       // Gửi qua X-Api-Key khi server bật --api-keys
+	HTTPClient *http.Client // nil = http.DefaultClient; không đặt Timeout nếu tải archive lớn
+}
+
+// New tạo Client cho server tại baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Error là response lỗi của server
+type Error struct {
+	StatusCode int
+	Code       string // Mã lỗi khi server trả JSON, ví dụ "download_in_progress"
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("server returned %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// IsStatus cho biết err là *Error với status code này
+func IsStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do gửi request và trả response 2xx; response lỗi được đọc thành *Error
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.APIKey != "" {
+		req.Header.Set("X-Api-Key", c.APIKey)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readError(resp)
+}
+
+// readError đọc body lỗi: JSON {"error": "...", "message": "..."} hoặc text của http.Error
+func readError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	var j struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, &j) == nil && j.Error != "" {
+		e.Code, e.Message = j.Error, j.Message
+		if e.Message == "" {
+			e.Message = j.Error
+		}
+	}
+	return e
+}
+
+// doJSON gửi in (nil = không có body) và giải mã response vào out
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Create tạo session qua POST /create
+func (c *Client) Create(ctx context.Context, req *CreateRequest) (*CreateResponse, error) {
+	var resp CreateResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/create", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Status đọc tiến độ của download gần nhất trên token qua GET /status/{token}
+func (c *Client) Status(ctx context.Context, token string) (*Status, error) {
+	var resp Status
+	if err := c.doJSON(ctx, http.MethodGet, "/status/"+url.PathEscape(token), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TokenFromURL tách token từ download URL dạng .../download/{token} hoặc .../d/{token}
+func TokenFromURL(downloadURL string) (string, error) {
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return "", err
+	}
+	segs := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for i := len(segs) - 2; i >= 0; i-- {
+		if segs[i] == "download" || segs[i] == "d" {
+			return segs[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no token in %q", downloadURL)
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== DOWNLOAD HELPERS ==============
+
+// Archive là archive đang stream từ server. Người gọi phải Close
+type Archive struct {
+	io.ReadCloser
+	Name        string // filename trong Content-Disposition, "" nếu thiếu
+	ContentType string
+	Size        int64 // Content-Length, -1 khi server stream không biết trước dung lượng
+}
+
+// downloadTarget trả URL tải: link là download URL của /create (kể cả link ký và link ngắn) hoặc
+// chỉ token, khi đó URL được dựng từ BaseURL
+func (c *Client) downloadTarget(link string) string {
+	if strings.Contains(link, "://") {
+		return link
+	}
+	return c.BaseURL + "/download/" + url.PathEscape(link)
+}
+
+// Download bắt đầu tải archive. link là DownloadURL của CreateResponse hoặc token; dùng token khi
+// download URL trỏ tới domain công khai mà client không gọi được
+func (c *Client) Download(ctx context.Context, link string) (*Archive, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.downloadTarget(link), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archive{ReadCloser: resp.Body, ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		a.Name = params["filename"]
+	}
+	return a, nil
+}
+
+// DownloadToWriter tải archive vào w, trả số byte đã ghi. Server cắt kết nối giữa chừng (file lỗi
+// với onError abort, session bị thu hồi, server tắt) trả về lỗi đọc của body
+func (c *Client) DownloadToWriter(ctx context.Context, link string, w io.Writer) (int64, error) {
+	a, err := c.Download(ctx, link)
+	if err != nil {
+		return 0, err
+	}
+	defer a.Close()
+	n, err := io.Copy(w, a)
+	if err == nil && a.Size >= 0 && n != a.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// DownloadToFile tải archive vào path qua file tạm cùng thư mục rồi rename, nên path không bao
+// giờ chứa archive dở dang
+func (c *Client) DownloadToFile(ctx context.Context, link, path string) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.part")
+	if err != nil {
+		return 0, err
+	}
+	n, err := c.DownloadToWriter(ctx, link, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return n, err
+	}
+	return n, nil
+}
+
+// ============== PARALLEL DOWNLOAD ==============
+
+// ErrChecksumMismatch là lỗi khi một đoạn tải về không khớp SHA-256 trong plan
+var ErrChecksumMismatch = errors.New("part checksum mismatch")
+
+// withSubPath nối sub vào path của link, giữ query (exp/sig của link ký)
+func withSubPath(link, sub string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + sub
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// PartPlan đọc plan tải song song của session resumableMode "file". Khi archive còn đang dựng,
+// server trả 202 và PartPlan chờ theo Retry-After rồi hỏi lại tới khi có plan hoặc ctx hết hạn
+func (c *Client) PartPlan(ctx context.Context, link string) (*PartPlan, error) {
+	target, err := withSubPath(c.downloadTarget(link), "/parts")
+	if err != nil {
+		return nil, err
+	}
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			defer resp.Body.Close()
+			var plan PartPlan
+			if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+				return nil, err
+			}
+			return &plan, nil
+		}
+		resp.Body.Close()
+		wait := time.Second
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			wait = time.Duration(s) * time.Second
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type partResult struct {
+	data []byte
+	err  error
+}
+
+// DownloadParallel tải archive resumableMode "file" theo plan, tối đa concurrency đoạn cùng lúc
+// bằng Range trên link, kiểm tra SHA-256 từng đoạn rồi ghi vào w theo thứ tự. Mỗi đoạn được giữ
+// trong bộ nhớ tới lượt ghi, nên dùng tối đa khoảng concurrency × PartBytes byte. Trả số byte đã
+// ghi; lỗi ở một đoạn dừng cả lần tải
+func (c *Client) DownloadParallel(ctx context.Context, link string, w io.Writer, concurrency int) (int64, error) {
+	plan, err := c.PartPlan(ctx, link)
+	if err != nil {
+		return 0, err
+	}
+	target := c.downloadTarget(link)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan partResult, len(plan.Parts))
+	for i := range results {
+		results[i] = make(chan partResult, 1)
+	}
+	// Slot được trả sau khi đoạn đã ghi, không phải khi tải xong, để giới hạn cả bộ nhớ
+	slots := make(chan struct{}, max(concurrency, 1))
+	go func() {
+		for i, p := range plan.Parts {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				data, err := c.fetchPart(ctx, target, plan, p)
+				results[i] <- partResult{data, err}
+			}()
+		}
+	}()
+
+	var n int64
+	for i, p := range plan.Parts {
+		var res partResult
+		select {
+		case res = <-results[i]:
+		case <-ctx.Done():
+			return n, ctx.Err()
+		}
+		if res.err != nil {
+			return n, fmt.Errorf("part %d: %w", p.N, res.err)
+		}
+		m, err := w.Write(res.data)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		<-slots
+	}
+	return n, nil
+}
+
+// fetchPart tải đoạn p bằng Range kèm If-Range: archive đã đổi (dựng lại) thì server trả cả file
+// thay vì 206 và đoạn bị từ chối
+func (c *Client) fetchPart(ctx context.Context, target string, plan *PartPlan, p PlanPart) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", p.Range)
+	req.Header.Set("If-Range", plan.ETag)
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	want := fmt.Sprintf("bytes %d-%d/%d", p.Offset, p.Offset+p.Length-1, plan.Size)
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != want {
+		return nil, fmt.Errorf("server answered %d %q instead of %s, the archive changed since the plan was read", resp.StatusCode, resp.Header.Get("Content-Range"), want)
+	}
+	data := make([]byte, p.Length)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != p.SHA256 {
+		return nil, ErrChecksumMismatch
+	}
+	return data, nil
+}
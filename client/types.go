@@ -0,0 +1,211 @@
+package client
+
+import "time"
+
+// ============== REQUEST / RESPONSE TYPES ==============
+
+// Các kiểu dưới đây khớp JSON của server; xem README cho ý nghĩa và giá trị mặc định của từng field
+
+// File là một file trong CreateRequest. Chỉ cần URL; các field khác là tùy chọn
+type File struct {
+	URL     string            `json:"url"`
+	Name    string            `json:"name,omitempty"` // Đường dẫn entry trong archive, rỗng = lấy từ response
+	Path    string            `json:"path,omitempty"` // Thư mục đặt entry, ghép trước name
+	Mirrors []string          `json:"mirrors,omitempty"`
+	Mode    string            `json:"mode,omitempty"`    // Quyền file dạng octal, ví dụ "0755"
+	Headers map[string]string `json:"headers,omitempty"` // Ghi đè CreateRequest.Headers cho file này
+
+	ExpectContentType string `json:"expectContentType,omitempty"`
+	SniffContentType  bool   `json:"sniffContentType,omitempty"`
+	ExpectSize        *int64 `json:"expectSize,omitempty"`
+	MinSize           *int64 `json:"minSize,omitempty"`
+	MaxSize           *int64 `json:"maxSize,omitempty"`
+	SHA256            string `json:"sha256,omitempty"` // SHA-256 mong đợi dạng hex
+
+	Retries      *int     `json:"retries,omitempty"`
+	RetryBackoff string   `json:"retryBackoff,omitempty"`
+	RetryOn      []string `json:"retryOn,omitempty"`
+}
+
+// Webhook nhận progress và event cuối của download
+type Webhook struct {
+	URL              string `json:"url"`
+	ProgressInterval string `json:"progressInterval,omitempty"` // Ví dụ "30s", rỗng = chỉ gửi event cuối
+}
+
+// CreateRequest là body của POST /create (và /validate, /zip)
+type CreateRequest struct {
+	Files          []File `json:"files"`
+	FilesFromURL   string `json:"filesFromURL,omitempty"`
+	ManifestFormat string `json:"manifestFormat,omitempty"`
+	Template       string `json:"template,omitempty"`
+	ZipName        string `json:"zipName,omitempty"`
+
+	SlidingTTL     bool   `json:"slidingTTL,omitempty"`
+	LinkDomain     string `json:"linkDomain,omitempty"`
+	ShortLink      bool   `json:"shortLink,omitempty"`
+	MirrorStrategy string `json:"mirrorStrategy,omitempty"`
+
+	Webhook     *Webhook `json:"webhook,omitempty"`
+	CallbackURL string   `json:"callbackUrl,omitempty"`
+
+	ASCIINames       bool   `json:"asciiNames,omitempty"`
+	NameConflict     string `json:"nameConflict,omitempty"` // suffix (mặc định), overwrite, error hoặc host
+	TimestampExtras  *bool  `json:"timestampExtras,omitempty"`
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int    `json:"compressionLevel,omitempty"`
+	ArchiveFormat    string `json:"archiveFormat,omitempty"`
+	ErrorReport      string `json:"errorReport,omitempty"`
+	Checksums        bool   `json:"checksums,omitempty"`
+	Password         string `json:"password,omitempty"`
+	GeneratePassword bool   `json:"generatePassword,omitempty"`
+	ResolveNames     bool   `json:"resolveNames,omitempty"`
+	Dedupe           bool   `json:"dedupe,omitempty"`
+
+	OnError             string  `json:"onError,omitempty"`
+	MaxFailureRatio     float64 `json:"maxFailureRatio,omitempty"`
+	MaxFailures         *int    `json:"maxFailures,omitempty"`
+	FailurePlaceholders bool    `json:"failurePlaceholders,omitempty"`
+
+	Open         bool     `json:"open,omitempty"`
+	NotBefore    string   `json:"notBefore,omitempty"` // RFC 3339
+	TTLFrom      string   `json:"ttlFrom,omitempty"`
+	ExpiresIn    string   `json:"expiresIn,omitempty"` // Duration Go, ví dụ "72h"
+	RateLimit    int      `json:"rateLimit,omitempty"`
+	MaxDownloads *int     `json:"maxDownloads,omitempty"` // nil = mặc định của server (1), 0 = tới hết TTL
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Retries      *int     `json:"retries,omitempty"`
+	RetryBackoff string   `json:"retryBackoff,omitempty"`
+	RetryOn      []string `json:"retryOn,omitempty"`
+
+	TotalTimeout   string  `json:"totalTimeout,omitempty"`
+	PerFileTimeout string  `json:"perFileTimeout,omitempty"`
+	FairnessFactor float64 `json:"fairnessFactor,omitempty"`
+	HedgeDelay     string  `json:"hedgeDelay,omitempty"`
+	HedgeBudget    int     `json:"hedgeBudget,omitempty"`
+
+	Resumable     bool   `json:"resumable,omitempty"`
+	ResumableMode string `json:"resumableMode,omitempty"`
+	Prebuild      bool   `json:"prebuild,omitempty"`
+	ContentLength bool   `json:"contentLength,omitempty"`
+
+	PartMaxBytes *int64 `json:"partMaxBytes,omitempty"` // nil = mặc định của server, 0 = không chia
+
+	Disposition string `json:"disposition,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+
+	AllowedReferrers   []string `json:"allowedReferrers,omitempty"`
+	AllowEmptyReferrer *bool    `json:"allowEmptyReferrer,omitempty"`
+	StrictReferrer     bool     `json:"strictReferrer,omitempty"`
+}
+
+// URLs tạo danh sách File chỉ có URL
+func URLs(urls ...string) []File {
+	files := make([]File, len(urls))
+	for i, u := range urls {
+		files[i] = File{URL: u}
+	}
+	return files
+}
+
+// Warning là cảnh báo mềm trả kèm response của /create
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Index   *int   `json:"index,omitempty"` // Vị trí file nếu warning gắn với một file
+}
+
+// CreateResponse là kết quả của POST /create
+type CreateResponse struct {
+	DownloadURL string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	FileNames   []string  `json:"file_names,omitempty"` // Chỉ có với ResolveNames
+	Password    string    `json:"password,omitempty"`   // Chỉ có với GeneratePassword
+	Parts       []string  `json:"parts,omitempty"`      // Link từng part khi archive được chia
+	Warnings    []Warning `json:"warnings,omitempty"`
+}
+
+// Token tách token từ DownloadURL, "" nếu không nhận ra
+func (r *CreateResponse) Token() string {
+	token, _ := TokenFromURL(r.DownloadURL)
+	return token
+}
+
+// ETA là ước lượng thời gian còn lại của download
+type ETA struct {
+	Seconds     float64   `json:"seconds"`
+	CompletesAt time.Time `json:"completes_at"`
+	Basis       string    `json:"basis"`      // bytes hoặc files
+	Confidence  string    `json:"confidence"` // high hoặc low
+}
+
+// FileResult là một entry đã ghi (hoặc lỗi) trong download
+type FileResult struct {
+	Index        int    `json:"index"`
+	Name         string `json:"name,omitempty"`
+	URL          string `json:"url"`
+	Bytes        int64  `json:"bytes"`
+	Error        string `json:"error,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+	Deduplicated bool   `json:"deduplicated,omitempty"`
+}
+
+// FileFailure là một file lỗi trong download
+type FileFailure struct {
+	Index    int    `json:"index"`
+	URL      string `json:"url"`
+	Error    string `json:"error"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+// Status là kết quả của GET /status/{token}
+type Status struct {
+	State           string        `json:"state"` // pending, in_progress, completed, failed hoặc expired
+	FilesTotal      int           `json:"files_total"`
+	FilesCompleted  int64         `json:"files_completed"`
+	FilesFailed     int64         `json:"files_failed"`
+	BytesWritten    int64         `json:"bytes_written"`
+	BytesTotal      int64         `json:"bytes_total,omitempty"`
+	BytesDeduped    int64         `json:"bytes_deduplicated,omitempty"`
+	CurrentFile     string        `json:"current_file,omitempty"`
+	RateBytesPerSec float64       `json:"rate_bytes_per_sec"`
+	ETA             *ETA          `json:"eta,omitempty"`
+	AbortReason     string        `json:"abort_reason,omitempty"`
+	ArchiveBytes    int64         `json:"archive_bytes,omitempty"`
+	Deduplicated    []FileResult  `json:"deduplicated,omitempty"`
+	Errors          []FileFailure `json:"errors,omitempty"`
+	ExpiresAt       *time.Time    `json:"expires_at,omitempty"` // nil khi token đã hết hiệu lực
+}
+
+// Done cho biết download đã kết thúc (hoặc token đã hết hạn), không cần poll tiếp
+func (s *Status) Done() bool {
+	switch s.State {
+	case "completed", "failed", "expired":
+		return true
+	}
+	return false
+}
+
+// PartPlan là kết quả của GET /download/{token}/parts: archive resumableMode "file" đã dựng chia
+// thành các đoạn tải song song
+type PartPlan struct {
+	Size      int64      `json:"size"`
+	PartBytes int64      `json:"part_bytes"`
+	ETag      string     `json:"etag"`
+	Parts     []PlanPart `json:"parts"`
+}
+
+// PlanPart là một đoạn của PartPlan
+type PlanPart struct {
+	N      int    `json:"n"` // Bắt đầu từ 1
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+	Range  string `json:"range"`  // Giá trị header Range trên download URL
+	SHA256 string `json:"sha256"` // SHA-256 hex của đoạn
+	URL    string `json:"url"`    // Link /download/{token}/part/{n}
+}
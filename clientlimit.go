@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============== CLIENT LIMITS ==============
+
+// Giới hạn theo client IP (clientIP, tin X-Forwarded-For từ TrustedProxies) cho API tạo session, và
+// giới hạn số download đang stream của cả server và của từng client. Đặt bằng flag lúc khởi động,
+// 0 = tắt từng giới hạn, --client-limits=false tắt tất cả.
+var (
+	CreateRateLimit        = 60   // --create-rate-limit: số lần tạo session (/create, /zip, clone) mỗi phút của một IP
+	MaxSessionsPerIP       = 1000 // --max-sessions-per-ip: số session còn sống tối đa do một IP tạo
+	MaxConcurrentDownloads = 256  // --max-concurrent-downloads: số download stream cùng lúc của cả server
+	MaxDownloadsPerClient  = 32   // --max-downloads-per-client: số download stream cùng lúc của một client (xem downloadClient)
+)
+
+const DownloadBusyRetryAfter = 5 * time.Second // Retry-After của 429 khi đã đủ MaxConcurrentDownloads hoặc MaxDownloadsPerClient
+
+var errSessionQuota = errors.New("too many active sessions for this client")
+
+var (
+	createLimitersMu sync.Mutex
+	createLimiters   = make(map[netip.Addr]*downloadLimiter) // Bucket /create theo IP, dọn bởi sweepCreateLimiters
+
+	sessionsPerIP    = make(map[netip.Addr]int) // Số session còn sống theo owner. Phải giữ mu
+	streamsPerClient = make(map[string]int)     // Download đang stream theo downloadClient. Phải giữ mu
+
+	activeStreams atomic.Int64 // Download đang giữ slot MaxConcurrentDownloads
+
+	createThrottled         atomic.Int64
+	sessionQuotaRejected    atomic.Int64
+	downloadsRejectedBusy   atomic.Int64
+	downloadsRejectedClient atomic.Int64
+)
+
+// allowCreate tiêu một lượt tạo session của IP gửi request, trả 429 kèm Retry-After khi hết lượt
+func allowCreate(w http.ResponseWriter, r *http.Request) bool {
+	if CreateRateLimit <= 0 {
+		return true
+	}
+	addr, ok := clientIP(r)
+	if !ok {
+		return true
+	}
+	createLimitersMu.Lock()
+	l := createLimiters[addr]
+	if l == nil {
+		l = &downloadLimiter{}
+		createLimiters[addr] = l
+	}
+	allowed, wait := l.allow(CreateRateLimit, time.Now())
+	createLimitersMu.Unlock()
+	if allowed {
+		return true
+	}
+	createThrottled.Add(1)
+	slog.WarnContext(r.Context(), "Throttled session create")
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+	http.Error(w, "Too many sessions created from this address, try again later", http.StatusTooManyRequests)
+	return false
+}
+
+// sessionOwner là IP ghi vào session mới để tính MaxSessionsPerIP, zero khi không xác định được
+func sessionOwner(r *http.Request) netip.Addr {
+	addr, _ := clientIP(r)
+	return addr
+}
+
+// checkSessionQuotaLocked từ chối session mới khi owner đã đủ MaxSessionsPerIP. Phải giữ mu
+func checkSessionQuotaLocked(owner netip.Addr) error {
+	if MaxSessionsPerIP <= 0 || !owner.IsValid() || sessionsPerIP[owner] < MaxSessionsPerIP {
+		return nil
+	}
+	sessionQuotaRejected.Add(1)
+	return errSessionQuota
+}
+
+// trackOwnerLocked cộng (delta 1) hoặc trừ (-1) session của owner. Phải giữ mu
+func trackOwnerLocked(owner netip.Addr, delta int) {
+	if !owner.IsValid() {
+		return
+	}
+	if n := sessionsPerIP[owner] + delta; n > 0 {
+		sessionsPerIP[owner] = n
+	} else {
+		delete(sessionsPerIP, owner)
+	}
+}
+
+// acquireDownloadSlot giữ một slot MaxConcurrentDownloads; ok = false khi đã đủ
+func acquireDownloadSlot() (release func(), ok bool) {
+	if MaxConcurrentDownloads <= 0 {
+		return func() {}, true
+	}
+	if activeStreams.Add(1) > int64(MaxConcurrentDownloads) {
+		activeStreams.Add(-1)
+		downloadsRejectedBusy.Add(1)
+		return nil, false
+	}
+	return func() { activeStreams.Add(-1) }, true
+}
+
+func writeServerBusy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(DownloadBusyRetryAfter.Seconds())))
+	localizedError(w, r, http.StatusTooManyRequests, "server_busy")
+}
+
+// downloadClient là client được tính MaxDownloadsPerClient: API key đã tạo session (mọi link của
+// một tenant dùng chung hạn mức), nếu không có thì IP đang tải. "" khi không xác định được
+func downloadClient(s *Session, r *http.Request) string {
+	if s.APIKeyName != "" {
+		return "key:" + s.APIKeyName
+	}
+	if addr, ok := clientIP(r); ok {
+		return "ip:" + addr.String()
+	}
+	return ""
+}
+
+// acquireClientSlotLocked giữ một slot MaxDownloadsPerClient của client; ok = false khi client đã đủ.
+// Phải giữ mu; release tự lấy mu nên phải gọi khi không giữ
+func acquireClientSlotLocked(client string) (release func(), ok bool) {
+	if MaxDownloadsPerClient <= 0 || client == "" {
+		return func() {}, true
+	}
+	if streamsPerClient[client] >= MaxDownloadsPerClient {
+		downloadsRejectedClient.Add(1)
+		return nil, false
+	}
+	streamsPerClient[client]++
+	return func() {
+		mu.Lock()
+		if n := streamsPerClient[client] - 1; n > 0 {
+			streamsPerClient[client] = n
+		} else {
+			delete(streamsPerClient, client)
+		}
+		mu.Unlock()
+	}, true
+}
+
+func writeClientBusy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(DownloadBusyRetryAfter.Seconds())))
+	localizedError(w, r, http.StatusTooManyRequests, "client_busy")
+}
+
+// sweepCreateLimiters xóa bucket của IP đã im lặng đủ lâu để bucket đầy lại (một phút), vì bucket
+// đầy không khác gì chưa có, nên map không phình theo số IP từng gọi
+func sweepCreateLimiters(ctx context.Context) {
+	ticker := time.NewTicker(CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		createLimitersMu.Lock()
+		for addr, l := range createLimiters {
+			if now.Sub(l.updated) >= time.Minute {
+				delete(createLimiters, addr)
+			}
+		}
+		createLimitersMu.Unlock()
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useClientLimits đặt các giới hạn theo client và xóa bucket /create của test khi xong
+func useClientLimits(t *testing.T, createRate, sessionsPerIP, concurrent, perClient int) {
+	old := []int{CreateRateLimit, MaxSessionsPerIP, MaxConcurrentDownloads, MaxDownloadsPerClient}
+	CreateRateLimit, MaxSessionsPerIP, MaxConcurrentDownloads, MaxDownloadsPerClient = createRate, sessionsPerIP, concurrent, perClient
+	t.Cleanup(func() {
+		CreateRateLimit, MaxSessionsPerIP, MaxConcurrentDownloads, MaxDownloadsPerClient = old[0], old[1], old[2], old[3]
+		createLimitersMu.Lock()
+		clear(createLimiters)
+		createLimitersMu.Unlock()
+	})
+}
+
+func postCreateFrom(base, body, forwardedFor string) (*http.Response, error) {
+	req, _ := http.NewRequest(http.MethodPost, base+"/create", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+func assertTooMany(t *testing.T, resp *http.Response, what string) {
+	t.Helper()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("%s = %d, want 429", what, resp.StatusCode)
+	}
+	if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || wait < 1 {
+		t.Fatalf("%s: Retry-After = %q, want seconds", what, resp.Header.Get("Retry-After"))
+	}
+}
+
+// Gọi /create dồn dập: đúng CreateRateLimit lượt qua, phần còn lại nhận 429; sau proxy tin cậy mỗi
+// IP trong X-Forwarded-For có bucket riêng
+func TestCreateRateLimit(t *testing.T) {
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	useClientLimits(t, 5, 0, 0, 0)
+	prefixes := trustedProxyPrefixes
+	trustedProxyPrefixes = mustParsePrefixes([]string{"127.0.0.0/8"})
+	t.Cleanup(func() { trustedProxyPrefixes = prefixes })
+	body := `{"files":["` + origin.URL + `/a.txt"]}`
+
+	var wg sync.WaitGroup
+	statuses := make([]*http.Response, 12)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i], _ = postCreateFrom(base, body, "203.0.113.7")
+		}()
+	}
+	wg.Wait()
+	var ok int
+	for i, resp := range statuses {
+		if resp == nil {
+			t.Fatalf("create %d failed", i)
+		}
+		if resp.StatusCode == http.StatusOK {
+			ok++
+			continue
+		}
+		assertTooMany(t, resp, fmt.Sprintf("create %d", i))
+	}
+	if ok != 5 {
+		t.Fatalf("%d creates passed, want the limit of 5", ok)
+	}
+
+	if resp, err := postCreateFrom(base, body, "203.0.113.8"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("create from another forwarded IP = %v %v, want 200", resp, err)
+	}
+	if resp, err := postCreateFrom(base, body, "203.0.113.7"); err != nil {
+		t.Fatal(err)
+	} else {
+		assertTooMany(t, resp, "create past the limit")
+	}
+}
+
+// Số session còn sống của một IP bị chặn ở MaxSessionsPerIP; session được tải xong thì trả lại suất
+func TestSessionsPerIP(t *testing.T) {
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	mu.RLock()
+	live := sessionsPerIP[netip.MustParseAddr("127.0.0.1")] // Session còn sống của các test trước
+	mu.RUnlock()
+	useClientLimits(t, 0, live+2, 0, 0)
+	body := `{"files":["` + origin.URL + `/a.txt"]}`
+
+	first := createSession(t, base, body)
+	createSession(t, base, body)
+	resp, err := postCreateFrom(base, body, "")
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("create past the session cap = %v %v, want 429", resp, err)
+	}
+	if status, _ := download(t, first); status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	createSession(t, base, body)
+}
+
+// gatedOrigin giữ response của path trong gates tới khi channel được đóng hoặc request bị hủy;
+// started nhận path mỗi khi origin bắt đầu trả một path bị giữ
+func gatedOrigin(t *testing.T, gates map[string]chan struct{}) (*httptest.Server, chan string) {
+	started := make(chan string, 16)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "content of "+r.URL.Path)
+		w.(http.Flusher).Flush()
+		if gate := gates[r.URL.Path]; gate != nil {
+			started <- r.URL.Path
+			select {
+			case <-gate:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	t.Cleanup(origin.Close)
+	return origin, started
+}
+
+// Dồn download tới khi chạm giới hạn (của client hoặc cả server): lượt vượt nhận 429, slot được trả
+// lại khi client bỏ đi giữa chừng và khi một download xong
+func TestDownloadSlotsFreed(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		concurrent, perClient int
+		busy                  string
+	}{
+		{"per client", 0, 2, "client_busy"},
+		{"server", 2, 0, "server_busy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gates := map[string]chan struct{}{"/left.txt": make(chan struct{}), "/held.txt": make(chan struct{}), "/done.txt": make(chan struct{})}
+			origin, started := gatedOrigin(t, gates)
+			base := newTestServer(t)
+			useClientLimits(t, 0, 0, tc.concurrent, tc.perClient)
+			link := func(path string) string {
+				return createSession(t, base, `{"files":["`+origin.URL+path+`"]}`)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			defer close(gates["/held.txt"])
+
+			// hold bắt đầu download của path (bị gate giữ) và chờ origin trả byte đầu;
+			// kênh trả về nhận status khi download kết thúc
+			hold := func(ctx context.Context, path string) chan int {
+				req, _ := http.NewRequestWithContext(ctx, http.MethodGet, link(path), nil)
+				status := make(chan int, 1)
+				go func() {
+					resp, err := http.DefaultClient.Do(req)
+					if err != nil {
+						status <- 0
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					status <- resp.StatusCode
+				}()
+				if got := <-started; got != path {
+					t.Fatalf("origin started %s, want %s", got, path)
+				}
+				return status
+			}
+			// busy dồn các lượt tải vượt giới hạn: tất cả nhận 429 mà không tiêu link
+			busy := func(link string) {
+				t.Helper()
+				for i := range 5 {
+					resp, err := http.Get(link)
+					if err != nil {
+						t.Fatal(err)
+					}
+					body, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					assertTooMany(t, resp, fmt.Sprintf("download %d past the cap", i+1))
+					if want := localize(httptest.NewRequest(http.MethodGet, link, nil), tc.busy); !strings.Contains(string(body), want) {
+						t.Fatalf("429 body = %q, want %q", body, want)
+					}
+				}
+			}
+
+			leaveCtx, leave := context.WithCancel(ctx)
+			left := hold(leaveCtx, "/left.txt")
+			hold(ctx, "/held.txt")
+			extra := link("/extra.txt")
+			busy(extra)
+
+			// Client bỏ đi giữa chừng trả slot
+			leave()
+			<-left
+			waitDownload(t, extra)
+
+			// Download xong trả slot
+			done := hold(ctx, "/done.txt")
+			busy(link("/extra.txt"))
+			close(gates["/done.txt"])
+			if status := <-done; status != http.StatusOK {
+				t.Fatalf("gated download = %d", status)
+			}
+			waitDownload(t, link("/after.txt"))
+		})
+	}
+}
+
+// waitDownload tải link tới khi được 200, vì slot được trả sau khi byte cuối đã gửi
+func waitDownload(t *testing.T, link string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := download(t, link)
+		if status == http.StatusOK {
+			return
+		}
+		if status != http.StatusTooManyRequests || time.Now().After(deadline) {
+			t.Fatalf("download after a slot was freed = %d", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============== CLONE ==============
+
+// CloneRequest ghi đè một số tùy chọn của session gốc, field bỏ trống giữ nguyên
+type CloneRequest struct {
+	ZipName    *string        `json:"zipName"`
+	SlidingTTL *bool          `json:"slidingTTL"`
+	LinkDomain *string        `json:"linkDomain"`
+	ShortLink  *bool          `json:"shortLink"`
+	Webhook    *WebhookConfig `json:"webhook"`
+	OnError    *string        `json:"onError"`
+}
+
+// handleClone tạo session mới (token và TTL mới) với cùng danh sách file, từ session còn sống
+// hoặc session đã hết hạn/đã tải còn trong TombstoneRetention. Cần X-Api-Key như /create khi bật API key.
+func handleClone(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if rejectDuringShutdown(w, r) {
+		return
+	}
+	keyName, ok := requireAPIKey(w, r)
+	if !ok || !allowCreate(w, r) {
+		return
+	}
+
+	var req CloneRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxCreateBodyBytes)).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Webhook != nil {
+		if err := req.Webhook.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid webhook: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.OnError != nil {
+		switch *req.OnError {
+		case "", "skip", "abort":
+		default:
+			http.Error(w, fmt.Sprintf("Unknown onError: %s", *req.OnError), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.LinkDomain != nil && *req.LinkDomain != "" {
+		if _, ok := LinkDomains[*req.LinkDomain]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown linkDomain: %s", *req.LinkDomain), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var warnings []Warning
+	var zipName string
+	if req.ZipName != nil {
+		zipName = sanitizeZipName(*req.ZipName)
+		if *req.ZipName != "" && zipName != *req.ZipName {
+			warnings = append(warnings, Warning{
+				Code:    "zip_name_sanitized",
+				Message: fmt.Sprintf("zipName was changed to %q", zipName),
+			})
+		}
+	}
+
+	newToken := uuid.New().String()
+	now := time.Now()
+
+	mu.Lock()
+	origin, state := sessions[token], "live"
+	if origin == nil {
+		if t, ok := tombstones[token]; ok && t.session != nil {
+			origin, state = t.session, t.Reason
+		}
+	}
+	if origin == nil {
+		mu.Unlock()
+		http.Error(w, "Invalid or expired token", http.StatusNotFound)
+		return
+	}
+
+	clone := &Session{
+		Files:               append([]FileEntry(nil), origin.Files...),
+		ZipName:             origin.ZipName,
+		CreatedAt:           now,
+		LastAccessedAt:      now,
+		SlidingTTL:          origin.SlidingTTL,
+		LinkDomain:          origin.LinkDomain,
+		ShortLink:           origin.ShortLink,
+		MirrorStrategy:      origin.MirrorStrategy,
+		Webhook:             origin.Webhook,
+		ASCIINames:          origin.ASCIINames,
+		NameConflict:        origin.NameConflict,
+		Archive:             origin.Archive,
+		OnError:             origin.OnError,
+		FailureLimits:       origin.FailureLimits,
+		FailurePlaceholders: origin.FailurePlaceholders,
+		ErrorReport:         origin.ErrorReport,
+		Checksums:           origin.Checksums,
+		Dedupe:              origin.Dedupe,
+		NotBefore:           origin.NotBefore,
+		TTLFrom:             origin.TTLFrom,
+		TTL:                 origin.TTL,
+		PartMaxBytes:        origin.PartMaxBytes,
+		RateLimit:           origin.RateLimit,
+		MaxDownloads:        origin.MaxDownloads,
+		AllowedCIDRs:        origin.AllowedCIDRs,
+		Referrers:           origin.Referrers,
+		headers:             origin.headers,
+		Deadlines:           origin.Deadlines,
+		Hedge:               origin.Hedge,
+		Retry:               origin.Retry,
+		Resumable:           origin.Resumable,
+		ResumableMode:       origin.ResumableMode,
+		Prebuild:            origin.Prebuild,
+		Disposition:         origin.Disposition,
+		ContentType:         origin.ContentType,
+		APIKeyName:          keyName,
+		owner:               sessionOwner(r),
+	}
+	if req.ZipName != nil {
+		clone.ZipName = zipName
+		if zipName == "" {
+			clone.ZipName = origin.Archive.defaultArchiveName()
+		}
+	}
+	if req.SlidingTTL != nil {
+		clone.SlidingTTL = *req.SlidingTTL
+	}
+	if req.LinkDomain != nil {
+		clone.LinkDomain = *req.LinkDomain
+	}
+	if req.ShortLink != nil {
+		clone.ShortLink = *req.ShortLink
+	}
+	if req.Webhook != nil {
+		clone.Webhook = req.Webhook
+	}
+	// Session resumable dạng stream luôn abort khi có file lỗi
+	if req.OnError != nil && (!clone.Resumable || clone.ResumableMode == "file") {
+		clone.OnError = *req.OnError
+	}
+
+	err := addSessionLocked(newToken, clone)
+	if err == nil {
+		err = persistNewSessionLocked(clone)
+	}
+	if err == nil {
+		clone.prebuildLocked(newToken)
+	}
+	var expiresAt, signedUntil time.Time
+	partCount := 0
+	if err == nil {
+		expiresAt, signedUntil, partCount = clone.expiresAt(), clone.signedExpiry(), len(clone.parts())
+	}
+	mu.Unlock()
+
+	if errors.Is(err, errPersist) {
+		http.Error(w, "Failed to store session", http.StatusInternalServerError)
+		return
+	}
+	if errors.Is(err, errSessionQuota) {
+		slog.WarnContext(r.Context(), "Rejected session clone", "error", err, "limit", MaxSessionsPerIP)
+		http.Error(w, "Too many active sessions for this address, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected session clone", "error", err, "limit", MaxSessions)
+		http.Error(w, "Too many active sessions, try again later", http.StatusInsufficientStorage)
+		return
+	}
+
+	resp := DownloadResponse{
+		DownloadURL: downloadURL(r, clone.LinkDomain, clone.ShortLink, newToken, signedUntil),
+		ExpiresAt:   expiresAt,
+		Parts:       partURLs(r, clone.LinkDomain, clone.ShortLink, newToken, signedUntil, partCount),
+		Warnings:    warnings,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	sessionsCreated.Add(1)
+	slog.InfoContext(r.Context(), "Cloned session", "origin", token, "origin_state", state, "token", newToken, "files", len(clone.Files), "api_key", keyName)
+}
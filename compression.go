@@ -0,0 +1,100 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"io"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ============== COMPRESSION ==============
+
+// compression "auto" deflate nội dung nén được (text, JSON, XML, ...) và giữ Store cho media,
+// archive và mọi loại không rõ, vì nén lại dữ liệu đã nén chỉ tốn CPU
+
+// compressibleTypes là các media type được deflate với compression "auto", ngoài text/*
+var compressibleTypes = []string{
+	"application/json",
+	"application/xml",
+	"application/javascript",
+	"application/ecmascript",
+	"application/x-ndjson",
+	"application/x-yaml",
+	"application/yaml",
+	"application/toml",
+	"application/sql",
+	"application/rtf",
+	"application/x-sh",
+	"application/x-tar",
+	"application/wasm",
+	"application/vnd.ms-excel",
+	"application/msword",
+	"image/svg+xml",
+	"image/bmp",
+	"image/x-icon",
+	"font/ttf",
+	"font/otf",
+}
+
+// isCompressible cho biết entry nên được deflate với compression "auto". Content-Type thiếu hoặc
+// là application/octet-stream thì đoán theo đuôi tên entry
+func isCompressible(contentType, name string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mt == "application/octet-stream" {
+		mt, _, _ = mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+	}
+	if mt == "" {
+		return false
+	}
+	if strings.HasPrefix(mt, "text/") || strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml") {
+		return true
+	}
+	return slices.Contains(compressibleTypes, mt)
+}
+
+// flateWriters giữ flate.Writer đã dùng theo mức nén (1-9) vì mỗi writer cấp phát vài trăm KB
+var flateWriters [flate.BestCompression + 1]sync.Pool
+
+// registerDeflateLevel cho zw dùng mức nén level thay cho mặc định của archive/zip; 0 = giữ mặc định
+func registerDeflateLevel(zw *zip.Writer, level int) {
+	if level == 0 {
+		return
+	}
+	zw.RegisterCompressor(zip.Deflate, deflateCompressor(level))
+}
+
+// deflateCompressor trả compressor deflate mức level (1-9) dùng flate.Writer từ pool
+func deflateCompressor(level int) zip.Compressor {
+	pool := &flateWriters[level]
+	return func(out io.Writer) (io.WriteCloser, error) {
+		fw, ok := pool.Get().(*flate.Writer)
+		if ok {
+			fw.Reset(out)
+		} else {
+			var err error
+			if fw, err = flate.NewWriter(out, level); err != nil {
+				return nil, err
+			}
+		}
+		return &pooledFlateWriter{fw: fw, pool: pool}, nil
+	}
+}
+
+// pooledFlateWriter trả flate.Writer về pool khi entry đóng
+type pooledFlateWriter struct {
+	fw   *flate.Writer
+	pool *sync.Pool
+}
+
+func (w *pooledFlateWriter) Write(p []byte) (int, error) { return w.fw.Write(p) }
+
+func (w *pooledFlateWriter) Close() error {
+	err := w.fw.Close()
+	w.pool.Put(w.fw)
+	w.fw = nil
+	return err
+}
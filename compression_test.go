@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Entry lấy thời gian từ Last-Modified của origin (không có thì là lúc tải), "deflate" nén thật
+// còn mặc định là Store, và nội dung giải nén giống nhau ở cả hai chế độ
+func TestEntryTimesAndCompression(t *testing.T) {
+	modified := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	payload := strings.Repeat("the quick brown fox jumps over the lazy dog\n", 2000)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/dated.txt" {
+			w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		}
+		io.WriteString(w, payload)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	files := `"files":["` + origin.URL + `/dated.txt","` + origin.URL + `/undated.txt"]`
+
+	archives := make(map[string][]byte)
+	for _, compression := range []string{"store", "deflate"} {
+		start := time.Now().Truncate(time.Second)
+		status, body := download(t, createSession(t, base, `{`+files+`,"compression":"`+compression+`"}`))
+		if status != http.StatusOK {
+			t.Fatalf("%s: download = %d", compression, status)
+		}
+		archives[compression] = body
+		for name, content := range unzip(t, body) {
+			if content != payload {
+				t.Fatalf("%s: %s has %d bytes, want the %d byte payload", compression, name, len(content), len(payload))
+			}
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]uint16{"store": zip.Store, "deflate": zip.Deflate}[compression]
+		for _, f := range zr.File {
+			if f.Method != want || f.Mode() != 0644 {
+				t.Errorf("%s: %s method %d mode %v, want method %d mode 0644", compression, f.Name, f.Method, f.Mode(), want)
+			}
+			switch f.Name {
+			case "dated.txt":
+				if !f.Modified.Equal(modified) {
+					t.Errorf("%s: dated.txt modified %v, want Last-Modified %v", compression, f.Modified, modified)
+				}
+			case "undated.txt":
+				if f.Modified.Before(start) || f.Modified.After(time.Now()) {
+					t.Errorf("%s: undated.txt modified %v, want the download time", compression, f.Modified)
+				}
+			}
+		}
+	}
+	if len(archives["deflate"])*10 > len(archives["store"]) {
+		t.Fatalf("deflate archive is %d bytes, store is %d; want deflate under a tenth", len(archives["deflate"]), len(archives["store"]))
+	}
+
+	// Resumable stream tính offset theo dung lượng gốc nên không nhận deflate; giá trị lạ bị từ chối
+	for _, body := range []string{
+		`{` + files + `,"compression":"deflate","resumable":true}`,
+		`{` + files + `,"compression":"zstd"}`,
+	} {
+		if _, err := postCreate(base, body); err == nil || !strings.Contains(err.Error(), "create = 400") {
+			t.Fatalf("create %s: %v, want 400", body, err)
+		}
+	}
+}
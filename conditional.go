@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============== CONDITIONAL REQUESTS ==============
+
+// Archive của session resumable có validator ổn định: với resumableMode "file" là băm nội dung
+// file đã dựng (ServeContent tự xử lý điều kiện), với "stream" là archiveETag của danh sách file
+// và CreatedAt nên lần tải lại của cùng session nhận 304 mà không fetch lại origin. Response gửi
+// Cache-Control: no-cache để CDN phía trước được giữ bản sao nhưng luôn hỏi lại server, nhờ đó
+// token hết hạn, bị thu hồi hay đã tiêu thụ vẫn bị chặn như khi tải thẳng.
+
+// setArchiveValidators đặt ETag, Last-Modified và Cache-Control cho archive có validator
+func setArchiveValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+}
+
+// notModified đánh giá If-None-Match (so sánh yếu, "*" khớp mọi ETag) rồi If-Modified-Since khi
+// không có If-None-Match, như ServeContent. Chỉ áp dụng cho GET và HEAD
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Last-Modified chỉ chính xác tới giây
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// writeNotModified trả 304 kèm các validator, không có body
+func writeNotModified(w http.ResponseWriter, etag string, modTime time.Time) {
+	setArchiveValidators(w, etag, modTime)
+	w.WriteHeader(http.StatusNotModified)
+}
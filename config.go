@@ -0,0 +1,510 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== RUNTIME CONFIG ==============
+
+// version được gắn lúc build: go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// PublicURL là base của download_url khi không chọn linkDomain (ví dụ https://files.example.com
+// sau proxy TLS), rỗng = {scheme của request}://{Host của request}, xem requestScheme
+var PublicURL = ""
+
+// Config là cấu hình chạy server, đọc từ flag với fallback biến môi trường
+type Config struct {
+	Port            int
+	PublicURL       string
+	LinkDomains     map[string]string
+	HostProtocols   map[string]string
+	TLSCertFile     string
+	TLSKeyFile      string
+	H2C             bool
+	SessionTTL      time.Duration
+	MaxSessionTTL   time.Duration
+	HTTPTimeout     time.Duration
+	DownloadTimeout time.Duration
+	CleanupInterval time.Duration
+	DrainTimeout    time.Duration
+	ReadinessURL    string
+	Workers         int
+	Retries         int
+	RetryBackoff    time.Duration
+	RetryOn         []string
+	MaxFiles        int
+	MaxFileBytes    int64
+	MaxArchiveBytes int64
+	MaxStreamBPS    int64
+	MaxEgressBPS    int64
+	PreflightSizes  bool
+	SubsetCounts    bool
+	MaxSessions     int
+	EvictionPolicy  string
+	DataDir         string
+	SpoolDir        string
+	SourceCacheDir  string
+	SourceCacheMax  int64
+	PartMaxBytes    int64
+	AdminKey        string
+	WebhookSecret   string
+	APIKeys         []APIKey
+	APIKeyRateLimit int
+	HMACSecret      string
+	LogFormat       string
+	LogLevel        string
+	LocalRoot       string
+	RedisURL        string
+
+	AllowPrivateNetworks bool
+	AllowedSchemes       []string
+	AllowedHosts         []string
+	DeniedHosts          []string
+	ForwardHeaders       []string
+
+	CORSOrigins []string
+	CORSMethods []string
+	CORSHeaders []string
+
+	TrustedProxies         []string
+	StatusCIDRs            []string
+	StatusSessionCIDRs     bool
+	ClientLimits           bool
+	CreateRateLimit        int
+	DownloadRateLimit      int
+	MaxSessionsPerIP       int
+	MaxConcurrentDownloads int
+	MaxDownloadsPerClient  int
+
+	ShowVersion bool
+}
+
+// envAliases là tên biến môi trường thay thế của một số flag, dùng khi biến cùng tên flag không có
+var envAliases = map[string]string{
+	"max-files":         "MAX_FILES_PER_SESSION",
+	"max-file-bytes":    "MAX_SINGLE_FILE_BYTES",
+	"max-archive-bytes": "MAX_TOTAL_BYTES",
+	"public-url":        "PUBLIC_BASE_URL",
+	"link-domain":       "LINK_DOMAINS",
+	"host-protocol":     "HOST_PROTOCOLS",
+}
+
+// loadConfig đọc flag từ args; flag không có thì lấy biến môi trường cùng tên (PORT, SESSION_TTL...),
+// không có nữa thì dùng giá trị mặc định
+func loadConfig(args []string, getenv func(string) string, output io.Writer) (Config, error) {
+	cfg := Config{
+		Port:            6001,
+		SessionTTL:      SessionTTL,
+		MaxSessionTTL:   MaxSessionTTL,
+		HTTPTimeout:     HTTPTimeout,
+		DownloadTimeout: DownloadTimeout,
+		CleanupInterval: CleanupInterval,
+		DrainTimeout:    DrainTimeout,
+		Workers:         FetchConcurrency,
+		Retries:         DefaultRetries,
+		RetryBackoff:    DefaultRetryBackoff,
+		MaxFiles:        MaxFilesPerSession,
+		MaxFileBytes:    MaxFileBytes,
+		MaxArchiveBytes: MaxArchiveBytes,
+		MaxStreamBPS:    MaxStreamBPS,
+		MaxEgressBPS:    MaxEgressBPS,
+		MaxSessions:     MaxSessions,
+		EvictionPolicy:  EvictionPolicy,
+		DataDir:         DataDir,
+		SpoolDir:        SpoolDir,
+		SourceCacheDir:  SourceCacheDir,
+		SourceCacheMax:  SourceCacheMaxBytes,
+		PartMaxBytes:    PartMaxBytes,
+		AdminKey:        AdminKey,
+		WebhookSecret:   WebhookSecret,
+		LogFormat:       "text",
+		LogLevel:        "info",
+
+		StatusSessionCIDRs:     StatusSessionCIDRs,
+		ClientLimits:           true,
+		CreateRateLimit:        CreateRateLimit,
+		DownloadRateLimit:      DownloadRateLimit,
+		MaxSessionsPerIP:       MaxSessionsPerIP,
+		MaxConcurrentDownloads: MaxConcurrentDownloads,
+		MaxDownloadsPerClient:  MaxDownloadsPerClient,
+	}
+	apiKeys, apiKeysFile, trustedProxies := "", "", strings.Join(TrustedProxies, ",")
+	statusCIDRs := strings.Join(StatusCIDRs, ",")
+	retryOn := strings.Join(DefaultRetryOn, ",")
+	schemes, allowedHosts, deniedHosts := strings.Join(AllowedSchemes, ","), strings.Join(AllowedHostSuffixes, ","), strings.Join(DeniedHostSuffixes, ",")
+	forwardHeaders := strings.Join(ForwardHeaders, ",")
+	corsOrigins, corsMethods, corsHeaders := strings.Join(CORSOrigins, ","), strings.Join(CORSMethods, ","), strings.Join(CORSHeaders, ",")
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "Listen port (env PORT)")
+	fs.StringVar(&cfg.PublicURL, "public-url", "", "Base URL of download links, e.g. https://files.example.com (env PUBLIC_URL or PUBLIC_BASE_URL, empty = {request scheme}://{request Host})")
+	cfg.LinkDomains = make(map[string]string)
+	maps.Copy(cfg.LinkDomains, LinkDomains)
+	fs.Var(mapFlag(cfg.LinkDomains), "link-domain", "Named base URL for linkDomain as alias=host or alias=https://host, repeatable or comma-separated (env LINK_DOMAIN or LINK_DOMAINS); when set, /download only answers these hosts")
+	cfg.HostProtocols = make(map[string]string)
+	maps.Copy(cfg.HostProtocols, HostProtocols)
+	fs.Var(mapFlag(cfg.HostProtocols), "host-protocol", "Protocol used to fetch from a host[:port] as host=h2, host=h2c or host=h1, repeatable or comma-separated (env HOST_PROTOCOL or HOST_PROTOCOLS)")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert-file", "", "PEM certificate (and chain) to serve HTTPS and HTTP/2 directly, reloaded when it changes (env TLS_CERT_FILE)")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key-file", "", "PEM private key of tls-cert-file (env TLS_KEY_FILE)")
+	fs.BoolVar(&cfg.H2C, "h2c", false, "Accept HTTP/2 without TLS (prior knowledge), for proxies that speak h2c to the backend (env H2C)")
+	fs.DurationVar(&cfg.SessionTTL, "session-ttl", cfg.SessionTTL, "Session expiration time (env SESSION_TTL)")
+	fs.DurationVar(&cfg.MaxSessionTTL, "max-session-ttl", cfg.MaxSessionTTL, "Longest expiresIn a session may ask for (env MAX_SESSION_TTL)")
+	fs.DurationVar(&cfg.HTTPTimeout, "http-timeout", cfg.HTTPTimeout, "Timeout per upstream HTTP request (env HTTP_TIMEOUT)")
+	fs.DurationVar(&cfg.DownloadTimeout, "download-timeout", cfg.DownloadTimeout, "Default and maximum totalTimeout (env DOWNLOAD_TIMEOUT)")
+	fs.DurationVar(&cfg.CleanupInterval, "cleanup-interval", cfg.CleanupInterval, "Spool sweep period and longest sleep of the expiry timer (env CLEANUP_INTERVAL)")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout, "How long to wait for in-flight downloads on SIGINT/SIGTERM (env DRAIN_TIMEOUT)")
+	fs.StringVar(&cfg.ReadinessURL, "readiness-probe-url", "", "URL sent a HEAD by /readyz to check outbound connectivity (env READINESS_PROBE_URL, empty = not checked)")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "Files fetched in parallel ahead of the zip writer per download, 1 = sequential (env WORKERS)")
+	fs.IntVar(&cfg.Retries, "retries", cfg.Retries, fmt.Sprintf("Retries per URL for files that set no retries, at most %d (env RETRIES)", MaxRetries))
+	fs.DurationVar(&cfg.RetryBackoff, "retry-backoff", cfg.RetryBackoff, "First wait between retries, doubled after each attempt (env RETRY_BACKOFF)")
+	fs.StringVar(&retryOn, "retry-on", retryOn, "Comma-separated failures retried by default: 5xx, 429, timeout, connection (env RETRY_ON)")
+	fs.IntVar(&cfg.MaxFiles, "max-files", cfg.MaxFiles, "Files one session may hold, appended files included (env MAX_FILES)")
+	fs.Int64Var(&cfg.MaxFileBytes, "max-file-bytes", cfg.MaxFileBytes, "Largest uncompressed file, 0 = unlimited (env MAX_FILE_BYTES)")
+	fs.Int64Var(&cfg.MaxArchiveBytes, "max-archive-bytes", cfg.MaxArchiveBytes, "Largest uncompressed archive, 0 = unlimited (env MAX_ARCHIVE_BYTES)")
+	fs.Int64Var(&cfg.MaxStreamBPS, "max-stream-bps", cfg.MaxStreamBPS, "Bytes per second sent to one download, 0 = unlimited (env MAX_STREAM_BPS)")
+	fs.Int64Var(&cfg.MaxEgressBPS, "max-egress-bps", cfg.MaxEgressBPS, "Bytes per second sent to all downloads together, 0 = unlimited (env MAX_EGRESS_BPS)")
+	fs.BoolVar(&cfg.PreflightSizes, "preflight-sizes", PreflightSizes, "HEAD every file at create time, without resolveNames, to enforce the size limits before a session is issued (env PREFLIGHT_SIZES)")
+	fs.BoolVar(&cfg.SubsetCounts, "subset-downloads-count", SubsetDownloadsCount, "Partial downloads (?only=, ?match=) consume the session like a full download (env SUBSET_DOWNLOADS_COUNT)")
+	fs.IntVar(&cfg.MaxSessions, "max-sessions", cfg.MaxSessions, "Sessions held in memory (env MAX_SESSIONS)")
+	fs.StringVar(&cfg.EvictionPolicy, "eviction-policy", cfg.EvictionPolicy, "When max-sessions is reached: evict (drop the oldest session) or reject (env EVICTION_POLICY)")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "Directory sessions are saved to so they survive a restart (env DATA_DIR, empty = in memory)")
+	fs.StringVar(&cfg.SpoolDir, "spool-dir", cfg.SpoolDir, "Directory of temporary and artifact files, swept for orphans at startup (env SPOOL_DIR, empty = system temp dir)")
+	fs.StringVar(&cfg.SourceCacheDir, "source-cache-dir", cfg.SourceCacheDir, "Directory of the source file cache shared by all sessions, revalidated with the origin on every fetch (env SOURCE_CACHE_DIR, empty = disabled)")
+	fs.Int64Var(&cfg.SourceCacheMax, "source-cache-max-bytes", cfg.SourceCacheMax, "Size cap of the source file cache, least recently used files are dropped first (env SOURCE_CACHE_MAX_BYTES)")
+	fs.Int64Var(&cfg.PartMaxBytes, "part-max-bytes", cfg.PartMaxBytes, "Split archives larger than this into parts downloaded separately, unless the request sets partMaxBytes (env PART_MAX_BYTES, 0 = off)")
+	fs.StringVar(&cfg.AdminKey, "admin-key", cfg.AdminKey, "Bearer key of the admin API (env ADMIN_KEY, empty = admin API disabled)")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", cfg.WebhookSecret, "Secret used to sign webhook bodies with HMAC-SHA256 (env WEBHOOK_SECRET, empty = unsigned)")
+	fs.StringVar(&apiKeys, "api-keys", "", "Comma-separated keys accepted in X-Api-Key on /create (env API_KEYS, empty = no API key required)")
+	fs.StringVar(&apiKeysFile, "api-keys-file", "", "File with one \"name key [requests per minute]\" per line, added to api-keys (env API_KEYS_FILE)")
+	fs.IntVar(&cfg.APIKeyRateLimit, "api-key-rate-limit", 0, "Requests per minute per API key unless its line sets one, 0 = unlimited (env API_KEY_RATE_LIMIT)")
+	fs.StringVar(&cfg.HMACSecret, "hmac-secret", "", "Secret used to sign download URLs with an expiry (env HMAC_SECRET, empty = unsigned links)")
+	fs.StringVar(&cfg.RedisURL, "redis-url", "", "Store sessions in Redis shared by all instances, redis://[:password@]host:port/db (env REDIS_URL, empty = in memory)")
+	fs.StringVar(&cfg.LocalRoot, "local-root", "", "Directory served to file:// entries (env LOCAL_ROOT, empty = file:// disabled)")
+	fs.BoolVar(&cfg.AllowPrivateNetworks, "allow-private-networks", AllowPrivateNetworks.Load(), "Fetch loopback, link-local and private addresses; only for trusted callers (env ALLOW_PRIVATE_NETWORKS)")
+	fs.StringVar(&schemes, "allowed-schemes", schemes, "Comma-separated URL schemes that may be fetched (env ALLOWED_SCHEMES)")
+	fs.StringVar(&allowedHosts, "allowed-hosts", allowedHosts, "Comma-separated domains that may be fetched, with their subdomains (env ALLOWED_HOSTS, empty = any)")
+	fs.StringVar(&deniedHosts, "denied-hosts", deniedHosts, "Comma-separated domains that are never fetched, with their subdomains (env DENIED_HOSTS)")
+	fs.StringVar(&forwardHeaders, "forward-headers", forwardHeaders, "Comma-separated request headers clients may forward to origins, \"X-*\" matches a prefix (env FORWARD_HEADERS, empty = none)")
+	fs.StringVar(&corsOrigins, "cors-origins", corsOrigins, "Comma-separated browser origins allowed to call the API, \"https://*.example.com\" matches subdomains (env CORS_ORIGINS, \"*\" = any, empty = none)")
+	fs.StringVar(&corsMethods, "cors-methods", corsMethods, "Comma-separated methods allowed in CORS preflight (env CORS_METHODS)")
+	fs.StringVar(&corsHeaders, "cors-headers", corsHeaders, "Comma-separated request headers allowed in CORS preflight (env CORS_HEADERS)")
+	fs.StringVar(&trustedProxies, "trusted-proxies", trustedProxies, "Comma-separated CIDRs of reverse proxies whose X-Forwarded-For is trusted (env TRUSTED_PROXIES)")
+	fs.StringVar(&statusCIDRs, "status-cidrs", statusCIDRs, "Comma-separated CIDRs allowed to call /status, /result and /preview, on top of the session's allowedCIDRs (env STATUS_CIDRS, empty = any)")
+	fs.BoolVar(&cfg.StatusSessionCIDRs, "status-session-cidrs", cfg.StatusSessionCIDRs, "Apply a session's allowedCIDRs to its /status, /result and /preview as to downloads (env STATUS_SESSION_CIDRS)")
+	fs.BoolVar(&cfg.ClientLimits, "client-limits", cfg.ClientLimits, "Enforce create-rate-limit, max-sessions-per-ip, max-concurrent-downloads and max-downloads-per-client (env CLIENT_LIMITS)")
+	fs.IntVar(&cfg.CreateRateLimit, "create-rate-limit", cfg.CreateRateLimit, "Session creates per minute per client IP, 0 = unlimited (env CREATE_RATE_LIMIT)")
+	fs.IntVar(&cfg.DownloadRateLimit, "download-rate-limit", cfg.DownloadRateLimit, "Downloads per minute of one token, also for sessions without rateLimit, which can only lower it, 0 = unlimited (env DOWNLOAD_RATE_LIMIT)")
+	fs.IntVar(&cfg.MaxSessionsPerIP, "max-sessions-per-ip", cfg.MaxSessionsPerIP, "Live sessions one client IP may hold, 0 = unlimited (env MAX_SESSIONS_PER_IP)")
+	fs.IntVar(&cfg.MaxConcurrentDownloads, "max-concurrent-downloads", cfg.MaxConcurrentDownloads, "Archive downloads streaming at once, 0 = unlimited (env MAX_CONCURRENT_DOWNLOADS)")
+	fs.IntVar(&cfg.MaxDownloadsPerClient, "max-downloads-per-client", cfg.MaxDownloadsPerClient, "Archive downloads streaming at once per API key (of the session) or client IP, 0 = unlimited (env MAX_DOWNLOADS_PER_CLIENT)")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "Log output format: text or json (env LOG_FORMAT)")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "Minimum log level: debug, info, warn or error (env LOG_LEVEL)")
+	fs.BoolVar(&cfg.ShowVersion, "version", false, "Print the version and exit")
+
+	// Biến môi trường được đặt làm giá trị flag trước khi parse nên flag trên dòng lệnh luôn thắng
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env := strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v := getenv(env)
+		if alias := envAliases[f.Name]; v == "" && alias != "" {
+			env, v = alias, getenv(alias)
+		}
+		if v != "" && f.Name != "version" && err == nil {
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("invalid %s: %v", env, serr)
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	cfg.APIKeys = namedAPIKeys(splitList(apiKeys))
+	cfg.TrustedProxies = splitList(trustedProxies)
+	cfg.StatusCIDRs = splitList(statusCIDRs)
+	cfg.RetryOn = splitList(retryOn)
+	cfg.AllowedSchemes = splitList(strings.ToLower(schemes))
+	cfg.AllowedHosts, cfg.DeniedHosts = splitList(allowedHosts), splitList(deniedHosts)
+	cfg.ForwardHeaders = splitList(forwardHeaders)
+	cfg.CORSOrigins, cfg.CORSMethods, cfg.CORSHeaders = splitList(corsOrigins), splitList(strings.ToUpper(corsMethods)), splitList(corsHeaders)
+	if !cfg.ClientLimits {
+		cfg.CreateRateLimit, cfg.MaxSessionsPerIP, cfg.MaxConcurrentDownloads, cfg.MaxDownloadsPerClient = 0, 0, 0, 0
+	}
+	if cfg.ShowVersion {
+		return cfg, nil
+	}
+	if apiKeysFile != "" {
+		fileKeys, err := readAPIKeysFile(apiKeysFile)
+		if err != nil {
+			return cfg, fmt.Errorf("api-keys-file: %v", err)
+		}
+		cfg.APIKeys = append(cfg.APIKeys, fileKeys...)
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *Config) validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"session-ttl", c.SessionTTL},
+		{"max-session-ttl", c.MaxSessionTTL},
+		{"http-timeout", c.HTTPTimeout},
+		{"download-timeout", c.DownloadTimeout},
+		{"cleanup-interval", c.CleanupInterval},
+		{"drain-timeout", c.DrainTimeout},
+	} {
+		if d.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", d.name, d.value)
+		}
+	}
+	if c.Workers < 1 || c.Workers > MaxWorkers {
+		return fmt.Errorf("workers must be between 1 and %d, got %d", MaxWorkers, c.Workers)
+	}
+	if c.Retries < 0 || c.Retries > MaxRetries {
+		return fmt.Errorf("retries must be between 0 and %d, got %d", MaxRetries, c.Retries)
+	}
+	if c.RetryBackoff <= 0 || c.RetryBackoff > MaxRetryBackoff {
+		return fmt.Errorf("retry-backoff must be between 0 and %v, got %v", MaxRetryBackoff, c.RetryBackoff)
+	}
+	if err := validateRetryOn(c.RetryOn); err != nil {
+		return fmt.Errorf("retry-on: %v", err)
+	}
+	if c.MaxFiles < 1 {
+		return fmt.Errorf("max-files must be at least 1, got %d", c.MaxFiles)
+	}
+	if c.MaxSessions < 1 {
+		return fmt.Errorf("max-sessions must be at least 1, got %d", c.MaxSessions)
+	}
+	if c.MaxStreamBPS < 0 || c.MaxEgressBPS < 0 {
+		return errors.New("max-stream-bps and max-egress-bps must not be negative")
+	}
+	if c.MaxFileBytes < 0 || c.MaxArchiveBytes < 0 {
+		return errors.New("max-file-bytes and max-archive-bytes must not be negative")
+	}
+	if c.EvictionPolicy != "evict" && c.EvictionPolicy != "reject" {
+		return fmt.Errorf("eviction-policy must be evict or reject, got %q", c.EvictionPolicy)
+	}
+	if c.PartMaxBytes < 0 || c.PartMaxBytes > 0 && c.PartMaxBytes < MinPartBytes {
+		return fmt.Errorf("part-max-bytes must be 0 or at least %d, got %d", MinPartBytes, c.PartMaxBytes)
+	}
+	if c.DataDir != "" && c.RedisURL != "" {
+		return errors.New("data-dir and redis-url cannot be used together")
+	}
+	if c.SourceCacheDir != "" {
+		if c.SourceCacheMax <= 0 {
+			return fmt.Errorf("source-cache-max-bytes must be positive, got %d", c.SourceCacheMax)
+		}
+		// Cả hai thư mục được quét và dọn file lạ
+		dir := filepath.Clean(c.SourceCacheDir)
+		if c.DataDir != "" && dir == filepath.Clean(c.DataDir) || c.SpoolDir != "" && dir == filepath.Clean(c.SpoolDir) {
+			return errors.New("source-cache-dir must differ from data-dir and spool-dir")
+		}
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"create-rate-limit", c.CreateRateLimit},
+		{"download-rate-limit", c.DownloadRateLimit},
+		{"max-sessions-per-ip", c.MaxSessionsPerIP},
+		{"max-concurrent-downloads", c.MaxConcurrentDownloads},
+		{"max-downloads-per-client", c.MaxDownloadsPerClient},
+		{"api-key-rate-limit", c.APIKeyRateLimit},
+	} {
+		if n.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", n.name, n.value)
+		}
+	}
+	if c.RedisURL != "" {
+		if _, err := parseRedisURL(c.RedisURL); err != nil {
+			return fmt.Errorf("redis-url: %v", err)
+		}
+	}
+	for _, s := range c.AllowedSchemes {
+		if !slices.Contains(knownSchemes, s) {
+			return fmt.Errorf("allowed-schemes: unknown scheme %q (known: %s)", s, strings.Join(knownSchemes, ", "))
+		}
+	}
+	if err := validateCORSOrigins(c.CORSOrigins); err != nil {
+		return fmt.Errorf("cors-origins: %v", err)
+	}
+	if _, err := parseForwardHeaders(c.ForwardHeaders); err != nil {
+		return fmt.Errorf("forward-headers: %v", err)
+	}
+	if err := validateAPIKeys(c.APIKeys); err != nil {
+		return err
+	}
+	if _, err := parsePrefixes(c.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted-proxies: %v", err)
+	}
+	if _, err := parsePrefixes(c.StatusCIDRs); err != nil {
+		return fmt.Errorf("status-cidrs: %v", err)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		return fmt.Errorf("log-format must be text or json, got %q", c.LogFormat)
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		return fmt.Errorf("log-level must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return errors.New("public-url must be an absolute http(s) URL without query or fragment")
+		}
+		c.PublicURL = strings.TrimRight(c.PublicURL, "/")
+	}
+	if err := normalizeHostProtocols(c.HostProtocols); err != nil {
+		return fmt.Errorf("host-protocol %v", err)
+	}
+	for alias, base := range c.LinkDomains {
+		if !strings.Contains(base, "://") {
+			base = "https://" + base
+		}
+		u, err := url.Parse(base)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("link-domain %s: %q is not a host or an absolute http(s) URL without query", alias, c.LinkDomains[alias])
+		}
+		c.LinkDomains[alias] = strings.TrimRight(base, "/")
+	}
+	if c.ReadinessURL != "" {
+		if u, err := url.Parse(c.ReadinessURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("readiness-probe-url must be an absolute http(s) URL")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls-cert-file and tls-key-file must be set together")
+	}
+	if c.H2C && c.TLSCertFile != "" {
+		return errors.New("h2c cannot be combined with tls-cert-file, HTTP/2 is negotiated over TLS")
+	}
+	return nil
+}
+
+// apply ghi cấu hình vào các giá trị dùng chung của package trước khi server chạy
+func (c *Config) apply() {
+	setupLogging(c.LogFormat, c.LogLevel)
+	SessionTTL = c.SessionTTL
+	MaxSessionTTL = c.MaxSessionTTL
+	HTTPTimeout = c.HTTPTimeout
+	DownloadTimeout = c.DownloadTimeout
+	CleanupInterval = c.CleanupInterval
+	DrainTimeout = c.DrainTimeout
+	ReadinessProbeURL = c.ReadinessURL
+	FetchConcurrency = c.Workers
+	DefaultRetries, DefaultRetryBackoff, DefaultRetryOn = c.Retries, c.RetryBackoff, c.RetryOn
+	MaxFilesPerSession, MaxFileBytes, MaxArchiveBytes = c.MaxFiles, c.MaxFileBytes, c.MaxArchiveBytes
+	PreflightSizes = c.PreflightSizes
+	SubsetDownloadsCount = c.SubsetCounts
+	MaxStreamBPS, MaxEgressBPS = c.MaxStreamBPS, c.MaxEgressBPS
+	egressBucket = newByteBucket(c.MaxEgressBPS)
+	MaxSessions, EvictionPolicy = c.MaxSessions, c.EvictionPolicy
+	DataDir, SpoolDir = c.DataDir, c.SpoolDir
+	SourceCacheDir, SourceCacheMaxBytes = c.SourceCacheDir, c.SourceCacheMax
+	PartMaxBytes = c.PartMaxBytes
+	AdminKey, WebhookSecret = c.AdminKey, c.WebhookSecret
+	PublicURL = c.PublicURL
+	LinkDomains = c.LinkDomains
+	HostProtocols = c.HostProtocols
+	TLSCertFile, TLSKeyFile, H2C = c.TLSCertFile, c.TLSKeyFile, c.H2C
+	LocalRoot = c.LocalRoot
+	RedisURL = c.RedisURL
+	AllowPrivateNetworks.Store(c.AllowPrivateNetworks)
+	AllowedSchemes = c.AllowedSchemes
+	AllowedHostSuffixes, DeniedHostSuffixes = c.AllowedHosts, c.DeniedHosts
+	ForwardHeaders, _ = parseForwardHeaders(c.ForwardHeaders)
+	CORSOrigins, CORSMethods, CORSHeaders = c.CORSOrigins, c.CORSMethods, c.CORSHeaders
+	APIKeys = c.APIKeys
+	APIKeyRateLimit = c.APIKeyRateLimit
+	HMACSecret = c.HMACSecret
+	TrustedProxies = c.TrustedProxies
+	trustedProxyPrefixes = mustParsePrefixes(c.TrustedProxies)
+	StatusCIDRs, statusCIDRPrefixes = c.StatusCIDRs, mustParsePrefixes(c.StatusCIDRs)
+	StatusSessionCIDRs = c.StatusSessionCIDRs
+	CreateRateLimit = c.CreateRateLimit
+	DownloadRateLimit = c.DownloadRateLimit
+	MaxSessionsPerIP = c.MaxSessionsPerIP
+	MaxConcurrentDownloads = c.MaxConcurrentDownloads
+	MaxDownloadsPerClient = c.MaxDownloadsPerClient
+	httpClient.Timeout = c.HTTPTimeout
+}
+
+// mapFlag là flag dạng key=value lặp lại được; một giá trị có thể chứa nhiều cặp cách nhau bằng
+// dấu phẩy (như trong biến môi trường). Flag trên dòng lệnh được gộp vào các cặp từ biến môi trường
+type mapFlag map[string]string
+
+func (m mapFlag) String() string {
+	pairs := make([]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m mapFlag) Set(s string) error {
+	for _, pair := range splitList(s) {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return fmt.Errorf("%q is not key=value", pair)
+		}
+		m[k] = v
+	}
+	return nil
+}
+
+func (c *Config) addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// server gom cấu hình và router của một instance
+type server struct {
+	cfg     Config
+	mux     *http.ServeMux
+	handler http.Handler // mux bọc request ID và access log
+}
+
+func newServer(cfg Config) *server {
+	s := &server{cfg: cfg, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/create", enableCORS(handleCreate))
+	s.mux.HandleFunc("/zip", enableCORS(handleZip))
+	s.mux.HandleFunc("/validate", enableCORS(handleValidate))
+	s.mux.HandleFunc("/download/", enableCORS(handleDownload))
+	s.mux.HandleFunc("/d/", enableCORS(handleDownload))
+	s.mux.HandleFunc("/session/", enableCORS(handleSession))
+	s.mux.HandleFunc("/templates", enableCORS(handleTemplates))
+	s.mux.HandleFunc("/templates/", enableCORS(handleTemplates))
+	s.mux.HandleFunc("/status/", enableCORS(handleStatus))
+	s.mux.HandleFunc("/result/", enableCORS(handleResult))
+	s.mux.HandleFunc("/preview/", enableCORS(handlePreview))
+	s.mux.HandleFunc("/admin/", enableCORS(handleAdmin))
+	s.mux.HandleFunc("/metrics", handleMetrics)
+	s.mux.HandleFunc("/healthz", handleHealthz)
+	s.mux.HandleFunc("/readyz", handleReadyz)
+	s.handler = withRequestLogging(s.mux)
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func parseConfig(t *testing.T, env map[string]string, args ...string) (Config, error) {
+	t.Helper()
+	return loadConfig(args, func(k string) string { return env[k] }, io.Discard)
+}
+
+func TestConfigDownloadRateLimit(t *testing.T) {
+	if cfg, err := parseConfig(t, nil, "-download-rate-limit", "5"); err != nil || cfg.DownloadRateLimit != 5 {
+		t.Fatalf("flag: %d, %v", cfg.DownloadRateLimit, err)
+	}
+	if cfg, err := parseConfig(t, map[string]string{"DOWNLOAD_RATE_LIMIT": "7"}); err != nil || cfg.DownloadRateLimit != 7 {
+		t.Fatalf("env: %d, %v", cfg.DownloadRateLimit, err)
+	}
+	if _, err := parseConfig(t, nil, "-download-rate-limit", "-1"); err == nil || !strings.Contains(err.Error(), "download-rate-limit") {
+		t.Fatalf("negative limit: %v", err)
+	}
+}
+
+func TestConfigLinkDomains(t *testing.T) {
+	cfg, err := parseConfig(t, map[string]string{"LINK_DOMAINS": "customer=downloads.example.com,staff=https://old.example"},
+		"-link-domain", "staff=http://files.internal:8080/", "-link-domain", "eu=https://eu.example.com/dl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"customer": "https://downloads.example.com",
+		"staff":    "http://files.internal:8080",
+		"eu":       "https://eu.example.com/dl",
+	}
+	if !maps.Equal(cfg.LinkDomains, want) {
+		t.Fatalf("LinkDomains = %v, want %v", cfg.LinkDomains, want)
+	}
+	for _, bad := range []string{"customer", "=x.example", "x=ftp://x.example", "x=https://x.example/?a=1"} {
+		if _, err := parseConfig(t, nil, "-link-domain", bad); err == nil || !strings.Contains(err.Error(), "link-domain") {
+			t.Errorf("-link-domain %q: %v, want an error", bad, err)
+		}
+	}
+}
+
+// download_url dùng base của linkDomain đã chọn; khi có LinkDomains, Host khác bị từ chối
+func TestLinkDomainURLs(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	domains := LinkDomains
+	t.Cleanup(func() { LinkDomains = domains })
+	cfg, err := parseConfig(t, nil, "-link-domain", "customer=downloads.example.com", "-link-domain", "local="+base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LinkDomains = cfg.LinkDomains
+
+	file := `"files":[{"url":"` + origin.URL + `/a.txt"}]`
+	link := createSession(t, base, `{`+file+`,"linkDomain":"customer","shortLink":true}`)
+	if !strings.HasPrefix(link, "https://downloads.example.com/d/") {
+		t.Fatalf("customer link = %s", link)
+	}
+	link = createSession(t, base, `{`+file+`,"linkDomain":"local"}`)
+	if !strings.HasPrefix(link, base+"/download/") {
+		t.Fatalf("local link = %s", link)
+	}
+	if status, _ := postCreateWithKey(t, base, "", `{`+file+`,"linkDomain":"unknown"}`); status != http.StatusBadRequest {
+		t.Fatalf("unknown linkDomain = %d, want 400", status)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, link, nil)
+	req.Host = "evil.example"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Fatalf("download through another Host = %d, want 421", resp.StatusCode)
+	}
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("download through the link domain = %d", status)
+	}
+}
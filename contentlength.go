@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ============== PRECOMPUTED CONTENT-LENGTH ==============
+
+// contentLength: true resolve tên và dung lượng mọi file lúc tạo (như resolveNames). Khi tất cả đều
+// biết trước, session dùng layout cố định của resumable stream nên response có Content-Length (và
+// Range); thiếu dung lượng nào thì archive được stream chunked như thường kèm một warning.
+
+// contentLengthConflict trả option không dùng được với layout cố định, "" nếu không có
+func contentLengthConflict(req *DownloadRequest, format string) string {
+	switch {
+	case req.Compression != "":
+		return "compression " + req.Compression
+	case format != "":
+		return "archiveFormat " + format
+	case req.OnError == "skip":
+		return `onError "skip"`
+	case req.FailurePlaceholders:
+		return "failurePlaceholders"
+	case req.ErrorReport == "json":
+		return `errorReport "json"`
+	case req.Checksums:
+		return "checksums"
+	case req.Open:
+		return "open"
+	case req.Password != "" || req.GeneratePassword:
+		return "password"
+	}
+	return ""
+}
+
+// fixedLayoutFiles trả bản sao của files đã đặt expectSize theo dung lượng resolve, hoặc lỗi khi
+// có file chưa biết tên hay dung lượng. files không bị sửa khi lỗi
+func fixedLayoutFiles(files []FileEntry) ([]FileEntry, error) {
+	for i, f := range files {
+		if f.resolvedName == "" || f.resolvedSize <= 0 {
+			return nil, fmt.Errorf("size of files[%d] is unknown at create time", i)
+		}
+	}
+	fixed := slices.Clone(files)
+	if err := validateResumable(fixed); err != nil {
+		return nil, err
+	}
+	return fixed, nil
+}
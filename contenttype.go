@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ============== CONTENT TYPE VALIDATION ==============
+
+// http.DetectContentType chỉ xét tối đa 512 byte đầu
+const sniffLen = 512
+
+// mismatchError là lỗi "mong đợi X nhưng nhận Y", được ghi đủ hai giá trị vào báo cáo lỗi
+type mismatchError struct {
+	What     string
+	Expected string
+	Actual   string
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %s, got %s", e.What, e.Expected, e.Actual)
+}
+
+// checkContentType so sánh Content-Type của origin với expectContentType của entry.
+// Origin không gửi Content-Type thì dùng kết quả sniff thay vì coi là lỗi.
+func checkContentType(entry FileEntry, header http.Header, sniff func() string) error {
+	if entry.ExpectContentType == "" {
+		return nil
+	}
+
+	actual := mediaType(header.Get("Content-Type"))
+	if actual == "" {
+		actual = mediaType(sniff())
+	}
+	if !matchMediaType(entry.ExpectContentType, actual) {
+		return &mismatchError{What: "content type", Expected: entry.ExpectContentType, Actual: actual}
+	}
+
+	if entry.SniffContentType {
+		if sniffed := mediaType(sniff()); !matchMediaType(entry.ExpectContentType, sniffed) {
+			return &mismatchError{What: "sniffed content type", Expected: entry.ExpectContentType, Actual: sniffed}
+		}
+	}
+	return nil
+}
+
+// matchMediaType hỗ trợ khớp chính xác, "type/*" và "*/*"
+func matchMediaType(pattern, actual string) bool {
+	pattern = mediaType(pattern)
+	if pattern == "*/*" || pattern == actual {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return strings.HasPrefix(actual, prefix+"/")
+	}
+	return false
+}
+
+func mediaType(v string) string {
+	mt, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(v))
+	}
+	return mt
+}
+
+// sniffFile đọc 512 byte đầu của file rồi đưa con trỏ về đầu
+func sniffFile(f *os.File) string {
+	b := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, b)
+	f.Seek(0, io.SeekStart)
+	return http.DetectContentType(b[:n])
+}
+
+// ResponseContentTypes là các giá trị contentType được chấp nhận cho response download, theo
+// archiveFormat ("" = zip)
+var ResponseContentTypes = map[string][]string{
+	"":       {"application/zip", "application/x-zip-compressed", "application/x-zip", "application/octet-stream"},
+	"tar":    {"application/x-tar", "application/octet-stream"},
+	"tar.gz": {"application/gzip", "application/x-gzip", "application/octet-stream"},
+}
+
+// validateResponseContentType chuẩn hóa contentType của request; tham số không được chấp nhận
+// và kết quả được format lại nên không thể chèn header
+func validateResponseContentType(v, format string) (string, error) {
+	mt, params, err := mime.ParseMediaType(v)
+	if err != nil {
+		return "", fmt.Errorf("invalid contentType: %v", err)
+	}
+	if len(params) > 0 {
+		return "", fmt.Errorf("contentType must not have parameters")
+	}
+	allowed := ResponseContentTypes[format]
+	if slices.Contains(allowed, mt) {
+		return mt, nil
+	}
+	return "", fmt.Errorf("contentType must be one of %s", strings.Join(allowed, ", "))
+}
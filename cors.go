@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// ============== CORS MIDDLEWARE ==============
+
+// Đặt bằng flag lúc khởi động. CORSOrigins rỗng = không gửi header CORS nào (trình duyệt chặn mọi
+// request cross-origin), "*" = mọi origin như trước
+var (
+	CORSOrigins = []string{"*"}                                       // --cors-origins: "https://app.example.com", "https://*.example.com" hoặc "*"
+	CORSMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"} // --cors-methods
+	// --cors-headers: header request của trình duyệt được phép gửi
+	CORSHeaders = []string{"Content-Type", "Authorization", "X-Requested-With", "X-Api-Key", "X-Request-Id"}
+)
+
+// validateCORSOrigins kiểm tra mỗi origin là "*" hoặc scheme://host[:port], host có thể bắt đầu bằng "*."
+func validateCORSOrigins(origins []string) error {
+	for _, o := range origins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("invalid origin %q, want scheme://host[:port]", o)
+		}
+		if strings.Contains(strings.TrimPrefix(u.Host, "*."), "*") {
+			return fmt.Errorf("invalid origin %q, \"*.\" is only allowed at the start of the host", o)
+		}
+	}
+	return nil
+}
+
+// corsOriginAllowed so khớp Origin của request với một mục của CORSOrigins (không phân biệt hoa thường)
+func corsOriginAllowed(pattern, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
+		return true
+	}
+	scheme, host, ok := strings.Cut(pattern, "://*.")
+	if !ok {
+		return false
+	}
+	suffix := "." + strings.ToLower(host)
+	origin = strings.ToLower(origin)
+	return strings.HasPrefix(origin, strings.ToLower(scheme)+"://") && strings.HasSuffix(origin, suffix) &&
+		len(origin) > len(scheme)+3+len(suffix)
+}
+
+// allowedCORSOrigin trả giá trị Access-Control-Allow-Origin cho request, "" khi không cho phép
+func allowedCORSOrigin(origin string) string {
+	if slices.Contains(CORSOrigins, "*") {
+		return "*"
+	}
+	if origin == "" {
+		return ""
+	}
+	for _, p := range CORSOrigins {
+		if corsOriginAllowed(p, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+func enableCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedCORSOrigin(r.Header.Get("Origin"))
+		if allowed != "*" && len(CORSOrigins) > 0 {
+			// Response khác nhau theo Origin, cache không được dùng chung
+			w.Header().Add("Vary", "Origin")
+		}
+		if allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(CORSMethods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(CORSHeaders, ", "))
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id")
+			w.Header().Set("Access-Control-Max-Age", "86400")
+		}
+
+		// Preflight: trả lời ngay, kể cả khi origin không được phép (thiếu header thì trình duyệt chặn)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(w, r)
+	}
+}
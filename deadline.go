@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ============== PER-FILE DEADLINES ==============
+
+// deadlineAbortReason là lý do abort khi archive hết tổng thời gian
+const deadlineAbortReason = "deadline exceeded"
+
+// deadlinePolicy chia tổng thời gian của archive cho từng file, để một nguồn bị treo
+// không ăn hết thời gian của các file còn lại
+type deadlinePolicy struct {
+	Total          time.Duration // Tổng thời gian cho cả archive
+	PerFile        time.Duration // Trần cho mỗi file
+	FairnessFactor float64       // Một file được dùng tối đa bấy nhiêu lần phần chia đều của thời gian còn lại
+}
+
+// parseDeadlinePolicy đọc totalTimeout/perFileTimeout/fairnessFactor của request, áp mặc định và trần của server
+func parseDeadlinePolicy(total, perFile string, fairness float64) (deadlinePolicy, error) {
+	p := deadlinePolicy{Total: DownloadTimeout, PerFile: DefaultPerFileTimeout, FairnessFactor: DefaultFairnessFactor}
+	if total != "" {
+		d, err := time.ParseDuration(total)
+		if err != nil || d <= 0 || d > DownloadTimeout {
+			return p, fmt.Errorf("totalTimeout must be a duration between 0 and %v", DownloadTimeout)
+		}
+		p.Total = d
+	}
+	if perFile != "" {
+		d, err := time.ParseDuration(perFile)
+		if err != nil || d <= 0 || d > DownloadTimeout {
+			return p, fmt.Errorf("perFileTimeout must be a duration between 0 and %v", DownloadTimeout)
+		}
+		p.PerFile = d
+	}
+	if fairness != 0 {
+		if fairness < 1 || fairness > MaxFairnessFactor {
+			return p, fmt.Errorf("fairnessFactor must be between 1 and %g", MaxFairnessFactor)
+		}
+		p.FairnessFactor = fairness
+	}
+	return p, nil
+}
+
+// fileDeadline = min(perFile, thời gian còn lại / số file còn lại * fairnessFactor),
+// tính lại trước mỗi file nên file nhanh nhường thời gian dư cho các file sau
+func (p deadlinePolicy) fileDeadline(start, now time.Time, remainingFiles int) time.Duration {
+	remaining := p.Total - now.Sub(start)
+	if remaining <= 0 {
+		return 0
+	}
+	d := min(p.PerFile, remaining)
+	if remainingFiles > 0 {
+		share := time.Duration(float64(remaining) / float64(remainingFiles) * p.FairnessFactor)
+		d = min(d, share)
+	}
+	return d
+}
+
+// fileDeadlineError đổi lỗi do deadline của riêng file thành thông báo rõ ràng,
+// lỗi do hết tổng thời gian hoặc client ngắt được giữ nguyên
+func fileDeadlineError(err error, fileCtx, archiveCtx context.Context, d time.Duration) error {
+	if archiveCtx.Err() == nil && errors.Is(fileCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("file deadline of %v exceeded: %w", d.Round(time.Millisecond), err)
+	}
+	return err
+}
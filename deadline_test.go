@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// simulateDeadlines chạy archive trên đồng hồ giả: file i cần durations[i], bị cắt ở deadline của nó.
+// Trả về deadline đã cấp và file nào xong trong hạn
+func simulateDeadlines(p deadlinePolicy, durations []time.Duration) ([]time.Duration, []bool) {
+	start := time.Unix(0, 0)
+	now := start
+	deadlines := make([]time.Duration, len(durations))
+	done := make([]bool, len(durations))
+	for i, need := range durations {
+		d := p.fileDeadline(start, now, len(durations)-i)
+		deadlines[i] = d
+		if need <= d {
+			done[i] = true
+			now = now.Add(need)
+		} else {
+			now = now.Add(d)
+		}
+	}
+	return deadlines, done
+}
+
+func TestFileDeadlineFastSlowMix(t *testing.T) {
+	p := deadlinePolicy{Total: 100 * time.Second, PerFile: time.Minute, FairnessFactor: 2}
+	// File thứ hai bị treo: chỉ được tối đa 2 lần phần chia đều, các file sau vẫn đủ thời gian
+	durations := []time.Duration{time.Second, time.Hour, time.Second, time.Second, time.Second}
+	deadlines, done := simulateDeadlines(p, durations)
+
+	if want := 2 * 99 * time.Second / 4; deadlines[1] != want {
+		t.Fatalf("stuck file deadline = %v, want %v", deadlines[1], want)
+	}
+	for i, ok := range done {
+		if ok != (i != 1) {
+			t.Fatalf("file %d done = %v (deadlines %v)", i, ok, deadlines)
+		}
+	}
+}
+
+func TestFileDeadlinePerFileCap(t *testing.T) {
+	p := deadlinePolicy{Total: time.Hour, PerFile: 10 * time.Second, FairnessFactor: 2}
+	if d := p.fileDeadline(time.Unix(0, 0), time.Unix(0, 0), 1); d != 10*time.Second {
+		t.Fatalf("deadline = %v, want per-file cap", d)
+	}
+}
+
+func TestFileDeadlineAllSlow(t *testing.T) {
+	p := deadlinePolicy{Total: 60 * time.Second, PerFile: time.Hour, FairnessFactor: 1.5}
+	durations := make([]time.Duration, 6)
+	for i := range durations {
+		durations[i] = time.Hour
+	}
+	deadlines, done := simulateDeadlines(p, durations)
+
+	var used time.Duration
+	for i, d := range deadlines {
+		if done[i] {
+			t.Fatalf("file %d finished", i)
+		}
+		if d <= 0 && i < len(deadlines)-1 {
+			t.Fatalf("file %d got no time (deadlines %v)", i, deadlines)
+		}
+		used += d
+	}
+	if used > p.Total {
+		t.Fatalf("deadlines sum to %v, more than the %v budget", used, p.Total)
+	}
+}
+
+func TestFileDeadlineBudgetSpent(t *testing.T) {
+	p := deadlinePolicy{Total: time.Second, PerFile: time.Second, FairnessFactor: 1}
+	start := time.Unix(0, 0)
+	if d := p.fileDeadline(start, start.Add(2*time.Second), 3); d != 0 {
+		t.Fatalf("deadline = %v after budget, want 0", d)
+	}
+}
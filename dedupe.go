@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ============== CONTENT DEDUPE ==============
+
+// Các query param chữ ký của presigned URL (S3, GCS, Azure SAS, CloudFront), so sánh lowercase
+var signatureParams = map[string]bool{
+	"signature":      true,
+	"expires":        true,
+	"awsaccesskeyid": true,
+	"googleaccessid": true,
+	"key-pair-id":    true,
+	"policy":         true,
+	"sig":            true,
+	"se":             true,
+	"st":             true,
+	"sp":             true,
+	"sv":             true,
+	"sr":             true,
+	"spr":            true,
+	"skoid":          true,
+	"sktid":          true,
+	"skt":            true,
+	"ske":            true,
+	"sks":            true,
+	"skv":            true,
+}
+
+// normalizeSourceURL bỏ fragment và các param chữ ký để các presigned URL của cùng object trùng khóa
+func normalizeSourceURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+
+	q := u.Query()
+	for k := range q {
+		lk := strings.ToLower(k)
+		if signatureParams[lk] || strings.HasPrefix(lk, "x-amz-") || strings.HasPrefix(lk, "x-goog-") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode()
+	u.Fragment = ""
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String()
+}
+
+// sourceKey là khóa dedupe của entry: URL đã chuẩn hóa, cộng dấu vân tay header riêng nếu có
+// để entry dùng credential khác không nhận nội dung của nhau
+func (f FileEntry) sourceKey() string {
+	return normalizeSourceURL(f.URL) + headersFingerprint(f.headers)
+}
+
+// dropDuplicateFiles bỏ các entry của added có URL (đã chuẩn hóa) và header riêng giống hệt một
+// entry trước đó, kể cả trong existing. Warning mang vị trí của entry bị bỏ trong added
+func dropDuplicateFiles(existing, added []FileEntry) ([]FileEntry, []Warning) {
+	seen := make(map[string]int, len(existing)+len(added))
+	for i, f := range existing {
+		key := strings.TrimSpace(f.URL) + headersFingerprint(f.headers)
+		if _, ok := seen[key]; !ok {
+			seen[key] = i
+		}
+	}
+
+	kept := added[:0:0]
+	var warnings []Warning
+	for i, f := range added {
+		key := strings.TrimSpace(f.URL) + headersFingerprint(f.headers)
+		if first, ok := seen[key]; ok {
+			index := i
+			warnings = append(warnings, Warning{
+				Code:    "duplicate_dropped",
+				Message: fmt.Sprintf("Same URL as files[%d]; dropped because dedupe is on", first),
+				Index:   &index,
+			})
+			continue
+		}
+		seen[key] = len(existing) + len(kept)
+		kept = append(kept, f)
+	}
+	return kept, warnings
+}
+
+// cachedContent là bản sao trên disk của một file đã stream trong archive hiện tại
+type cachedContent struct {
+	path   string
+	rawURL string
+	etag   string
+	name   string
+	size   int64
+	header http.Header // Response header của lần tải đầu (dùng cho mode...)
+
+	release func() // Xóa file tạm
+}
+
+// dedupeCache chỉ giữ bản sao của những khóa xuất hiện nhiều lần trong session
+type dedupeCache struct {
+	spool   *spoolReservation
+	wanted  map[string]int
+	entries map[string]*cachedContent
+}
+
+func newDedupeCache(spool *spoolReservation, files []FileEntry) *dedupeCache {
+	c := &dedupeCache{
+		spool:   spool,
+		wanted:  make(map[string]int),
+		entries: make(map[string]*cachedContent),
+	}
+	for _, f := range files {
+		c.wanted[f.sourceKey()]++
+	}
+	return c
+}
+
+// exact trả về bản đã lưu khi URL gốc giống hệt, không cần request tới origin
+func (c *dedupeCache) exact(key, rawURL string) *cachedContent {
+	cached := c.entries[key]
+	if cached == nil || strings.TrimSpace(cached.rawURL) != strings.TrimSpace(rawURL) {
+		return nil
+	}
+	return cached
+}
+
+// matchETag trả về bản đã lưu khi URL khác chữ ký nhưng ETag trùng khớp
+func (c *dedupeCache) matchETag(key string, resp *http.Response) *cachedContent {
+	cached := c.entries[key]
+	etag := resp.Header.Get("ETag")
+	if cached == nil || etag == "" || cached.etag != etag {
+		return nil
+	}
+	return cached
+}
+
+// capture trả về reader để stream vào zip, đồng thời ghi ra file tạm nếu khóa còn được dùng lại.
+// size (0 hoặc âm = không rõ) được đặt trước trong phần spool của download.
+// finish(ok) phải được gọi sau khi stream xong; chỉ giữ bản sao khi ok.
+func (c *dedupeCache) capture(key, rawURL, name string, size int64, header http.Header, body io.Reader) (io.Reader, func(ok bool)) {
+	noop := func(bool) {}
+	if c.wanted[key] < 2 || c.entries[key] != nil {
+		return body, noop
+	}
+
+	f, release, err := createSpoolFile(c.spool, "dedupe", size)
+	if err != nil {
+		slog.Warn("Dedupe disabled", "name", name, "error", err)
+		return body, noop
+	}
+
+	cw := &captureWriter{f: f}
+	return io.TeeReader(body, cw), func(ok bool) {
+		closeErr := f.Close()
+		if !ok || cw.err != nil || closeErr != nil {
+			release()
+			return
+		}
+		c.entries[key] = &cachedContent{
+			path:    f.Name(),
+			rawURL:  rawURL,
+			etag:    header.Get("ETag"),
+			name:    name,
+			size:    cw.n,
+			header:  header,
+			release: release,
+		}
+	}
+}
+
+func (c *dedupeCache) cleanup() {
+	for _, cached := range c.entries {
+		cached.release()
+	}
+}
+
+// captureWriter không bao giờ trả lỗi để lỗi disk không làm hỏng entry đang stream
+type captureWriter struct {
+	f   *os.File
+	n   int64
+	err error
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if w.err == nil {
+		n, err := w.f.Write(b)
+		w.n += int64(n)
+		w.err = err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// methodOrigin đếm request theo "METHOD path"
+func methodOrigin(t *testing.T) (*httptest.Server, func() map[string]int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		counts[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Length", fmt.Sprint(len("content of "+r.URL.Path)))
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		snapshot := make(map[string]int, len(counts))
+		for k, v := range counts {
+			snapshot[k] = v
+			delete(counts, k)
+		}
+		return snapshot
+	}
+}
+
+// URL lặp lại (kể cả khác dạng viết trước khi chuẩn hóa) chỉ được GET một lần, kể cả lúc tạo
+// session: mặc định nội dung được ghi lại cho từng entry, dedupe bỏ hẳn entry trùng, resolveNames
+// chỉ HEAD mỗi URL một lần
+func TestDedupeOriginRequests(t *testing.T) {
+	origin, counts := methodOrigin(t)
+	base := newTestServer(t)
+	files := fmt.Sprintf(`"%[1]s/a.txt","%[2]s/./a.txt","%[1]s/b.txt","%[1]s/a.txt"`, origin.URL, strings.Replace(origin.URL, "http://", "HTTP://", 1))
+
+	for _, tc := range []struct {
+		options string
+		entries map[string]string
+		want    map[string]int
+	}{
+		{"", map[string]string{"a.txt": "content of /a.txt", "a_2.txt": "content of /a.txt", "b.txt": "content of /b.txt", "a_3.txt": "content of /a.txt"},
+			map[string]int{"GET /a.txt": 1, "GET /b.txt": 1}},
+		{`,"dedupe":true`, map[string]string{"a.txt": "content of /a.txt", "b.txt": "content of /b.txt"},
+			map[string]int{"GET /a.txt": 1, "GET /b.txt": 1}},
+		{`,"resolveNames":true`, map[string]string{"a.txt": "content of /a.txt", "a_2.txt": "content of /a.txt", "b.txt": "content of /b.txt", "a_3.txt": "content of /a.txt"},
+			map[string]int{"HEAD /a.txt": 1, "HEAD /b.txt": 1, "GET /a.txt": 1, "GET /b.txt": 1}},
+	} {
+		counts()
+		link := createSession(t, base, `{"files":[`+files+`]`+tc.options+`}`)
+		status, data := download(t, link)
+		if status != http.StatusOK {
+			t.Fatalf("%s: download = %d", tc.options, status)
+		}
+		if got := unzip(t, data); fmt.Sprint(got) != fmt.Sprint(tc.entries) {
+			t.Errorf("%s: entries = %v, want %v", tc.options, got, tc.entries)
+		}
+		if got := counts(); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: origin requests = %v, want %v", tc.options, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Client bỏ đi giữa chừng không tiêu thụ link dùng một lần; lần tải trọn vẹn sau đó tiêu thụ nó và
+// lần dùng lại nhận 410
+func TestDisconnectThenReuse(t *testing.T) {
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1000))
+		w.(http.Flusher).Flush()
+		if requests.Add(1) == 1 {
+			<-r.Context().Done() // Treo tới khi download bị hủy
+			return
+		}
+		io.WriteString(w, "end")
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}]}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	go func() {
+		for requests.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Chờ server ghi nhận download bị bỏ dở
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := download(t, base+"/status/"+token)
+		if !strings.Contains(string(body), `"state":"in_progress"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status after disconnect = %s", body)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if status, result := download(t, base+"/result/"+token); status != http.StatusOK || !strings.Contains(string(result), `"status":"client_disconnected"`) {
+		t.Fatalf("result after disconnect = %d %s", status, result)
+	}
+
+	status, data := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download after disconnect = %d: %s", status, data)
+	}
+	if got := unzip(t, data)["a.txt"]; got != strings.Repeat("x", 1000)+"end" {
+		t.Fatalf("a.txt = %d bytes, want the whole file", len(got))
+	}
+	if status, body := download(t, link); status != http.StatusGone {
+		t.Fatalf("reuse after a completed download = %d %s, want 410", status, body)
+	}
+}
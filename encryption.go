@@ -0,0 +1,183 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// ============== ARCHIVE ENCRYPTION ==============
+
+// password (hoặc generatePassword) mã hóa nội dung mọi entry của zip bằng AES-256 theo định dạng
+// WinZip AE-1, đọc được bằng 7-Zip, WinZip, WinRAR, bsdtar. Tên entry và metadata không được mã
+// hóa. Mật khẩu là secret của session như header forward: không trả lại qua API sau /create.
+
+const (
+	aesMethod     = 99     // Compression method của entry mã hóa AES
+	aesExtraID    = 0x9901 // Extra field AES của WinZip
+	aesKeySize    = 32     // AES-256
+	aesSaltSize   = 16
+	aesIterations = 1000 // PBKDF2-HMAC-SHA1 theo định dạng
+	aesAuthSize   = 10   // HMAC-SHA1 của dữ liệu mã hóa, cắt còn 10 byte
+
+	zipDeflateLevel   = 5 // Mức deflate mặc định của archive/zip
+	maxPasswordLength = 256
+)
+
+// archivePassword kiểm tra password/generatePassword của request và trả mật khẩu đã chọn, "" khi
+// không mã hóa; generated báo mật khẩu do server sinh (trả về trong response)
+func archivePassword(req *DownloadRequest, format string) (password string, generated bool, err error) {
+	switch {
+	case req.Password == "" && !req.GeneratePassword:
+		return "", false, nil
+	case req.Password != "" && req.GeneratePassword:
+		return "", false, errors.New("password and generatePassword cannot be combined")
+	case format != "":
+		return "", false, errors.New("password requires a zip archive")
+	case req.Resumable && req.ResumableMode == "":
+		// Layout resumable stream tính offset từ dung lượng gốc
+		return "", false, errors.New(`password requires resumableMode "file" on resumable sessions`)
+	case len(req.Password) > maxPasswordLength:
+		return "", false, fmt.Errorf("password is too long (max %d bytes)", maxPasswordLength)
+	case req.GeneratePassword:
+		return rand.Text(), true, nil
+	}
+	return req.Password, false, nil
+}
+
+// aesExtra tạo extra field AES: vendor version 1 (AE-1, giữ CRC-32), "AE", strength 3 (256 bit) và
+// compression method thật của dữ liệu trước khi mã hóa
+func aesExtra(method uint16) []byte {
+	b := make([]byte, 11)
+	binary.LittleEndian.PutUint16(b[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 7)
+	binary.LittleEndian.PutUint16(b[4:], 1)
+	copy(b[6:], "AE")
+	b[8] = 3
+	binary.LittleEndian.PutUint16(b[9:], method)
+	return b
+}
+
+// encryptEntry chuyển header sang method AES và đăng ký compressor của entry này trên zw:
+// nén theo method gốc (deflate mức level) rồi mã hóa. Gọi ngay trước CreateHeader
+func encryptEntry(zw *zip.Writer, header *zip.FileHeader, password string, level int) {
+	method := header.Method
+	header.Method = aesMethod
+	header.Flags |= 0x1 // Entry được mã hóa
+	header.Extra = append(header.Extra, aesExtra(method)...)
+	zw.RegisterCompressor(aesMethod, func(out io.Writer) (io.WriteCloser, error) {
+		aw, err := newAESWriter(out, password)
+		if err != nil {
+			return nil, err
+		}
+		if method != zip.Deflate {
+			return aw, nil
+		}
+		fw, err := deflateCompressor(cmp.Or(level, zipDeflateLevel))(aw)
+		if err != nil {
+			return nil, err
+		}
+		return &deflateAESWriter{WriteCloser: fw, aes: aw}, nil
+	})
+}
+
+// aesWriter mã hóa AES-CTR (counter little-endian bắt đầu từ 1 như WinZip) và ghi salt, giá trị
+// kiểm tra mật khẩu trước dữ liệu, mã xác thực HMAC-SHA1 sau dữ liệu
+type aesWriter struct {
+	out     io.Writer
+	prefix  []byte // Salt và giá trị kiểm tra, ghi ở lần ghi đầu: archive/zip tạo compressor trước local header
+	block   cipher.Block
+	mac     hash.Hash
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // Số byte đã dùng của stream
+	buf     []byte
+}
+
+func newAESWriter(out io.Writer, password string) (*aesWriter, error) {
+	salt := make([]byte, aesSaltSize)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha1.New, password, salt, aesIterations, 2*aesKeySize+2)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key[:aesKeySize])
+	if err != nil {
+		return nil, err
+	}
+	return &aesWriter{
+		out:    out,
+		prefix: append(salt, key[2*aesKeySize:]...),
+		block:  block,
+		mac:    hmac.New(sha1.New, key[aesKeySize:2*aesKeySize]),
+		used:   aes.BlockSize,
+	}, nil
+}
+
+func (w *aesWriter) writePrefix() error {
+	if w.prefix == nil {
+		return nil
+	}
+	_, err := w.out.Write(w.prefix)
+	w.prefix = nil
+	return err
+}
+
+func (w *aesWriter) Write(p []byte) (int, error) {
+	if err := w.writePrefix(); err != nil {
+		return 0, err
+	}
+	w.buf = append(w.buf[:0], p...)
+	for b := w.buf; len(b) > 0; {
+		if w.used == aes.BlockSize {
+			for i := range w.counter {
+				w.counter[i]++
+				if w.counter[i] != 0 {
+					break
+				}
+			}
+			w.block.Encrypt(w.stream[:], w.counter[:])
+			w.used = 0
+		}
+		n := subtle.XORBytes(b, b, w.stream[w.used:])
+		w.used += n
+		b = b[n:]
+	}
+	w.mac.Write(w.buf)
+	if _, err := w.out.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *aesWriter) Close() error {
+	if err := w.writePrefix(); err != nil {
+		return err
+	}
+	_, err := w.out.Write(w.mac.Sum(nil)[:aesAuthSize])
+	return err
+}
+
+// deflateAESWriter đóng flate trước để phần cuối của dữ liệu nén cũng được mã hóa
+type deflateAESWriter struct {
+	io.WriteCloser
+	aes *aesWriter
+}
+
+func (w *deflateAESWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return w.aes.Close()
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============== ENTRY NAME POLICY ==============
+
+// Mọi tên entry đi qua cùng một lớp lúc resolve, /validate, /preview và download. Tên lấy từ
+// origin (Content-Disposition, URL) chỉ giữ phần base nên "../../x" thành "x"; mọi đoạn của tên
+// được chuẩn hóa NFC (tên NFD từ macOS), ký tự Windows không cho phép thành "_", tên thiết bị như
+// CON được thêm "_" và độ dài bị cắt ở MaxNameBytes. Sau đó nameConflict của session quyết định
+// khi hai file trùng tên, xem nameConflictPolicies.
+
+// MaxNameBytes là độ dài tối đa (byte UTF-8) của mỗi đoạn trong tên entry, giới hạn tên file của
+// hầu hết hệ thống file
+const MaxNameBytes = 255
+
+// nameOptions là cách đặt tên entry của một session
+type nameOptions struct {
+	ASCII    bool
+	Conflict string // Key của nameConflictPolicies, rỗng = "suffix"
+}
+
+func (s *Session) nameOptions() nameOptions {
+	return nameOptions{ASCII: s.ASCIINames, Conflict: s.NameConflict}
+}
+
+// nameConflictPolicy xử lý tên entry có thể đã được dùng trong archive
+type nameConflictPolicy struct {
+	folder  func(f FileEntry) string                               // Thư mục đặt trước mọi tên, nil = không
+	resolve func(used map[string]int, name string) (string, error) // Tên cuối cùng, đánh dấu vào used
+}
+
+// nameConflictPolicies là các giá trị của nameConflict. Thêm chính sách mới bằng một entry ở đây
+var nameConflictPolicies = map[string]nameConflictPolicy{
+	"suffix":    {resolve: suffixName},                     // photo.jpg, photo_2.jpg (mặc định)
+	"overwrite": {resolve: overwriteName},                  // Giữ nguyên tên, khi giải nén file sau ghi đè file trước
+	"error":     {resolve: rejectDuplicateName},            // File trùng tên bị tính là lỗi
+	"host":      {folder: hostFolder, resolve: suffixName}, // example.com/photo.jpg, cdn.example.net/photo.jpg
+}
+
+func suffixName(used map[string]int, name string) (string, error) {
+	return uniqueName(used, name), nil
+}
+
+func overwriteName(used map[string]int, name string) (string, error) {
+	if _, taken := used[name]; !taken {
+		used[name] = 1
+	}
+	return name, nil
+}
+
+func rejectDuplicateName(used map[string]int, name string) (string, error) {
+	if _, taken := used[name]; taken {
+		return "", fmt.Errorf("name %q is already used by another file (nameConflict: error)", name)
+	}
+	used[name] = 1
+	return name, nil
+}
+
+// hostFolder là host của URL làm thư mục, "local" cho file:// và URL không có host
+func hostFolder(f FileEntry) string {
+	u, err := url.Parse(f.URL)
+	if err != nil || u.Hostname() == "" {
+		return "local"
+	}
+	return sanitizeNameSegment(strings.ToLower(u.Hostname()))
+}
+
+// entryNamer đặt tên các entry của một archive theo thứ tự file
+type entryNamer struct {
+	opts   nameOptions
+	policy nameConflictPolicy
+	used   map[string]int // Như uniqueName
+}
+
+func newEntryNamer(opts nameOptions) *entryNamer {
+	policy, ok := nameConflictPolicies[opts.Conflict]
+	if !ok {
+		policy = nameConflictPolicies["suffix"]
+	}
+	return &entryNamer{opts: opts, policy: policy, used: make(map[string]int)}
+}
+
+// reserve đánh dấu tên đã chốt lúc tạo (resolveNames) để các file còn lại không lấy trùng
+func (n *entryNamer) reserve(name string) {
+	n.used[name] = 1
+}
+
+// name là tên entry của f với fileName lấy từ origin
+func (n *entryNamer) name(f FileEntry, fileName string) (string, error) {
+	name := sanitizeEntryPath(f.archiveName(sanitizeFileName(fileName)))
+	// Chuyển ASCII trước khi xử lý trùng tên để các tên gộp về cùng chuỗi được thêm hậu tố
+	if n.opts.ASCII {
+		name = toASCIIName(name)
+	}
+	if n.policy.folder != nil {
+		name = n.policy.folder(f) + "/" + name
+	}
+	return n.policy.resolve(n.used, name)
+}
+
+// placeholder là tên FAILED_<tên>.txt của file lỗi, cùng thư mục với entry
+func (n *entryNamer) placeholder(f FileEntry) string {
+	name := placeholderName(f)
+	if n.opts.ASCII {
+		name = toASCIIName(name)
+	}
+	if f.resolvedName == "" && n.policy.folder != nil {
+		name = n.policy.folder(f) + "/" + name
+	}
+	return uniqueName(n.used, name)
+}
+
+// unique là tên cho entry do server sinh ra (manifest.json, checksums...), luôn thêm hậu tố khi trùng
+func (n *entryNamer) unique(name string) string {
+	return uniqueName(n.used, name)
+}
+
+// sanitizeFileName giữ đoạn cuối của tên lấy từ origin (bỏ mọi thư mục và "..", dấu \ tính như
+// /) rồi làm sạch như sanitizeNameSegment. Không còn gì thì là "file"
+func sanitizeFileName(name string) string {
+	segs := strings.Split(strings.ReplaceAll(name, "\\", "/"), "/")
+	for i := len(segs) - 1; i >= 0; i-- {
+		if s := segs[i]; s != "" && s != "." && s != ".." {
+			return sanitizeNameSegment(s)
+		}
+	}
+	return "file"
+}
+
+// sanitizeEntryPath làm sạch từng đoạn của tên entry, bỏ đoạn rỗng, "." và ".."
+func sanitizeEntryPath(name string) string {
+	var segs []string
+	for _, s := range strings.Split(strings.ReplaceAll(name, "\\", "/"), "/") {
+		if s != "" && s != "." && s != ".." {
+			segs = append(segs, sanitizeNameSegment(s))
+		}
+	}
+	if len(segs) == 0 {
+		return "file"
+	}
+	return strings.Join(segs, "/")
+}
+
+// windowsReserved là tên thiết bị Windows không dùng được làm tên file, kể cả khi có phần mở rộng
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeNameSegment làm sạch một đoạn tên (không chứa "/"): NFC, ký tự điều khiển và <>:"\|?*
+// thành "_", bỏ dấu chấm và khoảng trắng cuối, tránh tên thiết bị Windows, cắt ở MaxNameBytes
+// nhưng giữ phần mở rộng
+func sanitizeNameSegment(seg string) string {
+	seg = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f, strings.ContainsRune(`<>:"\|?*`, r):
+			return '_'
+		}
+		return r
+	}, composeNFC(seg))
+	seg = strings.TrimRight(seg, ". ")
+	if stem, _, _ := strings.Cut(seg, "."); windowsReserved[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		seg = "_" + seg
+	}
+	if len(seg) > MaxNameBytes {
+		ext := path.Ext(seg)
+		if len(ext) > 16 || len(ext) == len(seg) {
+			ext = ""
+		}
+		base := seg[:len(seg)-len(ext)]
+		for len(base)+len(ext) > MaxNameBytes {
+			_, size := utf8.DecodeLastRuneInString(base)
+			base = base[:len(base)-size]
+		}
+		seg = strings.TrimRight(base, ". ") + ext
+	}
+	if seg == "" {
+		return "_"
+	}
+	return seg
+}
+
+// nfcMark là các cặp hợp thành của một dấu kết hợp: base[i] + dấu → composed[i]. ccc là
+// canonical combining class của dấu
+type nfcMark struct {
+	ccc            uint8
+	base, composed string
+}
+
+// nfcCompositions là các cặp hợp thành chuẩn (Unicode 14) của chữ Latin, Hy Lạp, Cyrillic và
+// kana, sinh từ UnicodeData; đủ để gộp lại tên NFD (macOS, một số client zip). Hangul được ghép
+// bằng công thức trong composeHangul
+var nfcCompositions = map[rune]nfcMark{
+	0x0300: {230, "AEIOUaeiouÜüNnЕИеиĒēŌōWwÂâĂăÊêÔôƠơƯưYyἀἁἈἉἐἑἘἙἠἡἨἩἰἱἸἹὀὁὈὉὐὑὙὠὡὨὩαεηιουωΑΕΗ᾿ϊΙ῾ϋΥ¨ΟΩ", "ÀÈÌÒÙàèìòùǛǜǸǹЀЍѐѝḔḕṐṑẀẁẦầẰằỀềỒồỜờỪừỲỳἂἃἊἋἒἓἚἛἢἣἪἫἲἳἺἻὂὃὊὋὒὓὛὢὣὪὫὰὲὴὶὸὺὼᾺῈῊ῍ῒῚ῝ῢῪ῭ῸῺ"},
+	0x0301: {230, "AEIOUYaeiouyCcLlNnRrSsZzÜüGgÅåÆæØø¨ΑΕΗΙΟΥΩϊαεηιϋουωϒГКгкÇçĒēÏïKkMmÕõŌōPpŨũWwÂâĂăÊêÔôƠơƯưἀἁἈἉἐἑἘἙἠἡἨἩἰἱἸἹὀὁὈὉὐὑὙὠὡὨὩ᾿῾", "ÁÉÍÓÚÝáéíóúýĆćĹĺŃńŔŕŚśŹźǗǘǴǵǺǻǼǽǾǿ΅ΆΈΉΊΌΎΏΐάέήίΰόύώϓЃЌѓќḈḉḖḗḮḯḰḱḾḿṌṍṒṓṔṕṸṹẂẃẤấẮắẾếỐốỚớỨứἄἅἌἍἔἕἜἝἤἥἬἭἴἵἼἽὄὅὌὍὔὕὝὤὥὬὭ῎῞"},
+	0x0302: {230, "AEIOUaeiouCcGgHhJjSsWwYyZzẠạẸẹỌọ", "ÂÊÎÔÛâêîôûĈĉĜĝĤĥĴĵŜŝŴŵŶŷẐẑẬậỆệỘộ"},
+	0x0303: {230, "ANOanoIiUuVvÂâĂăEeÊêÔôƠơƯưYy", "ÃÑÕãñõĨĩŨũṼṽẪẫẴẵẼẽỄễỖỗỠỡỮữỸỹ"},
+	0x0304: {230, "AaEeIiOoUuÜüÄäȦȧÆæǪǫÖöÕõȮȯYyИиУуGgḶḷṚṛαΑιΙυΥ", "ĀāĒēĪīŌōŪūǕǖǞǟǠǡǢǣǬǭȪȫȬȭȰȱȲȳӢӣӮӯḠḡḸḹṜṝᾱᾹῑῙῡῩ"},
+	0x0306: {230, "AaEeGgIiOoUuУИиуЖжАаЕеȨȩẠạαΑιΙυΥ", "ĂăĔĕĞğĬĭŎŏŬŭЎЙйўӁӂӐӑӖӗḜḝẶặᾰᾸῐῘῠῨ"},
+	0x0307: {230, "CcEeGgIZzAaOoBbDdFfHhMmNnPpRrSsŚśŠšṢṣTtWwXxYyſ", "ĊċĖėĠġİŻżȦȧȮȯḂḃḊḋḞḟḢḣṀṁṄṅṖṗṘṙṠṡṤṥṦṧṨṩṪṫẆẇẊẋẎẏẛ"},
+	0x0308: {230, "AEIOUaeiouyYΙΥιυϒЕІеіАаӘәЖжЗзИиОоӨөЭэУуЧчЫыHhÕõŪūWwXxt", "ÄËÏÖÜäëïöüÿŸΪΫϊϋϔЁЇёїӒӓӚӛӜӝӞӟӤӥӦӧӪӫӬӭӰӱӴӵӸӹḦḧṎṏṺṻẄẅẌẍẗ"},
+	0x0309: {230, "AaÂâĂăEeÊêIiOoÔôƠơUuƯưYy", "ẢảẨẩẲẳẺẻỂểỈỉỎỏỔổỞởỦủỬửỶỷ"},
+	0x030A: {230, "AaUuwy", "ÅåŮůẘẙ"},
+	0x030B: {230, "OoUuУу", "ŐőŰűӲӳ"},
+	0x030C: {230, "CcDdEeLlNnRrSsTtZzAaIiOoUuÜüGgKkƷʒjHh", "ČčĎďĚěĽľŇňŘřŠšŤťŽžǍǎǏǐǑǒǓǔǙǚǦǧǨǩǮǯǰȞȟ"},
+	0x030F: {230, "AaEeIiOoRrUuѴѵ", "ȀȁȄȅȈȉȌȍȐȑȔȕѶѷ"},
+	0x0311: {230, "AaEeIiOoRrUu", "ȂȃȆȇȊȋȎȏȒȓȖȗ"},
+	0x0313: {230, "αΑεΕηΗιΙοΟυωΩρ", "ἀἈἐἘἠἨἰἸὀὈὐὠὨῤ"},
+	0x0314: {230, "αΑεΕηΗιΙοΟυΥωΩρΡ", "ἁἉἑἙἡἩἱἹὁὉὑὙὡὩῥῬ"},
+	0x031B: {216, "OoUu", "ƠơƯư"},
+	0x0323: {220, "BbDdHhKkLlMmNnRrSsTtVvWwZzAaEeIiOoƠơUuƯưYy", "ḄḅḌḍḤḥḲḳḶḷṂṃṆṇṚṛṢṣṬṭṾṿẈẉẒẓẠạẸẹỊịỌọỢợỤụỰựỴỵ"},
+	0x0324: {220, "Uu", "Ṳṳ"},
+	0x0325: {220, "Aa", "Ḁḁ"},
+	0x0326: {220, "SsTt", "ȘșȚț"},
+	0x0327: {202, "CcGgKkLlNnRrSsTtEeDdHh", "ÇçĢģĶķĻļŅņŖŗŞşŢţȨȩḐḑḨḩ"},
+	0x0328: {202, "AaEeIiUuOo", "ĄąĘęĮįŲųǪǫ"},
+	0x032D: {220, "DdEeLlNnTtUu", "ḒḓḘḙḼḽṊṋṰṱṶṷ"},
+	0x032E: {220, "Hh", "Ḫḫ"},
+	0x0330: {220, "EeIiUu", "ḚḛḬḭṴṵ"},
+	0x0331: {220, "BbDdKkLlNnRrTtZzh", "ḆḇḎḏḴḵḺḻṈṉṞṟṮṯẔẕẖ"},
+	0x0342: {230, "ἀἁἈἉἠἡἨἩἰἱἸἹὐὑὙὠὡὨὩα¨η᾿ιϊ῾υϋω", "ἆἇἎἏἦἧἮἯἶἷἾἿὖὗὟὦὧὮὯᾶ῁ῆ῏ῖῗ῟ῦῧῶ"},
+	0x0345: {240, "ἀἁἂἃἄἅἆἇἈἉἊἋἌἍἎἏἠἡἢἣἤἥἦἧἨἩἪἫἬἭἮἯὠὡὢὣὤὥὦὧὨὩὪὫὬὭὮὯὰαάᾶΑὴηήῆΗὼωώῶΩ", "ᾀᾁᾂᾃᾄᾅᾆᾇᾈᾉᾊᾋᾌᾍᾎᾏᾐᾑᾒᾓᾔᾕᾖᾗᾘᾙᾚᾛᾜᾝᾞᾟᾠᾡᾢᾣᾤᾥᾦᾧᾨᾩᾪᾫᾬᾭᾮᾯᾲᾳᾴᾷᾼῂῃῄῇῌῲῳῴῷῼ"},
+	0x3099: {8, "かきくけこさしすせそたちつてとはひふへほうゝカキクケコサシスセソタチツテトハヒフヘホウワヰヱヲヽ", "がぎぐげござじずぜぞだぢづでどばびぶべぼゔゞガギグゲゴザジズゼゾダヂヅデドバビブベボヴヷヸヹヺヾ"},
+	0x309A: {8, "はひふへほハヒフヘホ", "ぱぴぷぺぽパピプペポ"},
+}
+
+var nfcPairs, nfcClass = buildNFC()
+
+func buildNFC() (map[[2]rune]rune, map[rune]uint8) {
+	pairs := make(map[[2]rune]rune)
+	class := make(map[rune]uint8)
+	for mark, m := range nfcCompositions {
+		class[mark] = m.ccc
+		base, composed := []rune(m.base), []rune(m.composed)
+		for i, r := range base {
+			pairs[[2]rune{r, mark}] = composed[i]
+		}
+	}
+	return pairs, class
+}
+
+// combiningClass là ccc của r; dấu kết hợp không có trong bảng tính là 255 để chặn mọi dấu sau nó
+func combiningClass(r rune) uint8 {
+	if c, ok := nfcClass[r]; ok {
+		return c
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return 255
+	}
+	return 0
+}
+
+// composeNFC ghép các chuỗi đã phân rã (chữ + dấu kết hợp) về dạng dựng sẵn như NFC, với chuỗi
+// dấu đã theo thứ tự chuẩn (như output của NFD). Cặp ngoài bảng được giữ nguyên
+func composeNFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	out := make([]rune, 0, len(s))
+	starter := -1      // Vị trí của ký tự starter cuối cùng trong out
+	var last uint8 = 0 // ccc của ký tự chưa ghép cuối cùng sau starter, 0 = không có
+	for _, r := range s {
+		ccc := combiningClass(r)
+		if starter >= 0 {
+			adjacent := starter == len(out)-1
+			if c, ok := nfcPairs[[2]rune{out[starter], r}]; ok && ccc != 0 && (adjacent || last != 0 && last < ccc) {
+				out[starter] = c
+				continue
+			}
+			if c, ok := composeHangul(out[starter], r); ok && adjacent {
+				out[starter] = c
+				continue
+			}
+		}
+		out = append(out, r)
+		if ccc == 0 {
+			starter, last = len(out)-1, 0
+		} else {
+			last = ccc
+		}
+	}
+	return string(out)
+}
+
+// composeHangul ghép jamo L+V và LV+T thành âm tiết Hangul theo công thức của Unicode
+func composeHangul(a, b rune) (rune, bool) {
+	const (
+		sBase, lBase, vBase, tBase = 0xAC00, 0x1100, 0x1161, 0x11A7
+		lCount, vCount, tCount     = 19, 21, 28
+		sCount                     = lCount * vCount * tCount
+	)
+	switch {
+	case a >= lBase && a < lBase+lCount && b >= vBase && b < vBase+vCount:
+		return sBase + ((a-lBase)*vCount+(b-vBase))*tCount, true
+	case a >= sBase && a < sBase+sCount && (a-sBase)%tCount == 0 && b > tBase && b < tBase+tCount:
+		return a + (b - tBase), true
+	}
+	return 0, false
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// So sánh chi phí một lượt dọn session hết hạn giữa min-heap (sweepExpiredLocked) và cách quét toàn
+// bộ map trước đây (scanExpired), với 100k session còn sống:
+//
+//	go test -run '^$' -bench ExpirySweep -benchmem
+
+const benchSessions = 100_000
+
+// fillSessionStore thêm n session còn sống, hạn rải đều trong một giờ tới, và dọn store sau benchmark
+func fillSessionStore(b *testing.B, n int, now time.Time) {
+	b.Helper()
+	maxSessions := MaxSessions
+	MaxSessions = n + 1000
+	b.Cleanup(func() {
+		mu.Lock()
+		sessions, sessionOrder, expiryQueue = make(map[string]*Session), list.New(), nil
+		tombstones = make(map[string]tombstone)
+		mu.Unlock()
+		MaxSessions = maxSessions
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range n {
+		s := &Session{CreatedAt: now, TTL: time.Second + time.Duration(i)*time.Hour/time.Duration(n)}
+		if err := addSessionLocked(fmt.Sprintf("live-%d", i), s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// addExpiredSessions thêm n session đã quá hạn để lượt dọn kế tiếp có việc làm
+func addExpiredSessions(b *testing.B, round, n int, now time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	clear(tombstones)
+	for i := range n {
+		s := &Session{CreatedAt: now.Add(-2 * time.Hour), TTL: time.Hour}
+		if err := addSessionLocked(fmt.Sprintf("expired-%d-%d", round, i), s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// scanExpired là lượt dọn kiểu cũ: quét mọi session dưới RLock rồi retire những session đã quá hạn
+func scanExpired(now time.Time) int {
+	var expired []string
+	mu.RLock()
+	for token, session := range sessions {
+		if session.isExpired(now) {
+			expired = append(expired, token)
+		}
+	}
+	mu.RUnlock()
+
+	mu.Lock()
+	for _, token := range expired {
+		retireSessionLocked(token, "expired", now)
+	}
+	mu.Unlock()
+	return len(expired)
+}
+
+func heapExpired(now time.Time) int {
+	mu.Lock()
+	expired, _, _ := sweepExpiredLocked(now)
+	mu.Unlock()
+	return expired
+}
+
+func benchmarkSweep(b *testing.B, sweep func(time.Time) int, expiring int) {
+	now := time.Now()
+	fillSessionStore(b, benchSessions, now)
+	for i := 0; b.Loop(); i++ {
+		if expiring > 0 {
+			b.StopTimer()
+			addExpiredSessions(b, i, expiring, now)
+			b.StartTimer()
+		}
+		if got := sweep(now); got != expiring {
+			b.Fatalf("swept %d sessions, want %d", got, expiring)
+		}
+	}
+}
+
+// Lượt dọn thường gặp nhất: không session nào hết hạn
+func BenchmarkExpirySweepIdle(b *testing.B) {
+	b.Run("heap", func(b *testing.B) { benchmarkSweep(b, heapExpired, 0) })
+	b.Run("scan", func(b *testing.B) { benchmarkSweep(b, scanExpired, 0) })
+}
+
+// Mỗi lượt có 100 session hết hạn
+func BenchmarkExpirySweep100(b *testing.B) {
+	b.Run("heap", func(b *testing.B) { benchmarkSweep(b, heapExpired, 100) })
+	b.Run("scan", func(b *testing.B) { benchmarkSweep(b, scanExpired, 100) })
+}
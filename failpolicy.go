@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============== FAILURE THRESHOLDS ==============
+
+// failureLimits dừng hẳn archive khi số file lỗi vượt ngưỡng, thay vì giao một archive gần như rỗng
+type failureLimits struct {
+	MaxFailureRatio float64 // Tỉ lệ file lỗi trên tổng số file, 0 = tắt
+	MaxFailures     *int    // Số file lỗi tối đa, nil = tắt
+}
+
+func (l failureLimits) validate() error {
+	if l.MaxFailureRatio < 0 || l.MaxFailureRatio > 1 {
+		return fmt.Errorf("maxFailureRatio must be between 0 and 1")
+	}
+	if l.MaxFailures != nil && *l.MaxFailures < 0 {
+		return fmt.Errorf("maxFailures must not be negative")
+	}
+	return nil
+}
+
+// exceeded được gọi sau mỗi file lỗi, trả về lý do abort hoặc "" nếu còn trong ngưỡng
+func (l failureLimits) exceeded(failed int64, total int) string {
+	if l.MaxFailures != nil && failed > int64(*l.MaxFailures) {
+		return fmt.Sprintf("%d files failed, more than maxFailures %d", failed, *l.MaxFailures)
+	}
+	if l.MaxFailureRatio > 0 && total > 0 && float64(failed)/float64(total) > l.MaxFailureRatio {
+		return fmt.Sprintf("%d of %d files failed, above maxFailureRatio %g", failed, total, l.MaxFailureRatio)
+	}
+	return ""
+}
+
+// writeErrorsReport ghi báo cáo lỗi (ERRORS.txt) vào archive, trước khi abort hoặc ở cuối archive
+// còn thiếu file, để phần đã nhận được vẫn giải thích vì sao
+func writeErrorsReport(aw archiveWriter, name, summary string, failures []fileFailure) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", summary)
+	for _, f := range failures {
+		fmt.Fprintf(&b, "files[%d] %s: %s\n", f.Index, f.URL, f.Error)
+	}
+
+	if err := aw.writeText(name, b.String()); err != nil {
+		return err
+	}
+	return aw.Flush()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// unzip đọc archive thành tên entry -> nội dung
+func unzip(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(content)
+	}
+	return entries
+}
+
+func TestFileEntryJSON(t *testing.T) {
+	var files []FileEntry
+	err := json.Unmarshal([]byte(`["https://a.example/x.pdf", {"url":"https://b.example/y","name":"reports/2024/q1.pdf"}]`), &files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].URL != "https://a.example/x.pdf" || files[0].Name != "" || files[1].URL != "https://b.example/y" || files[1].Name != "reports/2024/q1.pdf" {
+		t.Fatalf("files = %+v", files)
+	}
+	if err := json.Unmarshal([]byte(`[42]`), &files); err == nil {
+		t.Fatal("a number was accepted as a file entry")
+	}
+}
+
+// Chuỗi và object trộn trong cùng files: object có name đặt đúng đường dẫn trong zip, chuỗi lấy tên từ URL
+func TestFilesStringAndObjectForms(t *testing.T) {
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	link := createSession(t, base, fmt.Sprintf(`{"files":["%[1]s/plain.txt",{"url":"%[1]s/download.php?id=7","name":"reports/2024/q1.pdf"},{"url":"%[1]s/other.txt"}]}`, origin.URL))
+
+	status, data := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	want := map[string]string{
+		"plain.txt":           "content of /plain.txt",
+		"reports/2024/q1.pdf": "content of /download.php",
+		"other.txt":           "content of /other.txt",
+	}
+	if got := unzip(t, data); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+
+	for name, reason := range map[string]string{
+		"/etc/passwd":   "absolute",
+		"C:/x.txt":      "absolute",
+		"../escape.txt": `".."`,
+		"a//b.txt":      "empty path segment",
+		"dir/":          "directory",
+		`a\b.txt`:       "backslash",
+	} {
+		body, _ := json.Marshal(map[string]any{"files": []any{origin.URL + "/a", map[string]string{"url": origin.URL + "/a", "name": name}}})
+		_, err := postCreate(base, string(body))
+		if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), reason) {
+			t.Errorf("name %q: %v, want 400 mentioning %s", name, err, reason)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== FTP SOURCES ==============
+
+// ftp://[user:password@]host[:port]/path được tải bằng RETR qua passive mode (EPSV, rồi PASV) và
+// trả về như một http.Response. Khác các scheme storage, host FTP là host mạng thường nên đi qua
+// SSRF guard như http: danh sách host, kiểm tra DNS lúc tạo và kiểm tra địa chỉ lúc kết nối cho
+// cả control lẫn data connection. Path theo RFC 1738, tính từ thư mục đăng nhập ("/%2F..." = tuyệt đối).
+
+// ftpDialer kiểm tra địa chỉ bằng guardDial như guardedTransport
+var ftpDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: guardDial}
+
+// ftpQuitTimeout là thời gian chờ 226 và QUIT khi đóng body, để server không treo download
+const ftpQuitTimeout = 5 * time.Second
+
+// ftpTransport tải file qua FTP, hỗ trợ GET và HEAD
+type ftpTransport struct{}
+
+// serverFTPCredentials tra FTP_CREDENTIALS của server: danh sách "user:password@host[:port]" phân
+// cách bằng dấu phẩy, phần user và password được percent-encode như trong URL
+func serverFTPCredentials(hostport string) (user, pass string, ok bool) {
+	for _, entry := range splitList(os.Getenv("FTP_CREDENTIALS")) {
+		u, err := url.Parse("ftp://" + entry)
+		if err != nil || u.User == nil {
+			continue
+		}
+		if ftpAddr(u) == hostport {
+			pass, _ := u.User.Password()
+			return u.User.Username(), pass, true
+		}
+	}
+	return "", "", false
+}
+
+// ftpAddr là host:port viết thường của URL ftp, port mặc định 21
+func ftpAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "21"
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// ftpCredentials chọn tài khoản đăng nhập: userinfo của URL (http.Client chuyển thành Authorization),
+// header Authorization Basic của request hoặc file, FTP_CREDENTIALS theo host, cuối cùng là anonymous
+func ftpCredentials(req *http.Request) (user, pass string) {
+	if u := req.URL.User; u != nil {
+		pass, _ := u.Password()
+		return u.Username(), pass
+	}
+	if user, pass, ok := req.BasicAuth(); ok {
+		return user, pass
+	}
+	if user, pass, ok := serverFTPCredentials(ftpAddr(req.URL)); ok {
+		return user, pass
+	}
+	return "anonymous", "anonymous@"
+}
+
+// ftpStatus đổi mã lỗi FTP sang status HTTP để retry và báo lỗi chạy như với origin http: lỗi
+// tạm thời 4yz là 503 (được retry), 530/532 (chưa đăng nhập) là 403, file không có là 404
+func ftpStatus(code int) int {
+	switch {
+	case code >= 400 && code < 500:
+		return http.StatusServiceUnavailable
+	case code == 530, code == 532:
+		return http.StatusForbidden
+	case code == 550, code == 551, code == 553:
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// ftpConn là một phiên FTP: control connection và data connection của RETR
+type ftpConn struct {
+	ctrl net.Conn
+	tp   *textproto.Conn
+	data net.Conn
+	stop func() bool // Hủy context.AfterFunc đóng kết nối khi request bị hủy
+}
+
+func (c *ftpConn) cmd(expect int, format string, args ...any) (int, string, error) {
+	id, err := c.tp.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.tp.StartResponse(id)
+	defer c.tp.EndResponse(id)
+	return c.tp.ReadResponse(expect)
+}
+
+func (c *ftpConn) close() {
+	c.stop()
+	if c.data != nil {
+		c.data.Close()
+	}
+	c.ctrl.Close()
+}
+
+func (ftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return syntheticResponse(req, http.StatusMethodNotAllowed, nil, http.NoBody), nil
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/")
+	if name == "" || strings.ContainsAny(name, "\r\n") {
+		return syntheticResponse(req, http.StatusBadRequest, nil, http.NoBody), nil
+	}
+
+	ctrl, err := ftpDialer.DialContext(req.Context(), "tcp", ftpAddr(req.URL))
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{ctrl: ctrl, tp: textproto.NewConn(ctrl)}
+	c.stop = context.AfterFunc(req.Context(), func() { c.ctrl.Close() })
+
+	resp, err := c.retrieve(req, name)
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		resp, err = syntheticResponse(req, ftpStatus(ftpErr.Code), nil, http.NoBody), nil
+	}
+	if err != nil && req.Context().Err() != nil {
+		err = req.Context().Err()
+	}
+	if err != nil || resp.Body == http.NoBody {
+		c.close()
+	}
+	return resp, err
+}
+
+// retrieve đăng nhập, đọc SIZE/MDTM rồi mở RETR (trừ HEAD). Lỗi của server là *textproto.Error
+func (c *ftpConn) retrieve(req *http.Request, name string) (*http.Response, error) {
+	if _, _, err := c.tp.ReadResponse(2); err != nil {
+		return nil, err
+	}
+	user, pass := ftpCredentials(req)
+	if strings.ContainsAny(user+pass, "\r\n") {
+		return nil, &textproto.Error{Code: 530, Msg: "invalid credentials"}
+	}
+	code, _, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code == 331:
+		if _, _, err := c.cmd(2, "PASS %s", pass); err != nil {
+			return nil, err
+		}
+	case code/100 != 2:
+		return nil, &textproto.Error{Code: code, Msg: "login rejected"}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return nil, err
+	}
+
+	h := make(http.Header)
+	size := int64(-1)
+	if _, msg, err := c.cmd(213, "SIZE %s", name); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil && n >= 0 {
+			size = n
+			h.Set("Content-Length", strconv.FormatInt(n, 10))
+		}
+	} else if e, ok := err.(*textproto.Error); ok && e.Code == 550 {
+		return nil, err // File không tồn tại; server không hỗ trợ SIZE thì bỏ qua
+	} else if !ok {
+		return nil, err
+	}
+	if _, msg, err := c.cmd(213, "MDTM %s", name); err == nil {
+		// "YYYYMMDDHHMMSS[.sss]" theo UTC (RFC 3659)
+		stamp, _, _ := strings.Cut(strings.TrimSpace(msg), ".")
+		if t, err := time.Parse("20060102150405", stamp); err == nil {
+			h.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+			if size >= 0 {
+				h.Set("ETag", fmt.Sprintf(`"%x-%x"`, t.Unix(), size))
+			}
+		}
+	} else if _, ok := err.(*textproto.Error); !ok {
+		return nil, err
+	}
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	if req.Method == http.MethodHead {
+		resp := syntheticResponse(req, http.StatusOK, h, http.NoBody)
+		resp.ContentLength = size
+		return resp, nil
+	}
+
+	if err := c.openData(req.Context()); err != nil {
+		return nil, err
+	}
+	if _, _, err := c.cmd(1, "RETR %s", name); err != nil {
+		return nil, err
+	}
+	resp := syntheticResponse(req, http.StatusOK, h, &ftpBody{c: c})
+	resp.ContentLength = size
+	return resp, nil
+}
+
+// openData mở data connection passive. Địa chỉ trong trả lời PASV bị bỏ qua, luôn dùng IP của
+// control connection, để server không chỉ data connection sang host khác (FTP bounce)
+func (c *ftpConn) openData(ctx context.Context) error {
+	var port string
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// "Entering Extended Passive Mode (|||6446|)"
+		_, rest, _ := strings.Cut(msg, "(|||")
+		port, _, _ = strings.Cut(rest, "|")
+	} else if _, ok := err.(*textproto.Error); !ok {
+		return err
+	} else {
+		_, msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return err
+		}
+		// "Entering Passive Mode (h1,h2,h3,h4,p1,p2)"
+		start, end := strings.IndexByte(msg, '('), strings.IndexByte(msg, ')')
+		if start < 0 || end < start {
+			return fmt.Errorf("ftp: bad PASV reply %q", msg)
+		}
+		parts := strings.Split(msg[start+1:end], ",")
+		if len(parts) != 6 {
+			return fmt.Errorf("ftp: bad PASV reply %q", msg)
+		}
+		hi, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+		lo, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("ftp: bad PASV reply %q", msg)
+		}
+		port = strconv.Itoa(hi<<8 | lo)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("ftp: bad passive port %q", port)
+	}
+	host, _, _ := net.SplitHostPort(c.ctrl.RemoteAddr().String())
+	data, err := ftpDialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+	c.data = data
+	stopCtrl := c.stop
+	stopData := context.AfterFunc(ctx, func() { data.Close() })
+	c.stop = func() bool { return stopCtrl() && stopData() }
+	return nil
+}
+
+// ftpBody đọc data connection của RETR. Hết dữ liệu thì đọc trả lời cuối của RETR: chỉ 2yz (226)
+// mới là EOF, trả lời khác (426 khi truyền bị cắt) là lỗi, để file thiếu byte không lọt vào archive
+type ftpBody struct {
+	c      *ftpConn
+	done   bool // Đã đọc trả lời cuối
+	closed bool
+}
+
+func (b *ftpBody) Read(p []byte) (int, error) {
+	n, err := b.c.data.Read(p)
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.c.ctrl.SetDeadline(time.Now().Add(ftpQuitTimeout))
+		if _, _, rerr := b.c.tp.ReadResponse(2); rerr != nil {
+			return n, fmt.Errorf("ftp transfer failed: %w", rerr)
+		}
+	}
+	return n, err
+}
+
+func (b *ftpBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.c.data.Close()
+	b.c.ctrl.SetDeadline(time.Now().Add(ftpQuitTimeout))
+	if !b.done {
+		b.c.tp.ReadResponse(0) // 226 hoặc 426 khi đóng sớm
+	}
+	b.c.cmd(0, "QUIT")
+	b.c.close()
+	return nil
+}
@@ -1,5 +1,5 @@
 module download-multi-file
 
-go 1.21
+go 1.27
 
 require github.com/google/uuid v1.6.0
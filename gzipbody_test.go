@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	io.WriteString(gz, s)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Body gzip của /create được giải nén và giới hạn theo MaxCreateBodyBytes sau giải nén: body nén nhỏ
+// nhưng bung ra quá giới hạn nhận 413, Content-Encoding lạ nhận 415
+func TestCreateGzipBody(t *testing.T) {
+	origin, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+
+	// body là request /create có n file, đệm khoảng trắng bên trong object tới size byte để decoder
+	// phải đọc hết (phần sau giá trị JSON không bao giờ được đọc)
+	body := func(n, size int) string {
+		var files []string
+		for i := range n {
+			files = append(files, fmt.Sprintf(`"%s/%d.txt"`, origin.URL, i))
+		}
+		s := `{"files":[` + strings.Join(files, ",") + `]`
+		return s + strings.Repeat(" ", max(size-len(s)-1, 0)) + `}`
+	}
+	post := func(data []byte, encoding string) (int, string) {
+		req, _ := http.NewRequest(http.MethodPost, base+"/create", bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(msg)
+	}
+
+	under := gzipBytes(t, body(20, MaxCreateBodyBytes-1))
+	if status, msg := post(under, "gzip"); status != http.StatusOK || !strings.Contains(msg, `"download_url"`) {
+		t.Fatalf("gzip body just under the decoded limit = %d %s, want 200", status, msg)
+	}
+	if status, _ := post(under, "x-gzip"); status != http.StatusOK {
+		t.Fatalf("x-gzip body = %d, want 200", status)
+	}
+
+	// Dưới giới hạn trên dây, vượt sau khi giải nén
+	bomb := gzipBytes(t, body(1, 4*MaxCreateBodyBytes))
+	if len(bomb) >= MaxCreateBodyBytes/100 {
+		t.Fatalf("compressed bomb is %d bytes, want it far under the wire limit", len(bomb))
+	}
+	if status, msg := post(bomb, "gzip"); status != http.StatusRequestEntityTooLarge || !strings.Contains(msg, fmt.Sprintf("max %d bytes decoded", MaxCreateBodyBytes)) {
+		t.Fatalf("gzip body of 4x the limit decoded = %d %s, want 413", status, msg)
+	}
+	if status, _ := post([]byte(body(1, MaxCreateBodyBytes+1)), ""); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("plain body over the limit = %d, want 413", status)
+	}
+
+	if status, _ := post([]byte("not gzip"), "gzip"); status != http.StatusBadRequest {
+		t.Fatalf("corrupt gzip body = %d, want 400", status)
+	}
+	for _, encoding := range []string{"br", "deflate", "zstd"} {
+		if status, msg := post(under, encoding); status != http.StatusUnsupportedMediaType || !strings.Contains(msg, encoding) {
+			t.Fatalf("Content-Encoding %s = %d %s, want 415", encoding, status, msg)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ============== FORWARDED HEADERS ==============
+
+// Header xác thực gửi kèm mọi request tới origin (GET, HEAD resolve, probe mirror, hedge).
+// Chúng là secret: không ghi log, không xuất hiện trong export dạng rõ, và bị bỏ khi redirect
+// sang origin khác.
+
+// MaxForwardHeaders giới hạn số header của session hoặc của một file
+const MaxForwardHeaders = 20
+
+// ForwardHeaders là các header client được gửi kèm tới origin, đặt bằng --forward-headers.
+// Phần tử kết thúc bằng "*" khớp theo tiền tố; rỗng = tắt forward header
+var ForwardHeaders = []string{"Authorization", "Cookie", "X-*"}
+
+// reservedHeaders do chính server đặt khi fetch (hop, Range lúc resume, nén), không bao giờ được
+// forward dù khớp ForwardHeaders. X-Forwarded-* cũng vậy vì mô tả hop của chính server
+var reservedHeaders = []string{
+	"Host", "Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	"Content-Length", "Range", "If-Range", "Accept-Encoding", "Forwarded",
+}
+
+// forwardableHeader cho biết header (tên đã chuẩn hóa) khớp ForwardHeaders và không bị giữ lại
+func forwardableHeader(name string) bool {
+	if slices.Contains(reservedHeaders, name) || strings.HasPrefix(name, "X-Forwarded-") {
+		return false
+	}
+	return slices.ContainsFunc(ForwardHeaders, func(pattern string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(name, prefix)
+		}
+		return name == pattern
+	})
+}
+
+// parseForwardHeaders chuẩn hóa danh sách của --forward-headers; "*" chỉ được đứng cuối
+func parseForwardHeaders(list []string) ([]string, error) {
+	patterns := make([]string, 0, len(list))
+	for _, p := range list {
+		name, wildcard := strings.CutSuffix(p, "*")
+		if (name != "" || !wildcard) && !validHeaderName(name) || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("invalid header pattern %q", p)
+		}
+		name = http.CanonicalHeaderKey(name)
+		if !wildcard && (slices.Contains(reservedHeaders, name) || strings.HasPrefix(name, "X-Forwarded-")) {
+			return nil, fmt.Errorf("header %s is set by the server and cannot be forwarded", name)
+		}
+		if wildcard {
+			name += "*"
+		}
+		patterns = append(patterns, name)
+	}
+	return patterns, nil
+}
+
+// toForwardHeaders chuyển map của request sang http.Header với tên đã chuẩn hóa, nil nếu rỗng
+func toForwardHeaders(m map[string]string) http.Header {
+	if len(m) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(m))
+	for k, v := range m {
+		h.Set(strings.TrimSpace(k), v)
+	}
+	return h
+}
+
+// validateForwardHeaders kiểm tra tên thuộc safelist và giá trị không có ký tự điều khiển.
+// Lỗi chỉ nêu tên header, không bao giờ nêu giá trị
+func validateForwardHeaders(h http.Header) error {
+	if len(h) > MaxForwardHeaders {
+		return fmt.Errorf("too many headers (max %d)", MaxForwardHeaders)
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !validHeaderName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if !forwardableHeader(name) {
+			if len(ForwardHeaders) == 0 {
+				return fmt.Errorf("header %s cannot be forwarded (header forwarding is disabled)", name)
+			}
+			return fmt.Errorf("header %s cannot be forwarded (allowed: %s)", name, strings.Join(ForwardHeaders, ", "))
+		}
+		for _, v := range h[name] {
+			if strings.ContainsFunc(v, func(r rune) bool { return r < 0x20 && r != '\t' || r == 0x7f }) {
+				return fmt.Errorf("header %s has control characters in its value", name)
+			}
+		}
+	}
+	return nil
+}
+
+// validHeaderName kiểm tra token theo RFC 9110
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range []byte(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type forwardHeadersKey struct{}
+
+// withForwardHeaders gắn h vào ctx, ghi đè theo tên lên header đã gắn trước đó (session rồi file)
+func withForwardHeaders(ctx context.Context, h http.Header) context.Context {
+	if len(h) == 0 {
+		return ctx
+	}
+	merged := forwardHeaders(ctx).Clone()
+	if merged == nil {
+		merged = make(http.Header, len(h))
+	}
+	for k, v := range h {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, forwardHeadersKey{}, merged)
+}
+
+func forwardHeaders(ctx context.Context) http.Header {
+	h, _ := ctx.Value(forwardHeadersKey{}).(http.Header)
+	return h
+}
+
+// newUpstreamRequest tạo request tới origin kèm header đã gắn vào ctx
+func newUpstreamRequest(ctx context.Context, method, target string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range forwardHeaders(ctx) {
+		req.Header[k] = append([]string(nil), v...)
+	}
+	return req, nil
+}
+
+// stripForwardHeaders bỏ header đã forward khi redirect sang origin khác (scheme hoặc host khác),
+// chặt hơn net/http vốn vẫn giữ Authorization cho subdomain và không biết các header X-*
+func stripForwardHeaders(req *http.Request, first *url.URL) {
+	h := forwardHeaders(req.Context())
+	if h == nil || sameOrigin(req.URL, first) {
+		return
+	}
+	for k := range h {
+		req.Header.Del(k)
+	}
+}
+
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// headersFingerprint là băm của header (tên và giá trị) để so sánh mà không giữ giá trị, "" nếu rỗng
+func headersFingerprint(h http.Header) string {
+	if len(h) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sum := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(sum, "%s\x00%q\n", name, h[name])
+	}
+	return "#h=" + hex.EncodeToString(sum.Sum(nil)[:8])
+}
+
+// hasFileHeaders cho biết có file nào mang header riêng
+func hasFileHeaders(files []FileEntry) bool {
+	for _, f := range files {
+		if len(f.headers) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Header của session được forward, header của file ghi đè nó; origin trả 401 thì file nằm trong
+// báo cáo lỗi (ERRORS.txt và /result) với status 401
+func TestForwardHeadersUpstream401(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "secret "+r.URL.Path)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/ok.txt"},{"url":"`+origin.URL+`/denied.txt","headers":{"Authorization":"Bearer expired"}}],"headers":{"Authorization":"Bearer good"}}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+
+	status, data := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	entries := unzip(t, data)
+	if entries["ok.txt"] != "secret /ok.txt" {
+		t.Fatalf("entries = %v, want ok.txt fetched with the session header", entries)
+	}
+	if _, ok := entries["denied.txt"]; ok {
+		t.Fatal("denied.txt is in the archive")
+	}
+	if report := entries["ERRORS.txt"]; !strings.Contains(report, origin.URL+"/denied.txt") || !strings.Contains(report, "bad status 401") {
+		t.Fatalf("ERRORS.txt = %q, want the 401 of denied.txt", report)
+	}
+	if strings.Contains(entries["ERRORS.txt"], "Bearer") {
+		t.Fatal("ERRORS.txt leaks the forwarded header")
+	}
+
+	_, result := download(t, base+"/result/"+token)
+	if !strings.Contains(string(result), `/denied.txt","error":"bad status 401`) || !strings.Contains(string(result), `"status":"partial"`) {
+		t.Fatalf("result = %s, want denied.txt failed with 401", result)
+	}
+	if strings.Contains(string(result), "Bearer") {
+		t.Fatal("result leaks the forwarded header")
+	}
+}
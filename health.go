@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ============== HEALTH CHECKS ==============
+
+// GET /healthz (liveness) chỉ báo process còn phục vụ được: store session trong bộ nhớ không bị
+// kẹt khóa. GET /readyz (readiness) kiểm tra thêm các phụ thuộc: backend session (DataDir ghi
+// được, Redis trả PING), thư mục source cache và spool ghi được, và kết nối ra ngoài tới
+// ReadinessProbeURL nếu có. Khi đang drain /readyz trả 503 để load balancer bỏ instance ra, còn
+// /healthz vẫn ok để orchestrator không kill process giữa lúc chờ download kết thúc.
+
+// ReadinessProbeURL là URL được HEAD qua HTTP client tải file nguồn (--readiness-probe-url),
+// rỗng = không kiểm tra kết nối ra ngoài
+var ReadinessProbeURL = ""
+
+// healthCheckTimeout là thời gian tối đa của mỗi kiểm tra; các kiểm tra chạy song song
+const healthCheckTimeout = 2 * time.Second
+
+// healthCheck là kết quả của một kiểm tra
+type healthCheck struct {
+	Status     string `json:"status"` // ok hoặc fail
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+	Backend    string `json:"backend,omitempty"`     // session_store: memory, file hoặc redis
+	FreeBytes  *int64 `json:"free_bytes,omitempty"`  // Thư mục: dung lượng còn trống nếu đo được
+	StatusCode int    `json:"status_code,omitempty"` // outbound: status origin trả về
+}
+
+// healthResponse là body của /healthz và /readyz
+type healthResponse struct {
+	Status string                 `json:"status"` // ok, fail hoặc draining
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	checks := map[string]healthCheck{"session_store": runHealthCheck(checkSessionLock)}
+	writeHealth(w, checks)
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if shuttingDown.Load() {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(healthResponse{Status: "draining"})
+		return
+	}
+
+	probes := map[string]func(*healthCheck) error{
+		"session_store": checkSessionStore,
+		"spool":         func(c *healthCheck) error { return checkDir(c, spoolVolume()) },
+	}
+	if SourceCacheDir != "" {
+		probes["source_cache"] = func(c *healthCheck) error { return checkDir(c, SourceCacheDir) }
+	}
+	if ReadinessProbeURL != "" {
+		probes["outbound"] = func(c *healthCheck) error { return checkOutbound(r.Context(), c) }
+	}
+
+	checks := make(map[string]healthCheck, len(probes))
+	var wg sync.WaitGroup
+	var checksMu sync.Mutex
+	for name, probe := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := runHealthCheck(probe)
+			checksMu.Lock()
+			checks[name] = c
+			checksMu.Unlock()
+		}()
+	}
+	wg.Wait()
+	writeHealth(w, checks)
+}
+
+// writeHealth trả 200 khi mọi kiểm tra ok, 503 nếu có một kiểm tra lỗi
+func writeHealth(w http.ResponseWriter, checks map[string]healthCheck) {
+	resp := healthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			resp.Status, status = "fail", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runHealthCheck chạy probe, đo thời gian và ghi lỗi nếu có
+func runHealthCheck(probe func(*healthCheck) error) healthCheck {
+	var c healthCheck
+	start := time.Now()
+	err := probe(&c)
+	c.DurationMS = time.Since(start).Milliseconds()
+	c.Status = "ok"
+	if err != nil {
+		c.Status, c.Error = "fail", redactURLs(err.Error())
+	}
+	return c
+}
+
+// errStoreLocked báo mu không lấy được trong healthCheckTimeout: có thể đã kẹt khóa
+var errStoreLocked = errors.New("session store lock not acquired within timeout")
+
+// checkSessionLock lấy mu.RLock trong healthCheckTimeout. Goroutine chờ khóa được bỏ lại nếu quá
+// hạn, nó tự kết thúc khi khóa được nhả
+func checkSessionLock(*healthCheck) error {
+	done := make(chan struct{})
+	go func() {
+		mu.RLock()
+		mu.RUnlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(healthCheckTimeout):
+		return errStoreLocked
+	}
+}
+
+// checkSessionStore kiểm tra khóa của store rồi backend lưu session nếu có
+func checkSessionStore(c *healthCheck) error {
+	switch backend.(type) {
+	case nil:
+		c.Backend = "memory"
+	case fileBackend:
+		c.Backend = "file"
+	case *redisBackend:
+		c.Backend = "redis"
+	}
+	if err := checkSessionLock(c); err != nil {
+		return err
+	}
+	if backend == nil {
+		return nil
+	}
+	if c.Backend == "file" {
+		c.FreeBytes = freeBytes(DataDir)
+	}
+	return backend.ping()
+}
+
+// checkDir kiểm tra dir ghi được và báo dung lượng còn trống
+func checkDir(c *healthCheck, dir string) error {
+	c.FreeBytes = freeBytes(dir)
+	return probeWritableDir(dir)
+}
+
+// freeBytes là freeDiskBytes cho JSON, nil khi không đo được
+func freeBytes(dir string) *int64 {
+	if n := freeDiskBytes(dir); n >= 0 {
+		return &n
+	}
+	return nil
+}
+
+// probeWritableDir tạo rồi xóa một file rỗng trong dir
+func probeWritableDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkOutbound gửi HEAD tới ReadinessProbeURL qua httpClient (cùng proxy, giao thức và kiểm tra
+// mạng như khi tải file nguồn). Origin trả status nào cũng tính là kết nối được
+func checkOutbound(ctx context.Context, c *healthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, ReadinessProbeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	c.StatusCode = resp.StatusCode
+	return nil
+}
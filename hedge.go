@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ============== HEDGED REQUESTS ==============
+
+// Metric toàn server để kiểm chứng hedging có giúp ích hay không
+var (
+	hedgesFired atomic.Int64
+	hedgesWon   atomic.Int64
+)
+
+// hedgePolicy: nếu sau Delay chưa có response header thì gửi thêm một request giống hệt
+type hedgePolicy struct {
+	Delay  time.Duration // 0 = tắt
+	Budget int           // Số hedge tối đa cho cả archive
+}
+
+func parseHedgePolicy(delay string, budget int) (hedgePolicy, error) {
+	var p hedgePolicy
+	if delay == "" {
+		return p, nil
+	}
+	d, err := time.ParseDuration(delay)
+	if err != nil || d < MinHedgeDelay || d > HTTPTimeout {
+		return p, fmt.Errorf("hedgeDelay must be a duration between %v and %v", MinHedgeDelay, HTTPTimeout)
+	}
+	if budget < 0 || budget > MaxHedgeBudget {
+		return p, fmt.Errorf("hedgeBudget must be between 0 and %d", MaxHedgeBudget)
+	}
+	if budget == 0 {
+		budget = DefaultHedgeBudget
+	}
+	return hedgePolicy{Delay: d, Budget: budget}, nil
+}
+
+// hedgeBudget là ngân sách hedge của một lần download
+type hedgeBudget struct {
+	delay     time.Duration
+	remaining atomic.Int64
+	fired     atomic.Int64
+	won       atomic.Int64
+}
+
+// newHedgeBudget trả về nil khi session không bật hedging
+func newHedgeBudget(p hedgePolicy) *hedgeBudget {
+	if p.Delay <= 0 {
+		return nil
+	}
+	b := &hedgeBudget{delay: p.Delay}
+	b.remaining.Store(int64(p.Budget))
+	return b
+}
+
+func (b *hedgeBudget) take() bool {
+	for {
+		n := b.remaining.Load()
+		if n <= 0 {
+			return false
+		}
+		if b.remaining.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// entryHedge giới hạn một hedge cho mỗi entry, tính cả retry và mirror
+type entryHedge struct {
+	budget *hedgeBudget
+	used   bool
+}
+
+func (b *hedgeBudget) forEntry() *entryHedge {
+	if b == nil {
+		return nil
+	}
+	return &entryHedge{budget: b}
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	index  int
+	cancel context.CancelFunc
+}
+
+// do gửi GET tới fileURL, hedge khi cần. Chỉ dùng cho GET không có body nên gửi lặp là an toàn;
+// request thua bị hủy, request thắng được hủy context khi body đóng
+func (h *entryHedge) do(ctx context.Context, fileURL string) (*http.Response, error) {
+	if h == nil || h.used {
+		return sendGet(ctx, fileURL)
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	launch := func() {
+		reqCtx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			resp, err := sendGet(reqCtx, fileURL)
+			results <- hedgeResult{resp: resp, err: err, index: index, cancel: cancel}
+		}()
+	}
+
+	launch()
+	inflight := 1
+	timer := time.NewTimer(h.budget.delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if !h.budget.take() {
+				continue
+			}
+			h.used = true
+			h.budget.fired.Add(1)
+			hedgesFired.Add(1)
+			launch()
+			inflight++
+		case res := <-results:
+			inflight--
+			if res.err != nil && inflight > 0 {
+				// Còn request kia đang chờ, chưa coi là thất bại
+				res.cancel()
+				continue
+			}
+
+			for i, cancel := range cancels {
+				if i != res.index {
+					cancel()
+				}
+			}
+			if inflight > 0 {
+				go drainHedges(results, inflight)
+			}
+			if res.err != nil {
+				res.cancel()
+				return nil, res.err
+			}
+			if res.index > 0 {
+				h.budget.won.Add(1)
+				hedgesWon.Add(1)
+			}
+			res.resp.Body = &cancelOnClose{ReadCloser: res.resp.Body, cancel: res.cancel}
+			return res.resp, nil
+		}
+	}
+}
+
+// drainHedges đóng response của các request thua nếu chúng vẫn kịp trả về
+func drainHedges(results <-chan hedgeResult, n int) {
+	for ; n > 0; n-- {
+		res := <-results
+		if res.resp != nil {
+			res.resp.Body.Close()
+		}
+		res.cancel()
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func sendGet(ctx context.Context, fileURL string) (*http.Response, error) {
+	req, err := newUpstreamRequest(ctx, http.MethodGet, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	return httpClient.Do(req)
+}
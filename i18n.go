@@ -0,0 +1,108 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ============== LOCALIZED MESSAGES ==============
+
+// DefaultLanguage là catalog dùng khi Accept-Language không khớp ngôn ngữ nào, và khi thiếu key.
+// Thêm ngôn ngữ = thêm locales/<mã>.json, không cần sửa handler.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[string]map[string]string {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	loaded := make(map[string]map[string]string)
+	for _, e := range entries {
+		data, err := localeFS.ReadFile(path.Join("locales", e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("locales/%s: %v", e.Name(), err))
+		}
+		loaded[strings.TrimSuffix(e.Name(), ".json")] = catalog
+	}
+	if _, ok := loaded[DefaultLanguage]; !ok {
+		panic("missing catalog for DefaultLanguage " + DefaultLanguage)
+	}
+	return loaded
+}
+
+// missingCatalogKeys liệt kê key có trong catalog mặc định nhưng thiếu ở ngôn ngữ khác, kiểm tra lúc khởi động
+func missingCatalogKeys() []string {
+	var missing []string
+	for lang, catalog := range catalogs {
+		for key := range catalogs[DefaultLanguage] {
+			if _, ok := catalog[key]; !ok {
+				missing = append(missing, lang+": "+key)
+			}
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// requestLanguage chọn catalog theo Accept-Language (có trọng số q), so theo subtag chính: "vi-VN" -> "vi"
+func requestLanguage(r *http.Request) string {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[lang]; ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// localize trả về message theo ngôn ngữ của request, fallback về DefaultLanguage
+func localize(r *http.Request, key string, args ...any) string {
+	msg, ok := catalogs[requestLanguage(r)][key]
+	if !ok {
+		msg, ok = catalogs[DefaultLanguage][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// setLanguageHeaders đánh dấu response phụ thuộc Accept-Language
+func setLanguageHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Language", requestLanguage(r))
+	w.Header().Add("Vary", "Accept-Language")
+}
+
+// localizedError giống http.Error nhưng message lấy từ catalog theo key
+func localizedError(w http.ResponseWriter, r *http.Request, status int, key string, args ...any) {
+	setLanguageHeaders(w, r)
+	http.Error(w, localize(r, key, args...), status)
+}
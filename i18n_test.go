@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCatalogsComplete(t *testing.T) {
+	if missing := missingCatalogKeys(); len(missing) > 0 {
+		t.Fatalf("missing translations: %s", strings.Join(missing, ", "))
+	}
+}
+
+// Bản dịch phải nhận cùng số tham số với catalog mặc định, nếu không Sprintf in ra %!s(MISSING)
+func TestCatalogVerbsMatch(t *testing.T) {
+	verbs := regexp.MustCompile(`%[^%]`)
+	for lang, catalog := range catalogs {
+		for key, msg := range catalog {
+			def, ok := catalogs[DefaultLanguage][key]
+			if !ok {
+				t.Errorf("%s: %s is not in the %s catalog", lang, key, DefaultLanguage)
+				continue
+			}
+			if got, want := len(verbs.FindAllString(msg, -1)), len(verbs.FindAllString(def, -1)); got != want {
+				t.Errorf("%s: %s has %d format verbs, %s has %d", lang, key, got, DefaultLanguage, want)
+			}
+		}
+	}
+}
+
+// Mọi key handler dùng qua localize/localizedError phải có trong catalog mặc định
+func TestHandlerKeysInCatalog(t *testing.T) {
+	call := regexp.MustCompile(`\blocalize(?:dError)?\([^"\n]*"([a-z_]+)"`)
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range call.FindAllSubmatch(src, -1) {
+			if _, ok := catalogs[DefaultLanguage][string(m[1])]; !ok {
+				t.Errorf("%s: key %q is not in the %s catalog", name, m[1], DefaultLanguage)
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ============== STRUCTURED LOGGING ==============
+
+// Log ghi qua slog; log.Fatal lúc khởi động và log của thư viện cũng đi qua handler của slog
+// (slog.SetDefault chuyển hướng package log), nên mọi URL trong log đều được che credential
+
+// Query param chứa credential ngoài các param chữ ký của presigned URL, so sánh lowercase
+var credentialParams = map[string]bool{
+	"token":        true,
+	"access_token": true,
+	"api_key":      true,
+	"apikey":       true,
+	"key":          true,
+	"password":     true,
+	"secret":       true,
+	"auth":         true,
+	"credential":   true,
+}
+
+var urlPattern = regexp.MustCompile(`(?:https?|ftp)://[^\s"'<>]+`)
+
+// redactURL che mật khẩu trong userinfo và giá trị của các query param chứa credential
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			lk := strings.ToLower(k)
+			if credentialParams[lk] || signatureParams[lk] || strings.HasPrefix(lk, "x-amz-") || strings.HasPrefix(lk, "x-goog-") {
+				q.Set(k, "REDACTED")
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+func redactURLs(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	return urlPattern.ReplaceAllStringFunc(s, func(m string) string {
+		// Dấu câu ngay sau URL ("Error fetching %s: %v") không thuộc URL
+		trimmed := strings.TrimRight(m, ".,:;)")
+		return redactURL(trimmed) + m[len(trimmed):]
+	})
+}
+
+// redactingHandler che URL trong message và attribute dạng chuỗi trước khi ghi
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redactURLs(r.Message), r.PC)
+	if info := requestInfoFrom(ctx); info != nil {
+		out.AddAttrs(slog.String("request_id", info.id), slog.String("client_ip", info.clientIP))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return redactingHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(redactURLs(a.Value.String()))
+	}
+	return a
+}
+
+// logLevels là các giá trị của -log-level
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// setupLogging đặt logger mặc định theo -log-format ("text" hoặc "json") và -log-level
+func setupLogging(format, level string) error {
+	opts := &slog.HandlerOptions{Level: logLevels[level]}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("log-format must be text or json, got %q", format)
+	}
+	slog.SetDefault(slog.New(redactingHandler{h}))
+	return nil
+}
+
+// ============== REQUEST IDS ==============
+
+// Mỗi request nhận một request ID (lấy từ X-Request-Id của client/proxy nếu hợp lệ, không thì
+// sinh mới), trả lại trong header X-Request-Id. Log ghi bằng slog.*Context với context của
+// request (kể cả fetch, retry, dựng artifact) tự có request_id và client_ip.
+
+// requestInfo là thông tin log của một request, gắn vào context
+type requestInfo struct {
+	id       string
+	clientIP string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID lấy X-Request-Id đến nếu gồm tối đa 64 ký tự chữ, số, "-", "_" hoặc ".", không thì sinh mới
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 64 && strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLogWriter ghi lại status và số byte cho dòng access log
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *accessLogWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush giữ http.Flusher cho download stream và SSE
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// withRequestLogging gắn request ID vào request và ghi một dòng access log khi handler trả về,
+// kể cả khi handler abort bằng panic(http.ErrAbortHandler). /metrics ghi ở mức debug
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{id: requestID(r)}
+		if addr, ok := clientIP(r); ok {
+			info.clientIP = addr.String()
+		}
+		w.Header().Set("X-Request-Id", info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+		aw := &accessLogWriter{ResponseWriter: w}
+		start := time.Now()
+
+		defer func() {
+			p := recover()
+			level := slog.LevelInfo
+			if r.URL.Path == "/metrics" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+				level = slog.LevelDebug
+			}
+			status := aw.status
+			if p != nil {
+				status = 0 // Kết nối bị cắt giữa chừng
+			}
+			slog.LogAttrs(r.Context(), level, "HTTP request",
+				slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Int("status", status),
+				slog.Int64("bytes", aw.n), slog.Int64("duration_ms", time.Since(start).Milliseconds()))
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(aw, r)
+	})
+}
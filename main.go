@@ -2,292 +2,2119 @@ package main
 
 import (
 	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"container/heap"
+	"container/list"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"mime"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 // ============== CONFIG ==============
-const (
+
+// Giá trị mặc định, ghi đè bằng flag hoặc biến môi trường lúc khởi động (xem Config)
+var (
 	SessionTTL      = 1 * time.Hour    // Session hết hạn sau 1 giờ
-	CleanupInterval = 5 * time.Minute  // Cleanup mỗi 5 phút
+	CleanupInterval = 5 * time.Minute  // Chu kỳ quét spool và thời gian ngủ tối đa của expiry timer
 	HTTPTimeout     = 5 * time.Minute  // Timeout cho mỗi HTTP request
-	DownloadTimeout = 30 * time.Minute // Timeout cho toàn bộ download
+	DownloadTimeout = 30 * time.Minute // Timeout mặc định và tối đa cho toàn bộ download
+
+	MaxSessionTTL = 7 * 24 * time.Hour // Trần của expiresIn
+
+	FetchConcurrency = 4 // Số file được fetch song song trước khi ghi vào zip (theo thứ tự), 1 = tuần tự
+
+	DefaultRetries      = 3                      // Số lần retry mặc định cho mỗi URL
+	DefaultRetryBackoff = 500 * time.Millisecond // Backoff ban đầu mặc định
+
+	MaxSessions    = 10000   // Số session tối đa giữ trong bộ nhớ
+	EvictionPolicy = "evict" // Khi đầy: "evict" (xóa session cũ nhất) hoặc "reject" (từ chối tạo mới)
+
+	MaxFilesPerSession       = 10000    // Số file tối đa của một session, tính cả file append sau
+	MaxFileBytes       int64 = 2 << 30  // Dung lượng tối đa của một file (chưa nén), 0 = không giới hạn
+	MaxArchiveBytes    int64 = 10 << 30 // Tổng dung lượng tối đa của một archive (chưa nén), 0 = không giới hạn
+	PreflightSizes           = false    // HEAD mọi file lúc tạo session (khi không resolveNames) để kiểm tra hai giới hạn trên
+
+	DataDir  = "" // Thư mục lưu session ra đĩa để giữ qua restart, rỗng = chỉ giữ trong bộ nhớ
+	SpoolDir = "" // Thư mục file tạm/artifact (tên file bắt đầu bằng token), rỗng = thư mục tạm của hệ thống
+
+	AdminKey      = "" // Bearer key cho các API quản trị (rotate, templates...), rỗng = tắt
+	WebhookSecret = "" // Secret ký HMAC-SHA256 cho webhook, rỗng = không ký
+)
+
+const (
+	MaxSessionLifetime = 24 * time.Hour // Giới hạn tuyệt đối tính từ lúc tạo (khi dùng sliding TTL)
+
+	DefaultPerFileTimeout = 10 * time.Minute // Trần thời gian mặc định cho mỗi file
+	DefaultFairnessFactor = 3.0              // Mỗi file được tối đa 3 lần phần chia đều của thời gian còn lại
+	MaxFairnessFactor     = 100.0            // Giới hạn fairnessFactor được khai báo
+
+	MinHedgeDelay      = 50 * time.Millisecond // hedgeDelay nhỏ nhất được chấp nhận
+	DefaultHedgeBudget = 10                    // Số hedge tối đa mỗi archive khi không khai báo hedgeBudget
+	MaxHedgeBudget     = 1000                  // Giới hạn hedgeBudget được khai báo
+
+	SpoolOrphanAge = 10 * time.Minute // Chỉ xóa file mồ côi cũ hơn ngưỡng này
+
+	ArtifactRetryAfter = 5 * time.Second // Retry-After của 202 khi archive resumableMode "file" đang được dựng
+
+	SpoolReserveOverhead = 1.1 // Hệ số nhân lên dung lượng ước lượng khi đặt trước chỗ cho spool
+
+	MirrorProbeTimeout = 3 * time.Second // Timeout cho mỗi probe khi mirrorStrategy = "fastest"
+
+	WebhookTimeout      = 10 * time.Second // Timeout cho mỗi lần POST webhook
+	WebhookRetries      = 2                // Số lần gửi lại event cuối/expired khi POST lỗi
+	WebhookRetryDelay   = 2 * time.Second  // Chờ trước lần gửi lại đầu tiên, nhân đôi sau mỗi lần
+	MinProgressInterval = 5 * time.Second  // progressInterval nhỏ nhất được chấp nhận
+
+	ManyFilesWarning    = 500           // Số file vượt ngưỡng này sẽ có warning many_files
+	HostFailureWarning  = 0.5           // Tỉ lệ lỗi của host để cảnh báo unreliable_host
+	HostStatsMinSamples = 10            // Số lần fetch tối thiểu trước khi đánh giá tỉ lệ lỗi
+	HostStatsWindow     = 1 * time.Hour // Thống kê host được reset sau khoảng này
+
+	MaxCreateBodyBytes = 16 << 20         // Giới hạn body /create sau khi giải nén
+	MaxManifestBytes   = 4 << 20          // Giới hạn dung lượng manifest của filesFromURL
+	ManifestTimeout    = 30 * time.Second // Thời gian tối đa để tải manifest
+
+	TargetLookupTimeout = 5 * time.Second // Thời gian resolve DNS tối đa khi kiểm tra URL lúc tạo
+
+	TombstoneRetention   = 24 * time.Hour  // Token hết hạn/đã tải trả 410 và còn clone được trong khoảng này
+	NotBeforeSkew        = 5 * time.Second // Dung sai lệch đồng hồ khi kiểm tra notBefore
+	AnalyticsMaxAttempts = 100             // Số lần download gần nhất giữ lại cho /session/{token}/analytics
+
+	ResolveConcurrency = 8                // Số request resolve tên song song khi resolveNames
+	ResolveTimeout     = 30 * time.Second // Thời gian tối đa cho toàn bộ bước resolve lúc tạo
+
+	RateWindow            = 10 * time.Second // Hằng số thời gian EWMA khi tính tốc độ truyền
+	StatusStreamInterval  = 1 * time.Second  // Chu kỳ đọc tiến độ của /status/{token}/stream
+	StatusStreamKeepAlive = 15 * time.Second // Gửi comment giữ kết nối khi tiến độ không đổi lâu chừng này
+
+	PrefetchBufferBytes = 1 << 20 // Số byte đọc trước tối đa của mỗi response đang chờ
+	MaxWorkers          = 64      // Giới hạn trên của --workers
+
+	MaxRetries      = 5                // Giới hạn retries được khai báo (request, file, --retries)
+	MaxRetryBackoff = 30 * time.Second // Giới hạn backoff (cả giá trị khai báo lẫn sau khi nhân đôi)
 )
 
+// LinkDomains ánh xạ alias -> base URL dùng trong download_url, ví dụ
+// {"customer": "https://downloads.example.com", "staff": "https://files.internal.example"}.
+// Khi có cấu hình, /download chỉ chấp nhận Host thuộc các domain này.
+var LinkDomains = map[string]string{}
+
 // ============== TYPES ==============
 
 type DownloadRequest struct {
-	Files   []string `json:"files"`
-	ZipName string   `json:"zipName"`
+	Files               []FileEntry    `json:"files"`
+	FilesFromURL        string         `json:"filesFromURL,omitempty"`   // URL manifest chứa danh sách file, merge sau files
+	ManifestFormat      string         `json:"manifestFormat,omitempty"` // "json-array" (mặc định), "text" hoặc "csv"
+	ZipName             string         `json:"zipName"`
+	SlidingTTL          bool           `json:"slidingTTL"`
+	LinkDomain          string         `json:"linkDomain"`
+	ShortLink           bool           `json:"shortLink"`      // download_url dùng dạng ngắn /d/{token}
+	MirrorStrategy      string         `json:"mirrorStrategy"` // "failover" (mặc định) hoặc "fastest"
+	Webhook             *WebhookConfig `json:"webhook"`
+	CallbackURL         string         `json:"callbackUrl,omitempty"`  // Viết tắt của webhook.url, chỉ gửi event cuối
+	ASCIINames          bool           `json:"asciiNames"`             // Chuyển tên entry sang ASCII
+	NameConflict        string         `json:"nameConflict,omitempty"` // "suffix" (mặc định), "overwrite", "error" hoặc "host", xem nameConflictPolicies
+	TimestampExtras     *bool          `json:"timestampExtras"`        // Ghi thêm extra field thời gian UTC, mặc định bật
+	Compression         string         `json:"compression,omitempty"`  // "store" (mặc định), "deflate" hoặc "auto" (deflate theo Content-Type)
+	ResolveNames        bool           `json:"resolveNames"`           // Resolve tên file ngay lúc tạo và trả về trong response
+	OnError             string         `json:"onError"`                // "skip" (mặc định) bỏ qua file lỗi, "abort" hủy cả archive
+	MaxFailureRatio     float64        `json:"maxFailureRatio"`        // Hủy archive khi tỉ lệ file lỗi vượt ngưỡng này (0 = tắt)
+	MaxFailures         *int           `json:"maxFailures"`            // Hủy archive khi số file lỗi vượt ngưỡng này
+	FailurePlaceholders bool           `json:"failurePlaceholders"`    // Ghi FAILED_<tên>.txt thay cho mỗi file lỗi
+	Dedupe              bool           `json:"dedupe,omitempty"`       // Bỏ hẳn entry trùng URL với một entry trước thay vì ghi thêm bản sao
+	Open                bool           `json:"open"`                   // Còn nhận thêm file qua /session/{token}/files cho tới khi finalize
+	Template            string         `json:"template,omitempty"`     // Tạo từ template đã lưu qua PUT /templates/{name}
+	NotBefore           string         `json:"notBefore,omitempty"`    // RFC 3339, từ chối download trước thời điểm này
+	TTLFrom             string         `json:"ttlFrom,omitempty"`      // "created" (mặc định) hoặc "notBefore": mốc bắt đầu tính TTL
+	ExpiresIn           string         `json:"expiresIn,omitempty"`    // TTL riêng của session (duration Go), tối đa MaxSessionTTL
+	RateLimit           int            `json:"rateLimit,omitempty"`    // Số lượt download tối đa mỗi phút cho token này
+	MaxDownloads        *int           `json:"maxDownloads,omitempty"` // Số lần tải trọn vẹn trước khi token hết hiệu lực, mặc định 1, 0 = tới hết TTL
+	AllowedCIDRs        []string       `json:"allowedCIDRs,omitempty"` // Chỉ cho download từ các dải IP này (IPv4/IPv6)
+
+	Headers map[string]string `json:"headers,omitempty"` // Header gửi kèm mọi request tới origin (Authorization, Cookie, X-*)
+
+	retrySettings // retries, retryBackoff, retryOn cho mọi file không tự khai báo
+
+	TotalTimeout   string  `json:"totalTimeout,omitempty"`   // Tổng thời gian cho archive, tối đa DownloadTimeout
+	PerFileTimeout string  `json:"perFileTimeout,omitempty"` // Trần thời gian cho mỗi file
+	FairnessFactor float64 `json:"fairnessFactor,omitempty"` // Hệ số trên phần chia đều thời gian còn lại cho mỗi file
+
+	HedgeDelay  string `json:"hedgeDelay,omitempty"`  // Gửi request thứ hai nếu chưa có response header sau khoảng này, ví dụ "2s"
+	HedgeBudget int    `json:"hedgeBudget,omitempty"` // Số hedge tối đa cho mỗi archive
+
+	Resumable     bool   `json:"resumable,omitempty"`     // Cho phép tải tiếp bằng Range
+	ResumableMode string `json:"resumableMode,omitempty"` // "stream" (mặc định, cần resolveNames) hoặc "file": dựng archive ra file rồi phục vụ
+	Prebuild      bool   `json:"prebuild,omitempty"`      // resumableMode "file": dựng archive ngay khi tạo session thay vì ở GET đầu tiên
+	ContentLength bool   `json:"contentLength,omitempty"` // Gửi Content-Length khi mọi dung lượng resolve được lúc tạo, nếu không thì stream chunked
+	PartMaxBytes  *int64 `json:"partMaxBytes,omitempty"`  // Chia thành nhiều archive không quá ngưỡng này, nil = PartMaxBytes của server, 0 = không chia
+
+	ArchiveFormat    string `json:"archiveFormat,omitempty"`    // "zip" (mặc định), "tar" hoặc "tar.gz"
+	CompressionLevel int    `json:"compressionLevel,omitempty"` // Mức deflate 1 (nhanh) - 9 (nhỏ nhất) cho compression deflate/auto
+	ErrorReport      string `json:"errorReport,omitempty"`      // "text" (mặc định, ERRORS.txt khi có file lỗi) hoặc "json" (luôn ghi manifest.json)
+	Checksums        bool   `json:"checksums,omitempty"`        // Ghi checksums.sha256 với SHA-256 của mọi file đã ghi
+	Password         string `json:"password,omitempty"`         // Mã hóa mọi entry zip bằng AES-256 với mật khẩu này
+	GeneratePassword bool   `json:"generatePassword,omitempty"` // Như password nhưng server sinh mật khẩu và trả trong response
+	TarUID           int    `json:"tarUid,omitempty"`           // Uid của mọi entry tar, mặc định 0
+	TarGID           int    `json:"tarGid,omitempty"`           // Gid của mọi entry tar, mặc định 0
+	TarUname         string `json:"tarUname,omitempty"`         // Tên user của mọi entry tar, mặc định rỗng
+	TarGname         string `json:"tarGname,omitempty"`         // Tên group của mọi entry tar, mặc định rỗng
+
+	Disposition string `json:"disposition,omitempty"` // "attachment" (mặc định) hoặc "inline" (chỉ session một file)
+	ContentType string `json:"contentType,omitempty"` // Ghi đè Content-Type của response, trong ResponseContentTypes
+
+	AllowedReferrers   []string `json:"allowedReferrers,omitempty"`   // Hostname được phép trong Referer/Origin, hỗ trợ "*.example.com"
+	AllowEmptyReferrer *bool    `json:"allowEmptyReferrer,omitempty"` // Bắt buộc khi có allowedReferrers: có cho request không Referer/Origin không
+	StrictReferrer     bool     `json:"strictReferrer,omitempty"`     // Mọi header Referer/Origin có mặt đều phải khớp
+}
+
+// FileEntry là một file trong request, chấp nhận chuỗi URL hoặc object {"url", "name", "path", "mirrors", "mode", "headers"}
+type FileEntry struct {
+	URL     string   `json:"url"`
+	Name    string   `json:"name,omitempty"` // Đường dẫn entry trong zip, ví dụ "reports/2024/q1.pdf", rỗng = lấy từ response
+	Path    string   `json:"path,omitempty"` // Thư mục đặt entry, ví dụ "invoices/2024/", ghép trước name
+	Mirrors []string `json:"mirrors,omitempty"`
+	Mode    string   `json:"mode,omitempty"` // Quyền file dạng octal, ví dụ "0755"
+
+	ExpectContentType string `json:"expectContentType,omitempty"` // Ví dụ "image/jpeg" hoặc "image/*"
+	SniffContentType  bool   `json:"sniffContentType,omitempty"`  // Kiểm tra thêm magic bytes của 512 byte đầu
+
+	ExpectSize *int64 `json:"expectSize,omitempty"` // Dung lượng chính xác (byte)
+	MinSize    *int64 `json:"minSize,omitempty"`
+	MaxSize    *int64 `json:"maxSize,omitempty"`
+
+	SHA256 string `json:"sha256,omitempty"` // SHA-256 mong đợi (hex), lệch thì file bị tính là lỗi
+
+	retrySettings // retries, retryBackoff, retryOn: ghi đè retry của request
+
+	headers http.Header // "headers" của entry, ghi đè header của session; không export dạng rõ
+
+	resolvedName string // Tên entry đã resolve lúc tạo session (resolveNames)
+	resolvedSize int64  // Content-Length thấy lúc preflight, 0 = không rõ
+}
+
+func (f *FileEntry) UnmarshalJSON(data []byte) error {
+	var u string
+	if err := json.Unmarshal(data, &u); err == nil {
+		*f = FileEntry{URL: u}
+		return nil
+	}
+
+	type plain FileEntry
+	var p struct {
+		plain
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*f = FileEntry(p.plain)
+	f.headers = toForwardHeaders(p.Headers)
+	return nil
+}
+
+// sources trả về URL chính và các mirror theo thứ tự khai báo
+func (f FileEntry) sources() []string {
+	return append([]string{f.URL}, f.Mirrors...)
 }
 
 type DownloadResponse struct {
-	DownloadURL string `json:"download_url"`
+	DownloadURL string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	FileNames   []string  `json:"file_names,omitempty"` // Tên entry khi resolveNames, "" = sẽ resolve lúc download
+	Parts       []string  `json:"parts,omitempty"`      // Link tải từng part khi session được chia
+	Password    string    `json:"password,omitempty"`   // Mật khẩu archive khi generatePassword, chỉ trả một lần
+	Warnings    []Warning `json:"warnings,omitempty"`
+}
+
+// Warning báo các điều kiện có thể gây kết quả kém nhưng không đủ để từ chối request
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Index   *int   `json:"index,omitempty"` // Vị trí file (bắt đầu từ 0) nếu warning gắn với một file
 }
 
+type RotateRequest struct {
+	Force bool `json:"force"` // Hủy các download đang chạy trên token cũ
+}
+
+// rotatedAbortReason là lý do abort của download bị cắt khi rotate với force
+const rotatedAbortReason = "rotated"
+
+// errSessionRotated là cause của context download bị hủy khi rotate với force
+var errSessionRotated = errors.New(rotatedAbortReason)
+
 type Session struct {
-	Files     []string
-	ZipName   string
-	CreatedAt time.Time
+	Files               []FileEntry
+	ZipName             string
+	CreatedAt           time.Time
+	LastAccessedAt      time.Time
+	SlidingTTL          bool
+	LinkDomain          string
+	ShortLink           bool
+	MirrorStrategy      string
+	Webhook             *WebhookConfig
+	ASCIINames          bool
+	NameConflict        string
+	Archive             archiveOptions
+	OnError             string
+	FailureLimits       failureLimits
+	FailurePlaceholders bool
+	ErrorReport         string // "" (ERRORS.txt) hoặc "json" (manifest.json)
+	Checksums           bool
+	Dedupe              bool
+	Open                bool // Đang chờ thêm file, download bị từ chối cho tới khi finalize
+	NotBefore           time.Time
+	TTLFrom             string
+	TTL                 time.Duration // TTL riêng từ expiresIn, 0 = SessionTTL
+	PartMaxBytes        int64         // Ngưỡng chia part, 0 = không chia
+	RateLimit           int
+	MaxDownloads        int // 0 = không giới hạn
+	AllowedCIDRs        []netip.Prefix
+	Referrers           *referrerPolicy
+	Deadlines           deadlinePolicy
+	Hedge               hedgePolicy
+	Retry               retrySettings // Retry mặc định của request cho các file, kể cả file append sau
+	Resumable           bool
+	ResumableMode       string // "" (stream) hoặc "file"
+	Prebuild            bool
+	Disposition         string
+	ContentType         string
+	APIKeyName          string // Tên API key đã tạo session, cho audit; rỗng khi không bật API key
+
+	token     string
+	elem      *list.Element    // Vị trí trong sessionOrder
+	heapIndex int              // Vị trí trong expiryQueue, -1 nếu không có
+	started   bool             // Đã có download bắt đầu, danh sách file không được sửa nữa
+	claims    int              // Số download tiêu thụ session đang chạy
+	active    int              // Số download/serve artifact đang dùng session; session không bị xóa khi > 0
+	completed int              // Số lần đã tải trọn vẹn
+	resume    []resumeRecord   // Entry đã stream xong của session resumable, theo vị trí file
+	artifact  *archiveArtifact // Archive đã dựng của session resumableMode "file"
+	headers   http.Header      // Header forward tới origin, chỉ export khi được mã hóa
+	finalized bool             // Danh sách file đã chốt qua finalize
+	revoked   bool             // Đã bị thu hồi qua admin API, download đang chạy abort với revokedAbortReason
+
+	partClaims    []int // Như claims, theo part
+	partDownloads []int // Như completed, theo part
+
+	progress             *downloadProgress // Tiến độ của download gần nhất, cho /status
+	progressOutcome      string            // Kết quả của download đó, "" = đang chạy
+	progressDisconnected bool              // Client ngắt kết nối trước khi download đó kết thúc
+
+	downloads map[uint64]runningDownload // Các download đang chạy
+	limiter   downloadLimiter
+	analytics sessionAnalytics
+	recordSum [sha256.Size]byte // Băm bản ghi đã ghi/đọc ở backend, để biết khi instance khác sửa
+	owner     netip.Addr        // IP đã tạo session qua /create hoặc clone, cho MaxSessionsPerIP
 }
 
-// ============== GLOBAL STATE ==============
+// tombstone giữ lý do một token không còn hợp lệ để trả 410 thay vì 404
+type tombstone struct {
+	Reason string
+	Until  time.Time
 
-var (
-	sessions = make(map[string]*Session)
-	mu       sync.RWMutex
+	session *Session // Session đã hết hạn/đã tải, giữ lại để clone (nil với token rotated)
+}
 
-	// HTTP client với timeout
-	httpClient = &http.Client{
-		Timeout: HTTPTimeout,
+// expiresAt phải được gọi khi đang giữ mu (RLock hoặc Lock)
+func (s *Session) expiresAt() time.Time {
+	start := s.CreatedAt
+	if s.TTLFrom == "notBefore" && s.NotBefore.After(start) {
+		start = s.NotBefore
 	}
-)
-
-// ============== CORS MIDDLEWARE ==============
 
-func enableCORS(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
-		w.Header().Set("Access-Control-Max-Age", "86400")
+	if s.SlidingTTL {
+		// TTL tính từ lần truy cập cuối, nhưng không vượt quá MaxSessionLifetime
+		if s.LastAccessedAt.After(start) {
+			start = s.LastAccessedAt
+		}
+		deadline := start.Add(s.ttl())
+		if limit := s.CreatedAt.Add(s.maxLifetime()); limit.Before(deadline) {
+			return limit
+		}
+		return deadline
+	}
 
-		// Handle preflight OPTIONS request
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
+	deadline := start.Add(s.ttl())
+	if s.TTLFrom == "notBefore" {
+		if limit := s.CreatedAt.Add(s.maxLifetime()); limit.Before(deadline) {
+			return limit
 		}
+	}
+	return deadline
+}
 
-		next(w, r)
+// ttl là TTL của session: expiresIn nếu có, ngược lại SessionTTL
+func (s *Session) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
 	}
+	return SessionTTL
 }
 
-// ============== MAIN ==============
+// maxLifetime là giới hạn tuyệt đối tính từ lúc tạo: MaxSessionLifetime, hoặc TTL riêng nếu dài hơn
+func (s *Session) maxLifetime() time.Duration {
+	return max(MaxSessionLifetime, s.TTL)
+}
 
-func main() {
-	// Khởi động cleanup goroutine
-	go cleanupExpiredSessions()
+func (s *Session) isExpired(now time.Time) bool {
+	return now.After(s.expiresAt())
+}
 
-	http.HandleFunc("/create", enableCORS(handleCreate))
-	http.HandleFunc("/download/", enableCORS(handleDownload))
+// touch gia hạn session khi bật sliding TTL, phải giữ mu.Lock
+func (s *Session) touch(now time.Time) {
+	if s.SlidingTTL {
+		s.LastAccessedAt = now
+		if s.heapIndex >= 0 {
+			heap.Fix(&expiryQueue, s.heapIndex)
+		}
+	}
+}
+
+// expiryHeap là min-heap các session theo thời điểm hết hạn
+type expiryHeap []*Session
 
-	port := ":6001"
-	log.Printf("Server running on %s (Session TTL: %v, HTTP Timeout: %v)", port, SessionTTL, HTTPTimeout)
-	log.Fatal(http.ListenAndServe(port, nil))
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt().Before(h[j].expiresAt()) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
 }
 
-// ============== CLEANUP GOROUTINE ==============
+func (h *expiryHeap) Push(x any) {
+	s := x.(*Session)
+	s.heapIndex = len(*h)
+	*h = append(*h, s)
+}
 
-func cleanupExpiredSessions() {
-	ticker := time.NewTicker(CleanupInterval)
-	defer ticker.Stop()
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	s := old[n-1]
+	old[n-1] = nil
+	s.heapIndex = -1
+	*h = old[:n-1]
+	return s
+}
 
-	for range ticker.C {
-		now := time.Now()
-		expired := []string{}
+// ============== GLOBAL STATE ==============
 
-		mu.RLock()
-		for token, session := range sessions {
-			if now.Sub(session.CreatedAt) > SessionTTL {
-				expired = append(expired, token)
-			}
+var (
+	sessions     = make(map[string]*Session)
+	sessionOrder = list.New() // Token theo thứ tự tạo, phần tử đầu là cũ nhất
+	expiryQueue  expiryHeap
+	expiryWake   = make(chan struct{}, 1) // Báo cleanup goroutine khi có deadline sớm hơn
+	tombstones   = make(map[string]tombstone)
+	mu           sync.RWMutex
+
+	downloadSeq atomic.Uint64
+
+	evictedSessions    atomic.Int64
+	throttledDownloads atomic.Int64
+
+	errTooManySessions     = errors.New("too many active sessions")
+	errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+	// Registry các token đang có file spool
+	spoolMu             sync.Mutex
+	spoolActive         = make(map[string]int)
+	reclaimedSpoolBytes atomic.Int64
+
+	// HTTP client với timeout, giao thức theo HostProtocols, qua source cache khi bật
+	httpClient = &http.Client{
+		Timeout:       HTTPTimeout,
+		Transport:     sourceCacheTransport{next: hostProtocolTransport{}},
+		CheckRedirect: checkRedirect,
+	}
+)
+
+// ============== SESSION STORE ==============
+
+// addSessionLocked thêm session mới, áp dụng giới hạn MaxSessions. Phải giữ mu.Lock
+func addSessionLocked(token string, session *Session) error {
+	if err := checkSessionQuotaLocked(session.owner); err != nil {
+		return err
+	}
+	for len(sessions) >= MaxSessions {
+		if EvictionPolicy == "reject" {
+			return errTooManySessions
 		}
-		mu.RUnlock()
 
-		if len(expired) > 0 {
-			mu.Lock()
-			for _, token := range expired {
-				delete(sessions, token)
+		// Bỏ qua session đang có download, nó được giữ tới khi download xong
+		oldest := sessionOrder.Front()
+		for oldest != nil && sessions[oldest.Value.(string)].active > 0 {
+			oldest = oldest.Next()
+		}
+		if oldest == nil {
+			if len(sessions) > 0 {
+				return errTooManySessions
 			}
-			mu.Unlock()
-			log.Printf("Cleaned up %d expired sessions", len(expired))
+			break
+		}
+		// Với backend chung, evict chỉ bỏ bản cache, instance khác vẫn dùng được session
+		evictedToken := oldest.Value.(string)
+		if sharedBackend() {
+			forgetSessionLocked(evictedToken)
+		} else {
+			deleteSessionLocked(evictedToken)
+		}
+		n := evictedSessions.Add(1)
+		slog.Warn("Evicted session", "token", evictedToken, "limit", MaxSessions, "evicted_total", n)
+	}
+
+	session.token = token
+	session.elem = sessionOrder.PushBack(token)
+	heap.Push(&expiryQueue, session)
+	sessions[token] = session
+	trackOwnerLocked(session.owner, 1)
+
+	if session.heapIndex == 0 {
+		select {
+		case expiryWake <- struct{}{}:
+		default:
 		}
 	}
+	return nil
 }
 
-// ============== HANDLERS ==============
+// deleteSessionLocked xóa session khỏi store và bản ghi của nó trong backend. Phải giữ mu.Lock
+func deleteSessionLocked(token string) {
+	if forgetSessionLocked(token) {
+		removeSessionRecordLocked(token)
+	}
+}
 
-func handleCreate(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// forgetSessionLocked xóa session khỏi map và danh sách thứ tự, giữ nguyên bản ghi trong backend.
+// Trả về false nếu không có session. Phải giữ mu.Lock
+func forgetSessionLocked(token string) bool {
+	session, ok := sessions[token]
+	if !ok {
+		return false
+	}
+	if session.elem != nil {
+		sessionOrder.Remove(session.elem)
+		session.elem = nil
 	}
+	if session.heapIndex >= 0 {
+		heap.Remove(&expiryQueue, session.heapIndex)
+	}
+	delete(sessions, token)
+	trackOwnerLocked(session.owner, -1)
+	session.dropArtifactLocked()
+	return true
+}
 
-	var req DownloadRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+// retireSessionLocked xóa session và giữ lại trong tombstone trong TombstoneRetention để token
+// trả 410 kèm lý do và vẫn clone được. Phải giữ mu.Lock
+func retireSessionLocked(token, reason string, now time.Time) {
+	session, ok := sessions[token]
+	if !ok {
 		return
 	}
+	// Bản ghi Redis tự hết hạn theo TTL, và có thể đã được instance khác gia hạn (slidingTTL)
+	if reason == "expired" && sharedBackend() {
+		forgetSessionLocked(token)
+	} else {
+		deleteSessionLocked(token)
+	}
+	tombstones[token] = tombstone{Reason: reason, Until: now.Add(TombstoneRetention), session: session}
+	if reason == "expired" {
+		notifyExpired(token, session)
+	}
+}
 
-	if len(req.Files) == 0 {
-		http.Error(w, "No files provided", http.StatusBadRequest)
+// expireSessionLocked retire session đã hết hạn. Nếu còn download đang chạy thì session chỉ rời
+// expiryQueue và ở lại store (request mới vẫn thấy nó đã hết hạn) cho tới khi releaseSessionLocked
+// của download cuối retire nó. Phải giữ mu.Lock
+func expireSessionLocked(session *Session, now time.Time) {
+	if session.active > 0 {
+		if session.heapIndex >= 0 {
+			heap.Remove(&expiryQueue, session.heapIndex)
+		}
 		return
 	}
+	retireSessionLocked(session.token, "expired", now)
+}
 
-	zipName := req.ZipName
-	if zipName == "" {
-		zipName = "files.zip"
+// releaseSessionLocked trả lượt dùng của một download; lượt cuối của session đã hết hạn thì retire
+// nó. An toàn khi session đã bị xóa hay rotate trong lúc tải. Phải giữ mu.Lock
+func releaseSessionLocked(session *Session, now time.Time) {
+	session.active--
+	if session.active == 0 && sessions[session.token] == session && session.isExpired(now) {
+		retireSessionLocked(session.token, "expired", now)
 	}
+}
 
-	token := uuid.New().String()
+// rotateSessionLocked chuyển session sang token mới và đánh dấu token cũ là rotated. Phải giữ mu.Lock
+func rotateSessionLocked(oldToken, newToken string) (*Session, bool) {
+	session, ok := sessions[oldToken]
+	if !ok {
+		return nil, false
+	}
 
-	mu.Lock()
-	sessions[token] = &Session{
-		Files:     req.Files,
-		ZipName:   zipName,
-		CreatedAt: time.Now(),
+	delete(sessions, oldToken)
+	session.token = newToken
+	session.elem.Value = newToken
+	sessions[newToken] = session
+	// File archive mang tên token: đổi theo để restart còn nhận ra. Bản đang dựng được đổi khi dựng xong
+	if a := session.artifact; a != nil && a.finished() && a.err == nil {
+		a.renameLocked(newToken)
 	}
-	mu.Unlock()
 
-	resp := DownloadResponse{
-		DownloadURL: fmt.Sprintf("https://%s/download/%s", r.Host, token),
+	tombstones[oldToken] = tombstone{Reason: "rotated", Until: session.expiresAt()}
+	removeSessionRecordLocked(oldToken)
+	persistSessionLocked(session)
+	return session, true
+}
+
+// ============== MAIN ==============
+
+func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.ShowVersion {
+		fmt.Println(version)
+		return
+	}
+	cfg.apply()
+	if err := openLocalRoot(); err != nil {
+		log.Fatal(err)
+	}
+	if err := openSourceCache(); err != nil {
+		log.Fatalf("Failed to open source cache %s: %v", SourceCacheDir, err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	for _, missing := range missingCatalogKeys() {
+		slog.Warn("Missing translation", "key", missing, "fallback", DefaultLanguage)
+	}
 
-	log.Printf("Created session %s with %d files (expires: %v)", token, len(req.Files), time.Now().Add(SessionTTL).Format("15:04:05"))
-}
+	if err := openSessionBackend(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadPersistedSessions(); err != nil {
+		log.Fatalf("Failed to load sessions from %s: %v", DataDir, err)
+	}
+	if err := loadTemplates(); err != nil {
+		log.Fatalf("Failed to load templates: %v", err)
+	}
+	pruneSessionFiles()
+	// Dọn file tạm còn sót lại từ lần chạy trước (ví dụ crash giữa chừng), sau khi nạp session để
+	// giữ artifact của các session còn sống
+	sweepOrphanSpoolFiles()
 
-func handleDownload(w http.ResponseWriter, r *http.Request) {
-	token := path.Base(r.URL.Path)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	mu.RLock()
-	session, exists := sessions[token]
-	mu.RUnlock()
+	// Khởi động cleanup goroutine, dừng khi nhận tín hiệu tắt
+	go cleanupExpiredSessions(ctx)
+	go sweepSpoolPeriodically(ctx)
+	go sweepCreateLimiters(ctx)
 
-	if !exists {
-		http.Error(w, "Invalid or expired token", http.StatusNotFound)
-		return
+	addr := cfg.addr()
+	slog.Info("Server running", "version", version, "addr", addr, "tls", TLSCertFile != "", "h2c", H2C, "session_ttl", SessionTTL.String(), "http_timeout", HTTPTimeout.String())
+	srv, err := newHTTPServer(addr, newServer(cfg))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := serveUntil(ctx, srv); err != nil {
+		log.Fatal(err)
 	}
+	flushSessions()
+	waitForWebhooks()
+	slog.Info("Server stopped")
+}
+
+// ============== CLEANUP GOROUTINE ==============
+
+func cleanupExpiredSessions(ctx context.Context) {
+	timer := time.NewTimer(CleanupInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-expiryWake:
+		}
 
-	// Check nếu session đã expired
-	if time.Since(session.CreatedAt) > SessionTTL {
-		http.Error(w, "Session expired", http.StatusGone)
 		mu.Lock()
-		delete(sessions, token)
+		expired, _, next := sweepExpiredLocked(time.Now())
 		mu.Unlock()
-		return
-	}
 
-	// Set headers
-	w.Header().Set("Content-Type", "application/zip")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", session.ZipName))
+		if expired > 0 {
+			slog.Info("Cleaned up expired sessions", "sessions", expired)
+		}
+
+		// Ngủ tới deadline kế tiếp (+1ms để chắc chắn đã quá hạn)
+		timer.Reset(next + time.Millisecond)
+	}
+}
 
-	zipWriter := zip.NewWriter(w)
-	defer zipWriter.Close()
+// sweepExpiredLocked retire các session đã quá hạn và xóa tombstone hết hạn, trả số đã dọn và thời
+// gian tới deadline kế tiếp (tối đa CleanupInterval). Phải giữ mu.Lock
+func sweepExpiredLocked(now time.Time) (expired, pruned int, next time.Duration) {
+	// Chỉ pop các session đã quá hạn ở đầu heap, không quét toàn bộ map
+	for len(expiryQueue) > 0 && expiryQueue[0].isExpired(now) {
+		expireSessionLocked(expiryQueue[0], now)
+		expired++
+	}
+	for token, t := range tombstones {
+		if now.After(t.Until) {
+			delete(tombstones, token)
+			pruned++
+		}
+	}
+	next = CleanupInterval
+	if len(expiryQueue) > 0 {
+		if d := expiryQueue[0].expiresAt().Sub(now); d < next {
+			next = d
+		}
+	}
+	return expired, pruned, next
+}
 
-	usedNames := make(map[string]int)
+// ============== SPOOL SWEEPER ==============
 
-	// Context với timeout cho toàn bộ download
-	ctx, cancel := context.WithTimeout(r.Context(), DownloadTimeout)
-	defer cancel()
+func sweepSpoolPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(CleanupInterval)
+	defer ticker.Stop()
 
-	for _, fileURL := range session.Files {
-		// Check context trước mỗi file
+	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Download timeout for token: %s", token)
 			return
-		default:
+		case <-ticker.C:
 		}
+		sweepOrphanSpoolFiles()
+		reconcileSpoolReservations()
+		pruneSessionFiles()
+	}
+}
+
+// spoolTempPrefix đứng trước token trong tên file spool ở thư mục tạm của hệ thống, để sweeper chỉ
+// đụng tới file của chúng ta trong thư mục dùng chung
+const spoolTempPrefix = "dmf-"
 
-		fileName, resp, err := getOriginalFileName(ctx, fileURL)
+// createSpoolFile tạo file tạm trong SpoolDir (tên bắt đầu bằng token) hoặc thư mục tạm của hệ thống
+// (tên bắt đầu bằng spoolTempPrefix rồi token),
+// đặt trước size byte (0 = không rõ) từ phần của download. release đóng, xóa file, trả phần đặt trước
+// và bỏ đánh dấu với sweeper.
+func createSpoolFile(spool *spoolReservation, kind string, size int64) (*os.File, func(), error) {
+	token := spool.token
+	acquireSpool(token)
+
+	f, err := os.CreateTemp(SpoolDir, spoolFilePattern(token, kind))
+	if err == nil {
+		spoolMu.Lock()
+		err = spool.claimLocked(f.Name(), size)
+		spoolMu.Unlock()
 		if err != nil {
-			log.Printf("Error fetching %s: %v", fileURL, err)
-			continue
+			f.Close()
+			os.Remove(f.Name())
 		}
+	}
+	if err != nil {
+		releaseSpool(token)
+		return nil, nil, err
+	}
 
-		// Xử lý trùng tên - lưu tên gốc để đếm chính xác
-		originalName := fileName
-		if count, exists := usedNames[originalName]; exists {
-			ext := path.Ext(fileName)
-			base := fileName[:len(fileName)-len(ext)]
-			fileName = fmt.Sprintf("%s_%d%s", base, count+1, ext)
-		}
-		usedNames[originalName]++
+	return f, func() {
+		f.Close()
+		os.Remove(f.Name())
+		spoolMu.Lock()
+		releaseSpoolClaimLocked(f.Name())
+		spoolMu.Unlock()
+		releaseSpool(token)
+	}, nil
+}
 
-		log.Printf("Streaming: %s -> %s", fileURL, fileName)
+// spoolFilePattern là mẫu tên (cho os.CreateTemp và filepath.Glob) của file spool loại kind của token
+func spoolFilePattern(token, kind string) string {
+	if SpoolDir == "" {
+		return spoolTempPrefix + token + "-" + kind + "-*"
+	}
+	return token + "-" + kind + "-*"
+}
 
-		if err := streamToZip(zipWriter, resp, fileName); err != nil {
-			log.Printf("Error streaming: %v", err)
-			resp.Body.Close()
+// removeSpoolFiles xóa các file spool loại kind của token trừ keep, dùng lúc khởi động khi chưa có
+// download nào chạy
+func removeSpoolFiles(token, kind, keep string) {
+	paths, _ := filepath.Glob(filepath.Join(spoolVolume(), spoolFilePattern(token, kind)))
+	for _, p := range paths {
+		if p == keep {
 			continue
 		}
-		resp.Body.Close()
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove spool file", "file", p, "error", err)
+		}
 	}
+}
 
-	// Xóa session sau khi download xong
-	mu.Lock()
-	delete(sessions, token)
-	mu.Unlock()
+// renameSpoolFile đổi tên file spool của oldToken sang newToken (giữ loại và phần ngẫu nhiên), chuyển
+// theo phần đặt trước và đánh dấu với sweeper. Trả về đường dẫn mới
+func renameSpoolFile(path, oldToken, newToken string) (string, error) {
+	dir, name := filepath.Split(path)
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(name, spoolTempPrefix), oldToken)
+	if !ok {
+		return "", fmt.Errorf("spool file %s does not belong to %s", name, oldToken)
+	}
+	newPath := filepath.Join(dir, strings.TrimSuffix(name, oldToken+rest)+newToken+rest)
 
-	log.Printf("Download completed for token: %s", token)
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+	if err := os.Rename(path, newPath); err != nil {
+		return "", err
+	}
+	if claim, ok := spoolClaims[path]; ok {
+		delete(spoolClaims, path)
+		claim.token = newToken
+		spoolClaims[newPath] = claim
+	}
+	spoolActive[newToken]++
+	if spoolActive[oldToken] <= 1 {
+		delete(spoolActive, oldToken)
+	} else {
+		spoolActive[oldToken]--
+	}
+	return newPath, nil
 }
 
-// ============== HELPERS ==============
+// acquireSpool đánh dấu token đang có file spool để sweeper không xóa
+func acquireSpool(token string) {
+	spoolMu.Lock()
+	spoolActive[token]++
+	spoolMu.Unlock()
+}
 
-func getOriginalFileName(ctx context.Context, fileURL string) (string, *http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
-	if err != nil {
-		return "", nil, err
+func releaseSpool(token string) {
+	spoolMu.Lock()
+	if spoolActive[token] <= 1 {
+		delete(spoolActive, token)
+	} else {
+		spoolActive[token]--
 	}
+	spoolMu.Unlock()
+}
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", nil, err
+// spoolToken lấy token từ tên file theo quy ước "<token>.<ext>" hoặc "<token>-<suffix>"
+func spoolToken(name string) string {
+	if len(name) >= 36 {
+		if _, err := uuid.Parse(name[:36]); err == nil {
+			return name[:36]
+		}
 	}
+	if i := strings.IndexAny(name, ".-"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
 
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return "", nil, fmt.Errorf("bad status %d", resp.StatusCode)
+// sweepOrphanSpoolFiles xóa file spool cũ hơn SpoolOrphanAge của token không còn session và không
+// có build nào đang giữ. Khi không đặt SpoolDir chỉ xét file mang spoolTempPrefix trong os.TempDir()
+func sweepOrphanSpoolFiles() {
+	dir, prefix := SpoolDir, ""
+	if dir == "" {
+		dir, prefix = os.TempDir(), spoolTempPrefix
 	}
 
-	// Thử lấy từ Content-Disposition header
-	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
-		_, params, err := mime.ParseMediaType(cd)
-		if err == nil {
-			if filename, ok := params["filename"]; ok && filename != "" {
-				return filename, resp, nil
-			}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("Spool sweep failed", "error", err)
 		}
+		return
 	}
 
-	// Fallback: lấy từ URL path
-	parsed, err := url.Parse(fileURL)
-	if err == nil {
-		fileName := path.Base(parsed.Path)
-		if fileName != "" && fileName != "/" && fileName != "." {
-			return fileName, resp, nil
+	now := time.Now()
+	var removed int
+	var reclaimed int64
+
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) < SpoolOrphanAge {
+			continue
 		}
-	}
 
-	return "file", resp, nil
-}
+		token := spoolToken(name)
 
-func streamToZip(zw *zip.Writer, resp *http.Response, fileName string) error {
-	header := &zip.FileHeader{
-		Name:   fileName,
-		Method: zip.Store,
+		mu.RLock()
+		_, live := sessions[token]
+		mu.RUnlock()
+		if live {
+			continue
+		}
+
+		// Giữ spoolMu khi xóa để không race với build vừa acquire cùng token
+		spoolMu.Lock()
+		if spoolActive[token] == 0 {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err == nil {
+				removed++
+				reclaimed += info.Size()
+			} else if !os.IsNotExist(err) {
+				slog.Warn("Failed to remove orphan spool file", "file", entry.Name(), "error", err)
+			}
+		}
+		spoolMu.Unlock()
 	}
-	header.SetModTime(time.Now())
 
-	fileWriter, err := zw.CreateHeader(header)
-	if err != nil {
-		return err
+	if removed > 0 {
+		total := reclaimedSpoolBytes.Add(reclaimed)
+		slog.Info("Removed orphan spool files", "files", removed, "bytes", reclaimed, "bytes_total", total)
+	}
+}
+
+// ============== HANDLERS ==============
+
+// createMode cho biết session dựng từ body /create được dùng thế nào
+type createMode int
+
+const (
+	createStored   createMode = iota // /create: lưu vào store, trả link
+	createOneShot                    // /zip: stream ngay trong response, không lưu
+	createValidate                   // /validate: chỉ kiểm tra, dung lượng được báo theo từng file
+)
+
+// newSession là session đã validate từ body /create, chưa lưu vào store
+type newSession struct {
+	token     string
+	session   *Session
+	fileNames []string
+	parts     int
+	warnings  []Warning
+	password  string // Chỉ có khi server sinh mật khẩu
+}
+
+// newSessionFromRequest đọc và validate body /create rồi dựng session. Trả nil khi đã trả lỗi cho client
+func newSessionFromRequest(w http.ResponseWriter, r *http.Request, mode createMode) *newSession {
+	if rejectDuringShutdown(w, r) {
+		return nil
+	}
+	keyName, ok := requireAPIKey(w, r)
+	if !ok || !allowCreate(w, r) {
+		return nil
+	}
+
+	body, err := createRequestBody(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedEncoding) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return nil
+	}
+	defer body.Close()
+
+	var req DownloadRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body too large (max %d bytes decoded)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return nil
+		}
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return nil
+	}
+
+	if req.Template != "" {
+		merged, ok := instantiateTemplate(req)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown template: %s", req.Template), http.StatusBadRequest)
+			return nil
+		}
+		req = merged
+	}
+
+	if req.FilesFromURL != "" {
+		extra, err := fetchManifest(r.Context(), req.FilesFromURL, req.ManifestFormat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return nil
+		}
+		req.Files = append(req.Files, extra...)
+	} else if req.ManifestFormat != "" {
+		http.Error(w, "manifestFormat requires filesFromURL", http.StatusBadRequest)
+		return nil
+	}
+
+	if len(req.Files) == 0 && !req.Open {
+		http.Error(w, "No files provided", http.StatusBadRequest)
+		return nil
+	}
+	if err := validateFiles(req.Files, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	normalizeWarnings, err := normalizeFiles(req.Files, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	// Sau khi chuẩn hóa để hai cách viết của cùng một URL được coi là trùng
+	var dropWarnings []Warning
+	if req.Dedupe {
+		req.Files, dropWarnings = dropDuplicateFiles(nil, req.Files)
+	}
+	if len(req.Files) > MaxFilesPerSession {
+		http.Error(w, fmt.Sprintf("Too many files (max %d per session)", MaxFilesPerSession), http.StatusBadRequest)
+		return nil
+	}
+	if err := checkFileTargets(r.Context(), req.Files, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	if req.CallbackURL != "" {
+		if req.Webhook != nil {
+			http.Error(w, "Use either callbackUrl or webhook, not both", http.StatusBadRequest)
+			return nil
+		}
+		req.Webhook = &WebhookConfig{URL: req.CallbackURL}
+	}
+	if req.Webhook != nil {
+		err := req.Webhook.validate()
+		if err == nil {
+			err = checkTargetURL(r.Context(), req.Webhook.URL)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid webhook: %v", err), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	switch req.MirrorStrategy {
+	case "", "failover", "fastest":
+	default:
+		http.Error(w, fmt.Sprintf("Unknown mirrorStrategy: %s", req.MirrorStrategy), http.StatusBadRequest)
+		return nil
+	}
+
+	switch req.OnError {
+	case "", "skip", "abort":
+	default:
+		http.Error(w, fmt.Sprintf("Unknown onError: %s", req.OnError), http.StatusBadRequest)
+		return nil
+	}
+	if _, ok := nameConflictPolicies[req.NameConflict]; req.NameConflict != "" && !ok {
+		http.Error(w, fmt.Sprintf("Unknown nameConflict: %s", req.NameConflict), http.StatusBadRequest)
+		return nil
+	}
+	switch req.ErrorReport {
+	case "", "text":
+		req.ErrorReport = ""
+	case "json":
+		// manifest.json luôn được ghi ở cuối nên không có trong layout tính trước của resume stream
+		if req.Resumable && req.ResumableMode != "file" {
+			http.Error(w, `errorReport "json" requires resumableMode "file" on resumable sessions`, http.StatusBadRequest)
+			return nil
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown errorReport: %s", req.ErrorReport), http.StatusBadRequest)
+		return nil
+	}
+	// checksums.sha256 cũng được ghi ở cuối, và entry client đã có khi resume không được hash lại
+	if req.Checksums && req.Resumable && req.ResumableMode != "file" {
+		http.Error(w, `checksums requires resumableMode "file" on resumable sessions`, http.StatusBadRequest)
+		return nil
+	}
+	switch req.Disposition {
+	case "", "attachment":
+	case "inline":
+		if len(req.Files) != 1 || req.Open {
+			http.Error(w, "disposition inline is only supported for single-file sessions; multi-file archives are always sent as attachment", http.StatusBadRequest)
+			return nil
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown disposition: %s", req.Disposition), http.StatusBadRequest)
+		return nil
+	}
+
+	format, ok := normalizeArchiveFormat(req.ArchiveFormat)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown archiveFormat: %s", req.ArchiveFormat), http.StatusBadRequest)
+		return nil
+	}
+
+	contentType := archiveOptions{Format: format}.contentType()
+	if req.ContentType != "" {
+		ct, err := validateResponseContentType(req.ContentType, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		contentType = ct
+	}
+
+	switch req.ResumableMode {
+	case "", "stream", "file":
+		if req.ResumableMode != "" && !req.Resumable {
+			http.Error(w, "resumableMode requires resumable", http.StatusBadRequest)
+			return nil
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown resumableMode: %s", req.ResumableMode), http.StatusBadRequest)
+		return nil
+	}
+	if req.ResumableMode == "stream" {
+		req.ResumableMode = ""
+	}
+	if req.ContentLength && !req.Resumable {
+		if conflict := contentLengthConflict(&req, format); conflict != "" {
+			http.Error(w, fmt.Sprintf("contentLength cannot be combined with %s", conflict), http.StatusBadRequest)
+			return nil
+		}
+		req.ResolveNames = true
+	}
+	partMax, err := partMaxBytes(&req, mode != createStored)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if partMax > 0 {
+		req.ResolveNames = true // Chia theo dung lượng resolve lúc tạo
+	}
+	switch req.Compression {
+	case "", "store":
+		req.Compression = ""
+	case "deflate", "auto":
+		// Layout resumable tính offset từ dung lượng gốc nên entry phải là Store
+		if req.Resumable && req.ResumableMode == "" {
+			http.Error(w, fmt.Sprintf("compression %q requires resumableMode \"file\" on resumable sessions", req.Compression), http.StatusBadRequest)
+			return nil
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown compression: %s", req.Compression), http.StatusBadRequest)
+		return nil
+	}
+	if req.CompressionLevel != 0 {
+		if req.CompressionLevel < flate.BestSpeed || req.CompressionLevel > flate.BestCompression {
+			http.Error(w, "compressionLevel must be between 1 and 9", http.StatusBadRequest)
+			return nil
+		}
+		if req.Compression == "" && format != "tar.gz" {
+			http.Error(w, `compressionLevel requires compression "deflate" or "auto", or archiveFormat "tar.gz"`, http.StatusBadRequest)
+			return nil
+		}
+	}
+	if format != "" {
+		// Entry tar không nén riêng lẻ: cả archive được gzip với tar.gz
+		if req.Compression != "" {
+			http.Error(w, `compression only applies to zip; use archiveFormat "tar.gz" to compress a tar`, http.StatusBadRequest)
+			return nil
+		}
+		// Layout resumable stream tính offset theo cấu trúc zip
+		if req.Resumable && req.ResumableMode == "" {
+			http.Error(w, fmt.Sprintf("archiveFormat %q requires resumableMode \"file\" on resumable sessions", format), http.StatusBadRequest)
+			return nil
+		}
+	}
+	if err := validateTarOwner(&req, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	password, generatedPassword, err := archivePassword(&req, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	archiveOpts := archiveOptions{
+		TimestampExtras: req.TimestampExtras == nil || *req.TimestampExtras,
+		Compression:     req.Compression,
+		Level:           req.CompressionLevel,
+		Format:          format,
+		Encrypted:       password != "",
+		UID:             req.TarUID,
+		GID:             req.TarGID,
+		Uname:           req.TarUname,
+		Gname:           req.TarGname,
+		password:        password,
+	}
+	if req.Resumable && req.ResumableMode == "file" && req.Open {
+		http.Error(w, "resumable sessions cannot be open", http.StatusBadRequest)
+		return nil
+	}
+	if req.Prebuild && (!req.Resumable || req.ResumableMode != "file") {
+		http.Error(w, `prebuild requires resumable with resumableMode "file"`, http.StatusBadRequest)
+		return nil
+	}
+	if req.Resumable && req.ResumableMode == "" {
+		// Archive phải sinh lại được y hệt: file lỗi hủy cả archive thay vì thay đổi layout
+		switch {
+		case !req.ResolveNames:
+			http.Error(w, "resumable requires resolveNames", http.StatusBadRequest)
+			return nil
+		case req.OnError == "skip", req.FailurePlaceholders:
+			http.Error(w, "resumable sessions always abort on a failed file (onError: abort, no failurePlaceholders)", http.StatusBadRequest)
+			return nil
+		case req.Open:
+			http.Error(w, "resumable sessions cannot be open", http.StatusBadRequest)
+			return nil
+		}
+		req.OnError = "abort"
+	}
+	failLimits := failureLimits{MaxFailureRatio: req.MaxFailureRatio, MaxFailures: req.MaxFailures}
+	if err := failLimits.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	var notBefore time.Time
+	if req.NotBefore != "" {
+		t, err := time.Parse(time.RFC3339, req.NotBefore)
+		if err != nil {
+			http.Error(w, "Invalid notBefore, expected RFC 3339", http.StatusBadRequest)
+			return nil
+		}
+		notBefore = t
+	}
+	allowedCIDRs, err := parsePrefixes(req.AllowedCIDRs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid allowedCIDRs: %v", err), http.StatusBadRequest)
+		return nil
+	}
+	headers := toForwardHeaders(req.Headers)
+	if err := validateForwardHeaders(headers); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid headers: %v", err), http.StatusBadRequest)
+		return nil
+	}
+	var referrers *referrerPolicy
+	if len(req.AllowedReferrers) > 0 {
+		if err := validateReferrerPatterns(req.AllowedReferrers); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid allowedReferrers: %v", err), http.StatusBadRequest)
+			return nil
+		}
+		if req.AllowEmptyReferrer == nil {
+			http.Error(w, "allowedReferrers requires allowEmptyReferrer to be set explicitly", http.StatusBadRequest)
+			return nil
+		}
+		referrers = &referrerPolicy{
+			Allowed:    req.AllowedReferrers,
+			AllowEmpty: *req.AllowEmptyReferrer,
+			Strict:     req.StrictReferrer,
+		}
+	}
+
+	if req.RateLimit < 0 {
+		http.Error(w, "rateLimit must not be negative", http.StatusBadRequest)
+		return nil
+	}
+	maxDownloads := 1
+	if req.MaxDownloads != nil {
+		if *req.MaxDownloads < 0 {
+			http.Error(w, "maxDownloads must not be negative", http.StatusBadRequest)
+			return nil
+		}
+		if req.Resumable && req.ResumableMode == "file" {
+			http.Error(w, "maxDownloads does not apply to resumableMode file (downloads are unlimited until the TTL)", http.StatusBadRequest)
+			return nil
+		}
+		maxDownloads = *req.MaxDownloads
+	}
+	deadlines, err := parseDeadlinePolicy(req.TotalTimeout, req.PerFileTimeout, req.FairnessFactor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	hedge, err := parseHedgePolicy(req.HedgeDelay, req.HedgeBudget)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	if err := req.validateRetry(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 || d > MaxSessionTTL {
+			http.Error(w, fmt.Sprintf("expiresIn must be a duration between 0 and %v", MaxSessionTTL), http.StatusBadRequest)
+			return nil
+		}
+		ttl = d
+	}
+
+	switch req.TTLFrom {
+	case "", "created":
+	case "notBefore":
+		if notBefore.IsZero() {
+			http.Error(w, "ttlFrom notBefore requires notBefore", http.StatusBadRequest)
+			return nil
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown ttlFrom: %s", req.TTLFrom), http.StatusBadRequest)
+		return nil
+	}
+
+	warnings := append(append(dropWarnings, normalizeWarnings...), createWarnings(req.Files)...)
+
+	zipName := sanitizeZipName(req.ZipName)
+	if req.ZipName != "" && zipName != req.ZipName {
+		warnings = append(warnings, Warning{
+			Code:    "zip_name_sanitized",
+			Message: fmt.Sprintf("zipName was changed to %q", zipName),
+		})
+	}
+	if zipName == "" {
+		zipName = archiveOpts.defaultArchiveName()
+	}
+
+	if req.LinkDomain != "" {
+		if _, ok := LinkDomains[req.LinkDomain]; !ok {
+			http.Error(w, fmt.Sprintf("Unknown linkDomain: %s", req.LinkDomain), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	var fileNames []string
+	if req.ResolveNames {
+		var resolveWarnings []Warning
+		var err error
+		fileNames, resolveWarnings, err = resolveNames(withForwardHeaders(r.Context(), headers), req.Files, nameOptions{ASCII: req.ASCIINames, Conflict: req.NameConflict})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		warnings = append(warnings, resolveWarnings...)
+	}
+	if req.Resumable && req.ResumableMode == "" {
+		if err := validateResumable(req.Files); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+	} else if req.ContentLength && !req.Resumable {
+		if fixed, err := fixedLayoutFiles(req.Files); err == nil {
+			req.Files, req.Resumable, req.OnError = fixed, true, "abort"
+		} else {
+			warnings = append(warnings, Warning{
+				Code:    "content_length_unavailable",
+				Message: err.Error() + "; the archive will be streamed without Content-Length",
+			})
+		}
+	}
+	parts := splitParts(req.Files, partMax)
+	if parts == nil {
+		partMax = 0
+	} else {
+		warnings = append(warnings, partWarnings(req.Files)...)
+	}
+	if mode != createValidate {
+		var probedSizes []int64
+		if PreflightSizes && !req.ResolveNames {
+			probedSizes = probeSizes(withForwardHeaders(r.Context(), headers), req.Files)
+		}
+		if err := checkKnownSizes(req.Files, probedSizes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	token := uuid.New().String()
+	now := time.Now()
+
+	session := &Session{
+		Files:               req.Files,
+		ZipName:             zipName,
+		CreatedAt:           now,
+		LastAccessedAt:      now,
+		SlidingTTL:          req.SlidingTTL,
+		LinkDomain:          req.LinkDomain,
+		ShortLink:           req.ShortLink,
+		MirrorStrategy:      req.MirrorStrategy,
+		Webhook:             req.Webhook,
+		ASCIINames:          req.ASCIINames,
+		NameConflict:        req.NameConflict,
+		OnError:             req.OnError,
+		FailureLimits:       failLimits,
+		FailurePlaceholders: req.FailurePlaceholders,
+		ErrorReport:         req.ErrorReport,
+		Checksums:           req.Checksums,
+		Dedupe:              req.Dedupe,
+		Open:                req.Open,
+		NotBefore:           notBefore,
+		TTLFrom:             req.TTLFrom,
+		TTL:                 ttl,
+		PartMaxBytes:        partMax,
+		RateLimit:           req.RateLimit,
+		MaxDownloads:        maxDownloads,
+		AllowedCIDRs:        allowedCIDRs,
+		Referrers:           referrers,
+		headers:             headers,
+		Deadlines:           deadlines,
+		Hedge:               hedge,
+		Retry:               req.retrySettings,
+		Resumable:           req.Resumable,
+		ResumableMode:       req.ResumableMode,
+		Prebuild:            req.Prebuild,
+		Disposition:         req.Disposition,
+		ContentType:         contentType,
+		Archive:             archiveOpts,
+		APIKeyName:          keyName,
+		owner:               sessionOwner(r),
+	}
+	expiresAt := session.expiresAt()
+	if !notBefore.IsZero() && !notBefore.Before(expiresAt) {
+		http.Error(w, fmt.Sprintf("notBefore is after the session expires (%s); use ttlFrom: notBefore", expiresAt.Format(time.RFC3339)), http.StatusBadRequest)
+		return nil
+	}
+
+	created := &newSession{token: token, session: session, fileNames: fileNames, parts: len(parts), warnings: warnings}
+	if generatedPassword {
+		created.password = password
+	}
+	return created
+}
+
+func handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	created := newSessionFromRequest(w, r, createStored)
+	if created == nil {
+		return // newSessionFromRequest đã trả lỗi
+	}
+	token, session := created.token, created.session
+	expiresAt := session.expiresAt()
+
+	mu.Lock()
+	err := addSessionLocked(token, session)
+	if err == nil {
+		err = persistNewSessionLocked(session)
+	}
+	if err == nil {
+		session.prebuildLocked(token)
+	}
+	mu.Unlock()
+
+	if errors.Is(err, errPersist) {
+		http.Error(w, "Failed to store session", http.StatusInternalServerError)
+		return
+	}
+	if errors.Is(err, errSessionQuota) {
+		slog.WarnContext(r.Context(), "Rejected session create", "error", err, "limit", MaxSessionsPerIP)
+		http.Error(w, "Too many active sessions for this address, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected session create", "error", err, "limit", MaxSessions)
+		http.Error(w, "Too many active sessions, try again later", http.StatusInsufficientStorage)
+		return
+	}
+
+	resp := DownloadResponse{
+		DownloadURL: downloadURL(r, session.LinkDomain, session.ShortLink, token, session.signedExpiry()),
+		ExpiresAt:   expiresAt,
+		FileNames:   created.fileNames,
+		Parts:       partURLs(r, session.LinkDomain, session.ShortLink, token, session.signedExpiry(), created.parts),
+		Warnings:    created.warnings,
+		Password:    created.password,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	sessionsCreated.Add(1)
+	slog.InfoContext(r.Context(), "Created session", "token", token, "files", len(session.Files), "expires_at", expiresAt, "sliding", session.SlidingTTL, "api_key", session.APIKeyName)
+}
+
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	if !isAllowedHost(r.Host) {
+		localizedError(w, r, http.StatusMisdirectedRequest, "unknown_host")
+		return
+	}
+
+	token, sub := downloadToken(r.URL.Path)
+	part := 0 // /download/{token}/part/{n}, 0 = cả archive
+	if n, ok := parsePartPath(sub); ok {
+		part, sub = n, ""
+	}
+	planOnly := sub == "parts" // /download/{token}/parts, plan tải song song của archive resumableMode "file"
+	if planOnly {
+		sub = ""
+	}
+	if sub != "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkDownloadSignature(w, r, token) {
+		return
+	}
+	syncSharedSession(token)
+	if r.Method == http.MethodDelete {
+		handleCancelDownload(w, r, token)
+		return
+	}
+	// Giới hạn download stream cùng lúc của cả server, không tính bản dựng artifact nội bộ (mỗi session một lần)
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		writeServerBusy(w, r)
+		return
+	}
+	defer release()
+
+	mu.Lock()
+	session, exists := sessions[token]
+	if !exists {
+		t, gone := tombstones[token]
+		mu.Unlock()
+		if gone {
+			localizedError(w, r, http.StatusGone, "token_gone", t.Reason)
+			return
+		}
+		localizedError(w, r, http.StatusNotFound, "invalid_token")
+		return
+	}
+
+	// Check nếu session đã expired, nếu chưa thì gia hạn (sliding TTL)
+	now := time.Now()
+	if session.isExpired(now) {
+		expireSessionLocked(session, now)
+		mu.Unlock()
+		localizedError(w, r, http.StatusGone, "session_expired")
+		return
+	}
+
+	if len(session.AllowedCIDRs) > 0 {
+		addr, ok := clientIP(r)
+		if !ok || !containsAddr(session.AllowedCIDRs, addr) {
+			session.recordAttempt(r, "forbidden_network", 0)
+			mu.Unlock()
+			slog.WarnContext(r.Context(), "Rejected download: outside allowedCIDRs", "token", token)
+			localizedError(w, r, http.StatusForbidden, "forbidden_network")
+			return
+		}
+	}
+
+	if session.Referrers != nil && !session.Referrers.allows(r) {
+		session.recordAttempt(r, "forbidden_referrer", 0)
+		mu.Unlock()
+		slog.WarnContext(r.Context(), "Rejected download: referrer not allowed", "token", token, "referrer", r.Referer(), "origin", r.Header.Get("Origin"))
+		localizedError(w, r, http.StatusForbidden, "forbidden_referrer")
+		return
+	}
+
+	// Giới hạn tần suất trước mọi request tới origin
+	if ok, wait := session.allowDownload(now); !ok {
+		throttled := session.limiter.throttled
+		session.recordAttempt(r, "throttled", 0)
+		persistSessionLocked(session) // Bucket đi cùng bản ghi sang các replica khác
+		mu.Unlock()
+		throttledDownloads.Add(1)
+		slog.WarnContext(r.Context(), "Throttled download", "token", token, "throttled_total", throttled)
+		writeThrottled(w, r, wait)
+		return
+	}
+
+	// Archive prebuild được dựng trước notBefore, chỉ phục vụ sau thời điểm đó
+	if notBefore := session.NotBefore; isBeforeNotBefore(notBefore, now) {
+		session.recordAttempt(r, "not_yet_available", 0)
+		mu.Unlock()
+		writeNotYetAvailable(w, r, notBefore, now)
+		return
+	}
+	if session.Open {
+		session.recordAttempt(r, "not_finalized", 0)
+		mu.Unlock()
+		localizedError(w, r, http.StatusConflict, "not_finalized")
+		return
+	}
+	if len(session.Files) == 0 {
+		session.recordAttempt(r, "no_files", 0)
+		mu.Unlock()
+		localizedError(w, r, http.StatusConflict, "no_files")
+		return
+	}
+
+	// Session chia part: mỗi part là một archive riêng trên một đoạn của danh sách file. Với
+	// resumableMode "file" part là một đoạn byte của archive đã dựng (xem serveArtifact)
+	parts := session.parts()
+	fileMode := session.Resumable && session.ResumableMode == "file"
+	partFiles := session.Files
+	if part > len(parts) && !fileMode {
+		session.recordAttempt(r, "bad_part", 0)
+		mu.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	if part > 0 && !fileMode {
+		p := parts[part-1]
+		partFiles = session.Files[p.start:p.end]
+	}
+
+	// Chọn một phần file qua ?only=1,4,7 và/hoặc ?match=*.pdf
+	files, subset, err := selectFiles(partFiles, r.URL.Query())
+	if err == nil && subset && (part > 0 || planOnly) {
+		err = errors.New("only and match cannot be combined with a part download")
+	}
+	if err == nil && planOnly && !fileMode {
+		err = errors.New("part plans need a resumable session with resumableMode file")
+	}
+	if err != nil {
+		session.recordAttempt(r, "bad_selection", 0)
+		mu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	files = append([]FileEntry(nil), files...) // Bản chụp, stream không đọc session.Files sau khi unlock
+
+	// Một client (API key hoặc IP) không được giữ hết slot download của server
+	client := downloadClient(session, r)
+	releaseClient, ok := acquireClientSlotLocked(client)
+	if !ok {
+		session.recordAttempt(r, "client_busy", 0)
+		mu.Unlock()
+		slog.WarnContext(r.Context(), "Rejected download: client at concurrency cap", "token", token, "client", client)
+		writeClientBusy(w, r)
+		return
+	}
+	defer releaseClient()
+
+	// Archive dựng sẵn ra file: các lần tải đều phục vụ từ file, session giữ tới hết TTL
+	if fileMode && !subset {
+		serveArtifact(w, r, session, token, part, planOnly)
+		return
+	}
+
+	// Archive resumable stream chỉ phụ thuộc danh sách file và CreatedAt: trả 304 trước khi claim
+	// hay fetch gì từ origin
+	if session.Resumable && !fileMode && !subset {
+		etag := archiveETag(files, session.CreatedAt)
+		if notModified(r, etag, session.CreatedAt) {
+			session.touch(now)
+			session.recordAttempt(r, "not_modified", 0)
+			createdAt := session.CreatedAt
+			mu.Unlock()
+			writeNotModified(w, etag, createdAt)
+			return
+		}
+	}
+
+	// Claim trước khi stream: số download tiêu thụ session chạy đồng thời không vượt số lượt còn lại
+	consumes := (!subset || SubsetDownloadsCount) && !fileMode && part == 0
+	if part > 0 {
+		switch session.claimPartLocked(part-1, len(parts)) {
+		case "consumed":
+			session.recordAttempt(r, "part_consumed", 0)
+			mu.Unlock()
+			localizedError(w, r, http.StatusGone, "token_gone", "consumed")
+			return
+		case "in_progress":
+			session.recordAttempt(r, "in_progress", 0)
+			mu.Unlock()
+			writeDownloadInProgress(w, r)
+			return
+		}
+	}
+	if consumes {
+		if !session.canClaim() {
+			session.recordAttempt(r, "in_progress", 0)
+			mu.Unlock()
+			writeDownloadInProgress(w, r)
+			return
+		}
+		session.claims++
+	}
+	session.touch(now)
+	session.started = true
+	persistSessionLocked(session)
+
+	// Context với tổng thời gian của download, đăng ký để rotate --force, thu hồi và DELETE có thể hủy
+	ctx, finishDownload := session.startDownloadLocked(r.Context(), false)
+	defer finishDownload()
+	zipName := session.ZipName
+	if part > 0 {
+		zipName = partZipName(zipName, part)
+	}
+	webhook := session.Webhook
+	opts := session.buildOptionsLocked(token)
+	opts.resumable = session.Resumable && !fileMode && !subset
+	opts.client, opts.startedAt = r.Context(), time.Now()
+	contentType := session.ContentType
+	disposition := session.Disposition
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	if opts.resumable {
+		opts.records = make([]resumeRecord, len(files))
+		copy(opts.records, session.resume)
+	}
+	mu.Unlock()
+
+	// Chỉ download đã ghi hết archive mà client còn kết nối mới tính một lượt (trừ khi token đã bị
+	// rotate trong lúc tải); hết lượt thì tiêu thụ session. Lỗi hay client ngắt giữa chừng thì
+	// nhả claim để người dùng thử lại
+	out := &sentCounter{w: throttleResponse(ctx, w)}
+	outcome, abortReason := "failed", ""
+	downloadStarted()
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		downloadFinished(outcome == "completed" && r.Context().Err() == nil, time.Since(opts.startedAt), out.n)
+		session.recordAttemptReason(r, outcome, abortReason, out.n)
+		if part > 0 {
+			session.finishPartLocked(token, part-1, len(parts), outcome == "completed" && r.Context().Err() == nil)
+			return
+		}
+		if !consumes {
+			return
+		}
+		session.claims--
+		if sessions[token] != session {
+			return
+		}
+		if outcome == "completed" && r.Context().Err() == nil {
+			session.completed++
+			if session.MaxDownloads > 0 && session.completed >= session.MaxDownloads {
+				retireSessionLocked(token, "consumed", time.Now())
+				return
+			}
+		}
+		persistSessionLocked(session)
+	}()
+
+	// Đặt trước chỗ cho file spool (dedupe, kiểm tra dung lượng) để các download song song
+	// không cùng lấp đầy ổ đĩa rồi chết giữa chừng
+	spool, err := reserveSpool(token, spoolEstimate(files))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected download", "token", token, "error", err)
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	defer spool.close()
+	opts.spool = spool
+
+	// Session resumable: tính trước layout để gửi Content-Length và tiếp tục từ Range: bytes=N-
+	var target io.Writer = out
+	if opts.resumable {
+		plan, err := planResume(r, files, opts.records, opts.archive, opts.createdAt)
+		if err != nil {
+			var re *resumeError
+			if !errors.As(err, &re) {
+				slog.ErrorContext(r.Context(), "Failed to compute archive layout", "token", token, "error", err)
+				http.Error(w, "Failed to compute archive layout", http.StatusInternalServerError)
+				return
+			}
+			if re.Status == http.StatusRequestedRangeNotSatisfiable {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", plan.Total))
+			}
+			slog.WarnContext(r.Context(), "Rejected resume", "token", token, "error", err)
+			http.Error(w, re.Message, re.Status)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		setArchiveValidators(w, plan.ETag, opts.createdAt)
+		w.Header().Set("Content-Length", strconv.FormatInt(plan.Total-plan.Offset, 10))
+		if plan.Offset > 0 {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", plan.Offset, plan.Total-1, plan.Total))
+			target = &skipWriter{w: out, skip: plan.Offset, start: func() { w.WriteHeader(http.StatusPartialContent) }}
+			slog.InfoContext(r.Context(), "Resuming download", "token", token, "offset", plan.Offset, "total", plan.Total, "from_file", plan.Boundary)
+		}
+		opts.plan = plan
+	}
+
+	// Set headers
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, zipName))
+
+	// Webhook cuối cùng được gửi sau khi zip đã đóng
+	opts.progress = newDownloadProgress(len(files))
+	opts.progress.setTotalBytes(knownTotalBytes(files[opts.plan.Boundary:]))
+	finishProgress := session.watchProgress(opts.progress)
+	reporter := startWebhookReporter(token, zipName, webhook, opts.progress)
+	defer func() {
+		reporter.finish(outcome, r.Context().Err() != nil)
+		finishProgress(outcome, r.Context().Err() != nil)
+	}()
+
+	err = buildArchive(ctx, target, files, opts)
+	outcome, abortReason = buildOutcome(err)
+	if err == nil && subset {
+		slog.InfoContext(r.Context(), "Partial download", "token", token, "files", len(files), "session_files", len(session.Files))
+	}
+	endStream(w, err)
+}
+
+// handleSession xử lý các API quản lý session: /session/{token}/{action}
+func handleSession(w http.ResponseWriter, r *http.Request) {
+	token, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/session/"), "/")
+	syncSharedSession(token)
+
+	switch action {
+	case "rotate":
+		handleRotate(w, r, token)
+	case "files":
+		handleSessionFiles(w, r, token)
+	case "finalize":
+		handleFinalize(w, r, token)
+	case "clone":
+		handleClone(w, r, token)
+	case "analytics":
+		handleAnalytics(w, r, token)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func handleRotate(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req RotateRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+
+	newToken := uuid.New().String()
+
+	// Kiểm tra người tạo và rotate trong cùng một lần giữ khóa: session không thể bị rotate hay
+	// thay giữa hai bước
+	mu.Lock()
+	var creator string
+	if session, ok := sessions[token]; ok {
+		creator = session.APIKeyName
+	}
+	if !requireCreatorOrAdmin(w, r, creator) {
+		mu.Unlock()
+		return
+	}
+	by := "admin"
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		by = creator
+	}
+	session, ok := rotateSessionLocked(token, newToken)
+	if !ok {
+		mu.Unlock()
+		http.Error(w, "Invalid or expired token", http.StatusNotFound)
+		return
+	}
+	expiresAt := session.expiresAt()
+	linkDomain := session.LinkDomain
+	shortLink := session.ShortLink
+	signedUntil := session.signedExpiry()
+	partCount := len(session.parts())
+	cancelled := 0
+	if req.Force {
+		for id, d := range session.downloads {
+			d.cancel(errSessionRotated)
+			delete(session.downloads, id)
+			cancelled++
+		}
+	}
+	notifyRotated(token, session)
+	mu.Unlock()
+
+	resp := DownloadResponse{
+		DownloadURL: downloadURL(r, linkDomain, shortLink, newToken, signedUntil),
+		ExpiresAt:   expiresAt,
+		Parts:       partURLs(r, linkDomain, shortLink, newToken, signedUntil, partCount),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	slog.InfoContext(r.Context(), "Rotated session", "token", token, "new_token", newToken, "by", by, "force", req.Force, "cancelled_downloads", cancelled)
+}
+
+// ============== HELPERS ==============
+
+// createRequestBody giải nén body gzip nếu có và giới hạn dung lượng sau giải nén
+// để chống decompression bomb
+func createRequestBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, error) {
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return http.MaxBytesReader(w, r.Body, MaxCreateBodyBytes), nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(http.MaxBytesReader(w, r.Body, MaxCreateBodyBytes))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %v", err)
+		}
+		return http.MaxBytesReader(w, gz, MaxCreateBodyBytes), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, enc)
+	}
+}
+
+// validateFiles kiểm tra các file entry; offset là số file đã có trước đó (để đánh số khi append)
+func validateFiles(files []FileEntry, offset int) error {
+	for i, f := range files {
+		n := offset + i + 1
+		if f.URL == "" {
+			return fmt.Errorf("File %d has no url", n)
+		}
+		if f.Name != "" {
+			if err := validateEntryName(f.Name); err != nil {
+				return fmt.Errorf("File %d has invalid name: %v", n, err)
+			}
+		}
+		if f.Path != "" {
+			if err := validateEntryName(strings.TrimSuffix(f.Path, "/")); err != nil {
+				return fmt.Errorf("File %d has invalid path: %v", n, err)
+			}
+		}
+		if err := validateForwardHeaders(f.headers); err != nil {
+			return fmt.Errorf("File %d has invalid headers: %v", n, err)
+		}
+		if f.Mode != "" {
+			if _, err := parseFileMode(f.Mode); err != nil {
+				return fmt.Errorf("File %d has invalid mode: %v", n, err)
+			}
+		}
+		if f.ExpectContentType != "" {
+			if _, _, err := mime.ParseMediaType(f.ExpectContentType); err != nil {
+				return fmt.Errorf("File %d has invalid expectContentType: %v", n, err)
+			}
+		}
+		if err := f.validateSize(); err != nil {
+			return fmt.Errorf("File %d: %v", n, err)
+		}
+		if err := f.validateChecksum(); err != nil {
+			return fmt.Errorf("File %d: %v", n, err)
+		}
+		if err := f.validateRetry(); err != nil {
+			return fmt.Errorf("File %d: %v", n, err)
+		}
+	}
+	return nil
+}
+
+// archiveName là tên entry trong archive: name của request (hoặc fileName lấy từ response)
+// đặt trong thư mục path
+func (f FileEntry) archiveName(fileName string) string {
+	if f.Name != "" {
+		fileName = f.Name
+	}
+	if f.Path != "" {
+		fileName = strings.TrimSuffix(f.Path, "/") + "/" + fileName
+	}
+	return fileName
+}
+
+// sanitizeZipName bỏ ký tự điều khiển, dấu nháy và dấu phân cách đường dẫn để tránh header injection
+func sanitizeZipName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case r == '"' || r == '/' || r == '\\':
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
+
+// validateEntryName chỉ nhận đường dẫn tương đối dùng "/" để archive không ghi được ra ngoài
+// thư mục giải nén (zip-slip)
+func validateEntryName(name string) error {
+	switch {
+	case strings.ContainsRune(name, '\\'):
+		return errors.New("backslashes are not allowed, use /")
+	case strings.HasPrefix(name, "/") || path.IsAbs(name) || len(name) >= 2 && name[1] == ':':
+		return errors.New("absolute paths are not allowed")
+	case strings.HasSuffix(name, "/"):
+		return errors.New("must name a file, not a directory")
+	}
+	for _, seg := range strings.Split(name, "/") {
+		switch {
+		case seg == "..":
+			return errors.New(`".." is not allowed`)
+		case seg == "" || seg == ".":
+			return errors.New("empty path segment")
+		case len(seg) > MaxNameBytes:
+			return fmt.Errorf("path segment longer than %d bytes", MaxNameBytes)
+		}
+		for _, r := range seg {
+			if r < 0x20 || r == 0x7f {
+				return errors.New("control characters are not allowed")
+			}
+		}
+	}
+	return nil
+}
+
+// uniqueName thêm hậu tố _N khi tên đã được dùng - lưu tên gốc để đếm chính xác,
+// bỏ qua hậu tố trùng với một tên có sẵn dạng _N
+func uniqueName(usedNames map[string]int, fileName string) string {
+	count, exists := usedNames[fileName]
+	if !exists {
+		usedNames[fileName] = 1
+		return fileName
+	}
+
+	ext := path.Ext(fileName)
+	base := fileName[:len(fileName)-len(ext)]
+	for {
+		count++
+		candidate := fmt.Sprintf("%s_%d%s", base, count, ext)
+		if _, taken := usedNames[candidate]; !taken {
+			usedNames[fileName] = count
+			usedNames[candidate] = 1
+			return candidate
+		}
+	}
+}
+
+// requireAdmin kiểm tra Bearer AdminKey, trả false nếu đã ghi response lỗi
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if AdminKey == "" {
+		http.Error(w, "Admin API disabled", http.StatusForbidden)
+		return false
+	}
+
+	key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(AdminKey)) != 1 {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// downloadURL dùng base URL của linkDomain nếu có, rồi PublicURL, ngược lại dùng scheme và Host của request.
+// Khi có HMACSecret, link được ký với hạn signedUntil
+func downloadURL(r *http.Request, linkDomain string, shortLink bool, token string, signedUntil time.Time) string {
+	return signDownloadURL(downloadPath(r, linkDomain, shortLink, token), token, signedUntil)
+}
+
+// downloadPath là link tải chưa ký của token
+func downloadPath(r *http.Request, linkDomain string, shortLink bool, token string) string {
+	route := "download"
+	if shortLink {
+		route = "d"
+	}
+	if base, ok := LinkDomains[linkDomain]; ok {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(base, "/"), route, token)
+	}
+	if PublicURL != "" {
+		return fmt.Sprintf("%s/%s/%s", PublicURL, route, token)
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", requestScheme(r), r.Host, route, token)
+}
+
+// downloadToken tách /download/{token}[/{sub}] hoặc /d/{token}[/{sub}] thành token và sub-resource
+func downloadToken(urlPath string) (string, string) {
+	rest, ok := strings.CutPrefix(urlPath, "/download/")
+	if !ok {
+		rest = strings.TrimPrefix(urlPath, "/d/")
+	}
+	token, sub, _ := strings.Cut(rest, "/")
+	return token, sub
+}
+
+// isAllowedHost chấp nhận mọi Host khi chưa cấu hình LinkDomains
+func isAllowedHost(host string) bool {
+	if len(LinkDomains) == 0 {
+		return true
+	}
+	for _, base := range LinkDomains {
+		u, err := url.Parse(base)
+		if err == nil && strings.EqualFold(u.Host, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// newEntryHeader tạo header cho entry, dùng chung cho stream và tính layout khi resume
+func newEntryHeader(entry zipEntry, opts archiveOptions) *zip.FileHeader {
+	header := &zip.FileHeader{
+		Name:   entry.Name,
+		Method: opts.method(entry),
+	}
+	header.SetMode(entry.Mode)
+	t := entry.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	setEntryTime(header, t, opts)
+	header.Extra = append(header.Extra, unicodePathExtra(entry.Name)...)
+	return header
+}
+
+func getOriginalFileName(ctx context.Context, fileURL string, hedge *entryHedge) (string, *http.Response, error) {
+	resp, err := hedge.do(ctx, fileURL)
+	if err != nil {
+		if ctx.Err() == nil {
+			recordHostResult(fileURL, false)
+		}
+		return "", nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		recordHostResult(fileURL, false)
+		return "", nil, &statusError{Code: resp.StatusCode, Proto: resp.Proto, RetryAfter: retryAfter(resp)}
+	}
+	recordHostResult(fileURL, true)
+
+	return fileNameFromResponse(resp.Header, fileURL), resp, nil
+}
+
+// fileNameFromResponse lấy tên từ Content-Disposition, fallback về URL path rồi "file"
+func fileNameFromResponse(header http.Header, fileURL string) string {
+	// Thử lấy từ Content-Disposition header
+	if cd := header.Get("Content-Disposition"); cd != "" {
+		_, params, err := mime.ParseMediaType(cd)
+		if err == nil {
+			if filename, ok := params["filename"]; ok && filename != "" {
+				return filename
+			}
+		}
+	}
+
+	// Fallback: lấy từ URL path
+	parsed, err := url.Parse(fileURL)
+	if err == nil {
+		fileName := path.Base(parsed.Path)
+		if fileName != "" && fileName != "/" && fileName != "." {
+			return fileName
+		}
 	}
 
-	_, err = io.Copy(fileWriter, resp.Body)
-	return err
+	return "file"
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// manifestError là lỗi khi tải hoặc parse manifest, trả 422 lúc tạo session
+type manifestError struct {
+	Message string
+}
+
+func (e *manifestError) Error() string { return "filesFromURL: " + e.Message }
+
+func manifestErrorf(format string, args ...any) error {
+	return &manifestError{Message: fmt.Sprintf(format, args...)}
+}
+
+// fetchManifest tải manifest tại manifestURL và parse thành danh sách file theo format
+func fetchManifest(ctx context.Context, manifestURL, format string) ([]FileEntry, error) {
+	switch format {
+	case "":
+		format = "json-array"
+	case "json-array", "text", "csv":
+	default:
+		return nil, manifestErrorf("unknown manifestFormat %q (json-array, text, csv)", format)
+	}
+
+	normalized, _, err := normalizeURL(manifestURL)
+	if err != nil {
+		return nil, manifestErrorf("invalid URL: %v", err)
+	}
+
+	if err := checkTargetURL(ctx, normalized); err != nil {
+		return nil, manifestErrorf("%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ManifestTimeout)
+	defer cancel()
+	resp, err := sendGet(ctx, normalized)
+	if err != nil {
+		return nil, manifestErrorf("fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, manifestErrorf("fetch failed: %v", &statusError{Code: resp.StatusCode, Proto: resp.Proto})
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxManifestBytes+1))
+	if err != nil {
+		return nil, manifestErrorf("fetch failed: %v", err)
+	}
+	if len(data) > MaxManifestBytes {
+		return nil, manifestErrorf("manifest too large (max %d bytes)", MaxManifestBytes)
+	}
+
+	switch format {
+	case "text":
+		return parseTextManifest(data)
+	case "csv":
+		return parseCSVManifest(data)
+	default:
+		return parseJSONManifest(data)
+	}
+}
+
+// parseJSONManifest nhận mảng JSON gồm chuỗi URL hoặc object file entry
+func parseJSONManifest(data []byte) ([]FileEntry, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			return nil, manifestErrorf("invalid JSON at line %d: %v", lineAt(data, syntax.Offset), err)
+		}
+		return nil, manifestErrorf("manifest must be a JSON array: %v", err)
+	}
+	files := make([]FileEntry, 0, len(elems))
+	for i, raw := range elems {
+		var f FileEntry
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return nil, manifestErrorf("element %d: %v", i, err)
+		}
+		if err := checkManifestURL(f.URL); err != nil {
+			return nil, manifestErrorf("element %d: %v", i, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// parseTextManifest nhận mỗi dòng một URL, bỏ qua dòng trống và dòng bắt đầu bằng #
+func parseTextManifest(data []byte) ([]FileEntry, error) {
+	var files []FileEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), MaxManifestBytes)
+	for line := 1; sc.Scan(); line++ {
+		u := strings.TrimSpace(sc.Text())
+		if u == "" || strings.HasPrefix(u, "#") {
+			continue
+		}
+		if err := checkManifestURL(u); err != nil {
+			return nil, manifestErrorf("line %d: %v", line, err)
+		}
+		files = append(files, FileEntry{URL: u})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, manifestErrorf("read failed: %v", err)
+	}
+	return files, nil
+}
+
+// parseCSVManifest nhận CSV có dòng header chứa cột url; các cột khác được bỏ qua
+func parseCSVManifest(data []byte) ([]FileEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, manifestErrorf("empty CSV manifest")
+		}
+		return nil, manifestErrorf("invalid CSV: %v", err)
+	}
+	col := -1
+	for i, h := range header {
+		if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")), "url") {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return nil, manifestErrorf("line 1: CSV header has no url column")
+	}
+
+	var files []FileEntry
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, manifestErrorf("invalid CSV: %v", err)
+		}
+		line, _ := r.FieldPos(0)
+		if col >= len(rec) {
+			return nil, manifestErrorf("line %d: missing url column", line)
+		}
+		u := strings.TrimSpace(rec[col])
+		if err := checkManifestURL(u); err != nil {
+			return nil, manifestErrorf("line %d: %v", line, err)
+		}
+		files = append(files, FileEntry{URL: u})
+	}
+	return files, nil
+}
+
+// checkManifestURL kiểm tra sơ bộ để báo lỗi kèm vị trí trong manifest;
+// validateFiles vẫn kiểm tra đầy đủ sau khi merge
+func checkManifestURL(raw string) error {
+	if raw == "" {
+		return errors.New("empty URL")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("not an absolute http(s) URL: %s", raw)
+	}
+	return nil
+}
+
+// lineAt trả về số dòng (bắt đầu từ 1) của byte offset trong data
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
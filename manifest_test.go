@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func manifestURLs(files []FileEntry) []string {
+	urls := make([]string, len(files))
+	for i, f := range files {
+		urls[i] = f.URL
+	}
+	return urls
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) ([]FileEntry, error)
+		data  string
+		urls  []string
+	}{
+		{"json strings", parseJSONManifest, `["https://a.example/1.bin", "https://a.example/2.bin"]`, []string{"https://a.example/1.bin", "https://a.example/2.bin"}},
+		{"json objects", parseJSONManifest, `[{"url": "https://a.example/1.bin", "name": "one.bin"}, "https://a.example/2.bin"]`, []string{"https://a.example/1.bin", "https://a.example/2.bin"}},
+		{"json empty", parseJSONManifest, `[]`, []string{}},
+		{"text", parseTextManifest, "# build 123\nhttps://a.example/1.bin\n\n  https://a.example/2.bin  \n", []string{"https://a.example/1.bin", "https://a.example/2.bin"}},
+		{"csv", parseCSVManifest, "name,URL,size\none,https://a.example/1.bin,10\ntwo,https://a.example/2.bin,20\n", []string{"https://a.example/1.bin", "https://a.example/2.bin"}},
+		{"csv bom", parseCSVManifest, "\ufeffurl\nhttps://a.example/1.bin\n", []string{"https://a.example/1.bin"}},
+	}
+	for _, tt := range tests {
+		files, err := tt.parse([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := manifestURLs(files); !slices.Equal(got, tt.urls) {
+			t.Errorf("%s: urls = %q, want %q", tt.name, got, tt.urls)
+		}
+	}
+
+	files, _ := parseJSONManifest([]byte(`[{"url": "https://a.example/1.bin", "name": "one.bin", "path": "dir/"}]`))
+	if f := files[0]; f.Name != "one.bin" || f.Path != "dir/" {
+		t.Fatalf("json object entry = %+v, want name and path kept", f)
+	}
+}
+
+// Lỗi parse phải chỉ ra dòng hoặc phần tử hỏng
+func TestParseManifestErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		parse func([]byte) ([]FileEntry, error)
+		data  string
+		want  string
+	}{
+		{"json syntax", parseJSONManifest, "[\n\"https://a.example/1\",\n}", "invalid JSON at line 3"},
+		{"json not array", parseJSONManifest, `{"url": "https://a.example/1"}`, "must be a JSON array"},
+		{"json bad element", parseJSONManifest, `["https://a.example/1", 42]`, "element 1"},
+		{"json relative url", parseJSONManifest, `["https://a.example/1", "/2.bin"]`, "element 1: not an absolute http(s) URL"},
+		{"json empty url", parseJSONManifest, `[{"name": "x"}]`, "element 0: empty URL"},
+		{"text bad line", parseTextManifest, "https://a.example/1\n\nftp://a.example/2\n", "line 3"},
+		{"csv no url column", parseCSVManifest, "name,size\na,1\n", "line 1: CSV header has no url column"},
+		{"csv empty", parseCSVManifest, "", "empty CSV manifest"},
+		{"csv short row", parseCSVManifest, "name,url\na,https://a.example/1\nb\n", "line 3: missing url column"},
+		{"csv bad url", parseCSVManifest, "url\nhttps://a.example/1\nnot a url\n", "line 3"},
+	}
+	for _, tt := range tests {
+		_, err := tt.parse([]byte(tt.data))
+		var me *manifestError
+		if !errors.As(err, &me) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want manifest error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestFetchManifest(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/manifest.txt":
+			w.Write([]byte("https://a.example/1.bin\nhttps://a.example/2.bin\n"))
+		case "/big.txt":
+			w.Write([]byte(strings.Repeat("x", MaxManifestBytes+1)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+	newTestServer(t)
+	ctx := context.Background()
+
+	files, err := fetchManifest(ctx, origin.URL+"/manifest.txt", "text")
+	if err != nil || len(files) != 2 {
+		t.Fatalf("fetch = %d files, %v", len(files), err)
+	}
+	for _, tt := range []struct {
+		path, format, want string
+	}{
+		{"/manifest.txt", "yaml", "unknown manifestFormat"},
+		{"/missing.json", "", "fetch failed"},
+		{"/big.txt", "text", "manifest too large"},
+	} {
+		if _, err := fetchManifest(ctx, origin.URL+tt.path, tt.format); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("fetch %s as %q = %v, want %q", tt.path, tt.format, err, tt.want)
+		}
+	}
+
+	guardPrivate(t)
+	if _, err := fetchManifest(ctx, origin.URL+"/manifest.txt", "text"); err == nil || !strings.Contains(err.Error(), "blocked target") {
+		t.Fatalf("fetch from a loopback origin = %v, want blocked", err)
+	}
+}
+
+// Manifest được merge sau các file inline; lỗi manifest trả 422
+func TestCreateFilesFromURL(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/manifest.json" {
+			w.Write([]byte(`["http://` + r.Host + `/b.txt", {"url": "http://` + r.Host + `/c.txt", "name": "renamed.txt"}]`))
+			return
+		}
+		if r.URL.Path == "/broken.json" {
+			w.Write([]byte(`["http://` + r.Host + `/b.txt", ]`))
+			return
+		}
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"filesFromURL":"`+origin.URL+`/manifest.json"}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+	mu.RLock()
+	var names []string
+	for _, f := range sessions[token].Files {
+		names = append(names, f.URL[len(origin.URL):]+"="+f.Name)
+	}
+	mu.RUnlock()
+	if want := []string{"/a.txt=", "/b.txt=", "/c.txt=renamed.txt"}; !slices.Equal(names, want) {
+		t.Fatalf("session files = %q, want %q", names, want)
+	}
+
+	status, resp := postCreateWithKey(t, base, "", `{"filesFromURL":"`+origin.URL+`/broken.json"}`)
+	if status != http.StatusUnprocessableEntity || !strings.Contains(resp, "line 1") {
+		t.Fatalf("broken manifest = %d %q, want 422 naming the line", status, resp)
+	}
+}
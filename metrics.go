@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============== METRICS ==============
+
+// Exporter text format của Prometheus viết tay để không thêm dependency
+
+var (
+	sessionsCreated    atomic.Int64
+	downloadsStarted   atomic.Int64
+	downloadsCompleted atomic.Int64
+	downloadsFailed    atomic.Int64
+	downloadsInFlight  atomic.Int64
+	filesFetched       atomic.Int64
+	filesFailed        atomic.Int64
+	bytesStreamed      atomic.Int64
+
+	downloadDuration = newHistogram(1, 5, 15, 60, 300, 900, 1800)
+)
+
+// histogram đếm quan sát theo bucket (giây), cộng dồn như Prometheus
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []int64 // Số quan sát <= bounds[i], chưa cộng dồn
+	count   int64
+	sum     float64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]int64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var cumulative int64
+	for i, b := range h.bounds {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// downloadStarted ghi nhận một download bắt đầu stream (không tính bản dựng artifact nội bộ)
+func downloadStarted() {
+	downloadsStarted.Add(1)
+	downloadsInFlight.Add(1)
+}
+
+// downloadFinished ghi nhận kết thúc của download đã downloadStarted
+func downloadFinished(completed bool, d time.Duration, sent int64) {
+	downloadsInFlight.Add(-1)
+	if completed {
+		downloadsCompleted.Add(1)
+	} else {
+		downloadsFailed.Add(1)
+	}
+	bytesStreamed.Add(sent)
+	downloadDuration.observe(d.Seconds())
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	mu.RLock()
+	active := len(sessions)
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"dmf_sessions_created_total", "counter", "Sessions created by /create and clone.", sessionsCreated.Load()},
+		{"dmf_downloads_started_total", "counter", "Archive downloads started.", downloadsStarted.Load()},
+		{"dmf_downloads_completed_total", "counter", "Archive downloads fully written to a connected client.", downloadsCompleted.Load()},
+		{"dmf_downloads_failed_total", "counter", "Archive downloads that failed, were aborted or lost the client.", downloadsFailed.Load()},
+		{"dmf_files_fetched_total", "counter", "Files written into archives from the origin.", filesFetched.Load()},
+		{"dmf_files_failed_total", "counter", "Files that could not be added to an archive.", filesFailed.Load()},
+		{"dmf_bytes_streamed_total", "counter", "Archive bytes sent to clients.", bytesStreamed.Load()},
+		{"dmf_creates_throttled_total", "counter", "Session creates rejected by the per-IP rate limit.", createThrottled.Load()},
+		{"dmf_session_quota_rejected_total", "counter", "Session creates rejected by the per-IP live session cap.", sessionQuotaRejected.Load()},
+		{"dmf_downloads_throttled_total", "counter", "Downloads rejected by the per-token rate limit.", throttledDownloads.Load()},
+		{"dmf_downloads_rejected_busy_total", "counter", "Downloads rejected because the concurrent download cap was reached.", downloadsRejectedBusy.Load()},
+		{"dmf_downloads_rejected_client_total", "counter", "Downloads rejected because the per-client concurrent download cap was reached.", downloadsRejectedClient.Load()},
+		{"dmf_source_cache_hits_total", "counter", "Origin fetches answered 304 and served from the source cache.", sourceCacheHits.Load()},
+		{"dmf_source_cache_stores_total", "counter", "Source files stored in the source cache.", sourceCacheStores.Load()},
+		{"dmf_source_cache_bytes", "gauge", "Bytes held in the source cache.", sourceCacheBytes()},
+		{"dmf_active_sessions", "gauge", "Sessions currently in the store.", int64(active)},
+		{"dmf_downloads_in_flight", "gauge", "Archive downloads currently streaming.", downloadsInFlight.Load()},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.kind, m.name, m.value)
+	}
+	fmt.Fprintf(w, "# HELP dmf_download_duration_seconds Duration of archive downloads.\n# TYPE dmf_download_duration_seconds histogram\n")
+	downloadDuration.write(w, "dmf_download_duration_seconds")
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============== EXPORT / IMPORT ==============
+
+// ExportSchemaVersion là version của định dạng NDJSON của /admin/export, /admin/import
+// từ chối record có version khác
+const ExportSchemaVersion = 1
+
+// exportRecord là một dòng của stream export
+type exportRecord struct {
+	V               int                `json:"v"`
+	Kind            string             `json:"kind"` // "session", "tombstone" hoặc "template"
+	Token           string             `json:"token,omitempty"`
+	Name            string             `json:"name,omitempty"` // Tên template
+	Session         *exportedSession   `json:"session,omitempty"`
+	Tombstone       *exportedTombstone `json:"tombstone,omitempty"`
+	Template        *Template          `json:"template,omitempty"`
+	Secrets         string             `json:"secrets,omitempty"`          // exportSecrets mã hóa AES-GCM bằng migration key
+	SecretsExcluded bool               `json:"secrets_excluded,omitempty"` // Session có secret nhưng export không kèm migration key
+}
+
+// exportedSession bọc Session cùng trạng thái không export được qua field của nó.
+// Webhook che field cùng tên của Session để không bao giờ ghi ra dạng rõ.
+type exportedSession struct {
+	*Session
+	Webhook       *WebhookConfig `json:"Webhook,omitempty"`
+	ResolvedNames []string       `json:"ResolvedNames,omitempty"`
+	ResolvedSizes []int64        `json:"ResolvedSizes,omitempty"`
+	Started       bool           `json:"Started,omitempty"`
+	Finalized     bool           `json:"Finalized,omitempty"`
+	Resume        []resumeRecord `json:"Resume,omitempty"`
+	MaxDownloads  *int           `json:"MaxDownloads"` // nil khi export từ bản chưa có maxDownloads (= 1)
+	Downloads     int            `json:"Downloads,omitempty"`
+	PartDownloads []int          `json:"PartDownloads,omitempty"` // Lượt đã tải của từng part
+	RateLimiter   *limiterState  `json:"RateLimiter,omitempty"`   // Bucket của rateLimit/DownloadRateLimit
+}
+
+type exportedTombstone struct {
+	Reason  string           `json:"reason"`
+	Until   time.Time        `json:"until"`
+	Session *exportedSession `json:"session,omitempty"`
+}
+
+// exportSecrets là phần dữ liệu nhạy cảm của session, chỉ đi qua export khi được mã hóa
+type exportSecrets struct {
+	Webhook     *WebhookConfig `json:"webhook,omitempty"`
+	Headers     http.Header    `json:"headers,omitempty"`
+	FileHeaders []http.Header  `json:"file_headers,omitempty"` // Theo vị trí file, nil nếu không file nào có header riêng
+	Password    string         `json:"password,omitempty"`     // Mật khẩu mã hóa archive
+}
+
+// sessionSecrets gom secret của s, nil nếu không có
+func sessionSecrets(s *Session) *exportSecrets {
+	sec := &exportSecrets{Webhook: s.Webhook, Headers: s.headers, Password: s.Archive.password}
+	if hasFileHeaders(s.Files) {
+		sec.FileHeaders = make([]http.Header, len(s.Files))
+		for i, f := range s.Files {
+			sec.FileHeaders[i] = f.headers
+		}
+	}
+	if sec.Webhook == nil && sec.Headers == nil && sec.FileHeaders == nil && sec.Password == "" {
+		return nil
+	}
+	return sec
+}
+
+// importResult là kết quả của một record trong /admin/import
+type importResult struct {
+	Line   int    `json:"line"`
+	Kind   string `json:"kind,omitempty"`
+	Token  string `json:"token,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"` // "imported", "skipped" hoặc "failed"
+	Error  string `json:"error,omitempty"`
+}
+
+type importResponse struct {
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Failed   int            `json:"failed"`
+	Results  []importResult `json:"results"`
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/")
+	if token, ok := strings.CutPrefix(path, "sessions/"); ok {
+		handleRevoke(w, r, token)
+		return
+	}
+	switch path {
+	case "sessions":
+		handleAdminSessions(w, r)
+	case "cleanup":
+		handleCleanup(w, r)
+	case "export":
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleExport(w, r)
+	case "import":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handleImport(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleExport ghi mọi session chưa hết hạn dạng NDJSON, kèm tombstone/template khi
+// ?include=tombstones,templates. Secret chỉ được export khi có header X-Migration-Key.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	var withTombstones, withTemplates bool
+	for _, inc := range strings.Split(r.URL.Query().Get("include"), ",") {
+		switch strings.TrimSpace(inc) {
+		case "":
+		case "tombstones":
+			withTombstones = true
+		case "templates":
+			withTemplates = true
+		default:
+			http.Error(w, fmt.Sprintf("Unknown include: %s", inc), http.StatusBadRequest)
+			return
+		}
+	}
+	aead, err := migrationCipher(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Snapshot dạng byte khi giữ lock để không ghi ra client chậm trong lúc khóa store
+	var lines [][]byte
+	var sessionCount, tombstoneCount int
+	now := time.Now()
+	mu.RLock()
+	for e := sessionOrder.Front(); e != nil; e = e.Next() {
+		token := e.Value.(string)
+		s := sessions[token]
+		if s.isExpired(now) {
+			continue
+		}
+		rec := exportRecord{V: ExportSchemaVersion, Kind: "session", Token: token, Session: exportSession(s)}
+		if err := sealSecrets(&rec, s, aead); err != nil {
+			mu.RUnlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		line, err := json.Marshal(rec)
+		if err != nil {
+			mu.RUnlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lines = append(lines, line)
+		sessionCount++
+	}
+	if withTombstones {
+		for token, t := range tombstones {
+			if !t.Until.After(now) {
+				continue
+			}
+			rec := exportRecord{V: ExportSchemaVersion, Kind: "tombstone", Token: token, Tombstone: &exportedTombstone{Reason: t.Reason, Until: t.Until}}
+			if t.session != nil {
+				rec.Tombstone.Session = exportSession(t.session)
+				if err := sealSecrets(&rec, t.session, aead); err != nil {
+					mu.RUnlock()
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			line, err := json.Marshal(rec)
+			if err != nil {
+				mu.RUnlock()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			lines = append(lines, line)
+			tombstoneCount++
+		}
+	}
+	mu.RUnlock()
+
+	if withTemplates {
+		syncSharedTemplates()
+		templatesMu.RLock()
+		for name, t := range templates {
+			t := t
+			line, err := json.Marshal(exportRecord{V: ExportSchemaVersion, Kind: "template", Name: name, Template: &t})
+			if err != nil {
+				templatesMu.RUnlock()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			lines = append(lines, line)
+		}
+		templatesMu.RUnlock()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	for _, line := range lines {
+		w.Write(line)
+		w.Write([]byte("\n"))
+	}
+	slog.InfoContext(r.Context(), "Exported sessions", "sessions", sessionCount, "tombstones", tombstoneCount, "secrets", aead != nil)
+}
+
+// exportSession chụp trạng thái session. Phải giữ mu (RLock hoặc Lock)
+func exportSession(s *Session) *exportedSession {
+	es := &exportedSession{
+		Session:   s,
+		Started:   s.started,
+		Finalized: s.finalized,
+		Resume:    s.resume,
+		Downloads: s.completed,
+
+		PartDownloads: s.partDownloads,
+		RateLimiter:   s.limiter.state(),
+	}
+	maxDownloads := s.MaxDownloads
+	es.MaxDownloads = &maxDownloads
+	for i, f := range s.Files {
+		if f.resolvedName != "" || f.resolvedSize != 0 {
+			if es.ResolvedNames == nil {
+				es.ResolvedNames = make([]string, len(s.Files))
+				es.ResolvedSizes = make([]int64, len(s.Files))
+			}
+			es.ResolvedNames[i] = f.resolvedName
+			es.ResolvedSizes[i] = f.resolvedSize
+		}
+	}
+	return es
+}
+
+// sealSecrets mã hóa secret của s vào rec, hoặc đánh dấu bị loại khi không có migration key
+func sealSecrets(rec *exportRecord, s *Session, aead cipher.AEAD) error {
+	sec := sessionSecrets(s)
+	if sec == nil {
+		return nil
+	}
+	if aead == nil {
+		rec.SecretsExcluded = true
+		return nil
+	}
+	plain, err := json.Marshal(sec)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	rec.Secrets = base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(rec.Token)))
+	return nil
+}
+
+func openSecrets(rec *exportRecord, aead cipher.AEAD) (*exportSecrets, error) {
+	if rec.Secrets == "" {
+		return nil, nil
+	}
+	if aead == nil {
+		return nil, errors.New("record has encrypted secrets, X-Migration-Key required")
+	}
+	data, err := base64.StdEncoding.DecodeString(rec.Secrets)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed secrets")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(rec.Token))
+	if err != nil {
+		return nil, errors.New("cannot decrypt secrets (wrong X-Migration-Key?)")
+	}
+	var sec exportSecrets
+	if err := json.Unmarshal(plain, &sec); err != nil {
+		return nil, errors.New("malformed secrets")
+	}
+	return &sec, nil
+}
+
+// migrationCipher trả AEAD từ header X-Migration-Key, nil nếu không có header
+func migrationCipher(r *http.Request) (cipher.AEAD, error) {
+	key := r.Header.Get("X-Migration-Key")
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) < 16 {
+		return nil, errors.New("X-Migration-Key must be at least 16 characters")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// handleImport nạp stream của /admin/export. Token/template đã tồn tại được bỏ qua,
+// mỗi dòng có một kết quả riêng.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	aead, err := migrationCipher(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp importResponse
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(make([]byte, 0, 64*1024), MaxCreateBodyBytes)
+	for line := 1; sc.Scan(); line++ {
+		if len(strings.TrimSpace(sc.Text())) == 0 {
+			continue
+		}
+		res := importLine(sc.Bytes(), aead)
+		res.Line = line
+		switch res.Status {
+		case "imported":
+			resp.Imported++
+		case "skipped":
+			resp.Skipped++
+		default:
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	if err := sc.Err(); err != nil {
+		resp.Failed++
+		resp.Results = append(resp.Results, importResult{Status: "failed", Error: fmt.Sprintf("read failed: %v", err)})
+	}
+
+	slog.InfoContext(r.Context(), "Imported sessions", "imported", resp.Imported, "skipped", resp.Skipped, "failed", resp.Failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func importLine(data []byte, aead cipher.AEAD) importResult {
+	var rec exportRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return importResult{Status: "failed", Error: fmt.Sprintf("invalid JSON: %v", err)}
+	}
+	res := importResult{Kind: rec.Kind, Token: rec.Token, Name: rec.Name}
+	fail := func(format string, args ...any) importResult {
+		res.Status = "failed"
+		res.Error = fmt.Sprintf(format, args...)
+		return res
+	}
+	if rec.V != ExportSchemaVersion {
+		return fail("unsupported schema version %d (expected %d)", rec.V, ExportSchemaVersion)
+	}
+
+	switch rec.Kind {
+	case "template":
+		if rec.Template == nil || !templateNamePattern.MatchString(rec.Name) {
+			return fail("invalid template record")
+		}
+		syncSharedTemplates()
+		templatesMu.Lock()
+		defer templatesMu.Unlock()
+		if _, ok := templates[rec.Name]; ok {
+			res.Status = "skipped"
+			res.Error = "template already exists"
+			return res
+		}
+		if err := setTemplateLocked(rec.Name, rec.Template); err != nil {
+			return fail("%v", err)
+		}
+		res.Status = "imported"
+		return res
+	case "session", "tombstone":
+	default:
+		return fail("unknown kind %q", rec.Kind)
+	}
+
+	if _, err := uuid.Parse(rec.Token); err != nil {
+		return fail("invalid token")
+	}
+	if tokenInUse(rec.Token) {
+		res.Status = "skipped"
+		res.Error = "token already exists"
+		return res
+	}
+	secrets, err := openSecrets(&rec, aead)
+	if err != nil {
+		return fail("%v", err)
+	}
+
+	var session *Session
+	es := rec.Session
+	if rec.Kind == "tombstone" {
+		if rec.Tombstone == nil {
+			return fail("missing tombstone")
+		}
+		es = rec.Tombstone.Session
+	}
+	if es != nil {
+		if session, err = importSession(es, secrets); err != nil {
+			return fail("%v", err)
+		}
+	} else if rec.Kind == "session" {
+		return fail("missing session")
+	}
+
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	if tokenInUseLocked(rec.Token) {
+		res.Status = "skipped"
+		res.Error = "token already exists"
+		return res
+	}
+
+	if rec.Kind == "tombstone" {
+		if !rec.Tombstone.Until.After(now) {
+			res.Status = "skipped"
+			res.Error = "tombstone expired"
+			return res
+		}
+		tombstones[rec.Token] = tombstone{Reason: rec.Tombstone.Reason, Until: rec.Tombstone.Until, session: session}
+		res.Status = "imported"
+		return res
+	}
+
+	if session.isExpired(now) {
+		res.Status = "skipped"
+		res.Error = "session expired"
+		return res
+	}
+	if err := addSessionLocked(rec.Token, session); err != nil {
+		return fail("%v", err)
+	}
+	if err := persistNewSessionLocked(session); err != nil {
+		return fail("%v", err)
+	}
+	res.Status = "imported"
+	if rec.SecretsExcluded {
+		res.Error = "secrets were excluded at export; webhook and forwarded headers dropped"
+	}
+	return res
+}
+
+func tokenInUse(token string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return tokenInUseLocked(token)
+}
+
+// tokenInUseLocked báo token đang là session hoặc tombstone. Phải giữ mu
+func tokenInUseLocked(token string) bool {
+	_, live := sessions[token]
+	_, gone := tombstones[token]
+	return live || gone
+}
+
+// importSession dựng lại Session từ record đã kiểm tra sơ bộ
+func importSession(es *exportedSession, secrets *exportSecrets) (*Session, error) {
+	s := es.Session
+	if s == nil || len(s.Files) == 0 && !s.Open {
+		return nil, errors.New("session has no files")
+	}
+	if len(s.Files) > MaxFilesPerSession {
+		return nil, fmt.Errorf("too many files (max %d per session)", MaxFilesPerSession)
+	}
+	if es.ResolvedNames != nil && (len(es.ResolvedNames) != len(s.Files) || len(es.ResolvedSizes) != len(s.Files)) {
+		return nil, errors.New("resolved names do not match files")
+	}
+	if es.Resume != nil && len(es.Resume) != len(s.Files) {
+		return nil, errors.New("resume records do not match files")
+	}
+	if s.Resumable && s.ResumableMode == "" && es.ResolvedNames == nil {
+		return nil, errors.New("resumable session without resolved names")
+	}
+
+	for i := range s.Files {
+		if es.ResolvedNames != nil {
+			s.Files[i].resolvedName = es.ResolvedNames[i]
+			s.Files[i].resolvedSize = es.ResolvedSizes[i]
+		}
+	}
+	s.Webhook = nil
+	if secrets != nil {
+		if secrets.FileHeaders != nil && len(secrets.FileHeaders) != len(s.Files) {
+			return nil, errors.New("file headers do not match files")
+		}
+		if err := validateForwardHeaders(secrets.Headers); err != nil {
+			return nil, fmt.Errorf("invalid headers: %v", err)
+		}
+		for i, h := range secrets.FileHeaders {
+			if err := validateForwardHeaders(h); err != nil {
+				return nil, fmt.Errorf("file %d has invalid headers: %v", i+1, err)
+			}
+			s.Files[i].headers = h
+		}
+		s.Webhook = secrets.Webhook
+		s.headers = secrets.Headers
+		s.Archive.password = secrets.Password
+	}
+	if s.Archive.Encrypted && s.Archive.password == "" {
+		// Không có mật khẩu thì archive sẽ không được mã hóa như người tạo yêu cầu
+		return nil, errors.New("encrypted session without its password (secrets excluded at export?)")
+	}
+	s.started = es.Started
+	s.finalized = es.Finalized
+	s.resume = es.Resume
+	s.MaxDownloads = 1
+	if es.MaxDownloads != nil {
+		if *es.MaxDownloads < 0 {
+			return nil, errors.New("negative MaxDownloads")
+		}
+		s.MaxDownloads = *es.MaxDownloads
+	}
+	s.completed = es.Downloads
+	s.partDownloads = es.PartDownloads
+	s.limiter = es.RateLimiter.limiter()
+	s.heapIndex = -1
+	return s, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest gửi request /admin với Bearer AdminKey và header phụ (cặp tên, giá trị)
+func adminRequest(t *testing.T, method, url string, body io.Reader, header ...string) []byte {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+AdminKey)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s = %d: %s", method, url, resp.StatusCode, data)
+	}
+	return data
+}
+
+func download(t *testing.T, link string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// TestExportImportRoundTrip: session export kèm migration key rồi import lại sau khi store quên nó tải ra
+// archive giống hệt từng byte, giữ cả header bí mật tới origin và số lượt đã tải
+func TestExportImportRoundTrip(t *testing.T) {
+	const secret = "Bearer origin-secret"
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != secret {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Last-Modified", modified)
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	adminKey := AdminKey
+	AdminKey = "test-admin-key"
+	t.Cleanup(func() { AdminKey = adminKey })
+
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"},{"url":"`+origin.URL+`/b.txt"}],`+
+		`"headers":{"Authorization":"`+secret+`"},"maxDownloads":2}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+	status, before := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download before export = %d: %s", status, before)
+	}
+
+	const migrationKey = "0123456789abcdef-migration"
+	plain := adminRequest(t, http.MethodGet, base+"/admin/export", nil)
+	if bytes.Contains(plain, []byte("origin-secret")) {
+		t.Fatal("export without a migration key contains the origin secret")
+	}
+	if !bytes.Contains(plain, []byte(`"secrets_excluded":true`)) {
+		t.Fatalf("export without a migration key does not flag excluded secrets: %s", plain)
+	}
+	exported := adminRequest(t, http.MethodGet, base+"/admin/export", nil, "X-Migration-Key", migrationKey)
+	if bytes.Contains(exported, []byte("origin-secret")) {
+		t.Fatal("encrypted export contains the origin secret in clear")
+	}
+
+	// Instance mới: store không còn session
+	mu.Lock()
+	forgetSessionLocked(token)
+	mu.Unlock()
+	if status, _ := download(t, link); status != http.StatusNotFound {
+		t.Fatalf("download after forgetting the session = %d, want 404", status)
+	}
+
+	var imported importResponse
+	data := adminRequest(t, http.MethodPost, base+"/admin/import", bytes.NewReader(exported), "X-Migration-Key", migrationKey)
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatus(imported, token); got != "imported" || imported.Failed != 0 {
+		t.Fatalf("import = %s", data)
+	}
+
+	status, after := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download after import = %d: %s", status, after)
+	}
+	if !bytes.Equal(after, before) {
+		t.Fatalf("archive after import differs: %d bytes, before %d", len(after), len(before))
+	}
+	if status, _ := download(t, link); status != http.StatusGone {
+		t.Fatalf("third download = %d, want 410 (maxDownloads carried over)", status)
+	}
+
+	// Import lại: token đã tồn tại được bỏ qua
+	data = adminRequest(t, http.MethodPost, base+"/admin/import", bytes.NewReader(exported), "X-Migration-Key", migrationKey)
+	if err := json.Unmarshal(data, &imported); err != nil {
+		t.Fatal(err)
+	}
+	if got := importStatus(imported, token); got != "skipped" {
+		t.Fatalf("re-import = %s, want the token skipped", data)
+	}
+}
+
+// importStatus là kết quả import của token; store có thể còn session của test khác
+func importStatus(resp importResponse, token string) string {
+	for _, res := range resp.Results {
+		if res.Token == token {
+			return res.Status
+		}
+	}
+	return ""
+}
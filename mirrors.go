@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============== MIRROR PROBING ==============
+
+// probeCache lưu TTFB theo host trong phạm vi một archive, giá trị âm = probe lỗi
+type probeCache map[string]time.Duration
+
+// rankMirrors probe song song các host chưa có trong cache và sắp xếp URL theo TTFB tăng dần.
+// Mirror probe lỗi bị đẩy xuống cuối nhưng vẫn được giữ để fallback.
+func rankMirrors(ctx context.Context, urls []string, cache probeCache) []string {
+	var (
+		wg      sync.WaitGroup
+		resMu   sync.Mutex
+		results = make(map[string]time.Duration)
+	)
+
+	for _, u := range urls {
+		host := mirrorHost(u)
+		if _, ok := cache[host]; ok {
+			continue
+		}
+		if _, pending := results[host]; pending {
+			continue
+		}
+		results[host] = -1
+
+		wg.Add(1)
+		go func(u, host string) {
+			defer wg.Done()
+			d := probeMirror(ctx, u)
+			resMu.Lock()
+			results[host] = d
+			resMu.Unlock()
+		}(u, host)
+	}
+	wg.Wait()
+
+	for host, d := range results {
+		cache[host] = d
+	}
+
+	ranked := append([]string(nil), urls...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return probeScore(cache[mirrorHost(ranked[i])]) < probeScore(cache[mirrorHost(ranked[j])])
+	})
+	return ranked
+}
+
+func probeScore(d time.Duration) time.Duration {
+	if d < 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return d
+}
+
+// probeMirror đo thời gian tới khi nhận được response header của một HEAD request
+func probeMirror(ctx context.Context, u string) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, MirrorProbeTimeout)
+	defer cancel()
+
+	req, err := newUpstreamRequest(ctx, http.MethodHead, u)
+	if err != nil {
+		return -1
+	}
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return -1
+	}
+	return time.Since(start)
+}
+
+func mirrorHost(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return parsed.Host
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ============== ASCII NAMES ==============
+
+// Bảng gộp ký tự có dấu (Latin-1, Latin Extended, tiếng Việt) về chữ cái gốc
+var diacriticGroups = map[string]string{
+	"A": "ÀÁÂÃÄÅĀĂĄǍǞǠǺȀȂȦḀẠẢẤẦẨẪẬẮẰẲẴẶ",
+	"B": "ḂḄḆ",
+	"C": "ÇĆĈĊČḈ",
+	"D": "ĎḊḌḎḐḒ",
+	"E": "ÈÉÊËĒĔĖĘĚȄȆȨḔḖḘḚḜẸẺẼẾỀỂỄỆ",
+	"F": "Ḟ",
+	"G": "ĜĞĠĢǦǴḠ",
+	"H": "ĤȞḢḤḦḨḪ",
+	"I": "ÌÍÎÏĨĪĬĮİǏȈȊḬḮỈỊ",
+	"J": "Ĵ",
+	"K": "ĶǨḰḲḴ",
+	"L": "ĹĻĽḶḸḺḼ",
+	"M": "ḾṀṂ",
+	"N": "ÑŃŅŇǸṄṆṈṊ",
+	"O": "ÒÓÔÕÖŌŎŐƠǑǪǬȌȎȪȬȮȰṌṎṐṒỌỎỐỒỔỖỘỚỜỞỠỢ",
+	"P": "ṔṖ",
+	"R": "ŔŖŘȐȒṘṚṜṞ",
+	"S": "ŚŜŞŠȘṠṢṤṦṨ",
+	"T": "ŢŤȚṪṬṮṰ",
+	"U": "ÙÚÛÜŨŪŬŮŰŲƯǓǕǗǙǛȔȖṲṴṶṸṺỤỦỨỪỬỮỰ",
+	"V": "ṼṾ",
+	"W": "ŴẀẂẄẆẈ",
+	"X": "ẊẌ",
+	"Y": "ÝŶŸȲẎỲỴỶỸ",
+	"Z": "ŹŻŽẐẒẔ",
+	"a": "àáâãäåāăąǎǟǡǻȁȃȧḁạảấầẩẫậắằẳẵặ",
+	"b": "ḃḅḇ",
+	"c": "çćĉċčḉ",
+	"d": "ďḋḍḏḑḓ",
+	"e": "èéêëēĕėęěȅȇȩḕḗḙḛḝẹẻẽếềểễệ",
+	"f": "ḟ",
+	"g": "ĝğġģǧǵḡ",
+	"h": "ĥȟḣḥḧḩḫẖ",
+	"i": "ìíîïĩīĭįǐȉȋḭḯỉị",
+	"j": "ĵǰ",
+	"k": "ķǩḱḳḵ",
+	"l": "ĺļľḷḹḻḽ",
+	"m": "ḿṁṃ",
+	"n": "ñńņňǹṅṇṉṋ",
+	"o": "òóôõöōŏőơǒǫǭȍȏȫȭȯȱṍṏṑṓọỏốồổỗộớờởỡợ",
+	"p": "ṕṗ",
+	"r": "ŕŗřȑȓṙṛṝṟ",
+	"s": "śŝşšșṡṣṥṧṩ",
+	"t": "ţťțṫṭṯṱẗ",
+	"u": "ùúûüũūŭůűųưǔǖǘǚǜȕȗṳṵṷṹṻụủứừửữự",
+	"v": "ṽṿ",
+	"w": "ŵẁẃẅẇẉẘ",
+	"x": "ẋẍ",
+	"y": "ýÿŷȳẏẙỳỵỷỹ",
+	"z": "źżžẑẓẕ",
+}
+
+// Các ký tự không phải "chữ gốc + dấu" hoặc cần chuyển tự theo quy ước riêng (tiếng Đức)
+var specialTransliterations = map[rune]string{
+	'Đ': "D", 'đ': "d", 'Ð': "D", 'ð': "d",
+	'Ä': "Ae", 'Ö': "Oe", 'Ü': "Ue", 'ä': "ae", 'ö': "oe", 'ü': "ue", 'ß': "ss", 'ẞ': "SS",
+	'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Ø': "O", 'ø': "o",
+	'Ł': "L", 'ł': "l", 'Þ': "Th", 'þ': "th", 'ı': "i",
+}
+
+var asciiFold = buildASCIIFold()
+
+func buildASCIIFold() map[rune]string {
+	fold := make(map[rune]string)
+	for base, chars := range diacriticGroups {
+		for _, r := range chars {
+			fold[r] = base
+		}
+	}
+	for r, s := range specialTransliterations {
+		fold[r] = s
+	}
+	return fold
+}
+
+// toASCIIName chuyển tên file sang ASCII: bỏ dấu theo bảng, dấu kết hợp (NFD) bị bỏ,
+// ký tự điều khiển thành "_", các ký tự khác (ví dụ CJK) thành escape dạng "u6587"
+func toASCIIName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// Dấu kết hợp của chuỗi đã phân rã, bỏ đi
+		case asciiFold[r] != "":
+			b.WriteString(asciiFold[r])
+		case unicode.IsPrint(r):
+			fmt.Fprintf(&b, "u%04x", r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
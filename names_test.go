@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestToASCIIName(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"Báo cáo tài chính Đà Nẵng.pdf", "Bao cao tai chinh Da Nang.pdf"},
+		{"Hướng dẫn sử dụng ỨNG DỤNG.docx", "Huong dan su dung UNG DUNG.docx"},
+		{"Größe Übersicht ärger.txt", "Groesse Uebersicht aerger.txt"},
+		{"Crème brûlée façade.txt", "Creme brulee facade.txt"},
+		{"Łódź Smørrebrød.csv", "Lodz Smorrebrod.csv"},
+		{"Cafe\u0301.txt", "Cafe.txt"}, // NFD: dấu kết hợp bị bỏ
+		{"文件.txt", "u6587u4ef6.txt"},
+		{"報告 2024.xlsx", "u5831u544a 2024.xlsx"},
+		{"a\u0085b.txt", "a_b.txt"}, // Ký tự điều khiển không in được
+		{"plain-ascii_1.txt", "plain-ascii_1.txt"},
+	} {
+		if got := toASCIIName(tc.in); got != tc.want {
+			t.Errorf("toASCIIName(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// Tên chuyển ASCII trước khi xử lý trùng: các tên gộp về cùng chuỗi ASCII được thêm hậu tố, cả khi
+// đặt tên lúc download lẫn lúc tạo (resolveNames); nameConflict "error" từ chối chúng
+func TestASCIINameCollisions(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	files := `"files":["` + origin.URL + `/T%C3%A0i%20li%E1%BB%87u.txt","` + origin.URL + `/Tai%20lieu.txt","` +
+		origin.URL + `/T%E1%BA%A0I%20LI%E1%BB%86U.txt","` + origin.URL + `/%E6%96%87%E4%BB%B6.txt"],"asciiNames":true`
+
+	for _, extra := range []string{"", `,"resolveNames":true`} {
+		_, data := download(t, createSession(t, base, `{`+files+extra+`}`))
+		entries := unzip(t, data)
+		for name, want := range map[string]string{
+			"Tai lieu.txt":   "content of /Tài liệu.txt",
+			"Tai lieu_2.txt": "content of /Tai lieu.txt",
+			"TAI LIEU.txt":   "content of /TẠI LIỆU.txt",
+			"u6587u4ef6.txt": "content of /文件.txt",
+		} {
+			if entries[name] != want {
+				t.Errorf("%s: entry %s = %q, want %q (entries %v)", extra, name, entries[name], want, entries)
+			}
+		}
+		if len(entries) != 4 {
+			t.Errorf("%s: entries = %v, want 4", extra, entries)
+		}
+	}
+
+	if _, err := postCreate(base, `{`+files+`,"resolveNames":true,"nameConflict":"error"}`); err == nil || !strings.Contains(err.Error(), "create = 400") {
+		t.Fatalf("create with names colliding after ASCII folding and nameConflict error: %v, want 400", err)
+	}
+}
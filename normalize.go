@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============== URL NORMALIZATION ==============
+
+// normalizeFiles chuẩn hóa URL và mirror của các entry tại chỗ lúc create/append, để fetch
+// và mọi kiểm tra về host đều chạy trên dạng chuẩn. offset dùng để đánh số như validateFiles
+func normalizeFiles(files []FileEntry, offset int) ([]Warning, error) {
+	var warnings []Warning
+	for i := range files {
+		n := offset + i + 1
+		normalized, material, err := normalizeURL(files[i].URL)
+		if err != nil {
+			return nil, fmt.Errorf("File %d has invalid url: %v", n, err)
+		}
+		if material {
+			index := offset + i
+			warnings = append(warnings, Warning{
+				Code:    "url_normalized",
+				Message: fmt.Sprintf("URL will be fetched as %s", normalized),
+				Index:   &index,
+			})
+		}
+		files[i].URL = normalized
+
+		for j, mirror := range files[i].Mirrors {
+			normalized, _, err := normalizeURL(mirror)
+			if err != nil {
+				return nil, fmt.Errorf("File %d has invalid mirror %d: %v", n, j+1, err)
+			}
+			files[i].Mirrors[j] = normalized
+		}
+	}
+	return warnings, nil
+}
+
+// normalizeURL viết thường scheme/host, mã hóa punycode host quốc tế, bỏ port mặc định,
+// xử lý dot segment và chuẩn hóa percent-encoding của path. material = khác input
+// nhiều hơn chữ hoa/thường và port mặc định
+func normalizeURL(raw string) (string, bool, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false, err
+	}
+	if isStorageURL(u.String()) && u.Opaque == "" {
+		normalized, err := normalizeStorageURL(u)
+		return normalized, false, err
+	}
+	if u.Host == "" || u.Opaque != "" {
+		return "", false, fmt.Errorf("not an absolute URL")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	hostname, port := u.Hostname(), u.Port()
+	host, err := asciiHost(hostname)
+	if err != nil {
+		return "", false, err
+	}
+	material := host != strings.ToLower(hostname)
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") || (u.Scheme == "ftp" && port == "21") {
+		port = ""
+	}
+	switch {
+	case port != "":
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	escaped := removeDotSegments(normalizeEscapes(u.EscapedPath()))
+	if escaped != u.EscapedPath() {
+		material = true
+	}
+	path, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", false, err
+	}
+	u.Path, u.RawPath = path, escaped
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String(), material, nil
+}
+
+// asciiHost chuyển host sang dạng ASCII như ToASCII của profile Lookup (UTS #46): ánh xạ dấu chấm
+// và ký tự ASCII full-width, viết thường, kiểm tra label rồi đổi label không phải ASCII sang dạng
+// xn-- (RFC 3492). golang.org/x/net/idna cần golang.org/x/text (bảng NFC, bidi) nên không dùng:
+// label có dấu kết hợp rời (chưa NFC) bị từ chối thay vì mã hóa ra A-label sai
+func asciiHost(host string) (string, error) {
+	host = strings.ToLower(strings.Map(mapLookupRune, host))
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", fmt.Errorf("empty label in host %q", host)
+		}
+		if !isASCII(label) {
+			if err := checkUnicodeLabel(label); err != nil {
+				return "", fmt.Errorf("invalid host %q: %v", host, err)
+			}
+			encoded, err := punycode(label)
+			if err != nil {
+				return "", fmt.Errorf("invalid host %q: %v", host, err)
+			}
+			labels[i] = "xn--" + encoded
+		}
+		if len(labels[i]) > 63 {
+			return "", fmt.Errorf("invalid host %q: label longer than 63 bytes", host)
+		}
+	}
+	ascii := strings.Join(labels, ".")
+	if len(ascii) > 253 {
+		return "", fmt.Errorf("invalid host %q: longer than 253 bytes", host)
+	}
+	return ascii, nil
+}
+
+// mapLookupRune là phần ánh xạ UTS #46 cho host hay gặp khi gõ bằng bộ gõ CJK: dấu chấm
+// ideographic/full-width thành ".", ký tự ASCII full-width thành ASCII; soft hyphen bị bỏ
+func mapLookupRune(r rune) rune {
+	switch {
+	case r == '\u3002' || r == '\uff0e' || r == '\uff61':
+		return '.'
+	case r >= '\uff01' && r <= '\uff5e':
+		return r - 0xfee0
+	case r == '\u00ad':
+		return -1
+	}
+	return r
+}
+
+// checkUnicodeLabel kiểm tra label (đã viết thường) trước khi mã hóa: không bắt đầu bằng dấu kết
+// hợp, không có dấu kết hợp rời của chữ Latin/Hy Lạp/Kirin (U+0300–U+036F, dạng chưa NFC), không
+// có dấu gạch ở đầu/cuối hay ở vị trí 3-4
+func checkUnicodeLabel(label string) error {
+	if !utf8.ValidString(label) {
+		return fmt.Errorf("invalid UTF-8")
+	}
+	if first, _ := utf8.DecodeRuneInString(label); unicode.Is(unicode.M, first) {
+		return fmt.Errorf("label %q starts with a combining mark", label)
+	}
+	for _, r := range label {
+		if r >= '\u0300' && r <= '\u036f' {
+			return fmt.Errorf("label %q is not in NFC form", label)
+		}
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") || (len(label) >= 4 && label[2:4] == "--") {
+		return fmt.Errorf("label %q has a misplaced hyphen", label)
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// punycode mã hóa một label theo RFC 3492
+func punycode(label string) (string, error) {
+	const (
+		base        = 36
+		tmin        = 1
+		tmax        = 26
+		skew        = 38
+		damp        = 700
+		initialBias = 72
+		initialN    = 128
+	)
+	adapt := func(delta, numPoints int, first bool) int {
+		if first {
+			delta /= damp
+		} else {
+			delta /= 2
+		}
+		delta += delta / numPoints
+		k := 0
+		for delta > ((base-tmin)*tmax)/2 {
+			delta /= base - tmin
+			k += base
+		}
+		return k + (base-tmin+1)*delta/(delta+skew)
+	}
+	digit := func(d int) byte {
+		if d < 26 {
+			return byte('a' + d)
+		}
+		return byte('0' + d - 26)
+	}
+
+	if !utf8.ValidString(label) {
+		return "", fmt.Errorf("invalid UTF-8")
+	}
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := initialN, 0, initialBias
+	for handled < len(runes) {
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := k - bias
+				if t < tmin {
+					t = tmin
+				} else if t > tmax {
+					t = tmax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, digit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			out = append(out, digit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+// normalizeEscapes giải mã các ký tự unreserved bị percent-encode, viết hoa hex của phần còn lại
+// và encode các byte không hợp lệ trong path; %2F và các delimiter khác được giữ nguyên
+func normalizeEscapes(p string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '%' && i+2 < len(p) && isHex(p[i+1]) && isHex(p[i+2]) {
+			v := unhex(p[i+1])<<4 | unhex(p[i+2])
+			if isUnreserved(v) {
+				b.WriteByte(v)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(hex[v>>4])
+				b.WriteByte(hex[v&15])
+			}
+			i += 2
+			continue
+		}
+		if c >= utf8.RuneSelf || c <= ' ' || c == '%' || strings.IndexByte(`"<>\^`+"`{|}", c) >= 0 {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// removeDotSegments xử lý "." và ".." theo RFC 3986 mục 5.2.4, giữ nguyên "//" và dấu "/" cuối
+func removeDotSegments(p string) string {
+	if !strings.Contains(p, ".") {
+		return p
+	}
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	return strings.Join(out, "/")
+}
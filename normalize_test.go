@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		material bool
+	}{
+		// Host quốc tế
+		{"https://münchen.de/x", "https://xn--mnchen-3ya.de/x", true},
+		{"https://MÜNCHEN.de/", "https://xn--mnchen-3ya.de/", true},
+		{"http://例え.テスト/", "http://xn--r8jz45g.xn--zckzah/", true},
+		{"https://tiếngviệt.vn/a", "https://xn--tingvit-5t4cyc.vn/a", true},
+		{"https://हिन्दी.example/", "https://xn--j2bd4cyah0f.example/", true},
+		{"https://bücher。example．com/", "https://xn--bcher-kva.example.com/", true},
+		{"https://ＥＸＡＭＰＬＥ.com/", "https://example.com/", true},
+		{"https://xn--mnchen-3ya.de/", "https://xn--mnchen-3ya.de/", false},
+		{"https://Example.COM./a", "https://example.com/a", true},
+		// Port mặc định
+		{"HTTP://Example.COM:80/a", "http://example.com/a", false},
+		{"https://example.com:443/", "https://example.com/", false},
+		{"ftp://example.com:21/f", "ftp://example.com/f", false},
+		{"https://example.com:8443/", "https://example.com:8443/", false},
+		{"http://example.com:443/", "http://example.com:443/", false},
+		{"http://[::1]:80/a", "http://[::1]/a", false},
+		{"https://münchen.de:443/", "https://xn--mnchen-3ya.de/", true},
+		// Dot segment
+		{"https://example.com/a/./b/../c", "https://example.com/a/c", true},
+		{"https://example.com/a/b/..", "https://example.com/a/", true},
+		{"https://example.com/../../a", "https://example.com/a", true},
+		{"https://example.com/a//b/./", "https://example.com/a//b/", true},
+		{"https://example.com/v1.2/file.tar.gz", "https://example.com/v1.2/file.tar.gz", false},
+		// Percent-encoding
+		{"https://example.com/%7euser/%41", "https://example.com/~user/A", true},
+		{"https://example.com/a%2fb/%2Fc", "https://example.com/a%2Fb/%2Fc", true},
+		{"https://example.com/%e2%82%ac", "https://example.com/%E2%82%AC", true},
+		{"https://example.com/ü", "https://example.com/%C3%BC", false},
+		{"https://example.com/a b", "https://example.com/a%20b", false},
+		{"https://example.com/a?q=%7e#frag", "https://example.com/a?q=%7e", false},
+	} {
+		got, material, err := normalizeURL(tc.in)
+		if err != nil || got != tc.want || material != tc.material {
+			t.Errorf("normalizeURL(%q) = %q, %v, %v; want %q, %v", tc.in, got, material, err, tc.want, tc.material)
+		}
+	}
+}
+
+func TestNormalizeURLInvalidHost(t *testing.T) {
+	for _, in := range []string{
+		"https://mu\u0308nchen.de/",                         // Chưa NFC
+		"https://\u0301abc.example/",                        // Bắt đầu bằng dấu kết hợp
+		"https://-bücher.example/",                          // Gạch đầu label
+		"https://bücher-.example/",                          // Gạch cuối label
+		"https://ab--ü.example/",                            // Gạch ở vị trí 3-4
+		"https://a..b/",                                     // Label rỗng
+		"https://" + strings.Repeat("ü", 60) + ".example/",  // A-label dài hơn 63 byte
+		"https://" + strings.Repeat("a.", 127) + "example/", // Host dài hơn 253 byte
+	} {
+		if got, _, err := normalizeURL(in); err == nil {
+			t.Errorf("normalizeURL(%q) = %q, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============== ONE-SHOT ZIP ==============
+
+// POST /zip nhận body như /create nhưng stream zip ngay trong response, không tạo session trong
+// store (không persist, không có token dùng lại). Session được validate như /create rồi dựng
+// bằng buildArchive như /download, cùng giới hạn download đồng thời và băng thông.
+
+func handleZip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	created := newSessionFromRequest(w, r, createOneShot)
+	if created == nil {
+		return // newSessionFromRequest đã trả lỗi
+	}
+	token, session := created.token, created.session
+
+	// Các tùy chọn cần session sống qua nhiều request không có nghĩa với /zip
+	switch {
+	case session.Open:
+		http.Error(w, "open is not supported by /zip", http.StatusBadRequest)
+		return
+	case session.Resumable:
+		http.Error(w, "resumable is not supported by /zip", http.StatusBadRequest)
+		return
+	case !session.NotBefore.IsZero():
+		http.Error(w, "notBefore is not supported by /zip", http.StatusBadRequest)
+		return
+	case session.PartMaxBytes > 0:
+		http.Error(w, "partMaxBytes is not supported by /zip", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := acquireDownloadSlot()
+	if !ok {
+		writeServerBusy(w, r)
+		return
+	}
+	defer release()
+	mu.Lock()
+	client := downloadClient(session, r)
+	releaseClient, ok := acquireClientSlotLocked(client)
+	opts := session.buildOptionsLocked(token)
+	mu.Unlock()
+	if !ok {
+		slog.WarnContext(r.Context(), "Rejected one-shot zip: client at concurrency cap", "client", client)
+		writeClientBusy(w, r)
+		return
+	}
+	defer releaseClient()
+
+	files := session.Files
+	slog.InfoContext(r.Context(), "One-shot zip", "token", token, "files", len(files), "zip_name", session.ZipName)
+	ctx, cancel := context.WithTimeout(withForwardHeaders(r.Context(), session.headers), opts.deadlines.Total)
+	defer cancel()
+
+	spool, err := reserveSpool(token, spoolEstimate(files))
+	if err != nil {
+		slog.WarnContext(r.Context(), "Rejected one-shot zip", "token", token, "error", err)
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return
+	}
+	defer spool.close()
+	opts.client, opts.spool, opts.startedAt = r.Context(), spool, time.Now()
+	opts.progress = newDownloadProgress(len(files))
+	opts.progress.setTotalBytes(knownTotalBytes(files))
+
+	disposition := session.Disposition
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", session.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, session.ZipName))
+
+	out := &sentCounter{w: throttleResponse(ctx, w)}
+	outcome := "failed"
+	downloadStarted()
+	reporter := startWebhookReporter(token, session.ZipName, session.Webhook, opts.progress)
+	defer func() {
+		reporter.finish(outcome, r.Context().Err() != nil)
+		downloadFinished(outcome == "completed" && r.Context().Err() == nil, time.Since(opts.startedAt), out.n)
+	}()
+
+	err = buildArchive(ctx, out, files, opts)
+	outcome, _ = buildOutcome(err)
+	endStream(w, err)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postZip gọi /zip với body JSON, trả response cùng body (lỗi đọc nếu kết nối bị cắt)
+func postZip(t *testing.T, base, body string) (*http.Response, []byte, error) {
+	t.Helper()
+	resp, err := http.Post(base+"/zip", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return resp, data, err
+}
+
+// /zip stream archive ngay trong response và không để lại session nào trong store; file lỗi được
+// liệt kê trong ERRORS.txt như /download, còn onError abort cắt kết nối giữa chừng
+func TestOneShotZip(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	mu.RLock()
+	live := len(sessions)
+	mu.RUnlock()
+
+	resp, data, err := postZip(t, base, `{"files":["`+origin.URL+`/a.txt","`+origin.URL+`/b.txt"],"zipName":"docs.zip"}`)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("zip = %d %v: %s", resp.StatusCode, err, data)
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename="docs.zip"` {
+		t.Fatalf("Content-Disposition = %q", got)
+	}
+	entries := unzip(t, data)
+	if len(entries) != 2 || entries["a.txt"] != "content of /a.txt" || entries["b.txt"] != "content of /b.txt" {
+		t.Fatalf("entries = %v, want a.txt and b.txt", entries)
+	}
+
+	resp, data, err = postZip(t, base, `{"files":["`+origin.URL+`/a.txt","`+origin.URL+`/missing.txt"]}`)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("zip with a failing URL = %d %v", resp.StatusCode, err)
+	}
+	entries = unzip(t, data)
+	if entries["a.txt"] != "content of /a.txt" {
+		t.Fatalf("entries = %v, want a.txt", entries)
+	}
+	if report := entries["ERRORS.txt"]; !strings.Contains(report, origin.URL+"/missing.txt") || !strings.Contains(report, "bad status 404") {
+		t.Fatalf("ERRORS.txt = %q, want the 404 of missing.txt", report)
+	}
+
+	if _, _, err := postZip(t, base, `{"files":["`+origin.URL+`/missing.txt","`+origin.URL+`/a.txt"],"onError":"abort"}`); err == nil {
+		t.Fatal("zip aborted on a failing URL read to the end, want the connection cut")
+	}
+	if resp, data, _ := postZip(t, base, `{"files":["`+origin.URL+`/a.txt"],"open":true}`); resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(data), "not supported by /zip") {
+		t.Fatalf("zip with open = %d %s, want 400", resp.StatusCode, data)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(sessions) > live {
+		t.Fatalf("sessions = %d after /zip, want at most %d", len(sessions), live)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== MULTI-PART ARCHIVES ==============
+
+// Khi tổng dung lượng các file vượt partMaxBytes (hoặc PartMaxBytes của server), session được chia
+// thành nhiều archive nhỏ theo thứ tự file: files-part1.zip, files-part2.zip... tải riêng qua
+// /download/{token}/part/{n}, để client có kết nối kém không phải tải một archive 20 GB. Dung lượng
+// lấy từ bước resolve lúc tạo (như resolveNames); một file lớn hơn ngưỡng nằm riêng một part.
+// Mỗi part có maxDownloads lượt riêng, session bị tiêu thụ khi mọi part đã hết lượt. Link chính vẫn
+// tải cả archive như thường.
+
+// PartMaxBytes là ngưỡng chia mặc định (--part-max-bytes), 0 = không chia
+var PartMaxBytes int64 = 0
+
+// MinPartBytes là ngưỡng nhỏ nhất được chấp nhận, tránh chia thành hàng nghìn part
+const MinPartBytes = 1 << 20
+
+// archivePart là files[start:end] của session
+type archivePart struct {
+	start, end int
+}
+
+// splitParts chia files theo thứ tự sao cho mỗi part không vượt maxBytes (trừ part chỉ có một file
+// lớn hơn ngưỡng). File chưa biết dung lượng tính là 0. nil khi tất cả vừa một archive
+func splitParts(files []FileEntry, maxBytes int64) []archivePart {
+	if maxBytes <= 0 {
+		return nil
+	}
+	var parts []archivePart
+	var size int64
+	start := 0
+	for i, f := range files {
+		n := f.knownSize()
+		if i > start && size+n > maxBytes {
+			parts = append(parts, archivePart{start, i})
+			start, size = i, 0
+		}
+		size += n
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return append(parts, archivePart{start, len(files)})
+}
+
+// parts là các part của session, nil nếu session không chia. Phải giữ mu
+func (s *Session) parts() []archivePart {
+	return splitParts(s.Files, s.PartMaxBytes)
+}
+
+// partMaxBytes chọn ngưỡng chia của request. Ngưỡng mặc định của server không áp dụng cho các
+// session không chia được (resumable, contentLength, open, /zip); khai báo tường minh thì báo lỗi
+func partMaxBytes(req *DownloadRequest, oneShot bool) (int64, error) {
+	if req.PartMaxBytes == nil {
+		if oneShot || req.Resumable || req.ContentLength || req.Open {
+			return 0, nil
+		}
+		return PartMaxBytes, nil
+	}
+	n := *req.PartMaxBytes
+	switch {
+	case n == 0:
+		return 0, nil
+	case n < MinPartBytes:
+		return 0, fmt.Errorf("partMaxBytes must be 0 or at least %d", MinPartBytes)
+	case req.Resumable:
+		return 0, fmt.Errorf("partMaxBytes cannot be combined with resumable")
+	case req.ContentLength:
+		return 0, fmt.Errorf("partMaxBytes cannot be combined with contentLength")
+	case req.Open:
+		return 0, fmt.Errorf("partMaxBytes cannot be combined with open")
+	}
+	return n, nil
+}
+
+// partWarnings báo các file chưa biết dung lượng: chúng được tính là 0 nên part chứa chúng có
+// thể vượt ngưỡng
+func partWarnings(files []FileEntry) []Warning {
+	var warnings []Warning
+	for i, f := range files {
+		if f.knownSize() == 0 {
+			index := i
+			warnings = append(warnings, Warning{
+				Code:    "part_size_unknown",
+				Message: "Size is unknown at create time; the part holding this file may exceed partMaxBytes",
+				Index:   &index,
+			})
+		}
+	}
+	return warnings
+}
+
+// partZipName chèn "-part{n}" trước phần mở rộng của tên archive: files.tar.gz → files-part2.tar.gz
+func partZipName(name string, n int) string {
+	base, ext := name, ""
+	for _, e := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if b, ok := strings.CutSuffix(strings.ToLower(name), e); ok {
+			base, ext = name[:len(b)], name[len(b):]
+			break
+		}
+	}
+	return base + "-part" + strconv.Itoa(n) + ext
+}
+
+// parsePartPath đọc sub-resource "part/{n}" của /download/{token}, n bắt đầu từ 1
+func parsePartPath(sub string) (int, bool) {
+	rest, ok := strings.CutPrefix(sub, "part/")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 1 || strconv.Itoa(n) != rest {
+		return 0, false
+	}
+	return n, true
+}
+
+// partURLs là link tải của count part, ký như download_url. nil khi count = 0
+func partURLs(r *http.Request, linkDomain string, shortLink bool, token string, signedUntil time.Time, count int) []string {
+	if count == 0 {
+		return nil
+	}
+	base := downloadPath(r, linkDomain, shortLink, token)
+	urls := make([]string, count)
+	for i := range urls {
+		urls[i] = signDownloadURL(fmt.Sprintf("%s/part/%d", base, i+1), token, signedUntil)
+	}
+	return urls
+}
+
+// partCountersLocked cấp bộ đếm lượt theo part khi cần. Phải giữ mu.Lock
+func (s *Session) partCountersLocked(count int) {
+	if len(s.partDownloads) < count {
+		s.partDownloads = append(s.partDownloads, make([]int, count-len(s.partDownloads))...)
+	}
+	if len(s.partClaims) < count {
+		s.partClaims = append(s.partClaims, make([]int, count-len(s.partClaims))...)
+	}
+}
+
+// claimPartLocked giữ một lượt của part i như canClaim với cả session. Trả "consumed" khi part
+// đã hết lượt, "in_progress" khi các lượt còn lại đang được tải. Phải giữ mu.Lock
+func (s *Session) claimPartLocked(i, count int) string {
+	s.partCountersLocked(count)
+	switch {
+	case s.MaxDownloads > 0 && s.partDownloads[i] >= s.MaxDownloads:
+		return "consumed"
+	case s.MaxDownloads > 0 && s.partDownloads[i]+s.partClaims[i] >= s.MaxDownloads:
+		return "in_progress"
+	}
+	s.partClaims[i]++
+	return ""
+}
+
+// partsConsumedLocked báo mọi part đã hết lượt tải. Phải giữ mu
+func (s *Session) partsConsumedLocked(count int) bool {
+	if s.MaxDownloads == 0 || len(s.partDownloads) < count {
+		return false
+	}
+	for _, n := range s.partDownloads[:count] {
+		if n < s.MaxDownloads {
+			return false
+		}
+	}
+	return true
+}
+
+// finishPartLocked nhả lượt của part i khi download kết thúc; tải trọn vẹn thì tính một lượt, mọi
+// part hết lượt thì tiêu thụ session. Phải giữ mu.Lock
+func (s *Session) finishPartLocked(token string, i, count int, completed bool) {
+	s.partClaims[i]--
+	if sessions[token] != s {
+		return // Token đã bị rotate hoặc thu hồi trong lúc tải
+	}
+	if completed {
+		s.partDownloads[i]++
+		if s.partsConsumedLocked(count) {
+			retireSessionLocked(token, "consumed", time.Now())
+			return
+		}
+	}
+	persistSessionLocked(s)
+}
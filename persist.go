@@ -0,0 +1,349 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ============== SESSION PERSISTENCE ==============
+
+// Session luôn nằm trong bộ nhớ (map, expiryQueue, sessionOrder); backend chỉ giữ bản ghi của nó
+// ngoài process: DataDir (file, nạp lại lúc khởi động) hoặc Redis (dùng chung giữa các instance,
+// bản trong bộ nhớ là cache được đồng bộ khi truy cập, xem syncSharedSession).
+
+// sessionBackend lưu bản ghi persistedSession (JSON) theo token, và bản ghi template dưới
+// templatesRecordKey (expiresAt zero = không hết hạn)
+type sessionBackend interface {
+	put(token string, data []byte, expiresAt time.Time) error
+	get(token string) ([]byte, error) // nil, nil khi không có
+	remove(token string) error
+	shared() bool // Nhiều instance cùng đọc ghi: session có thể bị instance khác sửa hoặc xóa
+	ping() error  // Kiểm tra backend còn ghi được, cho /readyz
+}
+
+var backend sessionBackend // nil = chỉ giữ trong bộ nhớ
+
+// openSessionBackend chọn backend theo DataDir hoặc RedisURL, gọi một lần trước khi server chạy
+func openSessionBackend() error {
+	switch {
+	case DataDir != "" && RedisURL != "":
+		return errors.New("--data-dir and --redis-url cannot be used together")
+	case DataDir != "":
+		backend = fileBackend{}
+	case RedisURL != "":
+		client, err := parseRedisURL(RedisURL)
+		if err != nil {
+			return fmt.Errorf("redis-url: %v", err)
+		}
+		if _, err := client.do("PING"); err != nil {
+			return fmt.Errorf("redis-url %s: %v", client.addr, err)
+		}
+		backend = &redisBackend{client: client}
+	}
+	return nil
+}
+
+func sharedBackend() bool {
+	return backend != nil && backend.shared()
+}
+
+// persistedSession là bản ghi của backend ({DataDir}/{token}.json hoặc key Redis), cùng schema
+// session với /admin/export. Chỉ server đọc được (file 0600, Redis của operator) nên secret
+// (webhook, header forward) được giữ dạng rõ thay vì mã hóa.
+type persistedSession struct {
+	V       int              `json:"v"`
+	Token   string           `json:"token"`
+	Session *exportedSession `json:"session"`
+
+	Webhook     *WebhookConfig `json:"webhook,omitempty"`
+	Headers     http.Header    `json:"headers,omitempty"`
+	FileHeaders []http.Header  `json:"file_headers,omitempty"`
+	Password    string         `json:"password,omitempty"`
+
+	Artifact *artifactRecord `json:"artifact,omitempty"`
+}
+
+var errPersist = errors.New("failed to persist session")
+
+func sessionFilePath(token string) string {
+	return filepath.Join(DataDir, token+".json")
+}
+
+// writeSessionRecordLocked ghi bản ghi của session vào backend. Phải giữ mu
+func writeSessionRecordLocked(session *Session) error {
+	if backend == nil {
+		return nil
+	}
+	rec := persistedSession{
+		V:       ExportSchemaVersion,
+		Token:   session.token,
+		Session: exportSession(session),
+	}
+	if sec := sessionSecrets(session); sec != nil {
+		rec.Webhook, rec.Headers, rec.FileHeaders, rec.Password = sec.Webhook, sec.Headers, sec.FileHeaders, sec.Password
+	}
+	// Đường dẫn artifact chỉ có nghĩa trên đĩa của instance này
+	if !backend.shared() {
+		rec.Artifact = session.artifactRecordLocked()
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := backend.put(session.token, data, session.expiresAt()); err != nil {
+		return err
+	}
+	session.recordSum = sha256.Sum256(data)
+	return nil
+}
+
+// fileBackend lưu mỗi session thành {DataDir}/{token}.json
+type fileBackend struct{}
+
+// put ghi file tạm rồi rename để không bao giờ để lại file ghi dở
+func (fileBackend) put(token string, data []byte, _ time.Time) error {
+	f, err := os.CreateTemp(DataDir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), sessionFilePath(token))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (fileBackend) get(token string) ([]byte, error) {
+	data, err := os.ReadFile(sessionFilePath(token))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (fileBackend) remove(token string) error {
+	if err := os.Remove(sessionFilePath(token)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (fileBackend) shared() bool { return false }
+
+func (fileBackend) ping() error { return probeWritableDir(DataDir) }
+
+// persistNewSessionLocked ghi session vừa thêm; nếu không ghi được thì gỡ session ra để không
+// trả về link sẽ mất khi restart. Phải giữ mu.Lock
+func persistNewSessionLocked(session *Session) error {
+	if err := writeSessionRecordLocked(session); err != nil {
+		slog.Error("Failed to persist session", "token", session.token, "error", err)
+		deleteSessionLocked(session.token)
+		return errPersist
+	}
+	return nil
+}
+
+// persistSessionLocked cập nhật file của session sau khi sửa, chỉ log khi lỗi. Phải giữ mu
+func persistSessionLocked(session *Session) {
+	if err := writeSessionRecordLocked(session); err != nil {
+		slog.Error("Failed to persist session", "token", session.token, "error", err)
+	}
+}
+
+// flushSessions ghi lại mọi session còn sống trước khi thoát, sau khi các download đã drain.
+// Backend chung đã được ghi xuyên và có thể có bản mới hơn từ instance khác nên bỏ qua
+func flushSessions() {
+	if backend == nil || backend.shared() {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, session := range sessions {
+		persistSessionLocked(session)
+	}
+	slog.Info("Flushed sessions", "sessions", len(sessions), "dir", DataDir)
+}
+
+// removeSessionRecordLocked xóa bản ghi của token khi session bị xóa/hết hạn/đã tải. Phải giữ mu.Lock
+func removeSessionRecordLocked(token string) {
+	if backend == nil {
+		return
+	}
+	if err := backend.remove(token); err != nil {
+		slog.Error("Failed to remove session record", "token", token, "error", err)
+	}
+}
+
+// loadPersistedSessions nạp lại các session trong DataDir lúc khởi động, bỏ file hết hạn hoặc hỏng
+func loadPersistedSessions() error {
+	if DataDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(DataDir, 0o700); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(DataDir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	loaded := 0
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range paths {
+		token := strings.TrimSuffix(filepath.Base(p), ".json")
+		if token == templatesRecordKey {
+			continue
+		}
+		session, artifact, err := readSessionFile(p, token)
+		if err != nil {
+			slog.Warn("Skipping session file", "file", p, "error", err)
+			continue
+		}
+		if session.isExpired(now) {
+			os.Remove(p)
+			removeSpoolFiles(token, "archive", "")
+			continue
+		}
+		if err := addSessionLocked(token, session); err != nil {
+			slog.Warn("Skipping session file", "file", p, "error", err)
+			removeSpoolFiles(token, "archive", "")
+			continue
+		}
+		session.restoreArtifactLocked(token, artifact)
+		loaded++
+	}
+	slog.Info("Loaded sessions", "sessions", loaded, "dir", DataDir)
+	return nil
+}
+
+func readSessionFile(p, token string) (*Session, *artifactRecord, error) {
+	if _, err := uuid.Parse(token); err != nil {
+		return nil, nil, errors.New("file name is not a token")
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeSessionRecord(data, token)
+}
+
+// decodeSessionRecord dựng lại session từ bản ghi, kèm trạng thái artifact đã lưu nếu có
+func decodeSessionRecord(data []byte, token string) (*Session, *artifactRecord, error) {
+	var rec persistedSession
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, nil, err
+	}
+	if rec.V != ExportSchemaVersion {
+		return nil, nil, fmt.Errorf("unsupported schema version %d", rec.V)
+	}
+	if rec.Token != token || rec.Session == nil {
+		return nil, nil, errors.New("token does not match record key")
+	}
+	session, err := importSession(rec.Session, &exportSecrets{Webhook: rec.Webhook, Headers: rec.Headers, FileHeaders: rec.FileHeaders, Password: rec.Password})
+	return session, rec.Artifact, err
+}
+
+// pruneSessionFiles xóa file của token không còn trong store (ví dụ hết hạn khi đang tắt) và
+// file tạm bị bỏ dở, chạy định kỳ cùng spool sweeper
+func pruneSessionFiles() {
+	if DataDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(DataDir)
+	if err != nil {
+		slog.Error("Failed to scan data dir", "dir", DataDir, "error", err)
+		return
+	}
+
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			if info, err := e.Info(); err == nil && now.Sub(info.ModTime()) > CleanupInterval {
+				os.Remove(filepath.Join(DataDir, name))
+			}
+			continue
+		}
+		token, ok := strings.CutSuffix(name, ".json")
+		if !ok || token == templatesRecordKey {
+			continue
+		}
+		if _, live := sessions[token]; !live {
+			os.Remove(filepath.Join(DataDir, name))
+		}
+	}
+}
+
+// syncSharedSession làm mới bản trong bộ nhớ của token từ backend chung trước khi xử lý request:
+// nạp session do instance khác tạo, thay bản cũ khi bản ghi đã bị sửa (giữ analytics, tiến độ và
+// artifact của instance này) và bỏ bản cũ khi bản ghi đã bị xóa (đã tải, rotate...). Không đụng
+// tới session đang có download. Gọi khi không giữ mu
+func syncSharedSession(token string) {
+	if !sharedBackend() {
+		return
+	}
+	if _, err := uuid.Parse(token); err != nil {
+		return
+	}
+	data, err := backend.get(token)
+	if err != nil {
+		slog.Error("Failed to read session record", "token", token, "error", err)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	local, live := sessions[token]
+	if live && local.active > 0 {
+		return
+	}
+	if data == nil {
+		if live {
+			forgetSessionLocked(token)
+		}
+		return
+	}
+	sum := sha256.Sum256(data)
+	if live && local.recordSum == sum {
+		return
+	}
+	session, _, err := decodeSessionRecord(data, token)
+	if err != nil {
+		slog.Warn("Skipping session record", "token", token, "error", err)
+		return
+	}
+	session.recordSum = sum
+	if session.isExpired(time.Now()) {
+		return
+	}
+	if live {
+		session.analytics, session.owner = local.analytics, local.owner
+		session.progress, session.progressOutcome, session.progressDisconnected = local.progress, local.progressOutcome, local.progressDisconnected
+		session.artifact, local.artifact = local.artifact, nil
+		forgetSessionLocked(token)
+	}
+	if err := addSessionLocked(token, session); err != nil {
+		slog.Error("Failed to cache session", "token", token, "error", err)
+	}
+}
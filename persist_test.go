@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session còn lượt tải, header forward và hạn được nạp lại sau restart; session đã dùng hết, bản
+// ghi hỏng và file tạm cũ bị dọn
+func TestPersistRestart(t *testing.T) {
+	useDataDir(t)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "auth="+r.Header.Get("Authorization"))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	token := func(link string) string { return link[strings.LastIndex(link, "/")+1:] }
+
+	kept := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"maxDownloads":2,"headers":{"Authorization":"Bearer s3cret"}}`)
+	used := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/b.txt"}]}`)
+	if status, _ := download(t, kept); status != http.StatusOK {
+		t.Fatalf("first download = %d", status)
+	}
+	if status, _ := download(t, used); status != http.StatusOK {
+		t.Fatalf("single-use download = %d", status)
+	}
+	mu.RLock()
+	expiresAt := sessions[token(kept)].expiresAt()
+	mu.RUnlock()
+
+	corrupt := filepath.Join(DataDir, uuid.New().String()+".json")
+	stale := filepath.Join(DataDir, ".tmp-stale")
+	for _, p := range []string{corrupt, stale} {
+		if err := os.WriteFile(p, []byte("{not json"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * CleanupInterval)
+	os.Chtimes(stale, old, old)
+
+	restart(t)
+	mu.RLock()
+	restored, ok := sessions[token(kept)]
+	var restoredExpiry time.Time
+	if ok {
+		restoredExpiry = restored.expiresAt()
+	}
+	_, usedLoaded := sessions[token(used)]
+	mu.RUnlock()
+	if !ok || !restoredExpiry.Equal(expiresAt) {
+		t.Fatalf("restored session %v, expires %v, want expiry %v", ok, restoredExpiry, expiresAt)
+	}
+	if usedLoaded {
+		t.Fatal("consumed session was loaded again")
+	}
+	for _, p := range []string{corrupt, stale} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed on restart: %v", filepath.Base(p), err)
+		}
+	}
+
+	status, data := download(t, kept)
+	if status != http.StatusOK {
+		t.Fatalf("download after restart = %d", status)
+	}
+	if entries := unzip(t, data); entries["a.txt"] != "auth=Bearer s3cret" {
+		t.Fatalf("entries after restart = %v, want the forwarded header", entries)
+	}
+	if status, _ := download(t, kept); status == http.StatusOK {
+		t.Fatal("maxDownloads was reset by the restart")
+	}
+	if _, err := os.Stat(sessionFilePath(token(kept))); !os.IsNotExist(err) {
+		t.Fatalf("record of the used-up session still exists: %v", err)
+	}
+}
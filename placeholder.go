@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// ============== FAILURE PLACEHOLDERS ==============
+
+// placeholderName là tên dự kiến của file lỗi: tên đã resolve hoặc khai báo, nếu không thì lấy
+// từ URL. Placeholder nằm cùng thư mục với entry
+func placeholderName(entry FileEntry) string {
+	name := entry.resolvedName
+	if name == "" {
+		name = sanitizeEntryPath(entry.archiveName(sanitizeFileName(urlBaseName(entry.URL))))
+	}
+	dir, base := path.Split(name)
+	return dir + "FAILED_" + base + ".txt"
+}
+
+// writeFailurePlaceholder ghi một entry text nhỏ thay cho file không tải được để người mở
+// archive thấy ngay file nào thiếu và vì sao
+func writeFailurePlaceholder(aw archiveWriter, name, fileURL string, cause error) error {
+	return aw.writeText(name, fmt.Sprintf("This file could not be downloaded.\n\nSource: %s\nError: %v\nTime: %s\n", fileURL, cause, time.Now().UTC().Format(time.RFC3339)))
+}
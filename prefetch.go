@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ============== PREFETCH ==============
+
+// prefetched là kết quả fetch một file (đã qua mirror/retry), chờ được ghi vào zip theo thứ tự
+type prefetched struct {
+	fileURL  string
+	fileName string
+	resp     *http.Response
+	attempts int
+	err      error
+
+	ctx     context.Context // Context riêng của file, hết hạn theo timeout
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+// prefetcher fetch trước các file với tối đa FetchConcurrency response đang mở (tính cả file
+// đang được ghi vào zip). Mỗi slot được take đúng một lần theo thứ tự file.
+type prefetcher struct {
+	slots  []chan *prefetched // nil = file không được prefetch
+	sem    chan struct{}
+	held   bool // Đang giữ một chỗ trong sem cho file vừa take
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+// startPrefetch bắt đầu fetch song song các file i (0 <= i < n) mà skip(i) false
+func startPrefetch(ctx context.Context, n int, skip func(i int) bool, fetch func(ctx context.Context, i int) *prefetched) *prefetcher {
+	workers := FetchConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &prefetcher{
+		slots:  make([]chan *prefetched, n),
+		sem:    make(chan struct{}, workers),
+		cancel: cancel,
+	}
+	for i := range p.slots {
+		if !skip(i) {
+			p.slots[i] = make(chan *prefetched, 1)
+		}
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for i, slot := range p.slots {
+			if slot == nil {
+				continue
+			}
+			select {
+			case p.sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			p.wg.Add(1)
+			go func(i int, slot chan *prefetched) {
+				defer p.wg.Done()
+				slot <- fetch(ctx, i)
+			}(i, slot)
+		}
+	}()
+	return p
+}
+
+func (p *prefetcher) has(i int) bool {
+	return p.slots[i] != nil
+}
+
+// take chờ kết quả của file i. Chỗ trong pool được trả lại ở lần finish tiếp theo
+func (p *prefetcher) take(i int) *prefetched {
+	res := <-p.slots[i]
+	p.slots[i] = nil
+	p.held = true
+	return res
+}
+
+// finish trả chỗ của file vừa take sau khi body của nó đã được đóng
+func (p *prefetcher) finish() {
+	if p.held {
+		<-p.sem
+		p.held = false
+	}
+}
+
+// close hủy các fetch còn lại và đóng những response chưa được dùng
+func (p *prefetcher) close() {
+	p.cancel()
+	slots := p.slots
+	go func() {
+		p.wg.Wait()
+		for _, slot := range slots {
+			if slot == nil {
+				continue
+			}
+			select {
+			case res := <-slot:
+				if res.resp != nil {
+					res.resp.Body.Close()
+				}
+				res.cancel()
+			default:
+			}
+		}
+	}()
+}
+
+// readahead đọc trước tối đa limit byte của body trong nền để file đứng sau trong hàng đợi
+// vẫn tải tiếp trong lúc zip đang ghi file trước
+type readahead struct {
+	src   io.ReadCloser
+	limit int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	err    error
+	closed bool
+}
+
+func newReadahead(src io.ReadCloser, limit int) *readahead {
+	r := &readahead{src: src, limit: limit}
+	r.cond = sync.NewCond(&r.mu)
+	go r.fill()
+	return r
+}
+
+func (r *readahead) fill() {
+	chunk := make([]byte, 32*1024)
+	for {
+		r.mu.Lock()
+		for len(r.buf) >= r.limit && !r.closed {
+			r.cond.Wait()
+		}
+		closed := r.closed
+		r.mu.Unlock()
+		if closed {
+			return
+		}
+
+		n, err := r.src.Read(chunk)
+		r.mu.Lock()
+		r.buf = append(r.buf, chunk[:n]...)
+		if err != nil {
+			r.err = err
+		}
+		r.cond.Broadcast()
+		r.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *readahead) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.buf) == 0 && r.err == nil && !r.closed {
+		r.cond.Wait()
+	}
+	if len(r.buf) == 0 {
+		if r.closed {
+			return 0, io.ErrClosedPipe
+		}
+		return 0, r.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	if len(r.buf) == 0 {
+		r.buf = nil
+	}
+	r.cond.Broadcast()
+	return n, nil
+}
+
+func (r *readahead) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.cond.Broadcast()
+	r.mu.Unlock()
+	return r.src.Close()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useFetchConcurrency(t *testing.T, n int) {
+	concurrency := FetchConcurrency
+	FetchConcurrency = n
+	t.Cleanup(func() { FetchConcurrency = concurrency })
+}
+
+// Origin chậm: với 4 worker, 8 file xong trong khoảng thời gian của 2 file, không bao giờ quá 4
+// request cùng lúc, và entry vẫn theo đúng thứ tự khai báo
+func TestPrefetchWallClock(t *testing.T) {
+	const delay = 150 * time.Millisecond
+	var inFlight, peak atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(delay)
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	var files, want []string
+	for i := range 8 {
+		files = append(files, fmt.Sprintf(`{"url":"%s/f%d.txt"}`, origin.URL, i))
+		want = append(want, fmt.Sprintf("f%d.txt", i))
+	}
+	body := `{"files":[` + strings.Join(files, ",") + `],"maxDownloads":0}`
+
+	useFetchConcurrency(t, 4)
+	link := createSession(t, base, body)
+	start := time.Now()
+	status, data := download(t, link)
+	elapsed := time.Since(start)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	if elapsed > 5*delay {
+		t.Fatalf("8 files with 4 workers took %v, want under %v", elapsed, 5*delay)
+	}
+	if peak.Load() > 4 {
+		t.Fatalf("origin saw %d requests at once, want at most 4", peak.Load())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("entries = %v, want %v", names, want)
+	}
+
+	useFetchConcurrency(t, 1)
+	peak.Store(0)
+	start = time.Now()
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("sequential download = %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < 8*delay || peak.Load() != 1 {
+		t.Fatalf("sequential download took %v with %d requests at once, want at least %v one at a time", elapsed, peak.Load(), 8*delay)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ============== ARCHIVE PREVIEW ==============
+
+// GET /preview/{token} liệt kê các entry archive sẽ chứa mà không tải: kiểm tra từng file như
+// /validate (HEAD, GET 1 byte nếu cần) trên danh sách file hiện tại của session. Dành cho UI hiển
+// thị trước khi người dùng bấm tải nên URL được che credential như trong log, và không tính là
+// một lần tải. allowedCIDRs và notBefore áp dụng như /status.
+
+// previewResponse là kết quả của GET /preview/{token}
+type previewResponse struct {
+	ZipName   string    `json:"zip_name"`
+	Format    string    `json:"format"` // zip, tar hoặc tar.gz
+	Encrypted bool      `json:"encrypted,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	validateResponse
+	// Ước lượng dung lượng archive khi mọi file ok đều báo dung lượng: dữ liệu chưa nén cộng
+	// header, chưa tính ERRORS.txt/manifest.json/checksum ghi ở cuối
+	EstimatedArchiveBytes *int64 `json:"estimated_archive_bytes,omitempty"`
+}
+
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/preview/"), "/")
+	syncSharedSession(token)
+
+	now := time.Now()
+	mu.Lock()
+	session, ok := sessions[token]
+	if !ok {
+		t, gone := tombstones[token]
+		mu.Unlock()
+		if gone {
+			localizedError(w, r, http.StatusGone, "token_gone", t.Reason)
+			return
+		}
+		localizedError(w, r, http.StatusNotFound, "invalid_token")
+		return
+	}
+	if session.isExpired(now) {
+		expireSessionLocked(session, now)
+		mu.Unlock()
+		localizedError(w, r, http.StatusGone, "session_expired")
+		return
+	}
+	if !statusNetworkAllowed(r, session) {
+		mu.Unlock()
+		localizedError(w, r, http.StatusForbidden, "forbidden_network")
+		return
+	}
+	// Trước notBefore không kiểm tra nguồn: preview không được gửi request nào tới origin
+	if notBefore := session.NotBefore; isBeforeNotBefore(notBefore, now) {
+		mu.Unlock()
+		writeNotYetAvailable(w, r, notBefore, now)
+		return
+	}
+	session.touch(now)
+	// Session mở còn nhận thêm hoặc bớt file: kiểm tra trên bản sao
+	files, headers, opts := slices.Clone(session.Files), session.headers, session.Archive
+	resp := previewResponse{ZipName: session.ZipName, Format: cmp.Or(opts.Format, "zip"), Encrypted: opts.Encrypted, ExpiresAt: session.expiresAt()}
+	names := session.nameOptions()
+	mu.Unlock()
+
+	resp.validateResponse = checkSources(r.Context(), files, headers, names)
+	for i := range resp.Files {
+		c := &resp.Files[i]
+		if c.OK && files[i].resolvedName != "" {
+			c.Name = files[i].resolvedName // Tên đã chốt lúc tạo (resolveNames), download dùng đúng tên này
+		}
+		c.URL = redactURL(c.URL)
+		c.Error = redactURLs(c.Error)
+	}
+	if resp.SizesUnknown == 0 {
+		if n, err := estimateArchiveBytes(resp.Files, opts, now); err == nil {
+			resp.EstimatedArchiveBytes = &n
+		}
+	}
+
+	slog.InfoContext(r.Context(), "Previewed archive", "token", token, "files", len(files), "files_ok", resp.FilesOK, "files_failed", resp.FilesFailed, "bytes", resp.TotalBytes)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// estimateArchiveBytes cộng dung lượng các file ok với header của định dạng. Với zip, header được
+// ghi thật vào một zip.Writer nháp (không có dữ liệu); entry nén deflate được tính như chưa nén
+func estimateArchiveBytes(checks []sourceCheck, opts archiveOptions, now time.Time) (int64, error) {
+	if opts.Format != "" {
+		var total int64 = 1024 // Hai block 0 kết thúc tar
+		for _, c := range checks {
+			if !c.OK {
+				continue
+			}
+			total += 512 + (*c.Size+511)/512*512
+			if len(c.Name) > 100 || strings.ContainsFunc(c.Name, func(r rune) bool { return r >= 0x80 }) {
+				total += 1024 // PAX header cho tên dài hoặc không phải ASCII
+			}
+		}
+		return total, nil
+	}
+
+	counter := &sentCounter{w: io.Discard}
+	zw := zip.NewWriter(counter)
+	var data int64
+	for _, c := range checks {
+		if !c.OK {
+			continue
+		}
+		h, err := rawEntryHeader(zipEntry{Name: c.Name, Mode: defaultFileMode, Time: now, ContentType: c.ContentType}, opts, 0, *c.Size)
+		if err != nil {
+			return 0, err
+		}
+		if opts.Encrypted {
+			h.Extra = append(h.Extra, aesExtra(h.Method)...)
+			data += aesSaltSize + 2 + aesAuthSize
+		}
+		if _, err := zw.CreateRaw(h); err != nil {
+			return 0, err
+		}
+		data += *c.Size
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n + data, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============== DOWNLOAD PROGRESS ==============
+
+// downloadProgress được handleDownload cập nhật và các reporter đọc đồng thời
+type downloadProgress struct {
+	startedAt  time.Time
+	filesTotal int
+
+	filesCompleted    atomic.Int64
+	filesFailed       atomic.Int64
+	bytesWritten      atomic.Int64
+	bytesDeduplicated atomic.Int64 // Số byte không phải tải lại nhờ dedupe
+	rateSampledAt     atomic.Int64 // UnixNano của lần cập nhật rate gần nhất từ progressWriter
+
+	mu          sync.Mutex
+	currentFile string
+	totalBytes  int64 // Tổng dung lượng dự kiến nếu biết trước (preflight), 0 = chưa biết
+	rate        rateEstimator
+	failures    []fileFailure
+	completed   []fileResult
+	abortReason string
+}
+
+// fileFailure là một dòng trong báo cáo lỗi của archive
+type fileFailure struct {
+	Index    int    `json:"index"` // Vị trí trong archive, bắt đầu từ 0
+	URL      string `json:"url"`
+	Error    string `json:"error"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Attempts int    `json:"attempts,omitempty"` // Số lần đã thử nếu có retry
+}
+
+// fileResult là kết quả của một file trong event cuối của webhook. Bytes là dung lượng gốc
+// của file đã ghi vào archive
+type fileResult struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"` // Tên entry trong archive, rỗng với file lỗi
+	URL   string `json:"url"`
+	Bytes int64  `json:"bytes"`
+	Error string `json:"error,omitempty"`
+
+	SHA256       string `json:"sha256,omitempty"`       // SHA-256 của nội dung, khi bật checksums hoặc entry có sha256
+	Deduplicated bool   `json:"deduplicated,omitempty"` // Lấy lại nội dung của entry trùng URL, không tải lại
+}
+
+type progressSnapshot struct {
+	FilesTotal      int          `json:"files_total"`
+	FilesCompleted  int64        `json:"files_completed"`
+	FilesFailed     int64        `json:"files_failed"`
+	BytesWritten    int64        `json:"bytes_written"`
+	BytesTotal      int64        `json:"bytes_total,omitempty"`
+	BytesDeduped    int64        `json:"bytes_deduplicated,omitempty"`
+	CurrentFile     string       `json:"current_file,omitempty"`
+	RateBytesPerSec float64      `json:"rate_bytes_per_sec"`
+	ETA             *etaEstimate `json:"eta,omitempty"`
+}
+
+// etaEstimate luôn là ước lượng: basis "bytes" (biết tổng dung lượng) có confidence cao hơn "files"
+type etaEstimate struct {
+	Seconds     float64   `json:"seconds"`
+	CompletesAt time.Time `json:"completes_at"`
+	Basis       string    `json:"basis"`      // bytes hoặc files
+	Confidence  string    `json:"confidence"` // high hoặc low
+}
+
+func newDownloadProgress(filesTotal int) *downloadProgress {
+	now := time.Now()
+	return &downloadProgress{
+		startedAt:  now,
+		filesTotal: filesTotal,
+		rate:       rateEstimator{window: RateWindow, lastAt: now},
+	}
+}
+
+// setTotalBytes ghi nhận tổng dung lượng dự kiến để ước lượng ETA theo byte
+func (p *downloadProgress) setTotalBytes(n int64) {
+	p.mu.Lock()
+	p.totalBytes = n
+	p.mu.Unlock()
+}
+
+// knownTotalBytes là tổng resolvedSize của files khi mọi dung lượng đều biết lúc preflight,
+// 0 nếu có file chưa rõ dung lượng (tổng thiếu sẽ làm ETA theo byte sai)
+func knownTotalBytes(files []FileEntry) int64 {
+	var total int64
+	for _, f := range files {
+		if f.resolvedSize <= 0 {
+			return 0
+		}
+		total += f.resolvedSize
+	}
+	return total
+}
+
+// fail đánh dấu một file lỗi và ghi lại lý do cho báo cáo
+func (p *downloadProgress) fail(index int, fileURL string, err error) {
+	p.filesFailed.Add(1)
+	filesFailed.Add(1)
+
+	f := fileFailure{Index: index, URL: fileURL, Error: err.Error()}
+	var mismatch *mismatchError
+	if errors.As(err, &mismatch) {
+		f.Expected = mismatch.Expected
+		f.Actual = mismatch.Actual
+	}
+	var retried *retryError
+	if errors.As(err, &retried) {
+		f.Attempts = retried.Attempts
+	}
+
+	p.mu.Lock()
+	p.failures = append(p.failures, f)
+	p.mu.Unlock()
+}
+
+// complete đánh dấu một file đã ghi xong vào archive
+func (p *downloadProgress) complete(index int, name, fileURL string, n int64, sum string) {
+	p.filesCompleted.Add(1)
+	filesFetched.Add(1)
+
+	p.mu.Lock()
+	p.completed = append(p.completed, fileResult{Index: index, Name: name, URL: fileURL, Bytes: n, SHA256: sum})
+	p.mu.Unlock()
+}
+
+// reuse đánh dấu một file đã ghi vào archive từ nội dung của entry trùng URL
+func (p *downloadProgress) reuse(index int, name, fileURL string, n int64, sum string) {
+	p.filesCompleted.Add(1)
+	p.bytesDeduplicated.Add(n)
+
+	p.mu.Lock()
+	p.completed = append(p.completed, fileResult{Index: index, Name: name, URL: fileURL, Bytes: n, SHA256: sum, Deduplicated: true})
+	p.mu.Unlock()
+}
+
+// deduplicatedFiles trả các file đã lấy lại từ entry trùng URL, sắp theo vị trí
+func (p *downloadProgress) deduplicatedFiles() []fileResult {
+	var reused []fileResult
+	for _, f := range p.fileResults() {
+		if f.Deduplicated {
+			reused = append(reused, f)
+		}
+	}
+	return reused
+}
+
+// fileResults gộp file đã xong và file lỗi, sắp theo vị trí trong archive
+func (p *downloadProgress) fileResults() []fileResult {
+	p.mu.Lock()
+	results := make([]fileResult, 0, len(p.completed)+len(p.failures))
+	results = append(results, p.completed...)
+	for _, f := range p.failures {
+		results = append(results, fileResult{Index: f.Index, URL: f.URL, Error: f.Error})
+	}
+	p.mu.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return results
+}
+
+func (p *downloadProgress) failureReport() []fileFailure {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]fileFailure(nil), p.failures...)
+}
+
+func (p *downloadProgress) setAbortReason(reason string) {
+	p.mu.Lock()
+	p.abortReason = reason
+	p.mu.Unlock()
+}
+
+func (p *downloadProgress) getAbortReason() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.abortReason
+}
+
+func (p *downloadProgress) setCurrentFile(name string) {
+	p.mu.Lock()
+	p.currentFile = name
+	p.mu.Unlock()
+}
+
+func (p *downloadProgress) snapshot() progressSnapshot {
+	now := time.Now()
+	written := p.bytesWritten.Load()
+
+	p.mu.Lock()
+	current := p.currentFile
+	totalBytes := p.totalBytes
+	rate := p.rate.estimate(now, written)
+	p.mu.Unlock()
+
+	snap := progressSnapshot{
+		FilesTotal:      p.filesTotal,
+		FilesCompleted:  p.filesCompleted.Load(),
+		FilesFailed:     p.filesFailed.Load(),
+		BytesWritten:    written,
+		BytesTotal:      totalBytes,
+		BytesDeduped:    p.bytesDeduplicated.Load(),
+		CurrentFile:     current,
+		RateBytesPerSec: rate,
+	}
+	snap.ETA = estimateETA(now, now.Sub(p.startedAt), snap)
+	return snap
+}
+
+// estimateETA ưu tiên số byte còn lại / tốc độ; nếu không biết dung lượng thì
+// dùng số file còn lại * thời gian trung bình mỗi file
+func estimateETA(now time.Time, elapsed time.Duration, snap progressSnapshot) *etaEstimate {
+	done := snap.FilesCompleted + snap.FilesFailed
+	remainingFiles := int64(snap.FilesTotal) - done
+	if remainingFiles <= 0 {
+		return nil
+	}
+
+	var eta etaEstimate
+	switch {
+	case snap.BytesTotal > 0 && snap.RateBytesPerSec > 0:
+		remaining := snap.BytesTotal - snap.BytesWritten
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta.Seconds = float64(remaining) / snap.RateBytesPerSec
+		eta.Basis = "bytes"
+		eta.Confidence = "high"
+	case done > 0:
+		eta.Seconds = elapsed.Seconds() / float64(done) * float64(remainingFiles)
+		eta.Basis = "files"
+		eta.Confidence = "low"
+	default:
+		return nil
+	}
+
+	eta.CompletesAt = now.Add(time.Duration(eta.Seconds * float64(time.Second))).UTC()
+	return &eta
+}
+
+// rateEstimator tính tốc độ truyền làm mượt bằng EWMA với hằng số thời gian window
+type rateEstimator struct {
+	window    time.Duration
+	lastAt    time.Time
+	lastBytes int64
+	rate      float64
+	primed    bool
+}
+
+// observe nhận tổng số byte tại thời điểm now và trả về tốc độ (byte/giây) đã làm mượt
+func (e *rateEstimator) observe(now time.Time, total int64) float64 {
+	if now.Sub(e.lastAt) <= 0 {
+		return e.rate
+	}
+	e.rate = e.estimate(now, total)
+	e.primed = true
+	e.lastAt = now
+	e.lastBytes = total
+	return e.rate
+}
+
+// estimate là tốc độ observe sẽ trả tại now mà không ghi lại mẫu: người đọc thấy tốc độ giảm dần
+// khi writer bị kẹt nhưng không làm lệch EWMA (đọc càng dày thì mẫu càng ngắn)
+func (e *rateEstimator) estimate(now time.Time, total int64) float64 {
+	dt := now.Sub(e.lastAt).Seconds()
+	if dt <= 0 {
+		return e.rate
+	}
+	instant := float64(total-e.lastBytes) / dt
+	if !e.primed {
+		return instant
+	}
+	alpha := 1 - math.Exp(-dt/e.window.Seconds())
+	return e.rate + alpha*(instant-e.rate)
+}
+
+// rateSampleInterval là khoảng tối thiểu giữa hai lần progressWriter cập nhật rate, để Write
+// không phải lấy khóa mỗi lần
+const rateSampleInterval = 100 * time.Millisecond
+
+// sampleRate cập nhật EWMA với tổng byte hiện tại, tối đa một lần mỗi rateSampleInterval
+func (p *downloadProgress) sampleRate(now time.Time) {
+	last := p.rateSampledAt.Load()
+	if now.UnixNano()-last < int64(rateSampleInterval) || !p.rateSampledAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+	p.mu.Lock()
+	p.rate.observe(now, p.bytesWritten.Load())
+	p.mu.Unlock()
+}
+
+// progressWriter đếm số byte đã ghi vào archive
+type progressWriter struct {
+	w io.Writer
+	p *downloadProgress
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.bytesWritten.Add(int64(n))
+	pw.p.sampleRate(time.Now())
+	return n, err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// replay đưa vào estimator một timeline byte: mỗi bước sau step, tổng tăng thêm delta[i]
+func replay(e *rateEstimator, start time.Time, step time.Duration, deltas []int64) (time.Time, int64) {
+	now, total := start, int64(0)
+	for _, d := range deltas {
+		now = now.Add(step)
+		total += d
+		e.observe(now, total)
+	}
+	return now, total
+}
+
+func TestRateEstimatorSteadyRate(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := rateEstimator{window: 10 * time.Second, lastAt: start}
+	deltas := make([]int64, 60)
+	for i := range deltas {
+		deltas[i] = 1000
+	}
+	replay(&e, start, time.Second, deltas)
+	if math.Abs(e.rate-1000) > 1e-6 {
+		t.Fatalf("rate = %v, want 1000", e.rate)
+	}
+}
+
+func TestRateEstimatorSmoothsBursts(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := rateEstimator{window: 10 * time.Second, lastAt: start}
+	steady := make([]int64, 30)
+	for i := range steady {
+		steady[i] = 1000
+	}
+	now, total := replay(&e, start, time.Second, steady)
+
+	// Một giây tăng vọt gấp 10 lần chỉ kéo rate lên một phần
+	now = now.Add(time.Second)
+	rate := e.observe(now, total+10000)
+	if rate <= 1000 || rate >= 5000 {
+		t.Fatalf("rate after burst = %v, want between 1000 and 5000", rate)
+	}
+}
+
+func TestRateEstimatorStall(t *testing.T) {
+	start := time.Unix(0, 0)
+	e := rateEstimator{window: 5 * time.Second, lastAt: start}
+	now, total := replay(&e, start, time.Second, []int64{1000, 1000, 1000, 1000, 1000})
+
+	// Writer bị kẹt: người đọc thấy tốc độ giảm dần mà EWMA không đổi
+	before := e
+	early := e.estimate(now.Add(2*time.Second), total)
+	late := e.estimate(now.Add(30*time.Second), total)
+	if e != before {
+		t.Fatal("estimate changed the estimator")
+	}
+	if !(late < early && early < 1000) {
+		t.Fatalf("stalled rates = %v then %v, want decreasing below 1000", early, late)
+	}
+	if late > 10 {
+		t.Fatalf("rate after a 30s stall = %v, want close to 0", late)
+	}
+}
+
+func TestRateEstimatorReadsDoNotSkew(t *testing.T) {
+	start := time.Unix(0, 0)
+	sampled := rateEstimator{window: 10 * time.Second, lastAt: start}
+	polled := sampled
+
+	// Cùng một timeline, một bên thêm rất nhiều lần đọc: không được lệch vì đọc không ghi mẫu
+	now, total := start, int64(0)
+	for i := 0; i < 20; i++ {
+		for j := 1; j <= 50; j++ {
+			polled.estimate(now.Add(time.Duration(j)*20*time.Millisecond), total)
+		}
+		now = now.Add(time.Second)
+		total += 2000
+		sampled.observe(now, total)
+		polled.observe(now, total)
+	}
+	if sampled.rate != polled.rate {
+		t.Fatalf("rate with reads = %v, without = %v", polled.rate, sampled.rate)
+	}
+}
+
+func TestEstimateETA(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name       string
+		snap       progressSnapshot
+		elapsed    time.Duration
+		seconds    float64
+		basis      string
+		confidence string
+	}{
+		{"bytes", progressSnapshot{FilesTotal: 4, FilesCompleted: 1, BytesWritten: 1000, BytesTotal: 5000, RateBytesPerSec: 100}, 10 * time.Second, 40, "bytes", "high"},
+		{"files without sizes", progressSnapshot{FilesTotal: 4, FilesCompleted: 1, BytesWritten: 1000, RateBytesPerSec: 100}, 10 * time.Second, 30, "files", "low"},
+		{"files counting failures", progressSnapshot{FilesTotal: 10, FilesCompleted: 3, FilesFailed: 2, RateBytesPerSec: 0}, 10 * time.Second, 10, "files", "low"},
+	}
+	for _, tt := range tests {
+		eta := estimateETA(now, tt.elapsed, tt.snap)
+		if eta == nil {
+			t.Fatalf("%s: no ETA", tt.name)
+		}
+		if eta.Seconds != tt.seconds || eta.Basis != tt.basis || eta.Confidence != tt.confidence {
+			t.Fatalf("%s: ETA = %+v, want %vs basis %s confidence %s", tt.name, eta, tt.seconds, tt.basis, tt.confidence)
+		}
+		if want := now.Add(time.Duration(tt.seconds * float64(time.Second))).UTC(); !eta.CompletesAt.Equal(want) {
+			t.Fatalf("%s: completes_at = %v, want %v", tt.name, eta.CompletesAt, want)
+		}
+	}
+
+	if eta := estimateETA(now, time.Second, progressSnapshot{FilesTotal: 3}); eta != nil {
+		t.Fatalf("ETA with nothing done = %+v, want none", eta)
+	}
+	if eta := estimateETA(now, time.Second, progressSnapshot{FilesTotal: 2, FilesCompleted: 2}); eta != nil {
+		t.Fatalf("ETA when finished = %+v, want none", eta)
+	}
+}
+
+func TestKnownTotalBytes(t *testing.T) {
+	files := []FileEntry{{resolvedSize: 10}, {resolvedSize: 20}}
+	if n := knownTotalBytes(files); n != 30 {
+		t.Fatalf("total = %d, want 30", n)
+	}
+	if n := knownTotalBytes(append(files, FileEntry{})); n != 0 {
+		t.Fatalf("total with an unknown size = %d, want 0", n)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ============== PER-HOST PROTOCOL ==============
+
+// HostProtocols (--host-protocol) ép giao thức khi fetch từ một host (hostname viết thường, có thể
+// kèm port), ví dụ {"internal-grpc-files:8080": "h2c", "legacy-lb.example.com": "http1"}.
+// "h2c" dùng HTTP/2 prior knowledge qua TCP thường, "h2" chỉ dùng HTTP/2 qua TLS (không lùi về
+// HTTP/1.1), "http1" tắt đàm phán h2; host khác giữ nguyên.
+var HostProtocols = map[string]string{}
+
+var (
+	h2cTransport   = newProtocolTransport(func(p *http.Protocols) { p.SetUnencryptedHTTP2(true) })
+	h2Transport    = newProtocolTransport(func(p *http.Protocols) { p.SetHTTP2(true) })
+	http1Transport = newProtocolTransport(func(p *http.Protocols) { p.SetHTTP1(true) })
+)
+
+func newProtocolTransport(enable func(*http.Protocols)) *http.Transport {
+	t := guardedTransport.Clone()
+	var p http.Protocols
+	enable(&p)
+	t.Protocols = &p
+	return t
+}
+
+// hostProtocolTransport chọn transport theo HostProtocols (scheme storage có transport riêng)
+// và gắn tên giao thức vào lỗi fetch
+type hostProtocolTransport struct{}
+
+func (hostProtocolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t := storageTransport(req.URL.Scheme); t != nil {
+		return t.RoundTrip(req)
+	}
+	if req.URL.Scheme == "ftp" {
+		return ftpTransport{}.RoundTrip(req)
+	}
+	force := forcedProtocol(req.URL.Host)
+	transport, proto := http.RoundTripper(guardedTransport), "auto"
+	switch force {
+	case "h2c":
+		transport, proto = h2cTransport, "h2c"
+	case "h2":
+		transport, proto = h2Transport, "HTTP/2"
+	case "http1":
+		transport, proto = http1Transport, "HTTP/1.1"
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, &protocolError{Proto: proto, Err: err}
+	}
+	return resp, nil
+}
+
+func forcedProtocol(host string) string {
+	host = strings.ToLower(host)
+	if force, ok := HostProtocols[host]; ok {
+		return force
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		return HostProtocols[host[:i]]
+	}
+	return ""
+}
+
+// protocolError ghi giao thức đã dùng cho lỗi transport, giữ Timeout() để phân loại retry
+type protocolError struct {
+	Proto string
+	Err   error
+}
+
+func (e *protocolError) Error() string {
+	return fmt.Sprintf("%v (protocol %s)", e.Err, e.Proto)
+}
+
+func (e *protocolError) Unwrap() error { return e.Err }
+
+func (e *protocolError) Timeout() bool {
+	t, ok := e.Err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
+
+// normalizeHostProtocols kiểm tra --host-protocol lúc khởi động, viết thường host và đổi "h1" thành "http1"
+func normalizeHostProtocols(protocols map[string]string) error {
+	for host, force := range protocols {
+		switch force = strings.ToLower(force); force {
+		case "h1":
+			force = "http1"
+		case "h2c", "h2", "http1":
+		default:
+			return fmt.Errorf("%s: unknown protocol %q (want h2, h2c or h1)", host, force)
+		}
+		delete(protocols, host)
+		protocols[strings.ToLower(host)] = force
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// protoOrigin trả r.Proto của request, chỉ phục vụ các giao thức enable bật (không TLS)
+func protoOrigin(t *testing.T, enable func(*http.Protocols)) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	var p http.Protocols
+	enable(&p)
+	srv.Config.Protocols = &p
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func useHostProtocols(t *testing.T, protocols map[string]string) {
+	allow, saved := AllowPrivateNetworks.Swap(true), HostProtocols
+	HostProtocols = protocols
+	t.Cleanup(func() {
+		AllowPrivateNetworks.Store(allow)
+		HostProtocols = saved
+	})
+}
+
+func fetchProto(t *testing.T, raw string) (string, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, raw, nil)
+	resp, err := hostProtocolTransport{}.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestHostProtocols(t *testing.T) {
+	both := protoOrigin(t, func(p *http.Protocols) { p.SetHTTP1(true); p.SetUnencryptedHTTP2(true) })
+	http1Only := protoOrigin(t, func(p *http.Protocols) { p.SetHTTP1(true) })
+	bothHost := strings.TrimPrefix(both.URL, "http://")
+	http1Host := strings.TrimPrefix(http1Only.URL, "http://")
+
+	// Không cấu hình: HTTP/1.1 qua TCP thường
+	useHostProtocols(t, map[string]string{})
+	if proto, err := fetchProto(t, both.URL); err != nil || proto != "HTTP/1.1" {
+		t.Fatalf("default = %q, %v", proto, err)
+	}
+
+	useHostProtocols(t, map[string]string{bothHost: "h2c"})
+	if proto, err := fetchProto(t, both.URL); err != nil || proto != "HTTP/2.0" {
+		t.Fatalf("h2c = %q, %v, want HTTP/2.0", proto, err)
+	}
+
+	// Host không kèm port áp dụng cho mọi port; http1 không bao giờ nâng lên h2
+	u, _ := url.Parse(both.URL)
+	useHostProtocols(t, map[string]string{u.Hostname(): "http1"})
+	if proto, err := fetchProto(t, both.URL); err != nil || proto != "HTTP/1.1" {
+		t.Fatalf("http1 = %q, %v, want HTTP/1.1", proto, err)
+	}
+
+	// h2c tới server chỉ nói HTTP/1.1: lỗi ghi rõ giao thức đã dùng
+	useHostProtocols(t, map[string]string{http1Host: "h2c"})
+	if _, err := fetchProto(t, http1Only.URL); err == nil || !strings.Contains(err.Error(), "protocol h2c") {
+		t.Fatalf("h2c to an HTTP/1.1 server = %v, want an error naming h2c", err)
+	}
+}
+
+func TestConfigHostProtocols(t *testing.T) {
+	cfg, err := parseConfig(t, map[string]string{"HOST_PROTOCOLS": "Legacy-LB.example.com=h1,files:8080=h2c"}, "-host-protocol", "api.example.com=H2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"legacy-lb.example.com": "http1", "files:8080": "h2c", "api.example.com": "h2"}
+	if !maps.Equal(cfg.HostProtocols, want) {
+		t.Fatalf("HostProtocols = %v, want %v", cfg.HostProtocols, want)
+	}
+	if _, err := parseConfig(t, nil, "-host-protocol", "a.example=h3"); err == nil || !strings.Contains(err.Error(), "host-protocol") {
+		t.Fatalf("unknown protocol: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func rangeGet(t *testing.T, link string, header ...string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// Range cắt giữa dữ liệu của một entry (store) trả đúng các byte đó của archive; If-Range sai
+// ETag trả cả archive
+func TestRangeSlicesEntry(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.bin"},{"url":"`+origin.URL+`/b.bin"}],"resumable":true,"resumableMode":"file"}`)
+
+	resp, archive := rangeGet(t, link)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("full download = %d, Accept-Ranges %q", resp.StatusCode, resp.Header.Get("Accept-Ranges"))
+	}
+	etag := resp.Header.Get("ETag")
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := zr.File[1]
+	off, err := entry.DataOffset()
+	if err != nil || entry.Method != zip.Store {
+		t.Fatalf("entry %s: offset %v, method %d, want a stored entry", entry.Name, err, entry.Method)
+	}
+
+	start, end := off+123, off+456
+	resp, slice := rangeGet(t, link, "Range", fmt.Sprintf("bytes=%d-%d", start, end), "If-Range", etag)
+	if resp.StatusCode != http.StatusPartialContent || resp.Header.Get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", start, end, len(archive)) {
+		t.Fatalf("range = %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	if string(slice) != content[123:457] || !bytes.Equal(slice, archive[start:end+1]) {
+		t.Fatalf("range returned %q, want bytes 123-456 of %s", slice, entry.Name)
+	}
+
+	resp, data := rangeGet(t, link, "Range", fmt.Sprintf("bytes=%d-", start), "If-Range", `"stale"`)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(data, archive) {
+		t.Fatalf("range with a stale If-Range = %d, %d bytes, want the whole %d byte archive", resp.StatusCode, len(data), len(archive))
+	}
+	if resp, _ := rangeGet(t, link, "Range", fmt.Sprintf("bytes=%d-", len(archive)+10)); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("range past the end = %d, want 416", resp.StatusCode)
+	}
+}
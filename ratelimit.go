@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============== PER-TOKEN RATE LIMIT ==============
+
+// downloadLimiter là token bucket theo session: tối đa limit lượt download mỗi phút, cho phép dồn
+// tối đa limit lượt. Nằm trong Session và được ghi cùng bản ghi session (limiterState) để các
+// replica dùng chung backend chia nhau một bucket. Cũng dùng cho giới hạn tạo session theo IP
+// (CreateRateLimit) và theo API key.
+type downloadLimiter struct {
+	tokens    float64
+	updated   time.Time
+	throttled int64 // Số lần bị từ chối với 429
+}
+
+// DownloadRateLimit (--download-rate-limit) là số lượt download tối đa mỗi phút của một token trên
+// toàn server, áp dụng cả cho session không khai báo rateLimit. 0 = không giới hạn
+var DownloadRateLimit = 0
+
+// limiterState là bucket của session trong bản ghi export/persist
+type limiterState struct {
+	Tokens    float64   `json:"tokens"`
+	Updated   time.Time `json:"updated"`
+	Throttled int64     `json:"throttled,omitempty"`
+}
+
+// state trả nil khi bucket chưa được dùng
+func (l *downloadLimiter) state() *limiterState {
+	if l.updated.IsZero() {
+		return nil
+	}
+	return &limiterState{Tokens: l.tokens, Updated: l.updated, Throttled: l.throttled}
+}
+
+func (st *limiterState) limiter() downloadLimiter {
+	if st == nil {
+		return downloadLimiter{}
+	}
+	return downloadLimiter{tokens: st.Tokens, updated: st.Updated, throttled: st.Throttled}
+}
+
+// downloadRateLimit là giới hạn hiệu lực: nhỏ hơn giữa cấu hình của session và DownloadRateLimit, 0 = không giới hạn
+func (s *Session) downloadRateLimit() int {
+	limit := s.RateLimit
+	if DownloadRateLimit > 0 && (limit <= 0 || limit > DownloadRateLimit) {
+		limit = DownloadRateLimit
+	}
+	return limit
+}
+
+// allowDownload tiêu một lượt download, trả về thời gian chờ khi hết lượt. Phải giữ mu.Lock
+func (s *Session) allowDownload(now time.Time) (bool, time.Duration) {
+	limit := s.downloadRateLimit()
+	if limit <= 0 {
+		return true, 0
+	}
+	return s.limiter.allow(limit, now)
+}
+
+// allow tiêu một lượt của bucket limit lượt mỗi phút, trả về thời gian chờ khi hết lượt
+func (l *downloadLimiter) allow(limit int, now time.Time) (bool, time.Duration) {
+	perSecond := float64(limit) / 60
+	if l.updated.IsZero() {
+		l.tokens = float64(limit)
+	} else {
+		l.tokens = math.Min(float64(limit), l.tokens+now.Sub(l.updated).Seconds()*perSecond)
+	}
+	l.updated = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	l.throttled++
+	wait := time.Duration((1 - l.tokens) / perSecond * float64(time.Second))
+	return false, wait
+}
+
+func writeThrottled(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(wait.Seconds())), 1)))
+	localizedError(w, r, http.StatusTooManyRequests, "throttled")
+}
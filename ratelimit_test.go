@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Giới hạn toàn server áp dụng cho session không khai báo rateLimit: lượt thứ limit+1 trong phút nhận 429
+func TestDownloadRateLimit(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	limit := DownloadRateLimit
+	DownloadRateLimit = 2
+	t.Cleanup(func() { DownloadRateLimit = limit })
+
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"maxDownloads":0}`)
+	for i := range 2 {
+		if status, _ := download(t, link); status != http.StatusOK {
+			t.Fatalf("download %d = %d, want 200", i+1, status)
+		}
+	}
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("download past the limit = %d, want 429", resp.StatusCode)
+	}
+	if wait, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || wait < 1 {
+		t.Fatalf("Retry-After = %q, want seconds", resp.Header.Get("Retry-After"))
+	}
+
+	// rateLimit của session chỉ hạ được giới hạn
+	if got := (&Session{RateLimit: 10}).downloadRateLimit(); got != 2 {
+		t.Fatalf("rateLimit 10 under DownloadRateLimit 2 = %d", got)
+	}
+	if got := (&Session{RateLimit: 1}).downloadRateLimit(); got != 1 {
+		t.Fatalf("rateLimit 1 under DownloadRateLimit 2 = %d", got)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== REDIS BACKEND ==============
+
+// Client RESP2 tối giản (SET/GET/DEL/PING) viết tay để không thêm dependency. Mỗi lệnh mượn một
+// kết nối từ pool; kết nối lỗi mạng bị bỏ, lỗi do Redis trả về (-ERR) thì giữ lại.
+
+// RedisURL là redis://[user:password@]host:port/db (rediss:// cho TLS), đặt bằng flag --redis-url;
+// rỗng = không dùng Redis
+var RedisURL = ""
+
+const (
+	RedisKeyPrefix = "dmf:session:"  // Key của session là prefix + token
+	RedisTimeout   = 2 * time.Second // Timeout kết nối và mỗi lệnh
+	RedisPoolSize  = 16              // Số kết nối rảnh giữ lại
+)
+
+type redisClient struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config // nil = TCP thường
+	idle     chan *redisConn
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// redisError là lỗi Redis trả về trong reply, kết nối vẫn dùng tiếp được
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// parseRedisURL đọc RedisURL, chưa kết nối
+func parseRedisURL(raw string) (*redisClient, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisClient{idle: make(chan *redisConn, RedisPoolSize)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		c.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, errors.New("scheme must be redis or rediss")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("missing host")
+	}
+	c.addr = u.Host
+	if u.Port() == "" {
+		c.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+		if c.password == "" {
+			// redis://:password@host hoặc redis://password@host đều là mật khẩu của user mặc định
+			c.username, c.password = "", c.username
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil || c.db < 0 {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisClient) dial() (*redisConn, error) {
+	d := &net.Dialer{Timeout: RedisTimeout}
+	var conn net.Conn
+	var err error
+	if c.tls != nil {
+		conn, err = tls.DialWithDialer(d, "tcp", c.addr, c.tls)
+	} else {
+		conn, err = d.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rc := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.username != "" {
+			args = []string{"AUTH", c.username, c.password}
+		}
+		if _, err := rc.do(args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := rc.do("SELECT", strconv.Itoa(c.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do gửi một lệnh và trả reply: string, int64, []byte, []any hoặc nil
+func (c *redisClient) do(args ...string) (any, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.idle:
+	default:
+		var err error
+		if rc, err = c.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := rc.do(args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		rc.conn.Close()
+		return nil, err
+	}
+	select {
+	case c.idle <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+func (rc *redisConn) do(args ...string) (any, error) {
+	rc.conn.SetDeadline(time.Now().Add(RedisTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(rc.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return rc.readReply()
+}
+
+func (rc *redisConn) readReply() (any, error) {
+	line, err := rc.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err // $-1 là nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = rc.readReply(); err != nil {
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisBackend lưu bản ghi session dưới RedisKeyPrefix+token, hết hạn theo TTL của session;
+// bản ghi template không có TTL
+type redisBackend struct {
+	client *redisClient
+}
+
+func (b *redisBackend) put(token string, data []byte, expiresAt time.Time) error {
+	if expiresAt.IsZero() {
+		_, err := b.client.do("SET", RedisKeyPrefix+token, string(data))
+		return err
+	}
+	ttl := max(time.Until(expiresAt).Milliseconds(), 1)
+	_, err := b.client.do("SET", RedisKeyPrefix+token, string(data), "PX", strconv.FormatInt(ttl, 10))
+	return err
+}
+
+func (b *redisBackend) get(token string) ([]byte, error) {
+	reply, err := b.client.do("GET", RedisKeyPrefix+token)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := reply.([]byte)
+	return data, nil
+}
+
+func (b *redisBackend) remove(token string) error {
+	_, err := b.client.do("DEL", RedisKeyPrefix+token)
+	return err
+}
+
+func (b *redisBackend) shared() bool { return true }
+
+func (b *redisBackend) ping() error {
+	_, err := b.client.do("PING")
+	return err
+}
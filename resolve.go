@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ============== CREATE-TIME NAME RESOLUTION ==============
+
+// resolveNames resolve tên từng file bằng HEAD (hoặc GET 1 byte nếu origin không hỗ trợ HEAD)
+// với số request song song giới hạn, rồi đặt tên như lúc download (entryNamer).
+// Tên được lưu vào files[i].resolvedName (kèm dung lượng vào resolvedSize nếu origin báo);
+// file lỗi để trống và có warning name_unresolved. Lỗi khi nameConflict "error" gặp tên trùng
+func resolveNames(ctx context.Context, files []FileEntry, opts nameOptions) ([]string, []Warning, error) {
+	ctx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+	defer cancel()
+
+	raw := make([]string, len(files))
+	sizes := make([]int64, len(files))
+	errs := make([]error, len(files))
+
+	// Entry trùng nguồn (cùng sourceKey) chỉ probe một lần, các bản sau dùng lại kết quả
+	first := make(map[string]int, len(files))
+	dup := make([]int, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ResolveConcurrency)
+	for i, f := range files {
+		key := f.sourceKey()
+		if j, ok := first[key]; ok {
+			dup[i] = j
+			continue
+		}
+		first[key], dup[i] = i, i
+		wg.Add(1)
+		go func(i int, f FileEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			raw[i], sizes[i], errs[i] = resolveFileName(withForwardHeaders(ctx, f.headers), f.URL)
+		}(i, f)
+	}
+	wg.Wait()
+	for i, j := range dup {
+		raw[i], sizes[i], errs[i] = raw[j], sizes[j], errs[j]
+	}
+
+	names := make([]string, len(files))
+	namer := newEntryNamer(opts)
+	var warnings []Warning
+	for i := range files {
+		if errs[i] != nil {
+			index := i
+			warnings = append(warnings, Warning{
+				Code:    "name_unresolved",
+				Message: fmt.Sprintf("Could not resolve name (%v); it will be resolved at download time", errs[i]),
+				Index:   &index,
+			})
+			continue
+		}
+
+		name, err := namer.name(files[i], raw[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("File %d: %v", i+1, err)
+		}
+		names[i] = name
+		files[i].resolvedName = names[i]
+		files[i].resolvedSize = sizes[i]
+	}
+	return names, warnings, nil
+}
+
+// resolveFileName trả về tên file và dung lượng (0 nếu origin không báo)
+func resolveFileName(ctx context.Context, fileURL string) (string, int64, error) {
+	resp, size, err := probeSource(ctx, fileURL)
+	if err != nil {
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return "", 0, fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+	return fileNameFromResponse(resp.Header, fileURL), size, nil
+}
+
+// probeSource gửi HEAD (hoặc GET 1 byte nếu origin không hỗ trợ HEAD) và trả response đã đóng
+// body cùng dung lượng đầy đủ của file (0 nếu origin không báo hoặc status không thành công)
+func probeSource(ctx context.Context, fileURL string) (*http.Response, int64, error) {
+	req, err := newUpstreamRequest(ctx, http.MethodHead, fileURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp.Body.Close()
+
+	// Một số origin (presigned URL, CDN) không cho HEAD: thử GET 1 byte
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotImplemented {
+		req, err = newUpstreamRequest(ctx, http.MethodGet, fileURL)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1))
+		resp.Body.Close()
+	}
+
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusOK:
+		size = max(resp.ContentLength, 0)
+	case http.StatusPartialContent:
+		size = contentRangeTotal(resp.Header.Get("Content-Range"))
+	}
+	return resp, size, nil
+}
+
+// contentRangeTotal lấy tổng dung lượng từ "bytes 0-0/12345", 0 nếu không rõ ("*")
+func contentRangeTotal(v string) int64 {
+	i := strings.LastIndexByte(v, '/')
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.ParseInt(v[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
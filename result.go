@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ============== DOWNLOAD RESULT ==============
+
+// downloadReport là kết quả từng file của một download: ghi vào archive dạng manifest.json
+// (errorReport "json") và trả qua GET /result/{token}
+type downloadReport struct {
+	Status         string        `json:"status"` // completed, partial, aborted; qua /result còn có failed, client_disconnected
+	AbortReason    string        `json:"abort_reason,omitempty"`
+	FilesTotal     int           `json:"files_total"`
+	FilesCompleted int64         `json:"files_completed"`
+	FilesFailed    int64         `json:"files_failed"`
+	BytesWritten   int64         `json:"bytes_written"`
+	Files          []fileResult  `json:"files"`
+	Errors         []fileFailure `json:"errors,omitempty"`
+}
+
+// resultStatus gộp outcome của handleDownload với số file lỗi, dùng chung cho webhook và /result
+func resultStatus(outcome string, disconnected bool, failures int) string {
+	switch {
+	case disconnected:
+		return "client_disconnected"
+	case outcome == "cancelled":
+		return "cancelled"
+	case outcome != "completed":
+		return "failed"
+	case failures > 0:
+		return "partial"
+	}
+	return "completed"
+}
+
+// report chụp kết quả hiện tại của progress
+func (p *downloadProgress) report(status string) downloadReport {
+	return downloadReport{
+		Status:         status,
+		AbortReason:    p.getAbortReason(),
+		FilesTotal:     p.filesTotal,
+		FilesCompleted: p.filesCompleted.Load(),
+		FilesFailed:    p.filesFailed.Load(),
+		BytesWritten:   p.bytesWritten.Load(),
+		Files:          p.fileResults(),
+		Errors:         p.failureReport(),
+	}
+}
+
+// progressFromReport dựng lại progress đã kết thúc từ báo cáo đã lưu, cho artifact nạp lại sau restart
+func progressFromReport(rep downloadReport) *downloadProgress {
+	p := newDownloadProgress(rep.FilesTotal)
+	p.filesCompleted.Store(rep.FilesCompleted)
+	p.filesFailed.Store(rep.FilesFailed)
+	p.bytesWritten.Store(rep.BytesWritten)
+	p.abortReason = rep.AbortReason
+	p.failures = rep.Errors
+	for _, f := range rep.Files {
+		if f.Error == "" {
+			p.completed = append(p.completed, f)
+		}
+	}
+	return p
+}
+
+// writeManifest ghi downloadReport vào archive (errorReport "json"), thay cho ERRORS.txt
+func writeManifest(aw archiveWriter, name string, report downloadReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := aw.writeText(name, string(data)+"\n"); err != nil {
+		return err
+	}
+	return aw.Flush()
+}
+
+// resultResponse là kết quả của GET /result/{token}
+type resultResponse struct {
+	State string `json:"state"` // completed, failed hoặc expired, như /status
+	downloadReport
+}
+
+// handleResult trả báo cáo từng file của download gần nhất đã kết thúc trên token, giống
+// manifest.json trong archive. Trả 409 khi chưa có download nào kết thúc
+func handleResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.Trim(strings.TrimPrefix(r.URL.Path, "/result/"), "/")
+
+	status, serr := readStatus(r, token)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	progress, outcome, disconnected := status.progress, status.outcome, status.disconnected
+	if progress == nil || outcome == "" {
+		localizedError(w, r, http.StatusConflict, "result_pending")
+		return
+	}
+
+	resp := resultResponse{State: status.State}
+	resp.downloadReport = progress.report(resultStatus(outcome, disconnected, int(progress.filesFailed.Load())))
+	if outcome == "aborted" {
+		resp.Status = "aborted"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
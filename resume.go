@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== RESUMABLE STREAMS ==============
+
+// Archive resumable được sinh lại y hệt từng byte: entry Store theo đúng thứ tự, tên và dung lượng
+// cố định từ resolveNames, thời gian entry = lúc tạo session. Nhờ vậy có thể tính trước layout,
+// bỏ qua các entry client đã có mà không fetch lại, rồi stream tiếp phần còn lại với 206.
+
+// resumeRecord ghi lại một entry đã stream trọn vẹn, đủ để ghi lại central directory khi bỏ qua nó
+type resumeRecord struct {
+	Done bool
+	CRC  uint32
+	ETag string // ETag mạnh của nguồn, "" nếu nguồn không gửi
+	Mode os.FileMode
+}
+
+// resumePlan là kế hoạch của một lần download trên session resumable
+type resumePlan struct {
+	Total    int64  // Dung lượng cả archive
+	Offset   int64  // Byte đầu tiên cần gửi, 0 = gửi toàn bộ
+	Boundary int    // Entry đầu tiên cần fetch, các entry trước được bỏ qua
+	Partial  bool   // Client đã có một phần entry Boundary, nguồn phải khớp ETag
+	ETag     string // ETag của archive, dùng cho If-Range
+}
+
+// resumeError là lỗi khi không tiếp tục được từ offset client yêu cầu
+type resumeError struct {
+	Status  int
+	Message string
+}
+
+func (e *resumeError) Error() string { return e.Message }
+
+// validateResumable kiểm tra session resumable lúc tạo và đặt expectSize theo dung lượng đã resolve
+func validateResumable(files []FileEntry) error {
+	for i := range files {
+		f := &files[i]
+		if f.resolvedName == "" || f.resolvedSize <= 0 {
+			return fmt.Errorf("File %d: name and size must resolve at create time for resumable sessions", i+1)
+		}
+		if f.ExpectSize != nil && *f.ExpectSize != f.resolvedSize {
+			return fmt.Errorf("File %d: expectSize %d does not match resolved size %d", i+1, *f.ExpectSize, f.resolvedSize)
+		}
+		size := f.resolvedSize
+		f.ExpectSize = &size
+	}
+	return checkKnownSizes(files, nil)
+}
+
+// strongETag trả về ETag nếu là ETag mạnh, "" nếu thiếu hoặc yếu (W/)
+func strongETag(h http.Header) string {
+	etag := h.Get("ETag")
+	if strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+	return etag
+}
+
+// resumeEntry là entry của file trong archive resumable
+func resumeEntry(f FileEntry, mode os.FileMode, createdAt time.Time) zipEntry {
+	return zipEntry{Name: f.resolvedName, Mode: mode, Time: createdAt}
+}
+
+// rawEntryHeader dựng header giống hệt header archive/zip tạo khi stream (qua một Writer nháp),
+// kèm CRC và dung lượng đã biết để ghi bằng CreateRaw
+func rawEntryHeader(entry zipEntry, opts archiveOptions, crc uint32, size int64) (*zip.FileHeader, error) {
+	h := newEntryHeader(entry, opts)
+	if _, err := zip.NewWriter(io.Discard).CreateHeader(h); err != nil {
+		return nil, err
+	}
+	h.CRC32 = crc
+	h.CompressedSize64 = uint64(size)
+	h.UncompressedSize64 = uint64(size)
+	if size > math.MaxUint32 {
+		h.ReaderVersion = 45 // ZIP64, giống archive/zip khi đóng entry lớn
+	}
+	return h, nil
+}
+
+// entryLength là số byte của entry trong archive: local header + dữ liệu + data descriptor.
+// archive/zip của Go 1.27 (bản go.mod yêu cầu) chỉ ghi data descriptor ZIP64 (24 byte) khi dung
+// lượng vượt quá 4 GiB - 1, entry đúng 4 GiB - 1 byte vẫn dùng bản 16 byte; các bản cũ hơn đổi từ
+// đúng 4 GiB - 1. TestArchiveLayoutZip64Boundary so layout với writer thật quanh ngưỡng này
+func entryLength(h *zip.FileHeader) int64 {
+	size := int64(h.UncompressedSize64)
+	descriptor := int64(16)
+	if size > math.MaxUint32 {
+		descriptor = 24
+	}
+	return 30 + int64(len(h.Name)+len(h.Extra)) + size + descriptor
+}
+
+// writeRawEntry ghi entry đã biết CRC mà không có dữ liệu thật: các byte này nằm trước offset
+// của client nên bị skipWriter bỏ đi, chỉ cần đúng độ dài và central directory đúng
+func writeRawEntry(zw *zip.Writer, h *zip.FileHeader) error {
+	w, err := zw.CreateRaw(h)
+	if err != nil {
+		return err
+	}
+	var zeros [32 << 10]byte
+	for n := int64(h.UncompressedSize64); n > 0; {
+		chunk := min(n, int64(len(zeros)))
+		if _, err := w.Write(zeros[:chunk]); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// archiveLayout tính offset bắt đầu của từng entry (phần tử cuối là đầu central directory)
+// và tổng dung lượng archive bằng cách ghi thử vào bộ đếm
+func archiveLayout(files []FileEntry, records []resumeRecord, opts archiveOptions, createdAt time.Time) ([]int64, int64, error) {
+	counter := &sentCounter{w: io.Discard}
+	zw := zip.NewWriter(counter)
+	starts := make([]int64, len(files)+1)
+	var offset int64
+	for i, f := range files {
+		starts[i] = offset
+		h, err := rawEntryHeader(resumeEntry(f, records[i].Mode, createdAt), opts, records[i].CRC, f.resolvedSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := writeRawEntry(zw, h); err != nil {
+			return nil, 0, err
+		}
+		offset += entryLength(h)
+	}
+	starts[len(files)] = offset
+	if err := zw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return starts, counter.n, nil
+}
+
+// archiveETag nhận diện nội dung archive: đổi khi danh sách file, tên, dung lượng hoặc thời điểm tạo đổi
+func archiveETag(files []FileEntry, createdAt time.Time) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n", createdAt.UnixNano())
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\n%s\n%d\n", f.URL, f.resolvedName, f.resolvedSize)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// parseResumeRange chỉ nhận dạng "bytes=N-"; các dạng khác bị bỏ qua (trả về toàn bộ archive)
+func parseResumeRange(v string) (int64, bool) {
+	spec, ok := strings.CutPrefix(v, "bytes=")
+	if !ok {
+		return 0, false
+	}
+	start, ok := strings.CutSuffix(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// planResume tính layout và, nếu request có Range hợp lệ, entry bắt đầu fetch lại
+func planResume(r *http.Request, files []FileEntry, records []resumeRecord, opts archiveOptions, createdAt time.Time) (resumePlan, error) {
+	starts, total, err := archiveLayout(files, records, opts, createdAt)
+	if err != nil {
+		return resumePlan{}, err
+	}
+	plan := resumePlan{Total: total, ETag: archiveETag(files, createdAt)}
+
+	offset, ok := parseResumeRange(r.Header.Get("Range"))
+	if !ok || offset == 0 {
+		return plan, nil
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != plan.ETag {
+		return plan, nil
+	}
+	if offset >= total {
+		return plan, &resumeError{Status: http.StatusRequestedRangeNotSatisfiable, Message: fmt.Sprintf("Range starts beyond the archive (%d bytes)", total)}
+	}
+
+	boundary := len(files)
+	for i := range files {
+		if starts[i+1] > offset {
+			boundary = i
+			break
+		}
+	}
+	for i := 0; i < boundary; i++ {
+		if !records[i].Done {
+			return plan, &resumeError{Status: http.StatusPreconditionFailed, Message: fmt.Sprintf("files[%d] was never fully sent; cannot resume at byte %d", i, offset)}
+		}
+	}
+	plan.Offset = offset
+	plan.Boundary = boundary
+	plan.Partial = boundary < len(files) && offset > starts[boundary]
+	if plan.Partial && records[boundary].ETag == "" {
+		return plan, &resumeError{Status: http.StatusPreconditionFailed, Message: fmt.Sprintf("files[%d] has no strong ETag; cannot resume inside it", boundary)}
+	}
+	return plan, nil
+}
+
+// skipWriter bỏ qua skip byte đầu (client đã có), gọi start ngay trước byte đầu tiên thực sự gửi đi
+type skipWriter struct {
+	w     io.Writer
+	skip  int64
+	start func()
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if s.skip >= int64(n) {
+		s.skip -= int64(n)
+		return n, nil
+	}
+	p = p[s.skip:]
+	s.skip = 0
+	if s.start != nil {
+		s.start()
+		s.start = nil
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// writeResumeChanged trả 412 khi nguồn của entry đang dở đã đổi; chưa byte nào được gửi
+func writeResumeChanged(w http.ResponseWriter, index int) {
+	for _, h := range []string{"Content-Range", "Content-Disposition", "Accept-Ranges", "ETag"} {
+		w.Header().Del(h)
+	}
+	http.Error(w, fmt.Sprintf("files[%d] changed since it was first sent; download the archive again", index), http.StatusPreconditionFailed)
+}
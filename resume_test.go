@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"testing"
+	"time"
+)
+
+// sparseRecorder đếm byte đi qua và chỉ giữ 4 byte tại các offset cần kiểm tra cùng toàn bộ
+// phần từ tailFrom trở đi, để archive nhiều GiB không phải nằm trong bộ nhớ
+type sparseRecorder struct {
+	n        int64
+	at       map[int64][]byte
+	tailFrom int64
+	tail     bytes.Buffer
+}
+
+func newSparseRecorder(offsets []int64) *sparseRecorder {
+	s := &sparseRecorder{at: make(map[int64][]byte, len(offsets)), tailFrom: math.MaxInt64}
+	for _, o := range offsets {
+		s.at[o] = nil
+	}
+	return s
+}
+
+func (s *sparseRecorder) Write(p []byte) (int, error) {
+	end := s.n + int64(len(p))
+	for o, b := range s.at {
+		for k := int64(len(b)); k < 4 && o+k >= s.n && o+k < end; k++ {
+			b = append(b, p[o+k-s.n])
+		}
+		s.at[o] = b
+	}
+	if end > s.tailFrom {
+		s.tail.Write(p[max(s.tailFrom-s.n, 0):])
+	}
+	s.n = end
+	return len(p), nil
+}
+
+// streamArchive ghi files như download stream (archiveWriter, dữ liệu toàn số 0) và trả record
+// resume với CRC thật của từng entry
+func streamArchive(t *testing.T, w io.Writer, files []FileEntry, createdAt time.Time) []resumeRecord {
+	t.Helper()
+	archive := newArchiveWriter(w, archiveOptions{}, nil)
+	progress := newDownloadProgress(len(files))
+	records := make([]resumeRecord, len(files))
+	for i, f := range files {
+		ze := resumeEntry(f, defaultFileMode, createdAt)
+		ze.CRC = crc32.NewIEEE()
+		if err := archive.writeEntry(ze, f.resolvedSize, io.LimitReader(zeroReader{}, f.resolvedSize), progress); err != nil {
+			t.Fatal(err)
+		}
+		records[i] = resumeRecord{Done: true, CRC: ze.CRC.Sum32(), Mode: defaultFileMode}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+// rawArchive ghi lại files từ record như khi resume (writeRawEntry cho mọi entry)
+func rawArchive(t *testing.T, w io.Writer, files []FileEntry, records []resumeRecord, createdAt time.Time) {
+	t.Helper()
+	zw := zip.NewWriter(w)
+	for i, f := range files {
+		h, err := rawEntryHeader(resumeEntry(f, records[i].Mode, createdAt), archiveOptions{}, records[i].CRC, f.resolvedSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeRawEntry(zw, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveLayoutZip64Boundary kiểm tra layout tính trước khớp byte với archive/zip quanh ngưỡng
+// ZIP64 của data descriptor (4 GiB - 1): offset từng entry, đầu central directory, tổng dung lượng,
+// và central directory của bản resume giống hệt bản stream
+func TestArchiveLayoutZip64Boundary(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes several GiB of synthetic data")
+	}
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, size := range []int64{math.MaxUint32 - 1, math.MaxUint32, math.MaxUint32 + 1} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			files := []FileEntry{
+				{URL: "http://a/big", resolvedName: "big.bin", resolvedSize: size},
+				{URL: "http://a/small", resolvedName: "small.txt", resolvedSize: 3},
+			}
+			// CRC chỉ biết sau khi stream: đo bằng bộ đếm trước rồi so với layout
+			probe := &sentCounter{w: io.Discard}
+			records := streamArchive(t, probe, files, createdAt)
+
+			starts, total, err := archiveLayout(files, records, archiveOptions{}, createdAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != probe.n {
+				t.Fatalf("layout total = %d, streamed archive = %d", total, probe.n)
+			}
+
+			streamed := newSparseRecorder(starts)
+			streamed.tailFrom = starts[len(files)]
+			streamArchive(t, streamed, files, createdAt)
+			for i, o := range starts {
+				want := "PK\x03\x04"
+				if i == len(files) {
+					want = "PK\x01\x02"
+				}
+				if got := string(streamed.at[o]); got != want {
+					t.Fatalf("streamed archive at start %d (%d) = %q, want %q", i, o, got, want)
+				}
+			}
+
+			resumed := newSparseRecorder(nil)
+			resumed.tailFrom = starts[len(files)]
+			rawArchive(t, resumed, files, records, createdAt)
+			if resumed.n != total {
+				t.Fatalf("resumed archive = %d bytes, want %d", resumed.n, total)
+			}
+			if !bytes.Equal(resumed.tail.Bytes(), streamed.tail.Bytes()) {
+				t.Fatal("central directory of the resumed archive differs from the streamed one")
+			}
+		})
+	}
+}
+
+// TestArchiveLayoutManyEntries: hơn 65535 entry cần bản ghi cuối ZIP64
+func TestArchiveLayoutManyEntries(t *testing.T) {
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := make([]FileEntry, 70000)
+	for i := range files {
+		files[i] = FileEntry{URL: fmt.Sprintf("http://a/%d", i), resolvedName: fmt.Sprintf("f%05d.txt", i), resolvedSize: int64(i % 7)}
+	}
+	var buf bytes.Buffer
+	records := streamArchive(t, &buf, files, createdAt)
+
+	starts, total, err := archiveLayout(files, records, archiveOptions{}, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != int64(buf.Len()) {
+		t.Fatalf("layout total = %d, streamed archive = %d", total, buf.Len())
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(files) {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), len(files))
+	}
+	for i, f := range zr.File {
+		if off, err := f.DataOffset(); err != nil || off-starts[i] != int64(30+len(f.Name)) {
+			t.Fatalf("entry %d data at %d, layout start %d (%v)", i, off, starts[i], err)
+		}
+	}
+
+	var raw bytes.Buffer
+	rawArchive(t, &raw, files, records, createdAt)
+	if !bytes.Equal(raw.Bytes(), buf.Bytes()) {
+		t.Fatal("resumed archive differs from the streamed one")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ============== PER-FILE RETRIES ==============
+
+// DefaultRetryOn là các loại lỗi được retry khi file và request không khai báo retryOn, đặt bằng
+// flag --retry-on
+var DefaultRetryOn = []string{"5xx", "429", "timeout", "connection"}
+
+var retryClasses = map[string]bool{"5xx": true, "429": true, "timeout": true, "connection": true}
+
+// statusError là lỗi origin trả status không phải 200
+type statusError struct {
+	Code       int
+	Proto      string        // Giao thức đã đàm phán, ví dụ "HTTP/2.0"
+	RetryAfter time.Duration // Theo header Retry-After của 429/503, 0 = không có
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("bad status %d (%s)", e.Code, e.Proto)
+}
+
+// retryError giữ số lần đã thử để đưa vào báo cáo lỗi
+type retryError struct {
+	Err      error
+	Attempts int
+}
+
+func (e *retryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *retryError) Unwrap() error { return e.Err }
+
+type retryPolicy struct {
+	Retries int
+	Backoff time.Duration
+	On      []string
+}
+
+// retrySettings là cấu hình retry khai báo ở request (mặc định cho mọi file) hoặc ở từng file;
+// field để trống thì lấy từ cấp trên
+type retrySettings struct {
+	Retries      *int     `json:"retries,omitempty"`      // Số lần retry, ghi đè DefaultRetries (tối đa MaxRetries)
+	RetryBackoff string   `json:"retryBackoff,omitempty"` // Backoff ban đầu, ví dụ "2s", nhân đôi sau mỗi lần
+	RetryOn      []string `json:"retryOn,omitempty"`      // "5xx", "429", "timeout", "connection"
+}
+
+func (s retrySettings) validateRetry() error {
+	if s.Retries != nil && (*s.Retries < 0 || *s.Retries > MaxRetries) {
+		return fmt.Errorf("retries must be between 0 and %d", MaxRetries)
+	}
+	if s.RetryBackoff != "" {
+		d, err := time.ParseDuration(s.RetryBackoff)
+		if err != nil || d <= 0 || d > MaxRetryBackoff {
+			return fmt.Errorf("retryBackoff must be a duration between 0 and %v", MaxRetryBackoff)
+		}
+	}
+	return validateRetryOn(s.RetryOn)
+}
+
+func validateRetryOn(classes []string) error {
+	for _, class := range classes {
+		if !retryClasses[class] {
+			return fmt.Errorf("unknown retryOn value %q", class)
+		}
+	}
+	return nil
+}
+
+// over ghi các field đã khai báo của s lên p
+func (s retrySettings) over(p retryPolicy) retryPolicy {
+	if s.Retries != nil {
+		p.Retries = *s.Retries
+	}
+	if d, err := time.ParseDuration(s.RetryBackoff); err == nil && d > 0 {
+		p.Backoff = d
+	}
+	if s.RetryOn != nil {
+		p.On = s.RetryOn
+	}
+	return p
+}
+
+// retryPolicy áp dụng cấu hình của file, rồi của request (defaults), lên mặc định của server
+func (f FileEntry) retryPolicy(defaults retrySettings) retryPolicy {
+	p := retryPolicy{Retries: DefaultRetries, Backoff: DefaultRetryBackoff, On: DefaultRetryOn}
+	return f.retrySettings.over(defaults.over(p))
+}
+
+func (p retryPolicy) retries(err error) bool {
+	class := retryClass(err)
+	for _, c := range p.On {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter đọc Retry-After (số giây hoặc HTTP date) của response 429/503
+func retryAfter(resp *http.Response) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+func retryClass(err error) string {
+	var blocked *blockedTargetError
+	if errors.As(err, &blocked) {
+		return ""
+	}
+	var se *statusError
+	if errors.As(err, &se) {
+		switch {
+		case se.Code == http.StatusTooManyRequests:
+			return "429"
+		case se.Code >= 500:
+			return "5xx"
+		}
+		return ""
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return "timeout"
+	}
+	// Chỉ lỗi ở tầng kết nối mới là "connection"; lỗi khác (URL sai, nội dung không hợp lệ, vượt
+	// giới hạn...) retry cũng không khác
+	var oe *net.OpError
+	if errors.As(err, &oe) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return "connection"
+	}
+	return ""
+}
+
+// fetchWithRetry gọi getOriginalFileName với retry và backoff lũy thừa theo policy,
+// không chờ backoff vượt quá deadline của cả archive
+func fetchWithRetry(ctx context.Context, fileURL string, policy retryPolicy, hedge *entryHedge) (string, *http.Response, int, error) {
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		name, resp, err := getOriginalFileName(ctx, fileURL, hedge)
+		if err == nil {
+			return name, resp, attempt, nil
+		}
+		if ctx.Err() != nil || attempt > policy.Retries || !policy.retries(err) {
+			if attempt > 1 {
+				err = &retryError{Err: err, Attempts: attempt}
+			}
+			return "", nil, attempt, err
+		}
+		// Retry-After của origin thay cho backoff, nhưng không chờ quá MaxRetryBackoff
+		wait := backoff
+		var se *statusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			wait = se.RetryAfter
+		}
+		if wait > MaxRetryBackoff {
+			return "", nil, attempt, &retryError{Err: err, Attempts: attempt}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return "", nil, attempt, &retryError{Err: err, Attempts: attempt}
+		}
+
+		slog.InfoContext(ctx, "Retrying", "url", fileURL, "wait", wait.String(), "attempt", attempt+1, "attempts_max", policy.Retries+1, "error", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", nil, attempt, &retryError{Err: err, Attempts: attempt}
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, MaxRetryBackoff)
+	}
+}
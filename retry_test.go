@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestRetryClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&statusError{Code: 503}, "5xx"},
+		{&statusError{Code: 429}, "429"},
+		{&statusError{Code: 404}, ""},
+		{&statusError{Code: 401}, ""},
+		{context.DeadlineExceeded, "timeout"},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, "connection"},
+		{fmt.Errorf("read body: %w", syscall.ECONNRESET), "connection"},
+		{fmt.Errorf("copy: %w", io.ErrUnexpectedEOF), "connection"},
+		{&blockedTargetError{}, ""},
+		{errors.New("unsupported protocol scheme"), ""},
+		{fmt.Errorf("open: %w", os.ErrNotExist), ""},
+	} {
+		if got := retryClass(tc.err); got != tc.want {
+			t.Errorf("retryClass(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+// flakyOrigin trả status cho fails request GET đầu tiên rồi trả nội dung, đếm số GET
+func flakyOrigin(t *testing.T, status, fails int) (*httptest.Server, *atomic.Int64) {
+	var gets atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			return
+		}
+		if gets.Add(1) <= int64(fails) {
+			http.Error(w, "flaky", status)
+			return
+		}
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &gets
+}
+
+// Origin lỗi 503 hai lần rồi trả được: file có trong archive sau đúng 3 lần GET
+func TestRetryFlakyOrigin(t *testing.T) {
+	origin, gets := flakyOrigin(t, http.StatusServiceUnavailable, 2)
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"retries":3,"retryBackoff":"10ms"}`)
+
+	status, body := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil || len(zr.File) != 1 || zr.File[0].Name != "a.txt" {
+		t.Fatalf("archive = %v, want only a.txt", err)
+	}
+	if gets.Load() != 3 {
+		t.Fatalf("origin got %d GETs, want 3", gets.Load())
+	}
+}
+
+// 404 không được retry: một GET duy nhất, báo cáo không ghi số lần thử
+func TestRetryNonRetryable4xx(t *testing.T) {
+	origin, gets := flakyOrigin(t, http.StatusNotFound, 100)
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"},{"url":"`+origin.URL+`/b.txt"}],"retries":3,"retryBackoff":"10ms","retryOn":["5xx","429","timeout","connection"]}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	if gets.Load() != 2 {
+		t.Fatalf("origin got %d GETs, want one per file", gets.Load())
+	}
+	status, result := download(t, base+"/result/"+token)
+	if status != http.StatusOK || !strings.Contains(string(result), "bad status 404") || strings.Contains(string(result), "attempts") {
+		t.Fatalf("result = %d %s, want a single 404 attempt per file", status, result)
+	}
+}
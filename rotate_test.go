@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func rotate(t *testing.T, base, token string) (string, string) {
+	t.Helper()
+	var resp DownloadResponse
+	if err := json.Unmarshal(adminRequest(t, http.MethodPost, base+"/session/"+token+"/rotate", nil), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.DownloadURL, resp.DownloadURL[strings.LastIndex(resp.DownloadURL, "/")+1:]
+}
+
+// archiveFileOf trả file archive duy nhất trong SpoolDir và kiểm tra nó mang token
+func archiveFileOf(t *testing.T, token string) string {
+	t.Helper()
+	files := archiveFiles(t)
+	if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), token+"-archive-") {
+		t.Fatalf("archive files = %v, want one of %s", files, token)
+	}
+	return files[0]
+}
+
+// Rotate đổi tên file archive theo token mới nên restart vẫn phục vụ lại archive cũ mà không fetch lại
+func TestRotateRestart(t *testing.T) {
+	useDataDir(t)
+	adminKey := AdminKey
+	AdminKey = "test-admin-key"
+	t.Cleanup(func() { AdminKey = adminKey })
+	origin, hits := countingOrigin(t, nil)
+	base := newTestServer(t)
+	oldLink, oldToken := createPrebuilt(t, base, origin.URL, "/a.txt", "/b.txt")
+	waitArtifact(t, oldToken)
+	_, before := download(t, oldLink)
+	fetched := hits.Load()
+
+	link, token := rotate(t, base, oldToken)
+	path := archiveFileOf(t, token)
+	if rec := readRecord(t, token); rec.Artifact == nil || rec.Artifact.Path != path {
+		t.Fatalf("record artifact = %+v, want ready at %s", rec.Artifact, path)
+	}
+	if _, err := os.Stat(sessionFilePath(oldToken)); !os.IsNotExist(err) {
+		t.Fatalf("record of the old token still exists: %v", err)
+	}
+
+	restart(t)
+	if archiveFileOf(t, token) != path {
+		t.Fatal("archive file changed on restart")
+	}
+	status, after := download(t, link)
+	if status != http.StatusOK || !bytes.Equal(after, before) {
+		t.Fatalf("download after rotate and restart = %d, %d bytes, want the %d bytes from before", status, len(after), len(before))
+	}
+	if hits.Load() != fetched {
+		t.Fatalf("origin fetched %d times after restart", hits.Load()-fetched)
+	}
+	if status, _ := download(t, oldLink); status == http.StatusOK {
+		t.Fatal("old link still downloads after rotate")
+	}
+}
+
+// Rotate trong lúc dựng: file được đổi tên khi dựng xong
+func TestRotateWhileBuilding(t *testing.T) {
+	useDataDir(t)
+	adminKey := AdminKey
+	AdminKey = "test-admin-key"
+	t.Cleanup(func() { AdminKey = adminKey })
+	gate := make(chan struct{})
+	origin, _ := countingOrigin(t, gate)
+	base := newTestServer(t)
+	_, oldToken := createPrebuilt(t, base, origin.URL, "/a.txt")
+
+	link, token := rotate(t, base, oldToken)
+	close(gate)
+	waitArtifact(t, token)
+	mu.RLock()
+	a := sessions[token].artifact
+	mu.RUnlock()
+	if path := archiveFileOf(t, token); a.path != path || readRecord(t, token).Artifact.Path != path {
+		t.Fatalf("artifact at %s, record %+v, want both at %s", a.path, readRecord(t, token).Artifact, path)
+	}
+
+	restart(t)
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("download after restart = %d", status)
+	}
+	archiveFileOf(t, token)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============== SCHEDULED AVAILABILITY ==============
+
+type notYetAvailableResponse struct {
+	Error   string    `json:"error"`   // Không đổi theo ngôn ngữ
+	Message string    `json:"message"` // Theo Accept-Language
+	RetryAt time.Time `json:"retry_at"`
+}
+
+// isBeforeNotBefore cho phép client sớm hơn tối đa NotBeforeSkew để bù lệch đồng hồ
+func isBeforeNotBefore(notBefore, now time.Time) bool {
+	return !notBefore.IsZero() && now.Add(NotBeforeSkew).Before(notBefore)
+}
+
+// writeNotYetAvailable trả 403 kèm retry_at và Retry-After (giây, làm tròn lên)
+func writeNotYetAvailable(w http.ResponseWriter, r *http.Request, notBefore, now time.Time) {
+	wait := int(math.Ceil(notBefore.Sub(now).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(wait, 1)))
+	setLanguageHeaders(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(notYetAvailableResponse{
+		Error:   "Session is not available yet",
+		Message: localize(r, "not_yet_available"),
+		RetryAt: notBefore,
+	})
+}
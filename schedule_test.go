@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Trước notBefore, /status, /result và /preview trả 403 như download, và preview không chạm origin
+func TestNotBeforeStatusPreview(t *testing.T) {
+	origin, hits := countingOrigin(t, nil)
+	base := newTestServer(t)
+	notBefore := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"notBefore":"`+notBefore.Format(time.RFC3339)+`"}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+	fetched := hits.Load()
+
+	for _, path := range []string{"/download/", "/status/", "/result/", "/preview/"} {
+		resp, err := http.Get(base + path + token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body notYetAvailableResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if resp.StatusCode != http.StatusForbidden || err != nil || !body.RetryAt.Equal(notBefore) || retryAfter < 3500 {
+			t.Fatalf("%s before notBefore = %d, retry_at %v, Retry-After %q (%v); want 403 until %v", path, resp.StatusCode, body.RetryAt, resp.Header.Get("Retry-After"), err, notBefore)
+		}
+	}
+	if hits.Load() != fetched {
+		t.Fatalf("origin got %d requests during the embargo", hits.Load()-fetched)
+	}
+
+	mu.Lock()
+	sessions[token].NotBefore = time.Now().Add(-time.Second)
+	mu.Unlock()
+	if status, body := download(t, base+"/status/"+token); status != http.StatusOK || !strings.Contains(string(body), `"state":"pending"`) {
+		t.Fatalf("status after notBefore = %d %s", status, body)
+	}
+	if status, body := download(t, base+"/preview/"+token); status != http.StatusOK {
+		t.Fatalf("preview after notBefore = %d %s", status, body)
+	}
+	if hits.Load() == fetched {
+		t.Fatal("preview after notBefore did not check the source")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSessionLifecycleStress tạo, tải và cho hết hạn session đồng thời trong khi sweeper chạy
+// liên tục. Chạy với -race. Download đã nhận 200 phải trọn vẹn dù session hết hạn giữa chừng, các
+// lần tải khác chỉ được nhận 404 hoặc 410, và cuối cùng không còn session nào sót lại
+func TestSessionLifecycleStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("stress test")
+	}
+	const chunk = "0123456789"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Body chảy chậm để download vượt qua TTL của session
+		for range 5 {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	want := strings.Repeat(chunk, 5)
+
+	stop := make(chan struct{})
+	var sweeper sync.WaitGroup
+	sweeper.Add(1)
+	go func() {
+		defer sweeper.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			mu.Lock()
+			sweepExpiredLocked(time.Now())
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	const workers, rounds = 8, 10
+	var (
+		tokensMu sync.Mutex
+		tokens   []string
+		wg       sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				link, err := postCreate(base, `{"files":[{"url":"`+origin.URL+`/a.txt"}],"expiresIn":"30ms","maxDownloads":0}`)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				tokensMu.Lock()
+				tokens = append(tokens, link[strings.LastIndex(link, "/")+1:])
+				tokensMu.Unlock()
+
+				var downloads sync.WaitGroup
+				for i := range 3 {
+					downloads.Add(1)
+					go func() {
+						defer downloads.Done()
+						time.Sleep(time.Duration(i) * 15 * time.Millisecond)
+						checkStressDownload(t, link, want)
+					}()
+				}
+				downloads.Wait()
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	sweeper.Wait()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	sweepExpiredLocked(time.Now())
+	var left []string
+	for _, token := range tokens {
+		if _, ok := sessions[token]; ok {
+			left = append(left, token)
+		}
+	}
+	mu.Unlock()
+	if len(left) > 0 {
+		t.Fatalf("%d of %d sessions left after expiry: %v", len(left), len(tokens), left)
+	}
+}
+
+// checkStressDownload tải link và kiểm tra kết quả là archive đầy đủ hoặc token đã hết hiệu lực
+func checkStressDownload(t *testing.T, link, want string) {
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return
+	case resp.StatusCode != http.StatusOK:
+		t.Errorf("download = %d %q", resp.StatusCode, body)
+		return
+	case err != nil:
+		t.Errorf("download body: %v", err)
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Errorf("truncated archive (%d bytes): %v", len(body), err)
+		return
+	}
+	if len(zr.File) != 1 {
+		t.Errorf("archive has %d entries", len(zr.File))
+		return
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(got) != want {
+		t.Errorf("entry = %q (%v), want %q", got, err, want)
+	}
+}
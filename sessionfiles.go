@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============== SESSION FILE LIST ==============
+
+type AppendFilesRequest struct {
+	Files    []FileEntry `json:"files"`
+	Finalize bool        `json:"finalize"` // Đánh dấu danh sách đã đủ sau khi append
+}
+
+// RemoveFilesRequest chọn file cần bỏ theo chỉ số (bắt đầu từ 0, như index của warnings) hoặc URL chính xác
+type RemoveFilesRequest struct {
+	Indices []int    `json:"indices"`
+	URLs    []string `json:"urls"`
+}
+
+type SessionFilesResponse struct {
+	FilesTotal int       `json:"files_total"`
+	Open       bool      `json:"open"`
+	Removed    int       `json:"removed,omitempty"`
+	Warnings   []Warning `json:"warnings,omitempty"`
+}
+
+var (
+	errSessionStarted   = errors.New("A download has already started")
+	errSessionFinalized = errors.New("Session is finalized")
+)
+
+func handleSessionFiles(w http.ResponseWriter, r *http.Request, token string) {
+	switch r.Method {
+	case http.MethodPost:
+		handleAppendFiles(w, r, token)
+	case http.MethodDelete:
+		handleRemoveFiles(w, r, token)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAppendFiles(w http.ResponseWriter, r *http.Request, token string) {
+	keyName, ok := requireAPIKey(w, r)
+	if !ok {
+		return
+	}
+	body, err := createRequestBody(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedEncoding) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer body.Close()
+
+	var req AppendFilesRequest
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body too large (max %d bytes decoded)", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Files) == 0 && !req.Finalize {
+		http.Error(w, "No files provided", http.StatusBadRequest)
+		return
+	}
+	// Kiểm tra trước khi khóa store vì cần resolve DNS; số thứ tự file tính trong request này
+	if err := checkFileTargets(r.Context(), req.Files, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	session, status, err := editableSessionLocked(token)
+	if err != nil {
+		mu.Unlock()
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// Layout của archive resumable được cố định từ tên/dung lượng resolve lúc tạo
+	if session.Resumable && len(req.Files) > 0 {
+		mu.Unlock()
+		http.Error(w, "Cannot append files to a resumable session", http.StatusConflict)
+		return
+	}
+	if session.PartMaxBytes > 0 && len(req.Files) > 0 {
+		mu.Unlock()
+		http.Error(w, "Cannot append files to a session split into parts", http.StatusConflict)
+		return
+	}
+	if session.Disposition == "inline" && len(req.Files) > 0 {
+		mu.Unlock()
+		http.Error(w, "Cannot append files to an inline (single-file) session", http.StatusConflict)
+		return
+	}
+
+	start := len(session.Files)
+	if err := validateFiles(req.Files, start); err != nil {
+		mu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	normalizeWarnings, err := normalizeFiles(req.Files, start)
+	if err != nil {
+		mu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var dropWarnings []Warning
+	if session.Dedupe {
+		req.Files, dropWarnings = dropDuplicateFiles(session.Files, req.Files)
+	}
+	if start+len(req.Files) > MaxFilesPerSession {
+		mu.Unlock()
+		http.Error(w, fmt.Sprintf("Too many files (max %d per session)", MaxFilesPerSession), http.StatusBadRequest)
+		return
+	}
+
+	// Chỉ kiểm tra dung lượng biết trước (resolve lúc tạo, expectSize, minSize), không HEAD
+	if err := checkKnownSizes(append(session.Files[:start:start], req.Files...), nil); err != nil {
+		mu.Unlock()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session.Files = append(session.Files, req.Files...)
+	if req.Finalize {
+		session.Open = false
+		session.finalized = true
+	}
+	persistSessionLocked(session)
+	resp := SessionFilesResponse{
+		FilesTotal: len(session.Files),
+		Open:       session.Open,
+		Warnings:   append(append(dropWarnings, normalizeWarnings...), appendWarnings(session.Files, start)...),
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	slog.InfoContext(r.Context(), "Appended files", "token", token, "files", len(req.Files), "files_total", resp.FilesTotal, "finalized", req.Finalize, "api_key", keyName)
+}
+
+// handleRemoveFiles bỏ các file đã chọn; chỉ số ngoài phạm vi hoặc URL không còn trong session
+// được bỏ qua để gọi lại nhiều lần vẫn an toàn. Bỏ hết file thì session rỗng và download trả 409.
+// Session đã finalize thì danh sách đã chốt, không bỏ được nữa
+func handleRemoveFiles(w http.ResponseWriter, r *http.Request, token string) {
+	keyName, ok := requireAPIKey(w, r)
+	if !ok {
+		return
+	}
+	var req RemoveFilesRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxCreateBodyBytes)).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Indices) == 0 && len(req.URLs) == 0 {
+		http.Error(w, "No indices or urls provided", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	session, status, err := editableSessionLocked(token)
+	if err != nil {
+		mu.Unlock()
+		http.Error(w, err.Error(), status)
+		return
+	}
+	// Link của từng part đã được trả lúc tạo theo danh sách file hiện tại
+	if session.PartMaxBytes > 0 {
+		mu.Unlock()
+		http.Error(w, "Cannot remove files from a session split into parts", http.StatusConflict)
+		return
+	}
+
+	drop := make(map[int]bool)
+	for _, i := range req.Indices {
+		if i >= 0 && i < len(session.Files) {
+			drop[i] = true
+		}
+	}
+	urls := make(map[string]bool)
+	for _, u := range req.URLs {
+		urls[u] = true
+	}
+
+	kept := session.Files[:0:0]
+	for i, f := range session.Files {
+		if !drop[i] && !urls[f.URL] {
+			kept = append(kept, f)
+		}
+	}
+	removed := len(session.Files) - len(kept)
+	session.Files = kept
+	persistSessionLocked(session)
+	resp := SessionFilesResponse{
+		FilesTotal: len(kept),
+		Open:       session.Open,
+		Removed:    removed,
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	slog.InfoContext(r.Context(), "Removed files", "token", token, "files", removed, "files_total", resp.FilesTotal, "api_key", keyName)
+}
+
+func handleFinalize(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keyName, ok := requireAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	mu.Lock()
+	session, status, err := editableSessionLocked(token)
+	if err != nil && !errors.Is(err, errSessionFinalized) {
+		mu.Unlock()
+		http.Error(w, err.Error(), status)
+		return
+	}
+	session.Open = false
+	session.finalized = true
+	persistSessionLocked(session)
+	resp := SessionFilesResponse{FilesTotal: len(session.Files)}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	slog.InfoContext(r.Context(), "Finalized session", "token", token, "files_total", resp.FilesTotal, "api_key", keyName)
+}
+
+// editableSessionLocked lấy session còn sửa được danh sách file: còn hạn, chưa có download
+// bắt đầu và chưa finalize (errSessionFinalized vẫn trả kèm session). Phải giữ mu.Lock
+func editableSessionLocked(token string) (*Session, int, error) {
+	session, ok := sessions[token]
+	if !ok {
+		if t, gone := tombstones[token]; gone {
+			return nil, http.StatusGone, fmt.Errorf("Token is no longer valid (reason: %s)", t.Reason)
+		}
+		return nil, http.StatusNotFound, errors.New("Invalid or expired token")
+	}
+	if now := time.Now(); session.isExpired(now) {
+		expireSessionLocked(session, now)
+		return nil, http.StatusGone, errors.New("Session expired")
+	}
+	if session.started {
+		return session, http.StatusConflict, errSessionStarted
+	}
+	if session.finalized {
+		return session, http.StatusConflict, errSessionFinalized
+	}
+	return session, 0, nil
+}
+
+// appendWarnings là các cảnh báo của create áp dụng cho phần file mới từ vị trí start
+func appendWarnings(files []FileEntry, start int) []Warning {
+	var warnings []Warning
+	for _, warn := range createWarnings(files) {
+		if warn.Index == nil {
+			if start > ManyFilesWarning {
+				continue // many_files đã được báo trước đó
+			}
+		} else if *warn.Index < start {
+			continue
+		}
+		warnings = append(warnings, warn)
+	}
+	return warnings
+}
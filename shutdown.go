@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ============== GRACEFUL SHUTDOWN ==============
+
+// DrainTimeout là thời gian tối đa chờ các download đang chạy kết thúc khi nhận SIGINT/SIGTERM,
+// đặt bằng flag --drain-timeout. Hết hạn thì các kết nối còn lại bị cắt
+var DrainTimeout = 5 * time.Minute
+
+// ShutdownGrace là thời gian cho các download bị hủy sau DrainTimeout kết thúc gọn (ghi kết quả,
+// nhả claim, persist session) trước khi bị cắt, và cho các webhook cuối gửi xong trước khi thoát
+var ShutdownGrace = 10 * time.Second
+
+// shuttingDown bật khi bắt đầu drain: API tạo session trả 503 để load balancer chuyển sang instance khác
+var shuttingDown atomic.Bool
+
+// rejectDuringShutdown trả 503 khi server đang tắt
+func rejectDuringShutdown(w http.ResponseWriter, r *http.Request) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+	return true
+}
+
+// serveUntil chạy srv tới khi ctx bị hủy rồi drain: ngừng nhận kết nối mới, chờ download đang
+// chạy trong DrainTimeout, sau đó cắt phần còn lại
+func serveUntil(ctx context.Context, srv *http.Server) error {
+	errc := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errc <- srv.ListenAndServeTLS("", "") // Certificate lấy từ TLSConfig.GetCertificate
+		} else {
+			errc <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shuttingDown.Store(true)
+	slog.Info("Shutting down, draining in-flight downloads", "timeout", DrainTimeout.String())
+	drainCtx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+	err := srv.Shutdown(drainCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Hủy context của các download còn chạy để handler tự dừng qua luồng lỗi thường thay vì
+		// bị cắt giữa lúc ghi
+		slog.Warn("Drain timeout reached, cancelled in-flight downloads", "downloads", cancelAllDownloads())
+		graceCtx, cancelGrace := context.WithTimeout(context.Background(), ShutdownGrace)
+		defer cancelGrace()
+		if err = srv.Shutdown(graceCtx); errors.Is(err, context.DeadlineExceeded) {
+			slog.Warn("Closing remaining connections")
+			err = srv.Close()
+		}
+	}
+	if lerr := <-errc; !errors.Is(lerr, http.ErrServerClosed) {
+		return lerr
+	}
+	return err
+}
+
+// shutdownAbortReason là lý do abort của download bị hủy khi hết DrainTimeout
+const shutdownAbortReason = "server is shutting down"
+
+// cancelAllDownloads hủy mọi download đang chạy trên mọi session, trả số download đã hủy
+func cancelAllDownloads() int {
+	mu.Lock()
+	defer mu.Unlock()
+	cancelled := 0
+	for _, session := range sessions {
+		for id, d := range session.downloads {
+			d.cancel(nil)
+			delete(session.downloads, id)
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// waitForWebhooks chờ các webhook cuối (kết quả download, expired) gửi xong, tối đa ShutdownGrace
+func waitForWebhooks() {
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ShutdownGrace):
+		slog.Warn("Gave up waiting for pending webhooks")
+	}
+}
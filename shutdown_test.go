@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// SIGINT/SIGTERM (hủy ctx) giữa lúc tải: server ngừng nhận kết nối mới, /create trả 503, download
+// đang chạy vẫn được stream trọn vẹn rồi serveUntil mới trả về
+func TestShutdownDrainsInFlightDownload(t *testing.T) {
+	gate := make(chan struct{})
+	var started atomic.Bool
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "first half,")
+		w.(http.Flusher).Flush()
+		started.Store(true)
+		<-gate
+		io.WriteString(w, "second half")
+	}))
+	defer origin.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	handler := newServer(Config{})
+	srv := &http.Server{Addr: addr, Handler: handler}
+	base := "http://" + addr
+	allowPrivate, createRate, publicURL := AllowPrivateNetworks.Load(), CreateRateLimit, PublicURL
+	AllowPrivateNetworks.Store(true)
+	CreateRateLimit, PublicURL = 0, base
+	t.Cleanup(func() {
+		shuttingDown.Store(false)
+		AllowPrivateNetworks.Store(allowPrivate)
+		CreateRateLimit, PublicURL = createRate, publicURL
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(ctx, srv) }()
+	var link string
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if link, err = postCreate(base, `{"files":[{"url":"`+origin.URL+`/a.txt"}]}`); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	type result struct {
+		status int
+		body   []byte
+		err    error
+	}
+	downloaded := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(link)
+		if err != nil {
+			downloaded <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		downloaded <- result{resp.StatusCode, body, err}
+	}()
+	for !started.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	for !shuttingDown.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/create", strings.NewReader(`{"files":["`+origin.URL+`/b.txt"]}`)))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("create during shutdown = %d, want 503 with Retry-After", w.Code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("listener still accepts connections during the drain")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("serveUntil returned before the download finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(gate)
+	r := <-downloaded
+	if r.err != nil || r.status != http.StatusOK {
+		t.Fatalf("in-flight download = %d, %v", r.status, r.err)
+	}
+	if got := unzip(t, r.body)["a.txt"]; got != "first half,second half" {
+		t.Fatalf("a.txt = %q, want the whole file", got)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("serveUntil = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveUntil did not return after the drain")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ============== SIZE VALIDATION ==============
+
+func (f FileEntry) hasSizeCheck() bool {
+	return f.ExpectSize != nil || f.MinSize != nil || f.MaxSize != nil
+}
+
+func (f FileEntry) validateSize() error {
+	for _, v := range []*int64{f.ExpectSize, f.MinSize, f.MaxSize} {
+		if v != nil && *v < 0 {
+			return errors.New("sizes must not be negative")
+		}
+	}
+	for _, v := range []*int64{f.ExpectSize, f.MinSize} {
+		if v != nil && MaxFileBytes > 0 && *v > MaxFileBytes {
+			return fmt.Errorf("size exceeds the server limit of %d bytes per file", MaxFileBytes)
+		}
+	}
+	if f.MinSize != nil && f.MaxSize != nil && *f.MinSize > *f.MaxSize {
+		return errors.New("minSize is greater than maxSize")
+	}
+	return nil
+}
+
+// knownSize là dung lượng biết trước của file: đã resolve, expectSize hoặc minSize (cận dưới),
+// 0 = không rõ
+func (f FileEntry) knownSize() int64 {
+	switch {
+	case f.resolvedSize > 0:
+		return f.resolvedSize
+	case f.ExpectSize != nil:
+		return *f.ExpectSize
+	case f.MinSize != nil:
+		return *f.MinSize
+	}
+	return 0
+}
+
+// checkKnownSizes từ chối lúc tạo session các file có dung lượng biết trước vượt MaxFileBytes và
+// tổng dung lượng biết trước vượt MaxArchiveBytes. probed (có thể nil) là dung lượng lấy bằng
+// HEAD với PreflightSizes, dùng khi file chưa biết dung lượng
+func checkKnownSizes(files []FileEntry, probed []int64) error {
+	var total int64
+	for i, f := range files {
+		size := f.knownSize()
+		if size == 0 && probed != nil {
+			size = probed[i]
+		}
+		if MaxFileBytes > 0 && size > MaxFileBytes {
+			return fmt.Errorf("File %d: %d bytes exceeds the server limit of %d bytes per file", i+1, size, MaxFileBytes)
+		}
+		total += size
+	}
+	if MaxArchiveBytes > 0 && total > MaxArchiveBytes {
+		return fmt.Errorf("files total %d bytes, over the server limit of %d bytes per archive", total, MaxArchiveBytes)
+	}
+	return nil
+}
+
+// probeSizes lấy dung lượng các file chưa biết dung lượng bằng HEAD (GET 1 byte nếu origin không
+// hỗ trợ HEAD), song song như resolveNames. File lỗi hoặc không báo dung lượng được để 0: lúc
+// download vẫn bị giới hạn bằng limitBody
+func probeSizes(ctx context.Context, files []FileEntry) []int64 {
+	ctx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+	defer cancel()
+
+	sizes := make([]int64, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ResolveConcurrency)
+	for i, f := range files {
+		if f.knownSize() > 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, f FileEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if _, size, err := probeSource(withForwardHeaders(ctx, f.headers), f.URL); err == nil {
+				sizes[i] = size
+			}
+		}(i, f)
+	}
+	wg.Wait()
+	return sizes
+}
+
+// checkSize trả về mismatchError khi n không khớp expectSize hoặc nằm ngoài [minSize, maxSize]
+func (f FileEntry) checkSize(n int64) error {
+	switch {
+	case f.ExpectSize != nil && n != *f.ExpectSize:
+		return &mismatchError{What: "size", Expected: strconv.FormatInt(*f.ExpectSize, 10), Actual: strconv.FormatInt(n, 10)}
+	case f.MinSize != nil && n < *f.MinSize, f.MaxSize != nil && n > *f.MaxSize:
+		return &mismatchError{What: "size", Expected: f.sizeRange(), Actual: strconv.FormatInt(n, 10)}
+	}
+	return nil
+}
+
+func (f FileEntry) sizeRange() string {
+	switch {
+	case f.MinSize != nil && f.MaxSize != nil:
+		return fmt.Sprintf("%d-%d", *f.MinSize, *f.MaxSize)
+	case f.MinSize != nil:
+		return fmt.Sprintf(">=%d", *f.MinSize)
+	default:
+		return fmt.Sprintf("<=%d", *f.MaxSize)
+	}
+}
+
+// sizeLimit là số byte tối đa hợp lệ, -1 nếu không có giới hạn trên
+func (f FileEntry) sizeLimit() int64 {
+	switch {
+	case f.ExpectSize != nil:
+		return *f.ExpectSize
+	case f.MaxSize != nil:
+		return *f.MaxSize
+	}
+	return -1
+}
+
+// tooLargeError là file vượt MaxFileBytes hoặc archive vượt MaxArchiveBytes
+type tooLargeError struct {
+	What    string // "file" hoặc "archive"
+	Limit   int64
+	Partial bool // Vượt giới hạn giữa chừng: entry trong archive bị cắt cụt
+}
+
+func (e *tooLargeError) Error() string {
+	switch {
+	case e.Partial && e.What == "archive":
+		return fmt.Sprintf("truncated: archive size limit of %d bytes reached", e.Limit)
+	case e.Partial:
+		return fmt.Sprintf("truncated: file size limit is %d bytes", e.Limit)
+	case e.What == "archive":
+		return fmt.Sprintf("skipped: archive size limit of %d bytes reached", e.Limit)
+	}
+	return fmt.Sprintf("too large: file size limit is %d bytes", e.Limit)
+}
+
+// limitBody giới hạn body theo giới hạn chặt hơn giữa MaxFileBytes và phần còn lại của
+// MaxArchiveBytes (sau written byte), để origin báo sai hoặc không gửi Content-Length cũng bị cắt
+func limitBody(body io.Reader, written int64) io.Reader {
+	limit, err := int64(MaxFileBytes), &tooLargeError{What: "file", Limit: MaxFileBytes, Partial: true}
+	if MaxArchiveBytes > 0 {
+		if left := max(MaxArchiveBytes-written, 0); limit <= 0 || left < limit {
+			limit, err = left, &tooLargeError{What: "archive", Limit: MaxArchiveBytes, Partial: true}
+		}
+	}
+	if limit <= 0 && err.What == "file" {
+		return body
+	}
+	return &capReader{r: body, remaining: limit, err: err}
+}
+
+// capReader trả err khi body dài hơn remaining byte; phần vượt quá không được trả ra
+type capReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > c.remaining+1 {
+		p = p[:c.remaining+1]
+	}
+	n, err := c.r.Read(p)
+	if int64(n) > c.remaining {
+		n = int(c.remaining)
+		c.remaining = 0
+		return n, c.err
+	}
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// spoolForSizeCheck dùng khi origin không gửi Content-Length: ghi body ra file tạm (đọc tối đa
+// giới hạn + 1 byte) và chỉ trả về file khi dung lượng hợp lệ, để không ghi file thiếu vào archive
+func spoolForSizeCheck(spool *spoolReservation, entry FileEntry, body io.Reader) (*os.File, func(), error) {
+	size := entry.sizeLimit()
+	if size < 0 {
+		size = entry.resolvedSize
+	}
+	f, release, err := createSpoolFile(spool, "size", size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if limit := entry.sizeLimit(); limit >= 0 {
+		body = io.LimitReader(body, limit+1)
+	}
+	n, err := io.Copy(f, body)
+	if err == nil {
+		err = entry.checkSize(n)
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return f, release, nil
+}
+
+// countingReader đếm số byte thực sự đọc được, dùng để kiểm tra lại khi origin báo sai Content-Length
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// rawOrigin trả nguyên văn response (kể cả header sai) cho mọi kết nối rồi đóng kết nối
+func rawOrigin(t *testing.T, response string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				conn.Read(buf) // Request của client, bỏ qua
+				io.WriteString(conn, response)
+			}()
+		}
+	}()
+	return "http://" + ln.Addr().String()
+}
+
+func useSizeLimits(t *testing.T, file, archive int64) {
+	maxFile, maxArchive := MaxFileBytes, MaxArchiveBytes
+	MaxFileBytes, MaxArchiveBytes = file, archive
+	t.Cleanup(func() { MaxFileBytes, MaxArchiveBytes = maxFile, maxArchive })
+}
+
+// Content-Length đúng nhưng quá MaxFileBytes thì file không được stream. Origin báo Content-Length
+// sai: nhỏ hơn body thật (kèm chunked) thì vẫn bị cắt theo MaxFileBytes, lớn hơn body thật thì entry
+// (đã stream dở) được báo lỗi trong kết quả
+func TestLyingContentLength(t *testing.T) {
+	useSizeLimits(t, 100, 0)
+	body := strings.Repeat("x", 500)
+	under := rawOrigin(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\nContent-Length: 50\r\nConnection: close\r\n\r\n%x\r\n%s\r\n0\r\n\r\n", len(body), body))
+	over := rawOrigin(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 80\r\nConnection: close\r\n\r\nonly ten b")
+	big := rawOrigin(t, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body))
+	honest, _ := countingOrigin(t, nil)
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+under+`/under.txt"},{"url":"`+over+`/over.txt"},{"url":"`+big+`/big.txt"},{"url":"`+honest.URL+`/ok.txt"}]}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+
+	status, data := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+	entries := unzip(t, data)
+	if entries["ok.txt"] != "content of /ok.txt" {
+		t.Fatalf("entries = %v, want ok.txt intact", entries)
+	}
+	if _, ok := entries["big.txt"]; ok {
+		t.Fatal("big.txt was streamed despite a Content-Length over the limit")
+	}
+	if got, ok := entries["under.txt"]; ok && len(got) > 100 {
+		t.Fatalf("under.txt has %d bytes, over the 100 byte limit", len(got))
+	}
+
+	_, result := download(t, base+"/result/"+token)
+	report := string(result)
+	if !strings.Contains(report, `/under.txt","error":"truncated: file size limit is 100 bytes"`) || !strings.Contains(report, `/over.txt","error":"unexpected EOF"`) || !strings.Contains(report, `/big.txt","error":"too large: file size limit is 100 bytes"`) || !strings.Contains(report, `"files_failed":3`) {
+		t.Fatalf("result = %s, want under.txt truncated at the limit, over.txt failed short and big.txt skipped", report)
+	}
+}
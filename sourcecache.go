@@ -0,0 +1,321 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============== SOURCE CACHE ==============
+
+// Bản sao trên disk của file nguồn, dùng chung giữa các session. Response 200 của GET http(s)
+// (không Range) có ETag mạnh hoặc Last-Modified được lưu lại; lần fetch sau gửi If-None-Match /
+// If-Modified-Since và 304 được phục vụ từ disk. Origin luôn được hỏi nên quyền truy cập và nội
+// dung mới vẫn do origin quyết định; khóa gồm URL và header forward để nội dung theo credential
+// không lẫn giữa các client. Bỏ entry ít dùng nhất khi vượt SourceCacheMaxBytes.
+
+var (
+	SourceCacheDir            = ""       // --source-cache-dir, rỗng = tắt
+	SourceCacheMaxBytes int64 = 10 << 30 // --source-cache-max-bytes: tổng dung lượng của cache
+)
+
+var (
+	sourceCacheHits   atomic.Int64 // Fetch được phục vụ từ cache sau 304
+	sourceCacheStores atomic.Int64 // Bản sao mới được lưu
+
+	sourceCache *contentCache // nil khi tắt
+)
+
+// cacheMeta là {key}.json cạnh {key}.data, đủ để dựng lại response
+type cacheMeta struct {
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Size   int64       `json:"size"`
+}
+
+type cacheEntry struct {
+	key  string
+	meta cacheMeta
+	elem *list.Element
+}
+
+// contentCache là LRU theo byte trên thư mục dir. An toàn đồng thời
+type contentCache struct {
+	dir string
+	max int64
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // Front = dùng gần nhất
+	size    int64
+}
+
+// openSourceCache nạp cache từ SourceCacheDir lúc khởi động, thứ tự LRU theo mtime của file data
+// (cập nhật mỗi lần hit). File tạm, file thiếu cặp hoặc không khớp dung lượng bị xóa
+func openSourceCache() error {
+	if SourceCacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(SourceCacheDir, 0o700); err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(SourceCacheDir)
+	if err != nil {
+		return err
+	}
+	c := &contentCache{dir: SourceCacheDir, max: SourceCacheMaxBytes, entries: make(map[string]*cacheEntry), lru: list.New()}
+	used := make(map[string]time.Time)
+	for _, de := range dirEntries {
+		key, ok := strings.CutSuffix(de.Name(), ".json")
+		if !ok {
+			continue
+		}
+		var meta cacheMeta
+		data, err := os.ReadFile(c.path(key, ".json"))
+		if err == nil {
+			err = json.Unmarshal(data, &meta)
+		}
+		info, serr := os.Stat(c.path(key, ".data"))
+		if err != nil || serr != nil || info.Size() != meta.Size {
+			continue
+		}
+		c.entries[key] = &cacheEntry{key: key, meta: meta}
+		used[key] = info.ModTime()
+	}
+	for _, de := range dirEntries {
+		name := de.Name()
+		if c.entries[strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".data")] == nil {
+			os.Remove(filepath.Join(c.dir, name))
+		}
+	}
+
+	loaded := make([]*cacheEntry, 0, len(c.entries))
+	for _, e := range c.entries {
+		loaded = append(loaded, e)
+	}
+	slices.SortFunc(loaded, func(a, b *cacheEntry) int { return used[b.key].Compare(used[a.key]) })
+	for _, e := range loaded {
+		e.elem = c.lru.PushBack(e)
+		c.size += e.meta.Size
+	}
+	c.evictLocked()
+	sourceCache = c
+	slog.Info("Source cache loaded", "dir", c.dir, "files", len(c.entries), "bytes", c.size)
+	return nil
+}
+
+func (c *contentCache) path(key, ext string) string {
+	return filepath.Join(c.dir, key+ext)
+}
+
+// sourceCacheKey là băm của URL và header forward của request
+func sourceCacheKey(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\n" + headersFingerprint(req.Header)))
+	return hex.EncodeToString(sum[:])
+}
+
+// open mở bản sao của key, file đã mở vẫn đọc được nếu entry bị bỏ ngay sau đó
+func (c *contentCache) open(key string) (cacheMeta, *os.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil {
+		return cacheMeta{}, nil
+	}
+	f, err := os.Open(c.path(key, ".data"))
+	if err != nil {
+		c.removeLocked(e)
+		return cacheMeta{}, nil
+	}
+	return e.meta, f
+}
+
+// touch đánh dấu key vừa được dùng, cả trên disk để thứ tự LRU giữ qua restart
+func (c *contentCache) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e := c.entries[key]; e != nil {
+		c.lru.MoveToFront(e.elem)
+		now := time.Now()
+		os.Chtimes(c.path(key, ".data"), now, now)
+	}
+}
+
+// add đưa file tạm đã ghi xong vào cache dưới key, thay bản cũ nếu có
+func (c *contentCache) add(key, tmp string, meta cacheMeta) {
+	data, err := json.Marshal(meta)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[key]; old != nil {
+		c.removeLocked(old)
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path(key, ".data"))
+	}
+	if err == nil {
+		err = os.WriteFile(c.path(key, ".json"), data, 0o600)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		os.Remove(c.path(key, ".data"))
+		slog.Warn("Source cache write failed", "url", meta.URL, "error", err)
+		return
+	}
+	e := &cacheEntry{key: key, meta: meta}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	c.size += meta.Size
+	sourceCacheStores.Add(1)
+	c.evictLocked()
+}
+
+func (c *contentCache) removeLocked(e *cacheEntry) {
+	c.lru.Remove(e.elem)
+	delete(c.entries, e.key)
+	c.size -= e.meta.Size
+	os.Remove(c.path(e.key, ".json"))
+	os.Remove(c.path(e.key, ".data"))
+}
+
+func (c *contentCache) evictLocked() {
+	for c.size > c.max {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry))
+	}
+}
+
+// sourceCacheBytes là dung lượng đang dùng của cache, cho /metrics
+func sourceCacheBytes() int64 {
+	if sourceCache == nil {
+		return 0
+	}
+	sourceCache.mu.Lock()
+	defer sourceCache.mu.Unlock()
+	return sourceCache.size
+}
+
+// sourceCacheTransport đặt sourceCache trước transport tới origin
+type sourceCacheTransport struct {
+	next http.RoundTripper
+}
+
+func (t sourceCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := sourceCache
+	if c == nil || req.Method != http.MethodGet || req.Header.Get("Range") != "" || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+		return t.next.RoundTrip(req)
+	}
+	key := sourceCacheKey(req)
+	meta, f := c.open(key)
+	out := req
+	if f != nil {
+		out = req.Clone(req.Context())
+		if etag := strongETag(meta.Header); etag != "" {
+			out.Header.Set("If-None-Match", etag)
+		}
+		if lm := meta.Header.Get("Last-Modified"); lm != "" {
+			out.Header.Set("If-Modified-Since", lm)
+		}
+	}
+	resp, err := t.next.RoundTrip(out)
+	if f != nil && (err != nil || resp.StatusCode != http.StatusNotModified) {
+		f.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case f != nil && resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		c.touch(key)
+		sourceCacheHits.Add(1)
+		hit := syntheticResponse(req, http.StatusOK, meta.Header.Clone(), f)
+		hit.ContentLength = meta.Size
+		return hit, nil
+	case resp.StatusCode == http.StatusOK:
+		resp.Body = c.fill(key, req.URL.String(), resp)
+	}
+	return resp, nil
+}
+
+// fill trả body ghi thêm ra file tạm khi response lưu được: có ETag mạnh hoặc Last-Modified để
+// kiểm tra lại, không có Cache-Control no-store, vừa SourceCacheMaxBytes
+func (c *contentCache) fill(key, rawURL string, resp *http.Response) io.ReadCloser {
+	h := resp.Header
+	if strongETag(h) == "" && h.Get("Last-Modified") == "" || resp.ContentLength > c.max ||
+		strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-store") {
+		return resp.Body
+	}
+	f, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		slog.Warn("Source cache unavailable", "url", rawURL, "error", err)
+		return resp.Body
+	}
+	meta := cacheMeta{URL: rawURL, Header: h.Clone()}
+	meta.Header.Del("Set-Cookie")
+	return &cacheFill{ReadCloser: resp.Body, c: c, f: f, key: key, meta: meta, want: resp.ContentLength}
+}
+
+// cacheFill chép body ra file tạm khi được đọc. Chỉ body đọc trọn tới EOF (đúng Content-Length
+// nếu có) mới được lưu; lỗi đọc, lỗi ghi disk hay đóng sớm bỏ bản sao mà không ảnh hưởng download
+type cacheFill struct {
+	io.ReadCloser
+	c    *contentCache
+	f    *os.File // nil khi đã lưu hoặc đã bỏ
+	key  string
+	meta cacheMeta
+	want int64 // Content-Length, -1 = không rõ
+}
+
+func (b *cacheFill) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.f != nil && n > 0 {
+		if _, werr := b.f.Write(p[:n]); werr != nil || b.meta.Size+int64(n) > b.c.max {
+			b.discard()
+		} else {
+			b.meta.Size += int64(n)
+		}
+	}
+	switch {
+	case b.f == nil:
+	case errors.Is(err, io.EOF) && (b.want < 0 || b.meta.Size == b.want):
+		b.store()
+	case err != nil:
+		b.discard()
+	}
+	return n, err
+}
+
+func (b *cacheFill) Close() error {
+	b.discard()
+	return b.ReadCloser.Close()
+}
+
+func (b *cacheFill) store() {
+	name := b.f.Name()
+	err := b.f.Close()
+	b.f = nil
+	if err != nil {
+		os.Remove(name)
+		return
+	}
+	b.c.add(b.key, name, b.meta)
+}
+
+func (b *cacheFill) discard() {
+	if b.f != nil {
+		b.f.Close()
+		os.Remove(b.f.Name())
+		b.f = nil
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============== STORAGE SOURCES ==============
+
+// Ngoài http(s), entry có thể trỏ tới file:///đường/dẫn (trong LocalRoot), s3://bucket/key,
+// gs://bucket/key hoặc azblob://container/blob. Các scheme này được phục vụ bằng RoundTripper
+// riêng trả về http.Response, nên đặt tên, dedupe, giới hạn dung lượng, retry và báo lỗi chạy y
+// như với URL http.
+
+// LocalRoot là thư mục gốc của file://, đặt bằng flag --local-root; rỗng = tắt file://
+var LocalRoot = ""
+
+var localRoot *os.Root // Mở lúc khởi động; mọi đường dẫn được mở qua đây nên không thoát ra ngoài được
+
+// openLocalRoot mở LocalRoot, gọi một lần trước khi server chạy
+func openLocalRoot() error {
+	if LocalRoot == "" {
+		return nil
+	}
+	root, err := os.OpenRoot(LocalRoot)
+	if err != nil {
+		return fmt.Errorf("local-root: %v", err)
+	}
+	localRoot = root
+	return nil
+}
+
+// storageScheme là một nguồn ngoài http(s): transport fetch và điều kiện bật
+type storageScheme struct {
+	transport http.RoundTripper
+	enabled   func() bool
+	disabled  string // Lỗi trả về khi scheme chưa được bật
+}
+
+var storageSchemes = map[string]storageScheme{
+	"file": {localFileTransport{}, func() bool { return localRoot != nil },
+		"file:// sources are disabled (set --local-root)"},
+	"s3": {s3Transport{s3Config}, func() bool { return s3Config() != nil },
+		"s3:// sources are disabled (set AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION)"},
+	"gs": {s3Transport{gcsConfig}, func() bool { return gcsConfig() != nil },
+		"gs:// sources are disabled (set GCS_HMAC_ACCESS_KEY and GCS_HMAC_SECRET)"},
+	"azblob": {azureTransport{}, func() bool { return azureConfig() != nil },
+		"azblob:// sources are disabled (set AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN)"},
+}
+
+// isStorageURL cho biết URL dùng một scheme trong storageSchemes
+func isStorageURL(raw string) bool {
+	scheme, _, ok := strings.Cut(raw, "://")
+	_, known := storageSchemes[strings.ToLower(scheme)]
+	return ok && known
+}
+
+// normalizeStorageURL chuẩn hóa URL storage: scheme viết thường, path file được làm sạch.
+// Key của bucket phân biệt hoa thường và có thể chứa "..", nên giữ nguyên
+func normalizeStorageURL(u *url.URL) (string, error) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Fragment, u.RawFragment = "", ""
+	switch u.Scheme {
+	case "file":
+		if u.Host != "" && !strings.EqualFold(u.Host, "localhost") {
+			return "", errors.New("file URL must not name a host")
+		}
+		u.Host = ""
+		if u.RawQuery != "" {
+			return "", errors.New("file URL must not have a query")
+		}
+		if u.Path == "" || u.Path == "/" {
+			return "", errors.New("file URL has no path")
+		}
+	case "s3", "gs":
+		u.Host = strings.ToLower(u.Host)
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return "", fmt.Errorf("%s URL must be %s://bucket/key", u.Scheme, u.Scheme)
+		}
+	case "azblob":
+		u.Host = strings.ToLower(u.Host)
+		if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+			return "", errors.New("azblob URL must be azblob://container/blob")
+		}
+	}
+	return u.String(), nil
+}
+
+// checkStorageURL thay cho kiểm tra SSRF với URL storage: scheme phải được bật và
+// đường dẫn file không được chứa segment ".."
+func checkStorageURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if !schemeAllowed(u.Scheme) {
+		return &blockedTargetError{Target: raw, Reason: fmt.Sprintf("scheme %s is not in AllowedSchemes", u.Scheme)}
+	}
+	scheme, ok := storageSchemes[u.Scheme]
+	if !ok {
+		return &blockedTargetError{Target: raw, Reason: "only " + strings.Join(knownSchemes, ", ") + " are allowed"}
+	}
+	if !scheme.enabled() {
+		return &blockedTargetError{Target: raw, Reason: scheme.disabled}
+	}
+	if u.Scheme == "file" {
+		for _, seg := range strings.Split(u.Path, "/") {
+			if seg == ".." {
+				return &blockedTargetError{Target: raw, Reason: "path must not contain .."}
+			}
+		}
+	}
+	return nil
+}
+
+// storageTransport trả transport cho scheme storage, nil với http(s)
+func storageTransport(scheme string) http.RoundTripper {
+	if s, ok := storageSchemes[scheme]; ok {
+		return s.transport
+	}
+	return nil
+}
+
+// localFileTransport đọc file trong LocalRoot, hỗ trợ GET và HEAD
+type localFileTransport struct{}
+
+func (localFileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if localRoot == nil {
+		return nil, errors.New("file:// sources are disabled")
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return syntheticResponse(req, http.StatusMethodNotAllowed, nil, http.NoBody), nil
+	}
+	name := strings.TrimPrefix(path.Clean("/"+req.URL.Path), "/")
+	f, err := localRoot.Open(name)
+	if err != nil {
+		// os.Root trả lỗi khi đường dẫn (kể cả qua symlink) thoát ra ngoài LocalRoot
+		status := http.StatusForbidden
+		if errors.Is(err, fs.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		return syntheticResponse(req, status, nil, http.NoBody), nil
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		f.Close()
+		return syntheticResponse(req, http.StatusForbidden, nil, http.NoBody), nil
+	}
+
+	h := make(http.Header)
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	h.Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	var body io.ReadCloser = f
+	if req.Method == http.MethodHead {
+		f.Close()
+		body = http.NoBody
+	}
+	resp := syntheticResponse(req, http.StatusOK, h, body)
+	resp.ContentLength = info.Size()
+	return resp, nil
+}
+
+func syntheticResponse(req *http.Request, status int, h http.Header, body io.ReadCloser) *http.Response {
+	if h == nil {
+		h = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+// s3Settings là credential và endpoint của một API tương thích S3 (S3, MinIO, GCS XML API)
+type s3Settings struct {
+	accessKey, secretKey, sessionToken string
+	region                             string
+	endpoint                           string // Endpoint path-style (MinIO, GCS...); rỗng = AWS
+}
+
+// s3Config đọc cấu hình S3 từ môi trường, nil khi thiếu credential hoặc region
+func s3Config() *s3Settings {
+	c := &s3Settings{
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		region:       os.Getenv("AWS_REGION"),
+		endpoint:     strings.TrimRight(os.Getenv("AWS_ENDPOINT_URL_S3"), "/"),
+	}
+	if c.region == "" {
+		c.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.accessKey == "" || c.secretKey == "" || c.region == "" {
+		return nil
+	}
+	return c
+}
+
+// gcsConfig đọc HMAC key của GCS (chế độ interoperability). GCS XML API nhận chữ ký V4 kiểu S3
+// với region "auto", nên gs:// dùng chung s3Transport. GCS_ENDPOINT_URL thay endpoint (emulator)
+func gcsConfig() *s3Settings {
+	c := &s3Settings{
+		accessKey: os.Getenv("GCS_HMAC_ACCESS_KEY"),
+		secretKey: os.Getenv("GCS_HMAC_SECRET"),
+		region:    "auto",
+		endpoint:  cmp.Or(strings.TrimRight(os.Getenv("GCS_ENDPOINT_URL"), "/"), "https://storage.googleapis.com"),
+	}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil
+	}
+	return c
+}
+
+// storageHTTPTransport gửi request đã ký tới endpoint do operator cấu hình, nên không qua guard SSRF
+var storageHTTPTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// s3Transport chuyển bucket/key thành request REST tới API tương thích S3, ký Signature V4
+type s3Transport struct {
+	settings func() *s3Settings
+}
+
+func (t s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := t.settings()
+	if cfg == nil {
+		return nil, fmt.Errorf("%s:// sources are disabled", req.URL.Scheme)
+	}
+	bucket, key := req.URL.Host, strings.TrimPrefix(req.URL.Path, "/")
+	target := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, cfg.region, s3EscapePath(key))
+	if cfg.endpoint != "" {
+		target = fmt.Sprintf("%s/%s/%s", cfg.endpoint, bucket, s3EscapePath(key))
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		// Header x-amz-* không được ký sẽ bị S3 từ chối; Authorization do ta ký lại
+		if k != "Authorization" && !strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+			out.Header[k] = v
+		}
+	}
+	signS3Request(out, cfg, time.Now().UTC())
+
+	resp, err := storageHTTPTransport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+// s3EscapePath mã hóa key như SigV4 yêu cầu: mọi byte ngoài A-Z a-z 0-9 - _ . ~ thành %XX, giữ "/"
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', strings.IndexByte("-_.~/", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+var emptyPayloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signS3Request ký request không có body theo AWS Signature V4 (host, x-amz-content-sha256,
+// x-amz-date và x-amz-security-token nếu có)
+func signS3Request(req *http.Request, cfg *s3Settings, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if cfg.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", cfg.sessionToken)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") || lk == "range" {
+			signed[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	scope := day + "/" + cfg.region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + cfg.secretKey)
+	for _, part := range []string{day, cfg.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		cfg.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// azureSettings là tài khoản Azure Storage lấy từ biến môi trường
+type azureSettings struct {
+	account  string
+	key      []byte // AZURE_STORAGE_KEY đã giải base64; nil = dùng SAS token
+	sas      string // AZURE_STORAGE_SAS_TOKEN, không có "?" ở đầu
+	endpoint string // AZURE_STORAGE_BLOB_ENDPOINT (Azurite...); rỗng = https://{account}.blob.core.windows.net
+}
+
+// azureAPIVersion là x-ms-version gửi kèm request Shared Key
+const azureAPIVersion = "2021-08-06"
+
+// azureConfig đọc cấu hình Azure Blob từ môi trường, nil khi thiếu tài khoản hoặc credential.
+// Có cả key lẫn SAS token thì ký bằng key
+func azureConfig() *azureSettings {
+	c := &azureSettings{
+		account:  os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		sas:      strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+		endpoint: strings.TrimRight(os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT"), "/"),
+	}
+	if k := os.Getenv("AZURE_STORAGE_KEY"); k != "" {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil
+		}
+		c.key = key
+	}
+	if c.account == "" || (c.key == nil && c.sas == "") {
+		return nil
+	}
+	if c.endpoint == "" {
+		c.endpoint = "https://" + c.account + ".blob.core.windows.net"
+	}
+	return c
+}
+
+// azureTransport chuyển azblob://container/blob thành request tới Blob service, ký Shared Key
+// hoặc gắn SAS token
+type azureTransport struct{}
+
+func (azureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := azureConfig()
+	if cfg == nil {
+		return nil, errors.New("azblob:// sources are disabled")
+	}
+	container, blob := req.URL.Host, strings.TrimPrefix(req.URL.Path, "/")
+	target := fmt.Sprintf("%s/%s/%s", cfg.endpoint, container, s3EscapePath(blob))
+	if cfg.key == nil {
+		target += "?" + cfg.sas
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.Header {
+		// Header x-ms-* không được ký sẽ làm sai chữ ký; Authorization do ta ký lại
+		if k != "Authorization" && !strings.HasPrefix(strings.ToLower(k), "x-ms-") {
+			out.Header[k] = v
+		}
+	}
+	out.Header.Set("X-Ms-Version", azureAPIVersion)
+	if cfg.key != nil {
+		signAzureRequest(out, cfg, time.Now().UTC())
+	}
+
+	resp, err := storageHTTPTransport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+// signAzureRequest ký request không có body theo Shared Key của Blob service
+func signAzureRequest(req *http.Request, cfg *azureSettings, now time.Time) {
+	req.Header.Set("X-Ms-Date", now.Format(http.TimeFormat))
+
+	var names []string
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+	var canonical strings.Builder
+	for _, k := range names {
+		canonical.WriteString(k + ":" + strings.TrimSpace(strings.Join(req.Header.Values(k), ",")) + "\n")
+	}
+	canonical.WriteString("/" + cfg.account + req.URL.EscapedPath())
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := query[k]
+		sort.Strings(values)
+		canonical.WriteString("\n" + strings.ToLower(k) + ":" + strings.Join(values, ","))
+	}
+
+	h := req.Header.Get
+	toSign := strings.Join([]string{
+		req.Method,
+		h("Content-Encoding"),
+		h("Content-Language"),
+		"", // Content-Length: request không có body
+		h("Content-MD5"),
+		h("Content-Type"),
+		"", // Date: đã có x-ms-date
+		h("If-Modified-Since"),
+		h("If-Match"),
+		h("If-None-Match"),
+		h("If-Unmodified-Since"),
+		h("Range"),
+		canonical.String(),
+	}, "\n")
+	mac := hmac.New(sha256.New, cfg.key)
+	mac.Write([]byte(toSign))
+	req.Header.Set("Authorization", "SharedKey "+cfg.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
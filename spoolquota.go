@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+)
+
+// ============== SPOOL RESERVATIONS ==============
+
+var (
+	// Sổ đặt trước dung lượng spool, cùng khóa spoolMu với registry spoolActive
+	spoolJobs          = make(map[*spoolReservation]struct{}) // Download đang giữ phần đặt trước chưa dùng
+	spoolClaims        = make(map[string]spoolClaim)          // File spool -> phần đã đặt trước cho file đó
+	spoolReservedBytes int64
+)
+
+// spoolReservation là phần dung lượng một download đặt trước lúc bắt đầu (từ preflight).
+// File spool của download đó dùng phần này trước, thiếu mới đặt thêm.
+type spoolReservation struct {
+	token  string
+	unused int64
+}
+
+type spoolClaim struct {
+	token string
+	bytes int64
+}
+
+type spoolShortfallError struct {
+	Need      int64
+	Available int64
+}
+
+func (e *spoolShortfallError) Error() string {
+	return fmt.Sprintf("insufficient spool space: need %d bytes, %d available (short by %d)", e.Need, e.Available, e.Need-e.Available)
+}
+
+func spoolVolume() string {
+	if SpoolDir != "" {
+		return SpoolDir
+	}
+	return os.TempDir()
+}
+
+func withSpoolOverhead(n int64) int64 {
+	return int64(math.Ceil(float64(n) * SpoolReserveOverhead))
+}
+
+// spoolEstimate ước lượng dung lượng spool của một download: bản đầu tiên của mỗi URL lặp lại
+// (dedupe) và các file cần kiểm tra dung lượng mà preflight không thấy Content-Length.
+// Không có preflight (resolveNames) thì chỉ tính được phần có expectSize/maxSize.
+func spoolEstimate(files []FileEntry) int64 {
+	wanted := make(map[string]int)
+	for _, f := range files {
+		wanted[f.sourceKey()]++
+	}
+
+	var total int64
+	counted := make(map[string]bool)
+	for _, f := range files {
+		key := f.sourceKey()
+		switch {
+		case wanted[key] > 1 && !counted[key]:
+			counted[key] = true
+			total += f.resolvedSize
+		case f.hasSizeCheck() && f.resolvedSize == 0:
+			if limit := f.sizeLimit(); limit > 0 {
+				total += limit
+			}
+		}
+	}
+	return total
+}
+
+// availableSpoolLocked là dung lượng trống của volume trừ phần đã đặt trước nhưng chưa ghi
+// (byte đã ghi vào file spool đã nằm trong phần "đã dùng" của volume). -1 nếu không đo được. Phải giữ spoolMu
+func availableSpoolLocked() int64 {
+	free := freeDiskBytes(spoolVolume())
+	if free < 0 {
+		return -1
+	}
+	var written int64
+	for path, claim := range spoolClaims {
+		if info, err := os.Stat(path); err == nil {
+			written += min(info.Size(), claim.bytes)
+		}
+	}
+	return free + written - spoolReservedBytes
+}
+
+func reserveSpoolLocked(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if avail := availableSpoolLocked(); avail >= 0 && avail < n {
+		return &spoolShortfallError{Need: n, Available: avail}
+	}
+	spoolReservedBytes += n
+	return nil
+}
+
+// reserveSpool đặt trước estimate byte (cộng SpoolReserveOverhead) cho một download.
+// Trả về spoolShortfallError khi volume không đủ chỗ; luôn phải gọi close khi xong.
+func reserveSpool(token string, estimate int64) (*spoolReservation, error) {
+	r := &spoolReservation{token: token}
+	if estimate <= 0 {
+		return r, nil
+	}
+
+	n := withSpoolOverhead(estimate)
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+	if err := reserveSpoolLocked(n); err != nil {
+		return nil, err
+	}
+	r.unused = n
+	spoolJobs[r] = struct{}{}
+	return r, nil
+}
+
+// close trả lại phần đặt trước chưa dùng; phần của file spool được trả khi file bị xóa
+func (r *spoolReservation) close() {
+	spoolMu.Lock()
+	spoolReservedBytes -= r.unused
+	r.unused = 0
+	delete(spoolJobs, r)
+	spoolMu.Unlock()
+}
+
+// claimLocked gắn size byte (cộng overhead) cho file spool path. size <= 0 (không rõ) thì không đặt trước. Phải giữ spoolMu
+func (r *spoolReservation) claimLocked(path string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	n := withSpoolOverhead(size)
+	take := min(n, r.unused)
+	if err := reserveSpoolLocked(n - take); err != nil {
+		return err
+	}
+	r.unused -= take
+	spoolClaims[path] = spoolClaim{token: r.token, bytes: n}
+	return nil
+}
+
+func releaseSpoolClaimLocked(path string) {
+	if claim, ok := spoolClaims[path]; ok {
+		spoolReservedBytes -= claim.bytes
+		delete(spoolClaims, path)
+	}
+}
+
+// reconcileSpoolReservations bỏ các phần đặt trước không còn file tương ứng (download bị hủy
+// giữa chừng mà không kịp release, file bị sweeper hoặc bên ngoài xóa) và tính lại tổng từ sổ
+func reconcileSpoolReservations() {
+	spoolMu.Lock()
+	defer spoolMu.Unlock()
+
+	var dropped int
+	for path, claim := range spoolClaims {
+		if _, err := os.Stat(path); os.IsNotExist(err) || spoolActive[claim.token] == 0 {
+			delete(spoolClaims, path)
+			dropped++
+		}
+	}
+
+	var total int64
+	for r := range spoolJobs {
+		total += r.unused
+	}
+	for _, claim := range spoolClaims {
+		total += claim.bytes
+	}
+	if dropped > 0 || total != spoolReservedBytes {
+		slog.Info("Reconciled spool reservations", "claims_dropped", dropped, "reserved_before", spoolReservedBytes, "reserved", total)
+	}
+	spoolReservedBytes = total
+}
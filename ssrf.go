@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ============== SSRF GUARD ==============
+
+// Đặt bằng flag lúc khởi động. Host khớp chính host đó và mọi subdomain, ví dụ
+// {"cdn.example.com", "s3.amazonaws.com"}; áp dụng cho file, mirror, manifest, webhook và redirect
+var (
+	AllowedSchemes      = []string{"http", "https", "ftp", "file", "s3", "gs", "azblob"} // --allowed-schemes: các scheme storage còn cần được bật riêng
+	AllowedHostSuffixes = []string{}                                                     // --allowed-hosts: rỗng = mọi host
+	DeniedHostSuffixes  = []string{}                                                     // --denied-hosts: luôn bị chặn, kể cả khi khớp AllowedHostSuffixes
+)
+
+// AllowPrivateNetworks (--allow-private-networks) cho phép fetch tới loopback, link-local và dải
+// private, chỉ dùng khi tin cậy người gọi /create. Atomic vì goroutine dial của transport đọc nó
+var AllowPrivateNetworks atomic.Bool
+
+// knownSchemes là các scheme server biết fetch
+var knownSchemes = []string{"http", "https", "ftp", "file", "s3", "gs", "azblob"}
+
+func schemeAllowed(scheme string) bool {
+	return slices.Contains(AllowedSchemes, scheme)
+}
+
+// matchHostSuffix cho biết host (đã viết thường, bỏ dấu chấm cuối) khớp một phần tử của list
+func matchHostSuffix(host string, list []string) bool {
+	for _, suffix := range list {
+		suffix = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(suffix), "*"), ".")
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dải không phải unicast công cộng ngoài những gì netip.Addr phân loại sẵn
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // CGNAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // Reserved, gồm broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64 trỏ về IPv4 bất kỳ
+}
+
+// blockedTargetError là URL/địa chỉ bị chặn bởi SSRF guard, không được retry
+type blockedTargetError struct {
+	Target string
+	Reason string
+}
+
+func (e *blockedTargetError) Error() string {
+	return fmt.Sprintf("blocked target %s: %s", e.Target, e.Reason)
+}
+
+// guardedTransport là transport gốc cho mọi fetch ra ngoài: địa chỉ được kiểm tra lúc kết nối
+// (sau khi resolve DNS) nên hostname trỏ về IP nội bộ hay redirect vào mạng nội bộ đều bị chặn
+var guardedTransport = newGuardedTransport()
+
+func newGuardedTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   guardDial,
+	}
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+func guardDial(network, address string, _ syscall.RawConn) error {
+	if AllowPrivateNetworks.Load() {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return &blockedTargetError{Target: address, Reason: "unparseable address"}
+	}
+	if reason := blockedAddr(ap.Addr()); reason != "" {
+		return &blockedTargetError{Target: address, Reason: reason}
+	}
+	return nil
+}
+
+// blockedAddr trả lý do nếu addr không phải địa chỉ công cộng, "" nếu được phép
+func blockedAddr(addr netip.Addr) string {
+	addr = addr.Unmap()
+	switch {
+	case addr.IsLoopback():
+		return "loopback address"
+	case addr.IsLinkLocalUnicast(), addr.IsLinkLocalMulticast():
+		return "link-local address"
+	case addr.IsPrivate():
+		return "private address"
+	case addr.IsUnspecified(), addr.IsMulticast(), addr.IsInterfaceLocalMulticast():
+		return "non-unicast address"
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return "reserved address"
+		}
+	}
+	return ""
+}
+
+// checkTargetURL kiểm tra scheme và danh sách host, rồi (trừ khi AllowPrivateNetworks)
+// resolve host và từ chối nếu có địa chỉ không công cộng. Lỗi DNS không bị từ chối ở đây:
+// lúc fetch địa chỉ vẫn được kiểm tra lại.
+func checkTargetURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if err := checkTargetHost(u); err != nil {
+		return err
+	}
+	if AllowPrivateNetworks.Load() {
+		return nil
+	}
+
+	host := u.Hostname()
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if reason := blockedAddr(addr); reason != "" {
+			return &blockedTargetError{Target: raw, Reason: reason}
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, TargetLookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if reason := blockedAddr(addr); reason != "" {
+			return &blockedTargetError{Target: raw, Reason: fmt.Sprintf("%s resolves to %s (%s)", host, addr.Unmap(), reason)}
+		}
+	}
+	return nil
+}
+
+// checkTargetHost kiểm tra phần không cần DNS, dùng cả cho từng bước redirect
+func checkTargetHost(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "ftp" {
+		return &blockedTargetError{Target: u.String(), Reason: "only http, https and ftp are allowed"}
+	}
+	if !schemeAllowed(u.Scheme) {
+		return &blockedTargetError{Target: u.String(), Reason: fmt.Sprintf("scheme %s is not in AllowedSchemes", u.Scheme)}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return &blockedTargetError{Target: u.String(), Reason: "missing host"}
+	}
+	if matchHostSuffix(host, DeniedHostSuffixes) {
+		return &blockedTargetError{Target: u.String(), Reason: "host is in DeniedHostSuffixes"}
+	}
+	if len(AllowedHostSuffixes) == 0 || matchHostSuffix(host, AllowedHostSuffixes) {
+		return nil
+	}
+	return &blockedTargetError{Target: u.String(), Reason: "host is not in AllowedHostSuffixes"}
+}
+
+// checkRedirect kiểm tra lại mỗi bước redirect, giữ giới hạn 10 bước của http.Client và bỏ
+// header đã forward khi sang origin khác
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	stripForwardHeaders(req, via[0].URL)
+	return checkTargetHost(req.URL)
+}
+
+// checkFileTargets kiểm tra URL và mirror của các file; offset như validateFiles
+func checkFileTargets(ctx context.Context, files []FileEntry, offset int) error {
+	for i, f := range files {
+		for _, u := range f.sources() {
+			check := checkTargetURL
+			if isStorageURL(u) {
+				check = func(_ context.Context, u string) error { return checkStorageURL(u) }
+			}
+			if err := check(ctx, u); err != nil {
+				return fmt.Errorf("File %d: %v", offset+i+1, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestBlockedAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"10.0.0.1", true},
+		{"172.16.5.4", true},
+		{"192.168.1.1", true},
+		{"fd00::1", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"64:ff9b::7f00:1", true},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if reason := blockedAddr(netip.MustParseAddr(tt.addr)); (reason != "") != tt.blocked {
+			t.Errorf("blockedAddr(%s) = %q, want blocked %v", tt.addr, reason, tt.blocked)
+		}
+	}
+}
+
+// guardPrivate tắt AllowPrivateNetworks trong test
+func guardPrivate(t *testing.T) {
+	allow := AllowPrivateNetworks.Swap(false)
+	t.Cleanup(func() { AllowPrivateNetworks.Store(allow) })
+}
+
+// Hostname trỏ về 127.0.0.1 bị chặn lúc tạo session, và lúc kết nối dù đã qua được kiểm tra trước
+// đó (DNS rebinding: lần resolve sau trả địa chỉ khác lần đầu)
+func TestGuardHostnameResolvingToLoopback(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer origin.Close()
+	guardPrivate(t)
+	target := strings.Replace(origin.URL, "127.0.0.1", "localhost", 1) + "/secret"
+
+	var blocked *blockedTargetError
+	if err := checkTargetURL(context.Background(), target); !errors.As(err, &blocked) {
+		t.Fatalf("checkTargetURL(%s) = %v, want blocked", target, err)
+	}
+
+	// Transport không dựa vào kiểm tra lúc tạo: địa chỉ được xét lại khi dial
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	resp, err := guardedTransport.RoundTrip(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("fetch of %s succeeded with status %d", target, resp.StatusCode)
+	}
+	if !errors.As(err, &blocked) {
+		t.Fatalf("fetch of %s = %v, want blocked target", target, err)
+	}
+}
+
+// Origin chỉ được phép lúc tạo session rồi bị chặn lúc tải: file lỗi đi vào ERRORS.txt, archive
+// vẫn trọn vẹn với các file còn lại
+func TestGuardRebindingAtDownload(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "internal")
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/secret.txt"}]}`)
+	guardPrivate(t)
+
+	status, body := download(t, link)
+	if status != http.StatusOK {
+		t.Fatalf("download = %d: %s", status, body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report string
+	for _, f := range zr.File {
+		if f.Name == "secret.txt" {
+			t.Fatal("blocked file was fetched")
+		}
+		if f.Name == "ERRORS.txt" {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			report = string(data)
+		}
+	}
+	if !strings.Contains(report, "blocked target") {
+		t.Fatalf("ERRORS.txt = %q, want the blocked target", report)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============== DOWNLOAD STATUS ==============
+
+// statusResponse là kết quả của GET /status/{token}
+type statusResponse struct {
+	State string `json:"state"` // pending, in_progress, completed, failed hoặc expired
+	progressSnapshot
+	AbortReason  string        `json:"abort_reason,omitempty"`
+	ArchiveBytes int64         `json:"archive_bytes,omitempty"` // Content-Length của archive đã dựng (resumableMode "file")
+	Deduplicated []fileResult  `json:"deduplicated,omitempty"`  // Entry lấy lại nội dung của entry trùng URL trong download gần nhất
+	Errors       []fileFailure `json:"errors,omitempty"`        // File lỗi của download gần nhất tới lúc này
+	ExpiresAt    *time.Time    `json:"expires_at,omitempty"`    // Chỉ có khi token còn hiệu lực
+
+	progress     *downloadProgress // Download gần nhất, cho /result
+	outcome      string
+	disconnected bool
+}
+
+// watchProgress gắn progress của download mới vào session cho /status và /result, trả hàm ghi
+// kết quả khi download kết thúc
+func (s *Session) watchProgress(progress *downloadProgress) func(outcome string, disconnected bool) {
+	mu.Lock()
+	s.progress, s.progressOutcome, s.progressDisconnected = progress, "", false
+	mu.Unlock()
+	return func(outcome string, disconnected bool) {
+		mu.Lock()
+		if s.progress == progress {
+			s.progressOutcome, s.progressDisconnected = outcome, disconnected
+		}
+		mu.Unlock()
+	}
+}
+
+// statusLookupError là lỗi tra token của /status, trả bằng localizedError
+type statusLookupError struct {
+	status    int
+	key       string
+	args      []any
+	notBefore time.Time // Khác zero khi session chưa tới notBefore
+}
+
+// write trả lỗi cho client; session chưa tới notBefore trả như download (retry_at, Retry-After)
+func (e *statusLookupError) write(w http.ResponseWriter, r *http.Request) {
+	if !e.notBefore.IsZero() {
+		writeNotYetAvailable(w, r, e.notBefore, time.Now())
+		return
+	}
+	localizedError(w, r, e.status, e.key, e.args...)
+}
+
+// handleStatus trả tiến độ của download gần nhất trên token. Token đã tải xong/hết hạn
+// vẫn trả kết quả cuối trong TombstoneRetention.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, sub, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/status/"), "/"), "/")
+	switch sub {
+	case "":
+	case "stream":
+		handleStatusStream(w, r, token)
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	resp, serr := readStatus(r, token)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// readStatus dựng statusResponse của token, áp dụng allowedCIDRs và notBefore như download. Giữ mu.Lock vì
+// lần đọc gia hạn session slidingTTL
+func readStatus(r *http.Request, token string) (statusResponse, *statusLookupError) {
+	syncSharedSession(token)
+
+	now := time.Now()
+	mu.Lock()
+	session, alive := sessions[token]
+	t, gone := tombstones[token]
+	if !alive && gone {
+		session = t.session
+	}
+	if session == nil {
+		mu.Unlock()
+		if gone {
+			return statusResponse{}, &statusLookupError{status: http.StatusGone, key: "token_gone", args: []any{t.Reason}}
+		}
+		return statusResponse{}, &statusLookupError{status: http.StatusNotFound, key: "invalid_token"}
+	}
+	if !statusNetworkAllowed(r, session) {
+		mu.Unlock()
+		return statusResponse{}, &statusLookupError{status: http.StatusForbidden, key: "forbidden_network"}
+	}
+	// Trước notBefore không lộ gì về session, kể cả tiến độ của archive prebuild
+	if notBefore := session.NotBefore; alive && isBeforeNotBefore(notBefore, now) {
+		mu.Unlock()
+		return statusResponse{}, &statusLookupError{status: http.StatusForbidden, key: "not_yet_available", notBefore: notBefore}
+	}
+	progress, outcome, disconnected := session.progress, session.progressOutcome, session.progressDisconnected
+	filesTotal := len(session.Files)
+	archiveBytes := session.artifactSize()
+	expired := (!alive && t.Reason == "expired") || (alive && session.isExpired(now))
+	var expiresAt *time.Time
+	if alive && !expired {
+		session.touch(now) // Xem tiến độ cũng là truy cập link, gia hạn session slidingTTL
+	}
+	if alive {
+		e := session.expiresAt()
+		expiresAt = &e
+	}
+	mu.Unlock()
+
+	resp := statusResponse{ExpiresAt: expiresAt, ArchiveBytes: archiveBytes, progress: progress, outcome: outcome, disconnected: disconnected}
+	if progress != nil {
+		resp.progressSnapshot = progress.snapshot()
+		resp.AbortReason = progress.getAbortReason()
+		resp.Deduplicated = progress.deduplicatedFiles()
+		resp.Errors = progress.failureReport()
+	} else {
+		resp.FilesTotal = filesTotal
+	}
+	switch {
+	case progress != nil && outcome == "":
+		resp.State = "in_progress"
+	case outcome == "completed":
+		resp.State = "completed"
+	case expired:
+		resp.State = "expired"
+	case outcome == "cancelled":
+		resp.State = "cancelled"
+	case outcome != "":
+		resp.State = "failed"
+	default:
+		resp.State = "pending"
+	}
+	return resp, nil
+}
+
+// handleStatusStream gửi statusResponse dạng Server-Sent Events (event "status") mỗi
+// StatusStreamInterval khi có thay đổi. Kết thúc khi state là completed hoặc expired, khi token
+// không còn tra được (event "error") hoặc khi server tắt
+func handleStatusStream(w http.ResponseWriter, r *http.Request, token string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	resp, serr := readStatus(r, token)
+	if serr != nil {
+		serr.write(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // Tắt buffer của nginx
+	ticker := time.NewTicker(StatusStreamInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	var sentAt time.Time
+	for {
+		data, _ := json.Marshal(resp)
+		if !bytes.Equal(data, last) {
+			fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+			last, sentAt = data, time.Now()
+		} else if time.Since(sentAt) >= StatusStreamKeepAlive {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			sentAt = time.Now()
+		}
+		flusher.Flush()
+		if resp.State == "completed" || resp.State == "expired" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if shuttingDown.Load() {
+			return
+		}
+		if resp, serr = readStatus(r, token); serr != nil {
+			data, _ := json.Marshal(map[string]string{"error": localize(r, serr.key, serr.args...)})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+	}
+}
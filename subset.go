@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ============== SUBSET SELECTION ==============
+
+// SubsetDownloadsCount (--subset-downloads-count) quyết định download một phần (?only=, ?match=) có
+// tiêu thụ session như download đầy đủ không
+var SubsetDownloadsCount = true
+
+// selectFiles lọc danh sách file theo ?only= (chỉ số bắt đầu từ 1, hỗ trợ khoảng "2-5")
+// và ?match= (glob trên tên file trong URL). Trả về subset = true nếu có tham số lọc.
+func selectFiles(files []FileEntry, q url.Values) ([]FileEntry, bool, error) {
+	only := q.Get("only")
+	pattern := q.Get("match")
+	if only == "" && pattern == "" {
+		return files, false, nil
+	}
+
+	selected := make([]bool, len(files))
+	if only == "" {
+		for i := range selected {
+			selected[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(only, ",") {
+			lo, hi, err := parseIndexRange(strings.TrimSpace(part))
+			if err != nil {
+				return nil, true, fmt.Errorf("invalid only=%q: %v", only, err)
+			}
+			if lo < 1 || hi > len(files) {
+				return nil, true, fmt.Errorf("index out of range in only=%q, valid range is 1-%d", only, len(files))
+			}
+			for i := lo; i <= hi; i++ {
+				selected[i-1] = true
+			}
+		}
+	}
+
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, true, fmt.Errorf("invalid match pattern %q", pattern)
+		}
+		for i, f := range files {
+			if selected[i] {
+				name := urlBaseName(f.URL)
+				if f.Name != "" {
+					name = path.Base(f.Name)
+				}
+				ok, _ := path.Match(pattern, name)
+				selected[i] = ok
+			}
+		}
+	}
+
+	var result []FileEntry
+	for i, f := range files {
+		if selected[i] {
+			result = append(result, f)
+		}
+	}
+	if len(result) == 0 {
+		return nil, true, fmt.Errorf("no files match the selection")
+	}
+	return result, true, nil
+}
+
+func parseIndexRange(s string) (int, int, error) {
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	lo, err := strconv.Atoi(loStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad index %q", s)
+	}
+	if !isRange {
+		return lo, lo, nil
+	}
+	hi, err := strconv.Atoi(hiStr)
+	if err != nil || hi < lo {
+		return 0, 0, fmt.Errorf("bad range %q", s)
+	}
+	return lo, hi, nil
+}
+
+func urlBaseName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubsetDownloadsCount(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	if cfg, err := parseConfig(t, map[string]string{"SUBSET_DOWNLOADS_COUNT": "false"}); err != nil || cfg.SubsetCounts {
+		t.Fatalf("env SUBSET_DOWNLOADS_COUNT=false: %v, %v", cfg.SubsetCounts, err)
+	}
+	if cfg, err := parseConfig(t, nil); err != nil || !cfg.SubsetCounts {
+		t.Fatalf("default: %v, %v", cfg.SubsetCounts, err)
+	}
+	counts := SubsetDownloadsCount
+	t.Cleanup(func() { SubsetDownloadsCount = counts })
+	body := `{"files":[{"url":"` + origin.URL + `/a.txt"},{"url":"` + origin.URL + `/b.txt"}]}`
+
+	for _, tt := range []struct {
+		counts bool
+		full   int
+	}{
+		{true, http.StatusGone},
+		{false, http.StatusOK},
+	} {
+		SubsetDownloadsCount = tt.counts
+		link := createSession(t, base, body)
+		if status, _ := download(t, link+"?only=1"); status != http.StatusOK {
+			t.Fatalf("counts %v: subset download = %d", tt.counts, status)
+		}
+		if status, _ := download(t, link); status != tt.full {
+			t.Fatalf("counts %v: full download after a subset = %d, want %d", tt.counts, status, tt.full)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============== TEMPLATES ==============
+
+// Template lưu sẵn danh sách file và tùy chọn mặc định theo schema của /create. Khi có backend,
+// toàn bộ template được ghi thành một bản ghi templatesRecordKey ({DataDir}/templates.json hoặc
+// key Redis) và nạp lại lúc khởi động; với backend chung, bản trong bộ nhớ được làm mới từ bản
+// ghi trước mỗi lần dùng nên mọi instance thấy cùng một danh sách.
+type Template = DownloadRequest
+
+// templatesRecordKey là key của bản ghi template trong backend, không trùng được token (UUID)
+const templatesRecordKey = "templates"
+
+// persistedTemplates là bản ghi template trong backend
+type persistedTemplates struct {
+	V         int                 `json:"v"`
+	Templates map[string]Template `json:"templates"`
+}
+
+type TemplateSummary struct {
+	Name  string `json:"name"`
+	Files int    `json:"files"`
+}
+
+var (
+	templatesMu sync.RWMutex
+	templates   = make(map[string]Template)
+
+	templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+)
+
+// loadTemplates nạp template từ backend, gọi lúc khởi động sau openSessionBackend
+func loadTemplates() error {
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	return loadTemplatesLocked()
+}
+
+// loadTemplatesLocked thay templates bằng bản ghi trong backend, giữ nguyên khi không có backend.
+// Phải giữ templatesMu.Lock
+func loadTemplatesLocked() error {
+	if backend == nil {
+		return nil
+	}
+	data, err := backend.get(templatesRecordKey)
+	if err != nil {
+		return err
+	}
+	rec := persistedTemplates{Templates: make(map[string]Template)}
+	if data != nil {
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return err
+		}
+		if rec.V != ExportSchemaVersion {
+			return fmt.Errorf("unsupported schema version %d", rec.V)
+		}
+	}
+	templates = rec.Templates
+	if templates == nil {
+		templates = make(map[string]Template)
+	}
+	return nil
+}
+
+// syncSharedTemplates làm mới templates từ backend chung, chỉ log khi lỗi. Gọi khi không giữ templatesMu
+func syncSharedTemplates() {
+	if !sharedBackend() {
+		return
+	}
+	templatesMu.Lock()
+	defer templatesMu.Unlock()
+	if err := loadTemplatesLocked(); err != nil {
+		slog.Error("Failed to read templates", "error", err)
+	}
+}
+
+// setTemplateLocked lưu (t != nil) hoặc xóa template rồi ghi bản ghi vào backend; ghi lỗi thì
+// hoàn tác. Phải giữ templatesMu.Lock
+func setTemplateLocked(name string, t *Template) error {
+	prev, existed := templates[name]
+	if t != nil {
+		templates[name] = *t
+	} else {
+		delete(templates, name)
+	}
+	if backend == nil {
+		return nil
+	}
+	data, err := json.Marshal(persistedTemplates{V: ExportSchemaVersion, Templates: templates})
+	if err == nil {
+		err = backend.put(templatesRecordKey, data, time.Time{})
+	}
+	if err != nil {
+		if existed {
+			templates[name] = prev
+		} else {
+			delete(templates, name)
+		}
+		slog.Error("Failed to persist templates", "name", name, "error", err)
+		return errPersist
+	}
+	return nil
+}
+
+func handleTemplates(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	syncSharedTemplates()
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/templates"), "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		listTemplates(w)
+		return
+	}
+	if !templateNamePattern.MatchString(name) {
+		http.Error(w, "Invalid template name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		templatesMu.RLock()
+		t, ok := templates[name]
+		templatesMu.RUnlock()
+		if !ok {
+			http.Error(w, "Unknown template", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	case http.MethodPut:
+		putTemplate(w, r, name)
+	case http.MethodDelete:
+		templatesMu.Lock()
+		_, ok := templates[name]
+		var err error
+		if ok {
+			err = setTemplateLocked(name, nil)
+		}
+		templatesMu.Unlock()
+		if !ok {
+			http.Error(w, "Unknown template", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to delete template", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		slog.InfoContext(r.Context(), "Deleted template", "name", name)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listTemplates(w http.ResponseWriter) {
+	templatesMu.RLock()
+	list := make([]TemplateSummary, 0, len(templates))
+	for name, t := range templates {
+		list = append(list, TemplateSummary{Name: name, Files: len(t.Files)})
+	}
+	templatesMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func putTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	var t Template
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxCreateBodyBytes)).Decode(&t); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if t.Template != "" {
+		http.Error(w, "Templates cannot reference other templates", http.StatusBadRequest)
+		return
+	}
+	if len(t.Files) == 0 {
+		http.Error(w, "No files provided", http.StatusBadRequest)
+		return
+	}
+	if len(t.Files) > MaxFilesPerSession {
+		http.Error(w, fmt.Sprintf("Too many files (max %d per session)", MaxFilesPerSession), http.StatusBadRequest)
+		return
+	}
+	if err := validateFiles(t.Files, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if t.Webhook != nil {
+		if err := t.Webhook.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid webhook: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	templatesMu.Lock()
+	_, existed := templates[name]
+	err := setTemplateLocked(name, &t)
+	templatesMu.Unlock()
+	if err != nil {
+		http.Error(w, "Failed to store template", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(TemplateSummary{Name: name, Files: len(t.Files)})
+
+	slog.InfoContext(r.Context(), "Stored template", "name", name, "files", len(t.Files))
+}
+
+// instantiateTemplate sao chép template (để sửa template sau này không ảnh hưởng session đã tạo)
+// rồi nối thêm file của request; zipName của request được ưu tiên, các tùy chọn khác lấy từ template
+func instantiateTemplate(req DownloadRequest) (DownloadRequest, bool) {
+	syncSharedTemplates()
+	templatesMu.RLock()
+	t, ok := templates[req.Template]
+	templatesMu.RUnlock()
+	if !ok {
+		return req, false
+	}
+
+	merged := t
+	merged.Files = make([]FileEntry, 0, len(t.Files)+len(req.Files))
+	merged.Files = append(merged.Files, t.Files...)
+	merged.Files = append(merged.Files, req.Files...)
+	if req.ZipName != "" {
+		merged.ZipName = req.ZipName
+	}
+	if t.Webhook != nil {
+		webhook := *t.Webhook
+		merged.Webhook = &webhook
+	}
+	return merged, true
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============== TLS AND HTTP/2 ==============
+
+// Với TLSCertFile/TLSKeyFile server tự phục vụ HTTPS, HTTP/2 được bật qua ALPN. Cặp file được đọc
+// lại khi đổi nên một ACME client bên ngoài (certbot, lego...) gia hạn certificate mà không cần
+// restart. H2C nhận HTTP/2 không mã hóa (prior knowledge) cho proxy TLS nói h2c với backend.
+
+var (
+	TLSCertFile = ""    // --tls-cert-file, PEM, có thể kèm chain
+	TLSKeyFile  = ""    // --tls-key-file
+	H2C         = false // --h2c
+)
+
+// certCheckInterval là khoảng tối thiểu giữa hai lần kiểm tra cặp file certificate
+const certCheckInterval = 10 * time.Second
+
+// certReloader trả certificate cho handshake, nạp lại khi mtime của cert hoặc key đổi. Nạp lỗi thì
+// giữ certificate cũ
+type certReloader struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // mtime mới nhất của hai file lúc nạp
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := c.modified()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, modTime
+	if leaf := cert.Leaf; leaf != nil {
+		slog.Info("TLS certificate loaded", "subject", leaf.Subject.CommonName, "dns_names", leaf.DNSNames, "not_after", leaf.NotAfter)
+	}
+	return nil
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now := time.Now(); now.Sub(c.checkedAt) >= certCheckInterval {
+		c.checkedAt = now
+		if modTime, err := c.modified(); err == nil && !modTime.Equal(c.modTime) {
+			if err := c.load(modTime); err != nil {
+				// Cặp file có thể đang được ghi dở, lần kiểm tra sau thử lại
+				slog.Warn("TLS certificate reload failed, keeping the current one", "cert_file", c.certFile, "error", err)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// newHTTPServer dựng http.Server theo TLSCertFile/TLSKeyFile và H2C
+func newHTTPServer(addr string, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{Addr: addr, Handler: handler, Protocols: new(http.Protocols)}
+	srv.Protocols.SetHTTP1(true)
+	if TLSCertFile != "" {
+		certs, err := newCertReloader(TLSCertFile, TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: certs.getCertificate}
+		srv.Protocols.SetHTTP2(true)
+	}
+	srv.Protocols.SetUnencryptedHTTP2(H2C)
+	return srv, nil
+}
+
+// requestScheme là scheme client thấy: https khi server tự phục vụ TLS, X-Forwarded-Proto khi
+// request đến từ TrustedProxies, còn lại https (giả định proxy TLS phía trước)
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if fromTrustedProxy(r) {
+		// Nhiều proxy nối nhau thì giá trị đầu là của proxy ngoài cùng
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	return "https"
+}
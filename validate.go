@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// ============== PRE-FLIGHT VALIDATION ==============
+
+// POST /validate nhận body như /create, validate như /create (không lưu session) rồi HEAD từng
+// file (GET 1 byte nếu origin không hỗ trợ HEAD) để UI báo link chết và ước lượng dung lượng
+// archive trước khi tạo session thật.
+
+// sourceCheck là kết quả kiểm tra một file
+type sourceCheck struct {
+	Index       int    `json:"index"`
+	URL         string `json:"url"` // URL đã trả lời: URL chính hoặc mirror đầu tiên dùng được
+	OK          bool   `json:"ok"`
+	Status      int    `json:"status,omitempty"`
+	Size        *int64 `json:"size,omitempty"` // nil = origin không báo dung lượng
+	ContentType string `json:"content_type,omitempty"`
+	Name        string `json:"name,omitempty"` // Tên entry trong archive (đã xử lý trùng tên)
+	Error       string `json:"error,omitempty"`
+}
+
+type validateResponse struct {
+	Files        []sourceCheck `json:"files"`
+	FilesOK      int           `json:"files_ok"`
+	FilesFailed  int           `json:"files_failed"`
+	TotalBytes   int64         `json:"total_bytes"`   // Tổng dung lượng các file ok có báo dung lượng
+	SizesUnknown int           `json:"sizes_unknown"` // Số file ok không báo dung lượng
+	// Tổng dung lượng đã biết vượt MaxArchiveBytes: các file cuối sẽ bị bỏ qua khi download
+	ExceedsArchiveLimit bool `json:"exceeds_archive_limit,omitempty"`
+}
+
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	created := newSessionFromRequest(w, r, createValidate)
+	if created == nil {
+		return // newSessionFromRequest đã trả lỗi
+	}
+	session := created.session
+
+	resp := checkSources(r.Context(), session.Files, session.headers, session.nameOptions())
+	slog.InfoContext(r.Context(), "Validated sources", "files", len(session.Files), "files_ok", resp.FilesOK, "files_failed", resp.FilesFailed, "bytes", resp.TotalBytes)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkSources kiểm tra song song các file (tối đa ResolveTimeout), headers là header forward của
+// session, rồi đặt tên entry như lúc download
+func checkSources(ctx context.Context, files []FileEntry, headers http.Header, opts nameOptions) validateResponse {
+	ctx, cancel := context.WithTimeout(withForwardHeaders(ctx, headers), ResolveTimeout)
+	defer cancel()
+
+	checks := make([]sourceCheck, len(files))
+	names := make([]string, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ResolveConcurrency)
+	for i, f := range files {
+		wg.Add(1)
+		go func(i int, f FileEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			checks[i], names[i] = checkSource(withForwardHeaders(ctx, f.headers), i, f)
+		}(i, f)
+	}
+	wg.Wait()
+
+	// Đặt tên theo thứ tự file như lúc download để hậu tố trùng tên khớp với archive
+	resp := validateResponse{Files: checks}
+	namer := newEntryNamer(opts)
+	for i := range checks {
+		c := &checks[i]
+		if c.OK {
+			name, err := namer.name(files[i], names[i])
+			c.Name = name
+			if err != nil {
+				c.OK, c.Error = false, err.Error()
+			}
+		}
+		if !c.OK {
+			resp.FilesFailed++
+			continue
+		}
+		resp.FilesOK++
+		if c.Size == nil {
+			resp.SizesUnknown++
+		} else {
+			resp.TotalBytes += *c.Size
+		}
+	}
+	resp.ExceedsArchiveLimit = MaxArchiveBytes > 0 && resp.TotalBytes > MaxArchiveBytes
+	return resp
+}
+
+// checkSource thử lần lượt URL chính và các mirror, dừng ở URL đầu tiên trả lời thành công và
+// qua được kiểm tra Content-Type/dung lượng có thể làm mà không cần body. Trả kèm tên file lấy
+// từ response
+func checkSource(ctx context.Context, index int, f FileEntry) (sourceCheck, string) {
+	var c sourceCheck
+	for _, src := range f.sources() {
+		c = sourceCheck{Index: index, URL: src}
+		resp, size, err := probeSource(ctx, src)
+		if err != nil {
+			c.Error = err.Error()
+			continue
+		}
+		c.Status = resp.StatusCode
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			c.Error = fmt.Sprintf("bad status %d", resp.StatusCode)
+			continue
+		}
+		c.ContentType = resp.Header.Get("Content-Type")
+		if size > 0 {
+			c.Size = &size
+		}
+		if err := checkSourceHeaders(f, resp.Header, size); err != nil {
+			c.Error = err.Error()
+			continue
+		}
+		c.OK = true
+		return c, fileNameFromResponse(resp.Header, src)
+	}
+	return c, ""
+}
+
+// checkSourceHeaders áp dụng các kiểm tra của lúc download có thể làm chỉ với header: expect
+// Content-Type (sniff cần body nên bỏ qua), expectSize/minSize/maxSize và MaxFileBytes
+func checkSourceHeaders(f FileEntry, header http.Header, size int64) error {
+	if actual := mediaType(header.Get("Content-Type")); f.ExpectContentType != "" && actual != "" && !matchMediaType(f.ExpectContentType, actual) {
+		return &mismatchError{What: "content type", Expected: f.ExpectContentType, Actual: actual}
+	}
+	if size <= 0 {
+		return nil
+	}
+	if err := f.checkSize(size); err != nil {
+		return err
+	}
+	if MaxFileBytes > 0 && size > MaxFileBytes {
+		return &tooLargeError{What: "file", Limit: MaxFileBytes}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============== CREATE WARNINGS ==============
+
+// createWarnings kiểm tra danh sách file lúc tạo session và trả về các cảnh báo mềm
+func createWarnings(files []FileEntry) []Warning {
+	var warnings []Warning
+
+	if len(files) > ManyFilesWarning {
+		warnings = append(warnings, Warning{
+			Code:    "many_files",
+			Message: fmt.Sprintf("%d files requested; archives above %d files are slow to build", len(files), ManyFilesWarning),
+		})
+	}
+
+	seen := make(map[string]int)
+	warnedHosts := make(map[string]bool)
+	for i, f := range files {
+		index := i
+
+		key := f.sourceKey()
+		if first, ok := seen[key]; ok {
+			warnings = append(warnings, Warning{
+				Code:    "duplicate_url",
+				Message: fmt.Sprintf("Same URL as files[%d]; it will be fetched once", first),
+				Index:   &index,
+			})
+		} else {
+			seen[key] = i
+		}
+
+		host := urlHost(f.URL)
+		if host == "" || warnedHosts[host] {
+			continue
+		}
+		if rate, attempts := hostFailureRate(host); attempts >= HostStatsMinSamples && rate >= HostFailureWarning {
+			warnedHosts[host] = true
+			warnings = append(warnings, Warning{
+				Code:    "unreliable_host",
+				Message: fmt.Sprintf("%.0f%% of the last %d fetches from %s failed", rate*100, attempts, host),
+				Index:   &index,
+			})
+		}
+	}
+	return warnings
+}
+
+// ============== HOST STATS ==============
+
+const maxTrackedHosts = 10000
+
+type hostStat struct {
+	attempts    int64
+	failures    int64
+	windowStart time.Time
+}
+
+var (
+	hostStatsMu sync.Mutex
+	hostStats   = make(map[string]*hostStat)
+)
+
+// recordHostResult ghi nhận kết quả fetch để cảnh báo host hay lỗi ở các lần tạo sau
+func recordHostResult(rawURL string, ok bool) {
+	host := urlHost(rawURL)
+	if host == "" {
+		return
+	}
+	now := time.Now()
+
+	hostStatsMu.Lock()
+	defer hostStatsMu.Unlock()
+
+	st := hostStats[host]
+	if st == nil || now.Sub(st.windowStart) > HostStatsWindow {
+		if st == nil && len(hostStats) >= maxTrackedHosts {
+			// Đầy thì bỏ một host bất kỳ, đây chỉ là thống kê gợi ý
+			for h := range hostStats {
+				delete(hostStats, h)
+				break
+			}
+		}
+		st = &hostStat{windowStart: now}
+		hostStats[host] = st
+	}
+
+	st.attempts++
+	if !ok {
+		st.failures++
+	}
+}
+
+func hostFailureRate(host string) (float64, int64) {
+	hostStatsMu.Lock()
+	defer hostStatsMu.Unlock()
+
+	st := hostStats[host]
+	if st == nil || st.attempts == 0 || time.Since(st.windowStart) > HostStatsWindow {
+		return 0, 0
+	}
+	return float64(st.failures) / float64(st.attempts), st.attempts
+}
+
+func urlHost(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
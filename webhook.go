@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============== WEBHOOK ==============
+
+type WebhookConfig struct {
+	URL              string `json:"url"`
+	ProgressInterval string `json:"progressInterval"` // Ví dụ "30s", rỗng = chỉ gửi event cuối
+}
+
+type webhookEvent struct {
+	Event     string    `json:"event"` // progress, completed, failed, aborted, expired, rotated
+	Token     string    `json:"token"`
+	ZipName   string    `json:"zip_name"`
+	Sequence  uint64    `json:"sequence"`
+	Timestamp time.Time `json:"timestamp"`
+	progressSnapshot
+	Status      string           `json:"status,omitempty"`       // completed, partial, client_disconnected, failed, expired; event cuối và expired
+	Files       []fileResult     `json:"files,omitempty"`        // Chỉ có trong event cuối
+	DurationMs  *int64           `json:"duration_ms,omitempty"`  // Chỉ có trong event cuối, kể cả khi bằng 0
+	Failures    []fileFailure    `json:"failures,omitempty"`     // Chỉ có trong event cuối
+	AbortReason string           `json:"abort_reason,omitempty"` // Chỉ có trong event aborted
+	Analytics   *analyticsReport `json:"analytics,omitempty"`    // Chỉ có trong event expired
+	NewToken    string           `json:"new_token,omitempty"`    // Chỉ có trong event rotated
+}
+
+func (c *WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	if c.ProgressInterval != "" {
+		d, err := time.ParseDuration(c.ProgressInterval)
+		if err != nil {
+			return fmt.Errorf("bad progressInterval: %v", err)
+		}
+		if d < MinProgressInterval {
+			return fmt.Errorf("progressInterval must be at least %v", MinProgressInterval)
+		}
+	}
+	return nil
+}
+
+func (c *WebhookConfig) progressInterval() time.Duration {
+	d, _ := time.ParseDuration(c.ProgressInterval)
+	return d
+}
+
+var webhookClient = &http.Client{Timeout: WebhookTimeout, Transport: guardedTransport, CheckRedirect: checkRedirect}
+
+// webhookReporter gửi progress định kỳ trong lúc stream và event cuối khi kết thúc.
+// Không bao giờ block archive stream: nếu lần POST trước chưa xong thì bỏ qua update.
+type webhookReporter struct {
+	cfg      *WebhookConfig
+	token    string
+	zipName  string
+	progress *downloadProgress
+
+	seq      atomic.Uint64
+	inFlight atomic.Bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// startWebhookReporter trả về nil khi session không cấu hình webhook
+func startWebhookReporter(token, zipName string, cfg *WebhookConfig, progress *downloadProgress) *webhookReporter {
+	if cfg == nil {
+		return nil
+	}
+
+	h := &webhookReporter{
+		cfg:      cfg,
+		token:    token,
+		zipName:  zipName,
+		progress: progress,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	interval := cfg.progressInterval()
+	if interval <= 0 {
+		close(h.done)
+		return h
+	}
+
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				if !h.inFlight.CompareAndSwap(false, true) {
+					continue // Lần trước chưa gửi xong, drop update này
+				}
+				event := h.event("progress")
+				go func() {
+					defer h.inFlight.Store(false)
+					if err := postWebhook(h.cfg.URL, event); err != nil {
+						slog.Warn("Webhook failed", "event", "progress", "token", h.token, "error", err)
+					}
+				}()
+			}
+		}
+	}()
+	return h
+}
+
+// webhookDeliveries đếm các webhook cuối đang gửi (kể cả retry) để lúc tắt server chờ chúng xong
+var webhookDeliveries sync.WaitGroup
+
+// finish dừng progress ticker và gửi event cuối (có retry) trong goroutine riêng
+func (h *webhookReporter) finish(outcome string, disconnected bool) {
+	if h == nil {
+		return
+	}
+	close(h.stop)
+	<-h.done
+
+	event := h.event(outcome)
+	event.Files = h.progress.fileResults()
+	duration := time.Since(h.progress.startedAt).Milliseconds()
+	event.DurationMs = &duration
+	event.Failures = h.progress.failureReport()
+	event.AbortReason = h.progress.getAbortReason()
+	event.Status = resultStatus(outcome, disconnected, len(event.Failures))
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		if err := postWebhookWithRetry(h.cfg.URL, event); err != nil {
+			slog.Warn("Webhook failed", "event", outcome, "token", h.token, "error", err)
+		}
+	}()
+}
+
+func (h *webhookReporter) event(name string) webhookEvent {
+	return webhookEvent{
+		Event:            name,
+		Token:            h.token,
+		ZipName:          h.zipName,
+		Sequence:         h.seq.Add(1),
+		Timestamp:        time.Now().UTC(),
+		progressSnapshot: h.progress.snapshot(),
+	}
+}
+
+// notifyExpired gửi event expired kèm analytics khi session hết hạn. Phải giữ mu
+func notifyExpired(token string, session *Session) {
+	if session.Webhook == nil {
+		return
+	}
+
+	event := webhookEvent{
+		Event:            "expired",
+		Token:            token,
+		ZipName:          session.ZipName,
+		Sequence:         1,
+		Timestamp:        time.Now().UTC(),
+		progressSnapshot: progressSnapshot{FilesTotal: len(session.Files)},
+		Status:           "expired",
+		Analytics:        session.analytics.report(),
+	}
+	target := session.Webhook.URL
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		if err := postWebhookWithRetry(target, event); err != nil {
+			slog.Warn("Webhook failed", "event", "expired", "token", token, "error", err)
+		}
+	}()
+}
+
+// notifyRotated gửi event rotated với token cũ và token mới của session. Phải giữ mu
+func notifyRotated(oldToken string, session *Session) {
+	if session.Webhook == nil {
+		return
+	}
+
+	event := webhookEvent{
+		Event:            "rotated",
+		Token:            oldToken,
+		ZipName:          session.ZipName,
+		Sequence:         1,
+		Timestamp:        time.Now().UTC(),
+		progressSnapshot: progressSnapshot{FilesTotal: len(session.Files)},
+		NewToken:         session.token,
+	}
+	target := session.Webhook.URL
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
+		if err := postWebhookWithRetry(target, event); err != nil {
+			slog.Warn("Webhook failed", "event", "rotated", "token", oldToken, "error", err)
+		}
+	}()
+}
+
+func postWebhook(target string, event webhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), WebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", event.Event)
+	req.Header.Set("X-Webhook-Sequence", strconv.FormatUint(event.Sequence, 10))
+	if WebhookSecret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// postWebhookWithRetry gửi lại tối đa WebhookRetries lần khi POST lỗi, mỗi lần có timeout riêng.
+// Các lần gửi giữ nguyên sequence để receiver bỏ được bản trùng
+func postWebhookWithRetry(target string, event webhookEvent) error {
+	delay := WebhookRetryDelay
+	err := postWebhook(target, event)
+	for attempt := 0; err != nil && attempt < WebhookRetries; attempt++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = postWebhook(target, event)
+	}
+	return err
+}
+
+func signWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(WebhookSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
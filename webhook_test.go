@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+// Event cuối của callbackUrl: payload đủ field, ký HMAC-SHA256 bằng WebhookSecret trên đúng body
+// đã gửi, và được gửi lại với cùng sequence khi receiver trả lỗi
+func TestWebhookPayloadAndSignature(t *testing.T) {
+	secret := WebhookSecret
+	WebhookSecret = "webhook-test-secret"
+	t.Cleanup(func() { WebhookSecret = secret })
+
+	var posts atomic.Int64
+	deliveries := make(chan webhookDelivery, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- webhookDelivery{r.Header.Clone(), body}
+		if posts.Add(1) == 1 {
+			http.Error(w, "try again", http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+	base := newTestServer(t)
+	link := createSession(t, base, `{"files":[{"url":"`+origin.URL+`/a.txt"},{"url":"`+origin.URL+`/missing"}],"zipName":"bundle.zip","callbackUrl":"`+receiver.URL+`/hook"}`)
+	token := link[strings.LastIndex(link, "/")+1:]
+	if status, _ := download(t, link); status != http.StatusOK {
+		t.Fatalf("download = %d", status)
+	}
+
+	var got []webhookDelivery
+	for len(got) < 2 {
+		select {
+		case d := <-deliveries:
+			got = append(got, d)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d webhook deliveries, want the final event and its retry", len(got))
+		}
+	}
+	for _, d := range got {
+		mac := hmac.New(sha256.New, []byte(WebhookSecret))
+		mac.Write(d.body)
+		if sig := d.header.Get("X-Webhook-Signature"); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Fatalf("signature %q does not match the body", sig)
+		}
+		if d.header.Get("Content-Type") != "application/json" || d.header.Get("X-Webhook-Event") != "completed" || d.header.Get("X-Webhook-Sequence") != got[0].header.Get("X-Webhook-Sequence") {
+			t.Fatalf("headers = %v", d.header)
+		}
+	}
+	if string(got[1].body) != string(got[0].body) {
+		t.Fatal("retry sent a different body")
+	}
+
+	var event webhookEvent
+	if err := json.Unmarshal(got[1].body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != "completed" || event.Token != token || event.ZipName != "bundle.zip" || event.Status != "partial" || event.FilesTotal != 2 || event.FilesCompleted != 1 || event.FilesFailed != 1 || event.BytesWritten != 5 {
+		t.Fatalf("event = %+v", event)
+	}
+	if len(event.Files) != 2 || event.Files[0].Name != "a.txt" || event.Files[0].Bytes != 5 || event.Files[1].Error == "" || event.Files[1].URL != origin.URL+"/missing" {
+		t.Fatalf("files = %+v", event.Files)
+	}
+	if len(event.Failures) != 1 || !strings.Contains(event.Failures[0].Error, "404") || event.Timestamp.IsZero() {
+		t.Fatalf("failures = %+v, timestamp %v", event.Failures, event.Timestamp)
+	}
+	if event.DurationMs == nil || *event.DurationMs < 0 {
+		t.Fatalf("payload has no duration_ms: %s", got[1].body)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ============== ZIP ENTRY METADATA ==============
+
+// archiveOptions là các tùy chọn ghi entry, chụp từ session khi bắt đầu download
+type archiveOptions struct {
+	TimestampExtras bool
+	Compression     string // "" (Store), "deflate" hoặc "auto" (theo Content-Type), chỉ với zip
+	Level           int    // Mức deflate 1-9, 0 = mặc định của archive/zip
+	Format          string // "" (zip), "tar" hoặc "tar.gz"
+	Encrypted       bool   // Entry zip được mã hóa AES bằng password
+	UID, GID        int    // Chủ sở hữu của entry tar
+	Uname, Gname    string // Tên user/group của entry tar
+
+	password string // Secret, không ghi cùng Session (xem exportSecrets)
+}
+
+// method là phương thức nén của entry theo Compression
+func (o archiveOptions) method(entry zipEntry) uint16 {
+	switch o.Compression {
+	case "deflate":
+		return zip.Deflate
+	case "auto":
+		if isCompressible(entry.ContentType, entry.Name) {
+			return zip.Deflate
+		}
+	}
+	return zip.Store
+}
+
+// zipEntry mô tả một entry sắp ghi vào archive
+type zipEntry struct {
+	Name string
+	Mode os.FileMode
+	Time time.Time   // Thời gian sửa đổi, zero = lúc ghi
+	CRC  hash.Hash32 // Nếu có, nhận bản sao dữ liệu để tính CRC-32 (dùng cho resume)
+
+	ContentType string // Content-Type của origin, chọn phương thức nén với compression "auto"
+}
+
+const defaultFileMode os.FileMode = 0644
+
+// Header do origin hợp tác gửi kèm để khai báo quyền file
+var fileModeHeaders = []string{"X-File-Mode", "X-Amz-Meta-Mode"}
+
+// parseFileMode nhận chuỗi octal như "755" hoặc "0755", chỉ cho phép 9 bit quyền
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return 0, errors.New("must be an octal string like 0644")
+	}
+	if n > 0777 {
+		return 0, errors.New("only permission bits (0000-0777) are allowed")
+	}
+	return os.FileMode(n), nil
+}
+
+// lastModified lấy thời gian sửa đổi từ Last-Modified của origin, zero nếu không có hoặc sai định dạng
+func lastModified(header http.Header) time.Time {
+	t, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// entryMode ưu tiên mode trong request, sau đó tới header của origin, cuối cùng là 0644
+func entryMode(entry FileEntry, header http.Header) os.FileMode {
+	if entry.Mode != "" {
+		if mode, err := parseFileMode(entry.Mode); err == nil {
+			return mode
+		}
+	}
+	for _, name := range fileModeHeaders {
+		if v := header.Get(name); v != "" {
+			if mode, err := parseFileMode(v); err == nil {
+				return mode
+			}
+		}
+	}
+	return defaultFileMode
+}
+
+const (
+	ntfsExtraID        = 0x000a
+	unicodePathExtraID = 0x7075
+)
+
+// Số khoảng 100ns từ 1601-01-01 (mốc FILETIME) tới Unix epoch
+const filetimeUnixOffset = 116444736000000000
+
+// setEntryTime đặt thời gian sửa đổi cho entry. Khi bật TimestampExtras, archive/zip tự ghi
+// extended timestamp (0x5455) từ Modified, ta ghi thêm NTFS (0x000a) với độ chính xác 100ns.
+// Khi tắt, chỉ còn DOS timestamp (độ phân giải 2 giây, giờ địa phương).
+func setEntryTime(header *zip.FileHeader, t time.Time, opts archiveOptions) {
+	if !opts.TimestampExtras {
+		header.SetModTime(t)
+		header.Modified = time.Time{} // Tránh archive/zip tự thêm 0x5455
+		return
+	}
+
+	header.Modified = t.UTC()
+	header.Extra = append(header.Extra, ntfsExtra(t)...)
+}
+
+// ntfsExtra tạo NTFS extra field: reserved(4) + tag 0x0001 + size 24 + mtime/atime/ctime FILETIME
+func ntfsExtra(t time.Time) []byte {
+	ft := uint64(t.UnixNano()/100 + filetimeUnixOffset)
+
+	b := make([]byte, 36)
+	binary.LittleEndian.PutUint16(b[0:], ntfsExtraID)
+	binary.LittleEndian.PutUint16(b[2:], 32)
+	// b[4:8] reserved
+	binary.LittleEndian.PutUint16(b[8:], 0x0001)
+	binary.LittleEndian.PutUint16(b[10:], 24)
+	binary.LittleEndian.PutUint64(b[12:], ft)
+	binary.LittleEndian.PutUint64(b[20:], ft)
+	binary.LittleEndian.PutUint64(b[28:], ft)
+	return b
+}
+
+// unicodePathExtra tạo Info-ZIP Unicode Path extra field cho tên không phải ASCII: version 1 +
+// CRC-32 của tên trong header + tên UTF-8. archive/zip đã bật cờ UTF-8 (bit 11), field này dành
+// cho công cụ cũ bỏ qua cờ đó. Tên ASCII không cần nên trả nil
+func unicodePathExtra(name string) []byte {
+	if isASCII(name) {
+		return nil
+	}
+	b := make([]byte, 9+len(name))
+	binary.LittleEndian.PutUint16(b[0:], unicodePathExtraID)
+	binary.LittleEndian.PutUint16(b[2:], uint16(5+len(name)))
+	b[4] = 1
+	binary.LittleEndian.PutUint32(b[5:], crc32.ChecksumIEEE([]byte(name)))
+	copy(b[9:], name)
+	return b
+}
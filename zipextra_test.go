@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var extraFixtureTime = time.Date(2024, 1, 2, 3, 4, 5, 123456700, time.UTC)
+
+// Byte của từng extra field tính tay theo spec (APPNOTE 4.5.5, Info-ZIP extra field) cho
+// "báo cáo.txt" sửa lúc extraFixtureTime
+const (
+	ntfsExtraFixture        = "0a00" + "2000" + "00000000" + "0100" + "1800" + "07975b58283dda01" + "07975b58283dda01" + "07975b58283dda01"
+	unicodePathExtraFixture = "7570" + "1200" + "01" + "62c5271d" + "62c3a16f2063c3a16f2e747874"
+	extTimestampFixture     = "5554" + "0500" + "01" + "257d9365"
+)
+
+// writeOneEntry ghi một entry qua newEntryHeader và trả archive cùng extra field của local header
+func writeOneEntry(t *testing.T, entry zipEntry, opts archiveOptions) ([]byte, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(newEntryHeader(entry, opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "hello")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	nameLen, extraLen := binary.LittleEndian.Uint16(data[26:]), binary.LittleEndian.Uint16(data[28:])
+	return data, data[30+int(nameLen) : 30+int(nameLen)+int(extraLen)]
+}
+
+func TestEntryExtraFieldBytes(t *testing.T) {
+	entry := zipEntry{Name: "báo cáo.txt", Mode: 0644, Time: extraFixtureTime}
+	data, extra := writeOneEntry(t, entry, archiveOptions{TimestampExtras: true})
+	// archive/zip thêm 0x5455 (từ Modified) sau các extra của header
+	if got, want := hex.EncodeToString(extra), ntfsExtraFixture+unicodePathExtraFixture+extTimestampFixture; got != want {
+		t.Fatalf("local header extra =\n%s\nwant\n%s", got, want)
+	}
+	if flags := binary.LittleEndian.Uint16(data[6:]); flags&0x800 == 0 {
+		t.Fatalf("flags = %#x, want the UTF-8 bit", flags)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := zr.File[0]
+	if f.Name != entry.Name || !f.Modified.Equal(extraFixtureTime.Truncate(time.Second)) {
+		t.Fatalf("read back %q modified %v, want %q %v", f.Name, f.Modified, entry.Name, extraFixtureTime.Truncate(time.Second))
+	}
+
+	// Tên ASCII không có Unicode Path; timestampExtras false chỉ còn DOS timestamp
+	if _, extra := writeOneEntry(t, zipEntry{Name: "report.txt", Mode: 0644, Time: extraFixtureTime}, archiveOptions{TimestampExtras: true}); hex.EncodeToString(extra) != ntfsExtraFixture+extTimestampFixture {
+		t.Fatalf("extra of an ASCII name = %x", extra)
+	}
+	if _, extra := writeOneEntry(t, zipEntry{Name: "report.txt", Mode: 0644, Time: extraFixtureTime}, archiveOptions{}); len(extra) != 0 {
+		t.Fatalf("extra with timestampExtras off = %x, want none", extra)
+	}
+}
+
+// Qua server: Modified của entry bằng Last-Modified tới từng giây khi bật extra, khi tắt chỉ còn
+// DOS timestamp nên bị làm tròn xuống 2 giây
+func TestTimestampExtrasRoundTrip(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	origin := rawOrigin(t, "HTTP/1.1 200 OK\r\nLast-Modified: "+modified.Format(http.TimeFormat)+"\r\nContent-Length: 5\r\n\r\nhello")
+	base := newTestServer(t)
+	for _, tc := range []struct {
+		option string
+		want   time.Time
+	}{
+		{"", modified},
+		{`,"timestampExtras":false`, time.Date(2024, 1, 2, 3, 4, 4, 0, time.UTC)},
+	} {
+		_, data := download(t, createSession(t, base, `{"files":["`+origin+`/a.txt"]`+tc.option+`}`))
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := zr.File[0].Modified; !got.Equal(tc.want) {
+			t.Errorf("timestampExtras%s: modified %v, want %v", tc.option, got, tc.want)
+		}
+	}
+}